@@ -0,0 +1,123 @@
+package jsontree
+
+import (
+	"github.com/theory/jsonpath"
+	"github.com/theory/jsonpath/spec"
+)
+
+// Branch describes a branch of JSONPath segments for compiling into a
+// [Tree] without parsing JSONPath query strings. Create the first segment of
+// a branch with [Child] or [Descendant], extend it with the [Branch.Child]
+// and [Branch.Descendant] methods, and fork it with [Branch.Append]:
+//
+//	// $.a.b and $.a..c
+//	tree := jsontree.Build(
+//		jsontree.Child(spec.Name("a")).Append(
+//			jsontree.Child(spec.Name("b")),
+//			jsontree.Descendant(spec.Name("c")),
+//		),
+//	)
+//
+// A Branch is a builder: compile it with [Build] or pass the paths returned
+// by [Branch.Paths] to [New], [NewFixedModeTree], or a [Compiler].
+type Branch struct {
+	selectors  []spec.Selector
+	descendant bool
+	parent     *Branch
+	children   []*Branch
+}
+
+// Child creates a Branch that starts with a child segment containing
+// selectors, equivalent to the JSONPath segment [<selectors>].
+func Child(selectors ...spec.Selector) *Branch {
+	return &Branch{selectors: selectors}
+}
+
+// Descendant creates a Branch that starts with a descendant segment
+// containing selectors, equivalent to the JSONPath segment ..[<selectors>].
+func Descendant(selectors ...spec.Selector) *Branch {
+	return &Branch{selectors: selectors, descendant: true}
+}
+
+// Child appends a child segment containing selectors to b and returns the
+// new segment, so that calls chain to describe a path: Child(spec.Name("a"))
+// .Child(spec.Name("b")) describes $.a.b.
+func (b *Branch) Child(selectors ...spec.Selector) *Branch {
+	return b.add(&Branch{selectors: selectors})
+}
+
+// Descendant appends a descendant segment containing selectors to b and
+// returns the new segment.
+func (b *Branch) Descendant(selectors ...spec.Selector) *Branch {
+	return b.add(&Branch{selectors: selectors, descendant: true})
+}
+
+// Append appends each of branches to b, starting from their first segments,
+// and returns b, so that b forks into several paths.
+func (b *Branch) Append(branches ...*Branch) *Branch {
+	for _, c := range branches {
+		b.add(c.first())
+	}
+
+	return b
+}
+
+// add appends c to b's children and returns c.
+func (b *Branch) add(c *Branch) *Branch {
+	c.parent = b
+	b.children = append(b.children, c)
+
+	return c
+}
+
+// first returns the first segment of the branch that contains b.
+func (b *Branch) first() *Branch {
+	for b.parent != nil {
+		b = b.parent
+	}
+
+	return b
+}
+
+// Paths returns a [jsonpath.Path] for each path from the first segment of
+// the branch that contains b to each of its last segments.
+func (b *Branch) Paths() []*jsonpath.Path {
+	return b.first().appendPaths(nil, nil)
+}
+
+// appendPaths appends to paths a [jsonpath.Path] for each path from b to its
+// last segments, prefixed by parents, and returns the result.
+func (b *Branch) appendPaths(paths []*jsonpath.Path, parents []*spec.Segment) []*jsonpath.Path {
+	var seg *spec.Segment
+	if b.descendant {
+		seg = spec.Descendant(b.selectors...)
+	} else {
+		seg = spec.Child(b.selectors...)
+	}
+
+	// Use a full slice expression so that siblings never share storage.
+	segs := append(parents[:len(parents):len(parents)], seg)
+	if len(b.children) == 0 {
+		return append(paths, jsonpath.New(spec.Query(true, segs...)))
+	}
+
+	for _, c := range b.children {
+		paths = c.appendPaths(paths, segs)
+	}
+
+	return paths
+}
+
+// Build compiles branches into an ordered mode Tree, exactly as [New]
+// compiles the equivalent JSONPath queries, so that it merges and
+// deduplicates them in the same way. Build with no branches returns a
+// root-only Tree. For a fixed mode Tree or a Tree with options, pass the
+// paths returned by [Branch.Paths] to [NewFixedModeTree] or a [Compiler].
+func Build(branches ...*Branch) *Tree {
+	var paths []*jsonpath.Path
+	for _, b := range branches {
+		paths = append(paths, b.Paths()...)
+	}
+
+	return New(paths...)
+}
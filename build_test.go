@@ -0,0 +1,359 @@
+package jsontree
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theory/jsonpath"
+	"github.com/theory/jsonpath/spec"
+)
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test     string
+		branches []*Branch
+		paths    []string
+	}{
+		{
+			test:  "root_only",
+			paths: []string{"$"},
+		},
+		{
+			test:     "name",
+			branches: []*Branch{Child(spec.Name("a"))},
+			paths:    []string{"$.a"},
+		},
+		{
+			test:     "chain",
+			branches: []*Branch{Child(spec.Name("a")).Child(spec.Index(1)).Descendant(spec.Name("b"))},
+			paths:    []string{`$["a"][1]..["b"]`},
+		},
+		{
+			test: "fork",
+			branches: []*Branch{
+				Child(spec.Name("a")).Append(
+					Child(spec.Name("b")),
+					Descendant(spec.Name("c")).Child(spec.Wildcard(), spec.Index(0)),
+				),
+			},
+			paths: []string{`$["a"]["b"]`, `$["a"]..["c"][*,0]`},
+		},
+		{
+			test: "fork_mid_chain",
+			branches: []*Branch{
+				Descendant(spec.Name("x")).Child(spec.Name("y")).Append(
+					Child(spec.Name("z")),
+				),
+			},
+			paths: []string{`$..["x"]["y"]["z"]`},
+		},
+		{
+			test: "canonicalize",
+			branches: []*Branch{
+				Child(spec.Name("a")).Child(spec.Name("x")),
+				Child(spec.Name("b")).Child(spec.Name("x")),
+				Child(spec.Slice(0, 5), spec.Index(2)),
+			},
+			paths: []string{`$["a"]["x"]`, `$["b"]["x"]`, `$[0:5,2]`},
+		},
+		{
+			test:     "trailing_wildcard",
+			branches: []*Branch{Child(spec.Name("a")).Child(spec.Wildcard())},
+			paths:    []string{`$["a"][*]`},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			a.Equal(New(paths...).root, Build(tc.branches...).root)
+
+			var got []string
+			for _, b := range tc.branches {
+				for _, p := range b.Paths() {
+					got = append(got, p.String())
+				}
+			}
+
+			var exp []string
+			for _, p := range paths {
+				if len(p.Query().Segments()) > 0 {
+					exp = append(exp, p.String())
+				}
+			}
+
+			a.Equal(exp, got)
+		})
+	}
+}
+
+func TestBranchPathsFromAnySegment(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	first := Child(spec.Name("a"))
+	last := first.Child(spec.Name("b")).Child(spec.Name("c"))
+	first.Append(Child(spec.Name("d")))
+
+	exp := []string{`$["a"]["b"]["c"]`, `$["a"]["d"]`}
+	for _, b := range []*Branch{first, last} {
+		var got []string
+		for _, p := range b.Paths() {
+			got = append(got, p.String())
+		}
+
+		a.Equal(exp, got)
+	}
+}
+
+func TestFilterFunc(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	input := map[string]any{
+		"users": []any{
+			map[string]any{"name": "Kim", "active": true},
+			map[string]any{"name": "Lee", "active": false},
+			map[string]any{"name": "Max", "active": true},
+		},
+		"roles": map[string]any{"admin": 3, "user": 10, "guest": 0},
+	}
+
+	active := FilterFunc(func(val any) bool {
+		user, ok := val.(map[string]any)
+		return ok && user["active"] == true
+	})
+	positive := FilterFunc(func(val any) bool {
+		n, ok := val.(int)
+		return ok && n > 0
+	})
+
+	users := Child(spec.Name("users")).Child(active).Child(spec.Name("name"))
+	tree := Build(users, Child(spec.Name("roles")).Child(positive))
+	a.Equal("$\n"+
+		"├── [\"users\"]\n"+
+		"│\u00a0\u00a0 └── [?<func>]\n"+
+		"│\u00a0\u00a0     └── [\"name\"]\n"+
+		"└── [\"roles\"]\n"+
+		"    └── [?<func>]\n", tree.String())
+
+	t.Run("select", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		a.Equal(map[string]any{
+			"users": []any{map[string]any{"name": "Kim"}, map[string]any{"name": "Max"}},
+			"roles": map[string]any{"admin": 3, "user": 10},
+		}, tree.Select(input))
+		a.ElementsMatch([]any{"Kim", "Max", 3, 10}, tree.SelectTo(nil, input))
+		a.Equal(4, tree.Count(input))
+		a.Equal([]string{
+			"$['users'][0]['name']", "$['users'][2]['name']", "$['roles']['admin']", "$['roles']['user']",
+		}, tree.SelectPaths(input))
+	})
+
+	t.Run("fixed_mode", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		a.Equal(map[string]any{
+			"users": []any{map[string]any{"name": "Kim"}, nil, map[string]any{"name": "Max"}},
+		}, NewFixedModeTree(users.Paths()...).Select(input))
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		buf := new(strings.Builder)
+		r.NoError(tree.SelectStream(input, buf))
+		a.JSONEq(`{"users":[{"name":"Kim"},{"name":"Max"}],"roles":{"admin":3,"user":10}}`, buf.String())
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		a.Equal(map[string]any{
+			"users": []any{
+				map[string]any{"active": true},
+				map[string]any{"name": "Lee", "active": false},
+				map[string]any{"active": true},
+			},
+			"roles": map[string]any{"guest": 0},
+		}, tree.Delete(input))
+	})
+
+	t.Run("merge_by_identity", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		a.Equal("$\n└── [\"roles\",\"users\"]\n    └── [?<func>]\n",
+			Build(Child(spec.Name("users")).Child(active), Child(spec.Name("roles")).Child(active)).String())
+		a.Equal("$\n└── [\"users\"]\n    └── [?<func>,?<func>]\n", Build(
+			Child(spec.Name("users")).Child(positive),
+			Child(spec.Name("users")).Child(FilterFunc(nil)),
+		).String())
+	})
+
+	t.Run("jsonpath", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		a.Equal([]string{`$["users"][?<func>]["name"]`, `$["roles"][?<func>]`}, tree.Queries())
+		for _, q := range tree.Queries() {
+			_, err := jsonpath.Parse(q)
+			r.Error(err)
+		}
+		a.Equal(jsonpath.NodeList{3, 10}, tree.Paths()[1].Select(input))
+		a.Equal(jsonpath.NodeList{"Kim", "Max"}, users.Paths()[0].Select(input))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+		_, err := json.Marshal(tree)
+		r.ErrorIs(err, ErrJSON)
+	})
+
+	t.Run("select_by_path", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		lee := FilterFunc(func(val any) bool {
+			user, ok := val.(map[string]any)
+			return ok && user["name"] == "Lee"
+		})
+		branches := New(append(
+			Child(spec.Name("users")).Child(active).Paths(),
+			Child(spec.Name("users")).Child(lee).Paths()...,
+		)...)
+
+		// Both paths share a key, so its value holds the values of both.
+		a.Equal(map[string]any{
+			`$["users"][?<func>]`: map[string]any{"users": input["users"]},
+		}, branches.SelectByPath(input))
+	})
+
+	t.Run("raw_and_recover", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		panics := FilterFunc(func(val any) bool {
+			if val == nil {
+				panic("nil value")
+			}
+
+			return true
+		})
+		raw := []any{json.RawMessage(`{"x":1}`)}
+		a.Equal([]any{map[string]any{"x": json.RawMessage("1")}}, Build(Child(panics).Child(spec.Name("x"))).Select(raw))
+
+		raw = append(raw, nil)
+		a.Panics(func() { Build(Child(panics).Child(spec.Name("x"))).Select(raw) })
+		recovering := NewCompiler(WithRecoverFilters()).New(Child(panics).Child(spec.Name("x")).Paths()...)
+		a.Equal([]any{map[string]any{"x": json.RawMessage("1")}}, recovering.Select(raw))
+	})
+}
+
+func TestRegexpName(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	input := map[string]any{
+		"name":      "Kim",
+		"addr_home": map[string]any{"city": "Albuquerque", "zip": "87101"},
+		"addr_work": map[string]any{"city": "Santa Fe", "zip": "87501"},
+		"ADDR_old":  map[string]any{"city": "Chicago"},
+		"tags":      []any{"addr_x"},
+	}
+
+	addr := RegexpName(regexp.MustCompile(`^addr_`))
+	tree := Build(Child(addr).Child(spec.Name("city")))
+	a.Equal("$\n└── [~/^addr_/]\n    └── [\"city\"]\n", tree.String())
+
+	exp := map[string]any{
+		"addr_home": map[string]any{"city": "Albuquerque"},
+		"addr_work": map[string]any{"city": "Santa Fe"},
+	}
+
+	t.Run("select", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		a.Equal(exp, tree.Select(input))
+		a.Equal(exp, NewFixedModeTree(tree.Paths()...).Select(input))
+		a.Equal([]string{"$['addr_home']['city']", "$['addr_work']['city']"}, tree.SelectPaths(input))
+		a.Equal(2, tree.Count(input))
+
+		// Selects nothing from arrays.
+		a.Equal(map[string]any{}, Build(Child(spec.Name("tags")).Child(addr)).Select(input))
+
+		// Case-insensitive patterns.
+		ci := Build(Child(RegexpName(regexp.MustCompile(`(?i)^addr_o`))))
+		a.Equal(map[string]any{"ADDR_old": map[string]any{"city": "Chicago"}}, ci.Select(input))
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		buf := new(strings.Builder)
+		r.NoError(tree.SelectStream(input, buf))
+		a.JSONEq(`{"addr_home":{"city":"Albuquerque"},"addr_work":{"city":"Santa Fe"}}`, buf.String())
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		a.Equal(map[string]any{
+			"name":      "Kim",
+			"addr_home": map[string]any{"zip": "87101"},
+			"addr_work": map[string]any{"zip": "87501"},
+			"ADDR_old":  map[string]any{"city": "Chicago"},
+			"tags":      []any{"addr_x"},
+		}, tree.Delete(input))
+	})
+
+	t.Run("descendant", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		zips := Build(Descendant(RegexpName(regexp.MustCompile(`^zi`))))
+		a.Equal(map[string]any{
+			"addr_home": map[string]any{"zip": "87101"},
+			"addr_work": map[string]any{"zip": "87501"},
+		}, zips.Select(input))
+	})
+
+	t.Run("merge_by_pattern", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		merged := Build(
+			Child(addr).Child(spec.Name("city")),
+			Child(RegexpName(regexp.MustCompile(`^addr_`))).Child(spec.Name("zip")),
+		)
+		a.Equal("$\n└── [~/^addr_/]\n    └── [\"city\",\"zip\"]\n", merged.String())
+	})
+
+	t.Run("jsonpath", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		a.Equal([]string{`$[~/^addr_/]["city"]`}, tree.Queries())
+		_, err := jsonpath.Parse(tree.Queries()[0])
+		r.Error(err)
+		a.Equal(jsonpath.NodeList{"Albuquerque", "Santa Fe"}, tree.Paths()[0].Select(input))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+		r := require.New(t)
+		_, err := json.Marshal(tree)
+		r.ErrorIs(err, ErrJSON)
+		r.ErrorContains(err, "~/^addr_/")
+	})
+}
@@ -0,0 +1,111 @@
+package jsontree
+
+import (
+	"maps"
+
+	"github.com/theory/jsonpath/spec"
+)
+
+// Delete returns a copy of the from JSON value with the values selected by
+// tree's paths removed, leaving all other values intact: the complement of
+// [Tree.Select]. Use it to redact values identified by JSONPath queries.
+// Delete removes selected object members entirely. A fixed mode Tree
+// replaces selected array items with nil, or with the value configured by
+// [WithGapValue], preserving the indexes of the remaining items, while an
+// ordered mode Tree removes them, compacting the array.
+//
+// Delete removes the values [Tree.Walk] visits, so it deletes from
+// [json.RawMessage] values as Select selects from them, returning the
+// members and items of those it deletes from as undecoded raw messages, and
+// respects [WithObserver], [WithMaxDepth], and [WithMaxDescendDepth]. As
+// with Select, a path with a trailing wildcard, such as $.a.*, deletes its
+// parent. A root-only Tree deletes the entire value and returns nil. Delete
+// returns from itself when from is neither an array nor an object. Delete
+// never modifies from, but the returned value shares nested values from
+// which it deletes nothing.
+func (tree *Tree) Delete(from any) any {
+	if len(tree.root.children) == 0 {
+		return nil
+	}
+
+	del := &deletion{}
+	tree.Walk(from, func(path spec.NormalizedPath, _ any) bool {
+		cur := del
+		for _, elem := range path {
+			switch elem := elem.(type) {
+			case spec.Name:
+				cur = cur.child(string(elem))
+			case spec.Index:
+				cur = cur.child(int(elem))
+			}
+		}
+
+		cur.remove = true
+
+		return true
+	})
+
+	return tree.deleteFrom(from, del)
+}
+
+// deletion records the values to delete from a JSON value. If remove is
+// true, the value itself is deleted. Otherwise, children records the values
+// to delete from the members or items of the value, by object key or array
+// index.
+type deletion struct {
+	children map[any]*deletion
+	remove   bool
+}
+
+// child returns the deletion for the value at key, creating it if
+// necessary.
+func (del *deletion) child(key any) *deletion {
+	if del.children == nil {
+		del.children = map[any]*deletion{}
+	}
+
+	sub, ok := del.children[key]
+	if !ok {
+		sub = &deletion{}
+		del.children[key] = sub
+	}
+
+	return sub
+}
+
+// deleteFrom returns a copy of val without the values recorded by del,
+// decoding one level of val if it is a raw JSON object or array. Returns
+// val itself if it is neither an object nor an array.
+func (tree *Tree) deleteFrom(val any, del *deletion) any {
+	switch val := decodeRaw(val).(type) {
+	case map[string]any:
+		obj := maps.Clone(val)
+		for key, sub := range del.children {
+			k, _ := key.(string)
+			if sub.remove {
+				delete(obj, k)
+			} else {
+				obj[k] = tree.deleteFrom(obj[k], sub)
+			}
+		}
+
+		return obj
+	case []any:
+		ary := make([]any, 0, len(val))
+		for i, v := range val {
+			sub, ok := del.children[i]
+			switch {
+			case !ok:
+				ary = append(ary, v)
+			case !sub.remove:
+				ary = append(ary, tree.deleteFrom(v, sub))
+			case tree.index:
+				ary = append(ary, tree.gap)
+			}
+		}
+
+		return ary
+	}
+
+	return val
+}
@@ -0,0 +1,285 @@
+package jsontree
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/theory/jsonpath"
+)
+
+func TestDelete(t *testing.T) {
+	t.Parallel()
+
+	mkInput := func() any {
+		return map[string]any{
+			"name": "Barrack Obama",
+			"ssn":  "123-45-6789",
+			"contacts": map[string]any{
+				"email": "barrack@example.com",
+				"phone": "555-1212",
+				"ssn":   "987-65-4321",
+			},
+			"accounts": []any{
+				map[string]any{"id": 1, "ssn": "111", "type": "checking"},
+				map[string]any{"id": 2, "type": "savings"},
+				map[string]any{"id": 3, "ssn": "333", "type": "checking"},
+			},
+			"tags": []any{"a", "b", "c", "d"},
+		}
+	}
+
+	for _, tc := range []struct {
+		test    string
+		paths   []string
+		input   any
+		ordered any
+		fixed   any
+	}{
+		{
+			test:    "root_only",
+			paths:   []string{"$"},
+			ordered: nil,
+			fixed:   nil,
+		},
+		{
+			test:  "name",
+			paths: []string{"$.ssn"},
+			ordered: map[string]any{
+				"name":     "Barrack Obama",
+				"contacts": mkInput().(map[string]any)["contacts"],
+				"accounts": mkInput().(map[string]any)["accounts"],
+				"tags":     []any{"a", "b", "c", "d"},
+			},
+			fixed: map[string]any{
+				"name":     "Barrack Obama",
+				"contacts": mkInput().(map[string]any)["contacts"],
+				"accounts": mkInput().(map[string]any)["accounts"],
+				"tags":     []any{"a", "b", "c", "d"},
+			},
+		},
+		{
+			test:  "nested",
+			paths: []string{"$.contacts.email", "$.contacts.phone", "$.tags"},
+			ordered: map[string]any{
+				"name":     "Barrack Obama",
+				"ssn":      "123-45-6789",
+				"contacts": map[string]any{"ssn": "987-65-4321"},
+				"accounts": mkInput().(map[string]any)["accounts"],
+			},
+			fixed: map[string]any{
+				"name":     "Barrack Obama",
+				"ssn":      "123-45-6789",
+				"contacts": map[string]any{"ssn": "987-65-4321"},
+				"accounts": mkInput().(map[string]any)["accounts"],
+			},
+		},
+		{
+			test:  "descendant",
+			paths: []string{"$..ssn"},
+			ordered: map[string]any{
+				"name": "Barrack Obama",
+				"contacts": map[string]any{
+					"email": "barrack@example.com",
+					"phone": "555-1212",
+				},
+				"accounts": []any{
+					map[string]any{"id": 1, "type": "checking"},
+					map[string]any{"id": 2, "type": "savings"},
+					map[string]any{"id": 3, "type": "checking"},
+				},
+				"tags": []any{"a", "b", "c", "d"},
+			},
+			fixed: map[string]any{
+				"name": "Barrack Obama",
+				"contacts": map[string]any{
+					"email": "barrack@example.com",
+					"phone": "555-1212",
+				},
+				"accounts": []any{
+					map[string]any{"id": 1, "type": "checking"},
+					map[string]any{"id": 2, "type": "savings"},
+					map[string]any{"id": 3, "type": "checking"},
+				},
+				"tags": []any{"a", "b", "c", "d"},
+			},
+		},
+		{
+			test:  "array_items",
+			paths: []string{"$.tags[1,-1]"},
+			input: map[string]any{"tags": []any{"a", "b", "c", "d"}},
+			ordered: map[string]any{
+				"tags": []any{"a", "c"},
+			},
+			fixed: map[string]any{
+				"tags": []any{"a", nil, "c", nil},
+			},
+		},
+		{
+			test:    "array_slice",
+			paths:   []string{"$[1:3]", "$[::-3]"},
+			input:   []any{0, 1, 2, 3, 4, 5},
+			ordered: []any{0, 3, 4},
+			fixed:   []any{0, nil, nil, 3, 4, nil},
+		},
+		{
+			test:  "indexes_and_nested",
+			paths: []string{"$[0]", "$[1].x", "$[2]"},
+			input: []any{
+				map[string]any{"x": 0},
+				map[string]any{"x": 1, "y": 1},
+				map[string]any{"x": 2},
+				map[string]any{"x": 3},
+			},
+			ordered: []any{
+				map[string]any{"y": 1},
+				map[string]any{"x": 3},
+			},
+			fixed: []any{
+				nil,
+				map[string]any{"y": 1},
+				nil,
+				map[string]any{"x": 3},
+			},
+		},
+		{
+			test:  "filter",
+			paths: []string{`$.accounts[?@.type == "checking"]`},
+			input: map[string]any{"accounts": mkInput().(map[string]any)["accounts"]},
+			ordered: map[string]any{"accounts": []any{
+				map[string]any{"id": 2, "type": "savings"},
+			}},
+			fixed: map[string]any{"accounts": []any{
+				nil, map[string]any{"id": 2, "type": "savings"}, nil,
+			}},
+		},
+		{
+			test:  "wildcard_then_name",
+			paths: []string{"$.accounts[*].id"},
+			input: map[string]any{"accounts": mkInput().(map[string]any)["accounts"]},
+			ordered: map[string]any{"accounts": []any{
+				map[string]any{"ssn": "111", "type": "checking"},
+				map[string]any{"type": "savings"},
+				map[string]any{"ssn": "333", "type": "checking"},
+			}},
+			fixed: map[string]any{"accounts": []any{
+				map[string]any{"ssn": "111", "type": "checking"},
+				map[string]any{"type": "savings"},
+				map[string]any{"ssn": "333", "type": "checking"},
+			}},
+		},
+		{
+			test:    "trailing_wildcard",
+			paths:   []string{"$.a.*"},
+			input:   map[string]any{"a": map[string]any{"b": 1}, "c": 2},
+			ordered: map[string]any{"c": 2},
+			fixed:   map[string]any{"c": 2},
+		},
+		{
+			test:    "descendant_arrays",
+			paths:   []string{"$..[0]"},
+			input:   []any{[]any{1, 2}, []any{3, []any{4, 5}}},
+			ordered: []any{[]any{[]any{5}}},
+			fixed:   []any{nil, []any{nil, []any{nil, 5}}},
+		},
+		{
+			test:    "no_match",
+			paths:   []string{"$.x.y"},
+			input:   map[string]any{"x": []any{1}},
+			ordered: map[string]any{"x": []any{1}},
+			fixed:   map[string]any{"x": []any{1}},
+		},
+		{
+			test:    "scalar",
+			paths:   []string{"$.x"},
+			input:   "hi",
+			ordered: "hi",
+			fixed:   "hi",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			mk := func() any {
+				if tc.input != nil {
+					return tc.input
+				}
+				return mkInput()
+			}
+
+			input := mk()
+			a.Equal(tc.ordered, New(paths...).Delete(input))
+			a.Equal(mk(), input, "input modified")
+
+			input = mk()
+			a.Equal(tc.fixed, NewFixedModeTree(paths...).Delete(input))
+			a.Equal(mk(), input, "input modified")
+		})
+	}
+}
+
+func TestDeleteOptions(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	input := []any{
+		map[string]any{"a": 1, "b": 2},
+		map[string]any{"c": 3},
+	}
+
+	c := NewCompiler(WithGapValue("REDACTED"))
+	a.Equal([]any{"REDACTED", "REDACTED"}, c.NewFixedModeTree(jsonpath.MustParse("$[0,1]")).Delete(input))
+	a.Equal([]any{}, c.New(jsonpath.MustParse("$[0,1]")).Delete(input))
+
+	c = NewCompiler(WithExcludeKeys("b"))
+	a.Equal(
+		[]any{map[string]any{"b": 2}, map[string]any{}},
+		c.New(jsonpath.MustParse("$[*][*]")).Delete(input),
+	)
+}
+
+func TestDeleteShared(t *testing.T) {
+	t.Parallel()
+
+	t.Run("raw", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		raw := json.RawMessage(`{"a":{"b":1,"c":2},"d":[1,2,3]}`)
+		a.Equal(map[string]any{
+			"a": map[string]any{"c": json.RawMessage("2")},
+			"d": []any{json.RawMessage("1"), json.RawMessage("3")},
+		}, New(jsonpath.MustParse("$.a.b"), jsonpath.MustParse("$.d[1]")).Delete(raw))
+		a.Equal(map[string]any{"d": json.RawMessage("[1,2,3]")}, New(jsonpath.MustParse("$.a")).Delete(raw))
+	})
+
+	t.Run("observer", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		matched := map[string]int{}
+		tree := NewCompiler(WithObserver(func(seg *Segment) {
+			matched[FormatSelectors(seg.Selectors())]++
+		})).New(jsonpath.MustParse("$.a.b"), jsonpath.MustParse("$.x"))
+		a.Equal(map[string]any{"a": map[string]any{}}, tree.Delete(map[string]any{"a": map[string]any{"b": 1}}))
+		a.Equal(map[string]int{`["a"]`: 1, `["b"]`: 1}, matched)
+	})
+
+	t.Run("max_depth", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		input := map[string]any{"a": map[string]any{"a": map[string]any{"a": 1}}, "b": 2}
+		tree := NewCompiler(WithMaxDepth(2)).New(jsonpath.MustParse("$..a"), jsonpath.MustParse("$.b"))
+		a.Equal(map[string]any{}, tree.Delete(input))
+
+		tree = NewCompiler(WithMaxDepth(2)).New(jsonpath.MustParse("$.a.a.a"), jsonpath.MustParse("$.b"))
+		a.Equal(map[string]any{"a": input["a"]}, tree.Delete(input))
+	})
+}
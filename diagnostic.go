@@ -0,0 +1,81 @@
+package jsontree
+
+import (
+	"fmt"
+
+	"github.com/theory/jsonpath"
+)
+
+// Diagnostic describes a transformation that compiling a Tree made to one of
+// its paths, such as discarding a trailing wildcard or discarding segments
+// that another path already selects. Returned by [NewWithDiagnostics].
+type Diagnostic struct {
+	// Path is the index of the path in the paths passed to the constructor.
+	Path int
+
+	// Segment is the index of the segment in the path, starting from 0, or
+	// -1 if the transformation applies to the path as a whole.
+	Segment int
+
+	// Query is the string representation of the path.
+	Query string
+
+	// Message describes the transformation.
+	Message string
+}
+
+// String returns a string representation of d, with its query, segment, and
+// message.
+func (d Diagnostic) String() string {
+	if d.Segment < 0 {
+		return fmt.Sprintf("%v: %v", d.Query, d.Message)
+	}
+
+	return fmt.Sprintf("%v: segment %d: %v", d.Query, d.Segment, d.Message)
+}
+
+// NewWithDiagnostics compiles paths into an ordered mode Tree configured
+// with c's options, exactly as [Compiler.New] would, and returns a
+// [Diagnostic] for each transformation it made to a path. See
+// [NewWithDiagnostics] for details.
+func (c *Compiler) NewWithDiagnostics(paths ...*jsonpath.Path) (*Tree, []Diagnostic) {
+	tree := c.New()
+
+	var diags []Diagnostic
+
+	tree.explain = func(path, seg int, msg string, changed bool) {
+		if changed {
+			diags = append(diags, Diagnostic{
+				Path:    path,
+				Segment: seg,
+				Query:   paths[path].String(),
+				Message: msg,
+			})
+		}
+	}
+
+	tree.root = tree.compile(paths)
+	tree.sources = paths
+	tree.explain = nil
+
+	return tree, diags
+}
+
+// NewWithDiagnostics compiles paths into an ordered mode Tree exactly as
+// [New] would, and returns a [Diagnostic] for each transformation it made
+// to a path that would otherwise go unnoticed:
+//
+//   - Removing duplicate and redundant selectors from a segment, such as a
+//     repeated filter or names alongside a wildcard
+//   - Discarding a trailing wildcard, which selects the same values as its
+//     parent
+//   - Discarding the remaining segments of a path, or the children of an
+//     existing segment, when a shorter path selects their values
+//   - Absorbing a child wildcard segment into a descendant wildcard segment
+//   - Making the Tree root-only for a path that selects the entire value
+//
+// Returns no diagnostics for ordinary merging, such as continuing a path
+// into an existing segment. Use [Tree.Explain] to describe every decision.
+func NewWithDiagnostics(paths ...*jsonpath.Path) (*Tree, []Diagnostic) {
+	return NewCompiler().NewWithDiagnostics(paths...)
+}
@@ -0,0 +1,99 @@
+package jsontree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/theory/jsonpath"
+)
+
+func TestNewWithDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		exp   []string
+	}{
+		{
+			test:  "no_transformations",
+			paths: []string{"$.a.b", "$.a.c", "$.x"},
+		},
+		{
+			test:  "duplicate_filter",
+			paths: []string{"$[?@.x,?@.x].y"},
+			exp: []string{
+				`$[?@["x"],?@["x"]]["y"]: segment 0: removed duplicate and redundant selectors, leaving [?@["x"]]`,
+			},
+		},
+		{
+			test:  "names_and_wildcard",
+			paths: []string{"$.a[*,'x'].b"},
+			exp:   []string{`$["a"][*,"x"]["b"]: segment 1: removed duplicate and redundant selectors, leaving [*]`},
+		},
+		{
+			test:  "trailing_wildcard",
+			paths: []string{"$.a.*"},
+			exp: []string{
+				`$["a"][*]: segment 1: discarded trailing wildcard, which selects the same values as its parent`,
+			},
+		},
+		{
+			test:  "longer_path_discarded",
+			paths: []string{"$.a", "$.a.b"},
+			exp: []string{
+				`$["a"]["b"]: segment 0: discarded remaining segments, ` +
+					`since existing leaf segment ["a"] selects their values`,
+			},
+		},
+		{
+			test:  "children_discarded",
+			paths: []string{"$.a.b", "$.a"},
+			exp: []string{
+				`$["a"]: segment 0: discarded the children of existing segment ["a"], ` +
+					`since the path ends and selects their values`,
+			},
+		},
+		{
+			test:  "descendant_wildcard",
+			paths: []string{"$.*.a", "$..*.a"},
+			exp: []string{
+				`$..[*]["a"]: segment 0: descendant wildcard absorbed existing child wildcard ` +
+					`with the same remaining branch`,
+			},
+		},
+		{
+			test:  "root_path",
+			paths: []string{"$.a", "$"},
+			exp:   []string{`$: selects the entire value, making the tree root-only`},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree, diags := NewWithDiagnostics(paths...)
+			a.Equal(New(paths...), tree)
+
+			var got []string
+			for _, d := range diags {
+				a.Equal(paths[d.Path].String(), d.Query)
+				got = append(got, d.String())
+			}
+
+			a.Equal(tc.exp, got)
+		})
+	}
+
+	t.Run("options", func(t *testing.T) {
+		t.Parallel()
+		tree, diags := NewCompiler(WithExcludeKeys("x")).NewWithDiagnostics(jsonpath.MustParse("$.a.*"))
+		assert.Equal(t, []string{`$["a"][*]`}, tree.Queries())
+		assert.Empty(t, diags)
+	})
+}
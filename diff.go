@@ -0,0 +1,95 @@
+package jsontree
+
+import (
+	"strings"
+)
+
+// Diff returns a line-oriented description of the differences between the
+// branches of a and b, or an empty string if [Tree.Equal] reports them
+// equal, so that tests may assert that changes to generated queries leave a
+// compiled Tree unchanged. Each line shows the selectors of a segment,
+// indented four spaces for each level below the root, and prefixed like a
+// unified diff: "- " for a segment only in a, "+ " for a segment only in b,
+// and "  " for a segment in both that leads to differences. For example,
+// comparing the Trees for $.a.b and $.a.c, $..x returns:
+//
+//	  $
+//	      ["a"]
+//	-         ["b"]
+//	+         ["c"]
+//	+     ..["x"]
+//
+// Diff matches segments that have the same selectors and descendant flags,
+// regardless of their order, as Equal does, and reports a segment with
+// different selectors as a removal and an addition, along with all of its
+// descendants. It omits segments whose branches are the same in both. If a
+// and b use different array handling modes, Diff reports the root as removed
+// and added with the names of the modes. A root-only Tree has no branches,
+// so Diff reports all branches of the other Tree as removed or added.
+func Diff(a, b *Tree) string {
+	if a.Equal(b) {
+		return ""
+	}
+
+	buf := new(strings.Builder)
+
+	if a.index == b.index {
+		buf.WriteString("  $\n")
+	} else {
+		buf.WriteString("- $ " + modeName(a.index) + " mode\n")
+		buf.WriteString("+ $ " + modeName(b.index) + " mode\n")
+	}
+
+	diffChildren(buf, a.root, b.root, 1)
+
+	return buf.String()
+}
+
+// diffChildren writes the differences between the children of seg1 and
+// seg2, at depth levels below the root, to buf.
+func diffChildren(buf *strings.Builder, seg1, seg2 *segment, depth int) {
+	matched := make([]bool, len(seg2.children))
+
+C1:
+	for _, c1 := range seg1.children {
+		for i, c2 := range seg2.children {
+			if !matched[i] && c1.descendant == c2.descendant && c1.hasExactSelectors(c2.selectors) {
+				matched[i] = true
+
+				if !c1.sameBranches(c2) {
+					writeDiffLine(buf, "  ", c1, depth)
+					diffChildren(buf, c1, c2, depth+1)
+				}
+
+				continue C1
+			}
+		}
+
+		writeDiffBranch(buf, "- ", c1, depth)
+	}
+
+	for i, c2 := range seg2.children {
+		if !matched[i] {
+			writeDiffBranch(buf, "+ ", c2, depth)
+		}
+	}
+}
+
+// writeDiffBranch writes seg and all of its descendants to buf, each
+// preceded by marker.
+func writeDiffBranch(buf *strings.Builder, marker string, seg *segment, depth int) {
+	writeDiffLine(buf, marker, seg, depth)
+
+	for _, c := range seg.children {
+		writeDiffBranch(buf, marker, c, depth+1)
+	}
+}
+
+// writeDiffLine writes marker followed by the selectors of seg, indented
+// for depth, to buf.
+func writeDiffLine(buf *strings.Builder, marker string, seg *segment, depth int) {
+	buf.WriteString(marker)
+	buf.WriteString(strings.Repeat("    ", depth))
+	buf.WriteString(seg.selectorString())
+	buf.WriteByte('\n')
+}
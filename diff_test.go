@@ -0,0 +1,113 @@
+package jsontree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/theory/jsonpath"
+)
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		a     []string
+		b     []string
+		fixed bool
+		exp   string
+	}{
+		{
+			test: "same",
+			a:    []string{"$.a.b", "$..x"},
+			b:    []string{"$..x", "$.a.b"},
+		},
+		{
+			test: "both_root_only",
+		},
+		{
+			test: "equivalent_filters",
+			a:    []string{"$[?@.x > 1 && @.y]"},
+			b:    []string{"$[?@.y && 1 < @.x]"},
+		},
+		{
+			test: "added",
+			a:    []string{"$.a"},
+			b:    []string{"$.a", "$.b.c"},
+			exp:  "  $\n+     [\"b\"]\n+         [\"c\"]\n",
+		},
+		{
+			test: "removed",
+			a:    []string{"$.a", "$..b"},
+			b:    []string{"$.a"},
+			exp:  "  $\n-     ..[\"b\"]\n",
+		},
+		{
+			test: "nested",
+			a:    []string{"$.a.b"},
+			b:    []string{"$.a.c", "$..x"},
+			exp:  "  $\n      [\"a\"]\n-         [\"b\"]\n+         [\"c\"]\n+     ..[\"x\"]\n",
+		},
+		{
+			test: "changed_selectors",
+			a:    []string{"$.a[1,2].b"},
+			b:    []string{"$.a[1].b"},
+			exp:  "  $\n      [\"a\"]\n-         [1,2]\n-             [\"b\"]\n+         [1]\n+             [\"b\"]\n",
+		},
+		{
+			test: "descendant_flag",
+			a:    []string{"$.a"},
+			b:    []string{"$..a"},
+			exp:  "  $\n-     [\"a\"]\n+     ..[\"a\"]\n",
+		},
+		{
+			test: "shortened",
+			a:    []string{"$.a.b.c", "$.x.y"},
+			b:    []string{"$.a", "$.x.y"},
+			exp:  "  $\n      [\"a\"]\n-         [\"b\"]\n-             [\"c\"]\n",
+		},
+		{
+			test: "merged",
+			a:    []string{"$.a.b", "$.x"},
+			b:    []string{"$.a", "$.x"},
+			exp:  "  $\n-     [\"a\"]\n-         [\"b\"]\n-     [\"x\"]\n+     [\"a\",\"x\"]\n",
+		},
+		{
+			test: "root_only",
+			a:    []string{"$.a"},
+			b:    []string{"$"},
+			exp:  "  $\n-     [\"a\"]\n",
+		},
+		{
+			test:  "modes",
+			a:     []string{"$.a"},
+			b:     []string{"$.a"},
+			fixed: true,
+			exp:   "- $ ordered mode\n+ $ fixed mode\n",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			treeA := New(parse(tc.a)...)
+			treeB := New(parse(tc.b)...)
+			if tc.fixed {
+				treeB = NewFixedModeTree(parse(tc.b)...)
+			}
+
+			a.Equal(tc.exp, Diff(treeA, treeB))
+			a.Equal(tc.exp == "", treeA.Equal(treeB))
+		})
+	}
+}
+
+// parse parses each of paths.
+func parse(paths []string) []*jsonpath.Path {
+	res := make([]*jsonpath.Path, len(paths))
+	for i, p := range paths {
+		res[i] = jsonpath.MustParse(p)
+	}
+
+	return res
+}
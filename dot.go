@@ -0,0 +1,66 @@
+package jsontree
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DOT returns a Graphviz DOT representation of tree: a digraph with a node
+// for each segment, labeled by its selectors as in [Tree.String], and an
+// edge from each segment to each of its child segments. The root node is
+// labeled "$", and edges to descendant segments are dashed. Node IDs
+// combine the depth of a segment with its position among the segments at
+// that depth, so that the output is stable for diffs:
+//
+//	digraph jsontree {
+//	  n0_0 [label="$"];
+//	  n1_0 [label="[\"a\"]"];
+//	  n0_0 -> n1_0;
+//	  n2_0 [label="..[\"b\"]"];
+//	  n1_0 -> n2_0 [style=dashed];
+//	}
+func (tree *Tree) DOT() string {
+	buf := new(strings.Builder)
+	buf.WriteString("digraph jsontree {\n")
+	buf.WriteString("  n0_0 [label=\"$\"];\n")
+
+	// Count the segments written at each depth.
+	counts := []int{1}
+	tree.root.writeDOT(buf, "n0_0", 1, &counts)
+	buf.WriteString("}\n")
+
+	return buf.String()
+}
+
+// dotEscaper escapes labels for DOT quoted strings.
+//
+//nolint:gochecknoglobals
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// writeDOT writes a node for each of seg's child segments, which are at
+// depth depth, and an edge to it from id, seg's node ID, to buf, followed
+// by their child segments. counts records the number of segments written
+// at each depth.
+func (seg *segment) writeDOT(buf *strings.Builder, id string, depth int, counts *[]int) {
+	if len(*counts) <= depth {
+		*counts = append(*counts, 0)
+	}
+
+	for _, c := range seg.children {
+		cid := "n" + strconv.Itoa(depth) + "_" + strconv.Itoa((*counts)[depth])
+		(*counts)[depth]++
+
+		label := new(strings.Builder)
+		c.writeSelectors(&diagramWriter{w: label})
+
+		buf.WriteString("  " + cid + " [label=\"" + dotEscaper.Replace(label.String()) + "\"];\n")
+		buf.WriteString("  " + id + " -> " + cid)
+
+		if c.descendant {
+			buf.WriteString(" [style=dashed]")
+		}
+
+		buf.WriteString(";\n")
+		c.writeDOT(buf, cid, depth+1, counts)
+	}
+}
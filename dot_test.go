@@ -0,0 +1,70 @@
+package jsontree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/theory/jsonpath"
+)
+
+func TestDOT(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		exp   string
+	}{
+		{
+			test: "root_only",
+			exp:  "digraph jsontree {\n  n0_0 [label=\"$\"];\n}\n",
+		},
+		{
+			test:  "child_and_descendant",
+			paths: []string{"$.a..b"},
+			exp: `digraph jsontree {
+  n0_0 [label="$"];
+  n1_0 [label="[\"a\"]"];
+  n0_0 -> n1_0;
+  n2_0 [label="..[\"b\"]"];
+  n1_0 -> n2_0 [style=dashed];
+}
+`,
+		},
+		{
+			test:  "branches",
+			paths: []string{"$.a.x", "$.b[0,1]..y", "$.a.z.w", `$["q\"\\"]`},
+			exp: `digraph jsontree {
+  n0_0 [label="$"];
+  n1_0 [label="[\"a\"]"];
+  n0_0 -> n1_0;
+  n2_0 [label="[\"x\"]"];
+  n1_0 -> n2_0;
+  n2_1 [label="[\"z\"]"];
+  n1_0 -> n2_1;
+  n3_0 [label="[\"w\"]"];
+  n2_1 -> n3_0;
+  n1_1 [label="[\"b\"]"];
+  n0_0 -> n1_1;
+  n2_2 [label="[0,1]"];
+  n1_1 -> n2_2;
+  n3_1 [label="..[\"y\"]"];
+  n2_2 -> n3_1 [style=dashed];
+  n1_2 [label="[\"q\\\"\\\\\"]"];
+  n0_0 -> n1_2;
+}
+`,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			assert.Equal(t, tc.exp, New(paths...).DOT())
+		})
+	}
+}
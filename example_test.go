@@ -6,12 +6,16 @@ import (
 	"log"
 
 	"github.com/theory/jsonpath"
+	"github.com/theory/jsonpath/spec"
 	"github.com/theory/jsontree"
 )
 
 // Given a user profile as a JSON object, execute a JSONTree query that
-// creates a copy of the object that contains only fields named "last" and all
-// "primary" contacts of any type.
+// creates a copy of the object that contains only fields named "last" and the
+// "primary" field of any object named "contacts". As specified by RFC 9535,
+// the "primary" selector applies only to the "contacts" object itself, not to
+// the objects nested in it, so the copy includes no contacts; use
+// $.profile..contacts.*.primary to select them.
 func Example() {
 	// User profile fetched from storage. Contains more fields than we need.
 	src := []byte(`{
@@ -57,7 +61,8 @@ func Example() {
 	tree := jsontree.New(
 		// Select any field under "profile" named "last".
 		jsonpath.MustParse("$.profile..last"),
-		// Select the "primary" field from any object under "contacts".
+		// Select the "primary" field of any object under "profile" named
+		// "contacts".
 		jsonpath.MustParse("$.profile..contacts.primary"),
 	)
 
@@ -73,22 +78,6 @@ func Example() {
 	fmt.Println(string(js))
 	// Output: {
 	//   "profile": {
-	//     "contacts": {
-	//       "addresses": {
-	//         "primary": [
-	//           "123 Main Street",
-	//           "Chicago",
-	//           "IL",
-	//           "90210"
-	//         ]
-	//       },
-	//       "email": {
-	//         "primary": "foo@example.com"
-	//       },
-	//       "phones": {
-	//         "primary": "+1-234-567-8901"
-	//       }
-	//     },
 	//     "name": {
 	//       "last": "Obama"
 	//     }
@@ -111,10 +100,29 @@ func ExampleTree_String() {
 	// │   └── ..["contacts"]
 	// │       └── ["primary"]
 	// └── ["preferences"]
-	//     └── [0,2,1]
+	//     └── [0,1,2]
 	//         └── ["type","value"]
 }
 
+// Render a tree diagram using only ASCII characters.
+func ExampleTree_StringWith() {
+	tree := jsontree.New(
+		jsonpath.MustParse("$.profile..last"),
+		jsonpath.MustParse("$.profile..contacts.primary"),
+		jsonpath.MustParse(`$.preferences[0, 2]["type", "value"]`),
+	)
+	fmt.Print(tree.StringWith(jsontree.ASCIITreeStyle))
+	// Output:
+	// $
+	// +-- ["profile"]
+	// |   +-- ..["last"]
+	// |   `-- ..["contacts"]
+	// |       `-- ["primary"]
+	// `-- ["preferences"]
+	//     `-- [0,2]
+	//         `-- ["type","value"]
+}
+
 // Merge two path queries into an ordered mode JSONTree query. Note that the
 // second path selects the second item from the emails array, but the
 // [Tree.Select] returns it as the first item.
@@ -173,3 +181,155 @@ func ExampleNewFixedModeTree() {
 	// Output:
 	// {"emails":[null,"barrack@example.net"],"name":"Barrack Obama"}
 }
+
+// Use a Compiler to compile a JSONTree query that selects all of an object's
+// fields except those excluded by [jsontree.WithExcludeKeys].
+func ExampleCompiler() {
+	value := map[string]any{
+		"name":  "Barrack Obama",
+		"years": "2009-2017",
+		"ssn":   "123-45-6789",
+	}
+
+	compiler := jsontree.NewCompiler(jsontree.WithExcludeKeys("ssn"))
+	tree := compiler.New(jsonpath.MustParse("$.*"))
+
+	js, err := json.Marshal(tree.Select(value))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(string(js))
+	// Output:
+	// {"name":"Barrack Obama","years":"2009-2017"}
+}
+
+// Determine whether a set of selectors selects all the values selected by
+// another selector.
+func ExampleSelectorContains() {
+	set := []spec.Selector{spec.Name("a"), spec.Slice(0, 8, 2)}
+
+	fmt.Println(jsontree.SelectorContains(set, spec.Name("a")))
+	fmt.Println(jsontree.SelectorContains(set, spec.Index(4)))
+	fmt.Println(jsontree.SelectorContains(set, spec.Index(5)))
+	fmt.Println(jsontree.SelectorContains(set, spec.Slice(2, 6, 2)))
+	fmt.Println(jsontree.SelectorContains([]spec.Selector{spec.Wildcard()}, spec.Name("b")))
+	// Output:
+	// true
+	// true
+	// false
+	// true
+	// true
+}
+
+// Determine whether a set of selectors selects an index from an array of a
+// known length.
+func ExampleSelectorContainsIndex() {
+	set := []spec.Selector{spec.Index(-1), spec.Slice(-4, nil, 2)}
+
+	fmt.Println(jsontree.SelectorContainsIndex(set, spec.Index(9), 10))
+	fmt.Println(jsontree.SelectorContainsIndex(set, spec.Index(6), 10))
+	fmt.Println(jsontree.SelectorContainsIndex(set, spec.Index(7), 10))
+	fmt.Println(jsontree.SelectorContainsIndex(set, spec.Index(-3), 5))
+	// Output:
+	// true
+	// true
+	// false
+	// false
+}
+
+// Determine whether one slice selects all the indexes selected by another.
+func ExampleSliceContainsSlice() {
+	fmt.Println(jsontree.SliceContainsSlice(spec.Slice(0, 10), spec.Slice(2, 5)))
+	fmt.Println(jsontree.SliceContainsSlice(spec.Slice(0, 10, 2), spec.Slice(2, 6, 4)))
+	fmt.Println(jsontree.SliceContainsSlice(spec.Slice(0, 10, 2), spec.Slice(1, 5, 2)))
+	fmt.Println(jsontree.SliceContainsSlice(spec.Slice(2, 4), spec.Slice(1, 3)))
+	fmt.Println(jsontree.SliceContainsSlice(spec.Slice(2, 4), spec.Slice(5, 9, 0)))
+	// Output:
+	// true
+	// true
+	// false
+	// false
+	// true
+}
+
+// Traverse the segments of a Tree to render a custom outline of its paths.
+func ExampleTree_Root() {
+	tree := jsontree.New(
+		jsonpath.MustParse("$.profile.name"),
+		jsonpath.MustParse("$.profile..email"),
+		jsonpath.MustParse("$.tags[0,1]"),
+	)
+
+	var walk func(seg *jsontree.Segment, depth int)
+	walk = func(seg *jsontree.Segment, depth int) {
+		for _, c := range seg.Children() {
+			kind := "child"
+			if c.IsDescendant() {
+				kind = "descendant"
+			}
+			fmt.Printf("%*s%v (%v)\n", depth*2, "", c.Selectors(), kind)
+			walk(c, depth+1)
+		}
+	}
+	walk(tree.Root(), 0)
+	// Output:
+	// ["profile"] (child)
+	//   ["name"] (child)
+	//   ["email"] (descendant)
+	// ["tags"] (child)
+	//   [0 1] (child)
+}
+
+// Build a Tree from JSONPath segments rather than query strings.
+func ExampleBuild() {
+	tree := jsontree.Build(
+		jsontree.Child(spec.Name("profile")).Append(
+			jsontree.Child(spec.Name("name")),
+			jsontree.Descendant(spec.Name("email")),
+		),
+		jsontree.Child(spec.Name("tags")).Child(spec.Index(0), spec.Index(1)),
+	)
+	fmt.Printf("%v\n", tree)
+	// Output:
+	// $
+	// ├── ["profile"]
+	// │   ├── ["name"]
+	// │   └── ..["email"]
+	// └── ["tags"]
+	//     └── [0,1]
+}
+
+// Format selectors as Tree diagrams display them.
+func ExampleFormatSelectors() {
+	fmt.Println(jsontree.FormatSelectors([]spec.Selector{spec.Name("a"), spec.Index(42), spec.Slice(nil, 8, 2)}))
+	fmt.Println(jsontree.FormatSelectors([]spec.Selector{spec.Wildcard()}))
+	// Output:
+	// [:8:2,"a",42]
+	// [*]
+}
+
+// Determine whether a slice selects nothing from arrays of any length.
+func ExampleSliceIsEmpty() {
+	fmt.Println(jsontree.SliceIsEmpty(spec.Slice(1, 3, 0)))
+	fmt.Println(jsontree.SliceIsEmpty(spec.Slice(3, 1)))
+	fmt.Println(jsontree.SliceIsEmpty(spec.Slice(1, 3)))
+	fmt.Println(jsontree.SliceIsEmpty(spec.Slice(1, -1)))
+	// Output:
+	// true
+	// true
+	// false
+	// false
+}
+
+func ExampleDiff() {
+	before := jsontree.New(jsonpath.MustParse("$.a.b"))
+	after := jsontree.New(jsonpath.MustParse("$.a.c"), jsonpath.MustParse("$..x"))
+	fmt.Print(jsontree.Diff(before, after))
+	// Output:
+	//   $
+	//       ["a"]
+	// -         ["b"]
+	// +         ["c"]
+	// +     ..["x"]
+}
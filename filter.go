@@ -0,0 +1,140 @@
+package jsontree
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/theory/jsonpath/spec"
+)
+
+// normalizeFilter returns a canonical string representation of filter, so
+// that logically-equivalent filters have the same representation. It sorts
+// and deduplicates the operands of logical OR and AND expressions, rewrites
+// > and >= comparisons as < and <= comparisons by swapping their operands,
+// and sorts the operands of commutative == and != comparisons.
+func normalizeFilter(filter *spec.FilterSelector) string {
+	return "?" + normalizeOr(filter.LogicalOr)
+}
+
+// normalizeOr returns a canonical string representation of the logical OR
+// expression or.
+func normalizeOr(or spec.LogicalOr) string {
+	ands := make([]string, len(or))
+	for i, and := range or {
+		ands[i] = normalizeAnd(and)
+	}
+
+	slices.Sort(ands)
+
+	return strings.Join(slices.Compact(ands), " || ")
+}
+
+// normalizeAnd returns a canonical string representation of the logical AND
+// expression and.
+func normalizeAnd(and spec.LogicalAnd) string {
+	exprs := make([]string, len(and))
+	for i, expr := range and {
+		exprs[i] = normalizeExpr(expr)
+	}
+
+	slices.Sort(exprs)
+
+	return strings.Join(slices.Compact(exprs), " && ")
+}
+
+// normalizeExpr returns a canonical string representation of expr. It
+// prefixes negated expressions with "!" itself rather than relying on their
+// String methods, some of which omit it, so that an expression and its
+// negation never have the same representation.
+func normalizeExpr(expr spec.BasicExpr) string {
+	switch expr := expr.(type) {
+	case *spec.ParenExpr:
+		return "(" + normalizeOr(expr.LogicalOr) + ")"
+	case *spec.NotParenExpr:
+		return "!(" + normalizeOr(expr.LogicalOr) + ")"
+	case *spec.CompExpr:
+		return normalizeComparison(expr.String())
+	case *spec.ExistExpr:
+		return expr.PathQuery.String()
+	case *spec.NonExistExpr:
+		return "!" + expr.PathQuery.String()
+	case spec.NonExistExpr:
+		return "!" + expr.PathQuery.String()
+	case *spec.FuncExpr:
+		return expr.String()
+	case spec.NotFuncExpr:
+		return "!" + expr.FuncExpr.String()
+	case *spec.NotFuncExpr:
+		return "!" + expr.FuncExpr.String()
+	default:
+		return expr.String()
+	}
+}
+
+// comparisonOps lists the string representations of the comparison
+// operators, with longer operators before their prefixes.
+//
+//nolint:gochecknoglobals
+var comparisonOps = []string{" == ", " != ", " <= ", " >= ", " < ", " > "}
+
+// normalizeComparison returns a canonical string representation of cmp, the
+// string representation of a [spec.CompExpr]. It swaps the operands of >
+// and >= comparisons to express them as < and <= comparisons, and sorts the
+// operands of == and != comparisons. Returns cmp unchanged if it cannot find
+// the operator.
+func normalizeComparison(cmp string) string {
+	left, op, right, ok := splitComparison(cmp)
+	if !ok {
+		return cmp
+	}
+
+	switch op {
+	case " > ":
+		left, op, right = right, " < ", left
+	case " >= ":
+		left, op, right = right, " <= ", left
+	case " == ", " != ":
+		if right < left {
+			left, right = right, left
+		}
+	}
+
+	return left + op + right
+}
+
+// splitComparison splits cmp, the string representation of a
+// [spec.CompExpr], into its left operand, operator, and right operand. It
+// finds the first operator outside of string literals, brackets, and
+// parentheses, so that it ignores operators in operands such as filter
+// queries passed to functions. Returns false if it finds no operator.
+func splitComparison(cmp string) (string, string, string, bool) {
+	depth := 0
+	quoted := false
+
+	for i := 0; i < len(cmp); i++ {
+		switch c := cmp[i]; {
+		case quoted:
+			switch c {
+			case '\\':
+				// Skip the escaped character.
+				i++
+			case '"':
+				quoted = false
+			}
+		case c == '"':
+			quoted = true
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+		case c == ' ' && depth == 0:
+			for _, op := range comparisonOps {
+				if strings.HasPrefix(cmp[i:], op) {
+					return cmp[:i], op, cmp[i+len(op):], true
+				}
+			}
+		}
+	}
+
+	return "", "", "", false
+}
@@ -0,0 +1,157 @@
+package jsontree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/theory/jsonpath"
+)
+
+func TestNormalizeFilter(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test   string
+		filter string
+		exp    string
+	}{
+		{
+			test:   "exists",
+			filter: "$[?@.a]",
+			exp:    `?@["a"]`,
+		},
+		{
+			test:   "gt",
+			filter: "$[?@.a > 1]",
+			exp:    `?1 < @["a"]`,
+		},
+		{
+			test:   "ge",
+			filter: "$[?@.a >= 1]",
+			exp:    `?1 <= @["a"]`,
+		},
+		{
+			test:   "lt",
+			filter: "$[?@.a < 1]",
+			exp:    `?@["a"] < 1`,
+		},
+		{
+			test:   "eq",
+			filter: "$[?@.b == @.a]",
+			exp:    `?@["a"] == @["b"]`,
+		},
+		{
+			test:   "ne",
+			filter: "$[?@.b != @.a]",
+			exp:    `?@["a"] != @["b"]`,
+		},
+		{
+			test:   "and_or",
+			filter: "$[?@.c || @.b && @.a || @.c]",
+			exp:    `?@["a"] && @["b"] || @["c"]`,
+		},
+		{
+			test:   "paren",
+			filter: "$[?(@.b || @.a) && !(@.d || @.c)]",
+			exp:    `?!(@["c"] || @["d"]) && (@["a"] || @["b"])`,
+		},
+		{
+			test:   "string_with_op",
+			filter: `$[?"x > y" == @.a]`,
+			exp:    `?"x > y" == @["a"]`,
+		},
+		{
+			test:   "escaped_quote",
+			filter: `$[?@.a > "x\" > y"]`,
+			exp:    `?"x\" > y" < @["a"]`,
+		},
+		{
+			test:   "name_with_op",
+			filter: `$[?@["a > b"] > 1]`,
+			exp:    `?1 < @["a > b"]`,
+		},
+		{
+			test:   "not_exists",
+			filter: "$[?!@.a]",
+			exp:    `?!@["a"]`,
+		},
+		{
+			test:   "not_exists_and",
+			filter: "$[?@.x && !@.a]",
+			exp:    `?!@["a"] && @["x"]`,
+		},
+		{
+			test:   "not_function",
+			filter: "$[?!match(@.a, 'x')]",
+			exp:    `?!match(@["a"], "x")`,
+		},
+		{
+			test:   "function",
+			filter: "$[?match(@.a, 'x')]",
+			exp:    `?match(@["a"], "x")`,
+		},
+		{
+			test:   "function_with_filter",
+			filter: `$[?count(@.x[?@ > 1]) >= 2]`,
+			exp:    `?2 <= count(@["x"][?@ > 1])`,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.exp, normalizeFilter(mkFilter(tc.filter)))
+		})
+	}
+}
+
+func TestSplitComparison(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	left, op, right, ok := splitComparison(`@["a"] <= "b"`)
+	a.True(ok)
+	a.Equal([]string{`@["a"]`, " <= ", `"b"`}, []string{left, op, right})
+
+	_, _, _, ok = splitComparison(`@["a b"]`)
+	a.False(ok)
+	a.Equal(`@["a == b"]`, normalizeComparison(`@["a == b"]`))
+}
+
+func TestNewDedupesEquivalentFilters(t *testing.T) {
+	t.Parallel()
+
+	tree := New(
+		jsonpath.MustParse(`$[?@.x == 1 && @.y]`),
+		jsonpath.MustParse(`$[?@.y && 1 == @.x]`),
+	)
+	assert.Equal(t, "$\n└── [?@[\"x\"] == 1 && @[\"y\"]]\n", tree.String())
+}
+
+func TestNewKeepsNegatedFilters(t *testing.T) {
+	t.Parallel()
+
+	input := []any{map[string]any{"a": 1, "b": "1", "x": true}, map[string]any{"b": "2", "x": true}}
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		exp   []any
+	}{
+		{"not_exists", []string{"$[?!@.a]", "$[?@.a]"}, input},
+		{"exists_not", []string{"$[?@.a]", "$[?!@.a]"}, input},
+		{"and", []string{"$[?@.x && !@.a]", "$[?@.x && @.a]"}, input},
+		{"function", []string{"$[?!match(@.b, '1')]", "$[?match(@.b, '1')]"}, input},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := New(paths...)
+			a.Len(tree.root.children[0].selectors, 2)
+			a.Equal(tc.exp, tree.Select(input))
+		})
+	}
+}
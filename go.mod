@@ -5,11 +5,11 @@ go 1.23
 require (
 	github.com/stretchr/testify v1.10.0
 	github.com/theory/jsonpath v0.10.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,208 @@
+package jsontree
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// ErrDestination errors are returned by [Tree.SelectIntoMode] when the
+// destination is not a map[string]any or *[]any, or is not the same type of
+// value as the source.
+var ErrDestination = errors.New("jsontree: invalid destination")
+
+// SelectIntoMode selects tree's paths from the from JSON value into dst,
+// which must be a map[string]any when from is a map[string]any, or a *[]any
+// when from is a []any. It first clears dst, so that callers may reuse a
+// single destination value to reduce allocations when selecting from many
+// values. Nested objects and arrays are always newly allocated.
+//
+// The fixed argument determines array handling for this call regardless of
+// how tree was created: pass true for the behavior of [NewFixedModeTree],
+// which preserves the indexes of selected values, and false for the behavior
+// of [New], which preserves their order. Returns an [ErrDestination] error if
+// dst is not a supported type or does not match the type of from, and an
+// [ErrSliceBounds] error under the same conditions as [Tree.SelectE].
+func (tree *Tree) SelectIntoMode(from, dst any, fixed bool) error {
+	var err error
+
+	sel := *tree
+	sel.index = fixed
+	sel.errp = &err
+
+	switch dst := dst.(type) {
+	case map[string]any:
+		src, ok := from.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%w: cannot select %T into %T", ErrDestination, from, dst)
+		}
+
+		clear(dst)
+		sel.selectObjectInto(src, dst)
+	case *[]any:
+		src, ok := from.([]any)
+		if !ok || dst == nil {
+			return fmt.Errorf("%w: cannot select %T into %T", ErrDestination, from, dst)
+		}
+
+		*dst = sel.selectArrayInto(src, *dst)
+	default:
+		return fmt.Errorf("%w: cannot select into %T", ErrDestination, dst)
+	}
+
+	return err
+}
+
+// selectObjectInto selects tree's paths from src into dst.
+func (tree *Tree) selectObjectInto(src, dst map[string]any) {
+	if len(tree.root.children) == 0 {
+		for k, v := range src {
+			dst[k] = tree.ownValue(v)
+		}
+
+		return
+	}
+
+	tree.selectObject(tree.root.children, src, src, dst, 0)
+}
+
+// selectArrayInto selects tree's paths from src into buf's underlying array
+// if it has sufficient capacity, and otherwise into a newly-allocated slice.
+// Returns the selected values.
+func (tree *Tree) selectArrayInto(src, buf []any) []any {
+	if cap(buf) < cap(src) {
+		buf = make([]any, 0, cap(src))
+	} else {
+		// Release references to previously selected values.
+		buf = buf[:cap(buf)]
+		clear(buf)
+		buf = buf[:0]
+	}
+
+	if len(tree.root.children) == 0 {
+		for _, v := range src {
+			buf = append(buf, tree.ownValue(v))
+		}
+
+		return buf
+	}
+
+	return tree.selectArray(tree.root.children, src, src, buf, 0)
+}
+
+// SelectInto selects tree's paths from the from JSON object and merges the
+// selected values into dst, so that the selections of several Trees from
+// the same value accumulate in a single object. Does nothing if from is not
+// a map[string]any. Values merge into those already in dst as follows:
+//
+//   - Objects merge recursively by key.
+//   - Arrays selected by fixed mode Trees merge recursively by index; an
+//     array position not selected by tree keeps its existing value.
+//   - Arrays selected by ordered mode Trees, whose values have lost their
+//     original indexes, replace existing values.
+//   - All other values, and values of a different type than the existing
+//     value, replace existing values: later writes win.
+//
+// SelectInto modifies dst itself, but copies any nested object or array in
+// dst before merging into it, so it never modifies values shared with from.
+func (tree *Tree) SelectInto(from any, dst map[string]any) {
+	src, ok := from.(map[string]any)
+	if !ok {
+		return
+	}
+
+	sel, ok := tree.selectForMerge(src).(map[string]any)
+	if ok {
+		tree.mergeObject(dst, sel)
+	}
+}
+
+// SelectIntoArray selects tree's paths from the from JSON array and merges
+// the selected values into the array dst points to, as described for
+// [Tree.SelectInto]. Selections by fixed mode Trees merge by index, growing
+// the array as necessary, while selections by ordered mode Trees replace it.
+// Does nothing if from is not a []any or dst is nil.
+func (tree *Tree) SelectIntoArray(from any, dst *[]any) {
+	src, ok := from.([]any)
+	if !ok || dst == nil {
+		return
+	}
+
+	if sel, ok := tree.selectForMerge(src).([]any); ok {
+		*dst = tree.mergeArray(*dst, sel)
+	}
+}
+
+// selectForMerge selects tree's paths from src for merging. In fixed mode it
+// marks unselected array positions with unselected, so that merging can
+// distinguish them from selected nulls.
+func (tree *Tree) selectForMerge(src any) any {
+	sel := *tree
+	if sel.index {
+		sel.gap = unselected
+	}
+
+	return sel.Select(src)
+}
+
+// mergeValue merges src into dst and returns the result, copying dst rather
+// than modifying it.
+func (tree *Tree) mergeValue(dst, src any) any {
+	switch src := src.(type) {
+	case map[string]any:
+		if obj, ok := dst.(map[string]any); ok {
+			obj = maps.Clone(obj)
+			tree.mergeObject(obj, src)
+
+			return obj
+		}
+
+		tree.fillGaps(src)
+	case []any:
+		if ary, ok := dst.([]any); ok && tree.index {
+			return tree.mergeArray(slices.Clone(ary), src)
+		}
+
+		tree.fillGaps(src)
+	}
+
+	return src
+}
+
+// mergeObject merges the values in src into dst, modifying dst.
+func (tree *Tree) mergeObject(dst, src map[string]any) {
+	for k, v := range src {
+		dst[k] = tree.mergeValue(dst[k], v)
+	}
+}
+
+// mergeArray merges src into dst by index if tree is in fixed mode,
+// modifying and returning dst, and otherwise returns src.
+func (tree *Tree) mergeArray(dst, src []any) []any {
+	if !tree.index {
+		return src
+	}
+
+	if len(src) > len(dst) {
+		dst = slices.Grow(dst, len(src)-len(dst))
+		for i := len(dst); i < len(src); i++ {
+			dst = append(dst, unselected)
+		}
+	}
+
+	for i, v := range src {
+		if _, skip := v.(gapVal); !skip {
+			dst[i] = tree.mergeValue(dst[i], v)
+		}
+	}
+
+	// Replace positions unselected by any Tree with the gap value.
+	for i, v := range dst {
+		if _, ok := v.(gapVal); ok {
+			dst[i] = tree.gap
+		}
+	}
+
+	return dst
+}
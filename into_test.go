@@ -0,0 +1,353 @@
+package jsontree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theory/jsonpath"
+)
+
+func TestSelectIntoMode(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test    string
+		paths   []string
+		inputs  []any
+		ordered []any
+		fixed   []any
+	}{
+		{
+			test:  "object_names",
+			paths: []string{"$.a", "$.c"},
+			inputs: []any{
+				map[string]any{"a": 1, "b": 2, "c": 3},
+				map[string]any{"a": 4, "b": 5},
+			},
+			ordered: []any{
+				map[string]any{"a": 1, "c": 3},
+				map[string]any{"a": 4},
+			},
+			fixed: []any{
+				map[string]any{"a": 1, "c": 3},
+				map[string]any{"a": 4},
+			},
+		},
+		{
+			test:  "nested_arrays",
+			paths: []string{"$.a[1]"},
+			inputs: []any{
+				map[string]any{"a": []any{1, 2, 3}},
+				map[string]any{"a": []any{4, 5}},
+			},
+			ordered: []any{
+				map[string]any{"a": []any{2}},
+				map[string]any{"a": []any{5}},
+			},
+			fixed: []any{
+				map[string]any{"a": []any{nil, 2}},
+				map[string]any{"a": []any{nil, 5}},
+			},
+		},
+		{
+			test:  "array_indexes",
+			paths: []string{"$[1]", "$[3]"},
+			inputs: []any{
+				[]any{"a", "b", "c", "d", "e"},
+				[]any{"f", "g"},
+				[]any{"h"},
+			},
+			ordered: []any{
+				[]any{"b", "d"},
+				[]any{"g"},
+				[]any{},
+			},
+			fixed: []any{
+				[]any{nil, "b", nil, "d"},
+				[]any{nil, "g"},
+				[]any{},
+			},
+		},
+		{
+			test:  "array_grows",
+			paths: []string{"$[2]"},
+			inputs: []any{
+				[]any{"a"},
+				[]any{"a", "b", "c", "d"},
+			},
+			ordered: []any{
+				[]any{},
+				[]any{"c"},
+			},
+			fixed: []any{
+				[]any{},
+				[]any{nil, nil, "c"},
+			},
+		},
+		{
+			test:  "array_null",
+			paths: []string{"$[0,1]"},
+			inputs: []any{
+				[]any{nil, "a"},
+				[]any{"b", nil},
+			},
+			ordered: []any{
+				[]any{nil, "a"},
+				[]any{"b", nil},
+			},
+			fixed: []any{
+				[]any{nil, "a"},
+				[]any{"b", nil},
+			},
+		},
+		{
+			test:  "root_only",
+			paths: []string{"$"},
+			inputs: []any{
+				[]any{"a", "b"},
+				map[string]any{"a": 1},
+			},
+			ordered: []any{
+				[]any{"a", "b"},
+				map[string]any{"a": 1},
+			},
+			fixed: []any{
+				[]any{"a", "b"},
+				map[string]any{"a": 1},
+			},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+			tree := New(paths...)
+
+			for _, mode := range []struct {
+				fixed bool
+				exp   []any
+			}{
+				{false, tc.ordered},
+				{true, tc.fixed},
+			} {
+				// Reuse the same destinations for every input.
+				obj := map[string]any{}
+				arr := []any{}
+
+				for i, input := range tc.inputs {
+					switch input.(type) {
+					case map[string]any:
+						require.NoError(t, tree.SelectIntoMode(input, obj, mode.fixed))
+						assert.Equal(t, mode.exp[i], obj)
+					case []any:
+						require.NoError(t, tree.SelectIntoMode(input, &arr, mode.fixed))
+						assert.Equal(t, mode.exp[i], arr)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSelectIntoModeReuse(t *testing.T) {
+	t.Parallel()
+
+	tree := New(jsonpath.MustParse("$[0,2]"))
+	buf := make([]any, 0, 8)
+
+	require.NoError(t, tree.SelectIntoMode([]any{1, 2, 3}, &buf, true))
+	assert.Equal(t, []any{1, nil, 3}, buf)
+	assert.Equal(t, 8, cap(buf))
+
+	require.NoError(t, tree.SelectIntoMode([]any{4, 5, 6, 7}, &buf, false))
+	assert.Equal(t, []any{4, 6}, buf)
+	assert.Equal(t, 8, cap(buf))
+
+	// Stale values must not leak into fixed-mode gaps.
+	tree = New(jsonpath.MustParse("$[2]"))
+	require.NoError(t, tree.SelectIntoMode([]any{7, 8, 9}, &buf, true))
+	assert.Equal(t, []any{nil, nil, 9}, buf)
+}
+
+func TestSelectIntoModeErrors(t *testing.T) {
+	t.Parallel()
+
+	tree := New(jsonpath.MustParse("$.a"))
+	var nilArr *[]any
+
+	for _, tc := range []struct {
+		test string
+		from any
+		dst  any
+		err  string
+	}{
+		{
+			test: "array_into_object",
+			from: []any{1},
+			dst:  map[string]any{},
+			err:  "jsontree: invalid destination: cannot select []interface {} into map[string]interface {}",
+		},
+		{
+			test: "object_into_array",
+			from: map[string]any{"a": 1},
+			dst:  &[]any{},
+			err:  "jsontree: invalid destination: cannot select map[string]interface {} into *[]interface {}",
+		},
+		{
+			test: "nil_array_pointer",
+			from: []any{1},
+			dst:  nilArr,
+			err:  "jsontree: invalid destination: cannot select []interface {} into *[]interface {}",
+		},
+		{
+			test: "unsupported_destination",
+			from: []any{1},
+			dst:  []any{},
+			err:  "jsontree: invalid destination: cannot select into []interface {}",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			err := tree.SelectIntoMode(tc.from, tc.dst, false)
+			require.EqualError(t, err, tc.err)
+			require.ErrorIs(t, err, ErrDestination)
+		})
+	}
+}
+
+func TestSelectInto(t *testing.T) {
+	t.Parallel()
+
+	mkInput := func() map[string]any {
+		return map[string]any{
+			"a": map[string]any{"b": 1, "c": 2, "d": map[string]any{"e": 3, "f": 4}},
+			"x": []any{"x0", nil, "x2", map[string]any{"y": 5, "z": 6}},
+			"s": "hi",
+		}
+	}
+
+	for _, tc := range []struct {
+		test    string
+		paths   [][]string
+		dst     map[string]any
+		ordered map[string]any
+		fixed   map[string]any
+	}{
+		{
+			test:    "merge_objects",
+			paths:   [][]string{{"$.a.b"}, {"$.a.d.e"}, {"$.s"}},
+			ordered: map[string]any{"a": map[string]any{"b": 1, "d": map[string]any{"e": 3}}, "s": "hi"},
+			fixed:   map[string]any{"a": map[string]any{"b": 1, "d": map[string]any{"e": 3}}, "s": "hi"},
+		},
+		{
+			test:    "existing_dst",
+			paths:   [][]string{{"$.a.c"}},
+			dst:     map[string]any{"a": map[string]any{"q": true}, "r": false},
+			ordered: map[string]any{"a": map[string]any{"q": true, "c": 2}, "r": false},
+			fixed:   map[string]any{"a": map[string]any{"q": true, "c": 2}, "r": false},
+		},
+		{
+			test:    "merge_arrays",
+			paths:   [][]string{{"$.x[0]"}, {"$.x[1]", "$.x[3].z"}, {"$.x[3].y"}},
+			ordered: map[string]any{"x": []any{map[string]any{"y": 5}}},
+			fixed: map[string]any{"x": []any{
+				"x0", nil, nil, map[string]any{"y": 5, "z": 6},
+			}},
+		},
+		{
+			test:    "later_scalar_wins",
+			paths:   [][]string{{"$.a.d.e"}},
+			dst:     map[string]any{"a": map[string]any{"d": "old"}},
+			ordered: map[string]any{"a": map[string]any{"d": map[string]any{"e": 3}}},
+			fixed:   map[string]any{"a": map[string]any{"d": map[string]any{"e": 3}}},
+		},
+		{
+			test:    "scalar_replaces_object",
+			paths:   [][]string{{"$.a.d.e"}, {"$.s"}},
+			dst:     map[string]any{"s": map[string]any{"q": 1}},
+			ordered: map[string]any{"a": map[string]any{"d": map[string]any{"e": 3}}, "s": "hi"},
+			fixed:   map[string]any{"a": map[string]any{"d": map[string]any{"e": 3}}, "s": "hi"},
+		},
+		{
+			test:    "whole_then_part",
+			paths:   [][]string{{"$.a"}, {"$.a.d.e"}},
+			ordered: map[string]any{"a": mkInput()["a"]},
+			fixed:   map[string]any{"a": mkInput()["a"]},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			for _, mode := range []struct {
+				mk  func(...*jsonpath.Path) *Tree
+				exp map[string]any
+			}{
+				{New, tc.ordered},
+				{NewFixedModeTree, tc.fixed},
+			} {
+				dst := map[string]any{}
+				for k, v := range tc.dst {
+					dst[k] = v
+				}
+
+				input := mkInput()
+				for _, strs := range tc.paths {
+					paths := make([]*jsonpath.Path, len(strs))
+					for i, p := range strs {
+						paths[i] = jsonpath.MustParse(p)
+					}
+					mode.mk(paths...).SelectInto(input, dst)
+				}
+
+				a.Equal(mode.exp, dst)
+				a.Equal(mkInput(), input, "input modified")
+			}
+		})
+	}
+
+	t.Run("not_object", func(t *testing.T) {
+		t.Parallel()
+		dst := map[string]any{"a": 1}
+		New(jsonpath.MustParse("$[0]")).SelectInto([]any{1}, dst)
+		assert.Equal(t, map[string]any{"a": 1}, dst)
+	})
+
+	t.Run("gap_value", func(t *testing.T) {
+		t.Parallel()
+		c := NewCompiler(WithGapValue("GAP"))
+		dst := map[string]any{}
+		input := mkInput()
+		c.NewFixedModeTree(jsonpath.MustParse("$.x[3].y")).SelectInto(input, dst)
+		c.NewFixedModeTree(jsonpath.MustParse("$.x[1]")).SelectInto(input, dst)
+		assert.Equal(t, map[string]any{"x": []any{
+			"GAP", nil, "GAP", map[string]any{"y": 5},
+		}}, dst)
+	})
+}
+
+func TestSelectIntoArray(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	input := []any{"a", map[string]any{"x": 1, "y": 2}, "c", nil}
+
+	dst := []any{}
+	NewFixedModeTree(jsonpath.MustParse("$[1].x")).SelectIntoArray(input, &dst)
+	NewFixedModeTree(jsonpath.MustParse("$[1].y")).SelectIntoArray(input, &dst)
+	NewFixedModeTree(jsonpath.MustParse("$[3]")).SelectIntoArray(input, &dst)
+	a.Equal([]any{nil, map[string]any{"x": 1, "y": 2}, nil, nil}, dst)
+
+	// Ordered mode replaces the array.
+	dst = []any{"old"}
+	New(jsonpath.MustParse("$[2]")).SelectIntoArray(input, &dst)
+	a.Equal([]any{"c"}, dst)
+
+	// Not an array.
+	New(jsonpath.MustParse("$[2]")).SelectIntoArray(map[string]any{}, &dst)
+	a.Equal([]any{"c"}, dst)
+	a.NotPanics(func() { New().SelectIntoArray(input, nil) })
+}
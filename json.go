@@ -0,0 +1,685 @@
+package jsontree
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/theory/jsonpath"
+	"github.com/theory/jsonpath/spec"
+)
+
+// ErrJSON errors are returned by [Tree.SelectRaw], [Tree.SelectBytes],
+// [Tree.SelectReader], [Tree.SelectString], [SelectTyped], and
+// [Tree.UnmarshalJSON].
+var ErrJSON = errors.New("jsontree: json")
+
+// SelectRaw decodes src, selects tree's paths from the result, and returns
+// the selected value encoded as JSON. Numbers are decoded as [json.Number]
+// values, so that they are encoded exactly as they appear in src. Useful for
+// selecting from [json.RawMessage] fields without decoding the structs that
+// contain them. Returns [ErrJSON] if src is not a single valid JSON value or
+// if the selected value cannot be encoded, and the errors returned by
+// [Tree.SelectE] if selection fails. Objects are encoded with sorted keys
+// unless tree was configured by [WithSourceKeyOrder].
+func (tree *Tree) SelectRaw(src json.RawMessage) (json.RawMessage, error) {
+	value, err := decodeJSON(src, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if tree.keyOrder {
+		return tree.selectRawOrdered(value, src)
+	}
+
+	sel, err := tree.SelectE(value)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := json.Marshal(sel)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJSON, err)
+	}
+
+	return out, nil
+}
+
+// SelectString selects tree's paths from the from JSON value with
+// [Tree.Select] and returns the result encoded as JSON indented by two
+// spaces, without escaping HTML characters such as < and &. Useful for
+// logging and debugging. Returns [ErrJSON] if the selected value cannot be
+// encoded.
+func (tree *Tree) SelectString(from any) (string, error) {
+	var buf strings.Builder
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(tree.Select(from)); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrJSON, err)
+	}
+
+	// Encode appends a newline.
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// SelectTyped selects tree's paths from the from JSON value with
+// [Tree.Select] and decodes the result into a new value of type T by
+// encoding it as JSON and decoding the JSON with [json.Unmarshal], so that
+// callers may select directly into a struct. Returns the zero value of T
+// and an [ErrJSON] error if the selected value cannot be encoded or cannot
+// be decoded into T. A function rather than a method, since methods cannot
+// have type parameters.
+func SelectTyped[T any](tree *Tree, from any) (T, error) {
+	var res T
+
+	data, err := json.Marshal(tree.Select(from))
+	if err != nil {
+		return res, fmt.Errorf("%w: %w", ErrJSON, err)
+	}
+
+	if err := json.Unmarshal(data, &res); err != nil {
+		var zero T
+		return zero, fmt.Errorf("%w: %w", ErrJSON, err)
+	}
+
+	return res, nil
+}
+
+// selectRawOrdered selects tree's paths from value, decoded from src, and
+// encodes the result with object keys in the order they appear in src.
+func (tree *Tree) selectRawOrdered(value any, src json.RawMessage) (json.RawMessage, error) {
+	// Select in fixed mode and mark unselected array positions, so that the
+	// selected values line up with their sources in src.
+	sel := *tree
+	sel.index = true
+	sel.gap = unselected
+
+	res, err := sel.SelectE(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tree.encodeOrdered(&buf, res, src); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJSON, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodeOrdered writes the JSON encoding of val, selected from src, to buf,
+// ordering object keys as they appear in src.
+func (tree *Tree) encodeOrdered(buf *bytes.Buffer, val any, src json.RawMessage) error {
+	switch val := val.(type) {
+	case map[string]any:
+		return tree.encodeObject(buf, val, src)
+	case []any:
+		return tree.encodeArray(buf, val, src)
+	default:
+		return encodeValue(buf, val)
+	}
+}
+
+// encodeObject writes the JSON encoding of obj, selected from src, to buf,
+// ordering its keys as they appear in src.
+func (tree *Tree) encodeObject(buf *bytes.Buffer, obj map[string]any, src json.RawMessage) error {
+	keys, members, err := decodeMembers(src)
+	if err != nil {
+		return err
+	}
+
+	if tree.nullNames {
+		// Append the missing names selected as nulls, in sorted order.
+		for _, key := range slices.Sorted(maps.Keys(obj)) {
+			if _, ok := members[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	buf.WriteByte('{')
+
+	first := true
+	for _, key := range keys {
+		val, ok := obj[key]
+		if !ok {
+			continue
+		}
+
+		if !first {
+			buf.WriteByte(',')
+		}
+
+		first = false
+
+		if err := encodeValue(buf, key); err != nil {
+			return err
+		}
+
+		buf.WriteByte(':')
+
+		if err := tree.encodeOrdered(buf, val, members[key]); err != nil {
+			return err
+		}
+	}
+
+	buf.WriteByte('}')
+
+	return nil
+}
+
+// encodeArray writes the JSON encoding of ary, selected in fixed mode from
+// src, to buf. Omits unselected positions if tree is in ordered mode, and
+// otherwise encodes tree.gap in their place.
+func (tree *Tree) encodeArray(buf *bytes.Buffer, ary []any, src json.RawMessage) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(src, &items); err != nil {
+		return err
+	}
+
+	tree.keepShortGaps(ary)
+	buf.WriteByte('[')
+
+	first := true
+	for i, val := range ary {
+		_, gap := val.(gapVal)
+		if gap && !tree.index {
+			continue
+		}
+
+		if !first {
+			buf.WriteByte(',')
+		}
+
+		first = false
+
+		var err error
+		if gap {
+			err = encodeValue(buf, tree.gap)
+		} else {
+			err = tree.encodeOrdered(buf, val, items[i])
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	buf.WriteByte(']')
+
+	return nil
+}
+
+// encodeValue writes the JSON encoding of val to buf.
+func encodeValue(buf *bytes.Buffer, val any) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	buf.Write(data)
+
+	return nil
+}
+
+// decodeMembers decodes the JSON object in src, returning its keys in the
+// order they first appear and a map of its keys to their encoded values.
+// As with [json.Unmarshal], the last of duplicate keys wins.
+func decodeMembers(src json.RawMessage) ([]string, map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(src))
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+
+	var keys []string
+
+	members := map[string]json.RawMessage{}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		key, _ := tok.(string)
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, nil, err
+		}
+
+		if _, dup := members[key]; !dup {
+			keys = append(keys, key)
+		}
+
+		members[key] = raw
+	}
+
+	return keys, members, nil
+}
+
+// SelectBytes decodes the JSON value in data and returns the value selected
+// from it by [Tree.Select]. Returns nil and no error if data is empty or
+// contains only whitespace, and [ErrJSON] if data is not a single valid JSON
+// value. The returned value shares no storage with data, so callers may
+// reuse data once SelectBytes returns. Numbers are decoded as float64
+// values; use [Tree.SelectBytesNumber] to decode them as [json.Number]s.
+func (tree *Tree) SelectBytes(data []byte) (any, error) {
+	return tree.selectBytes(data, false)
+}
+
+// SelectBytesNumber decodes the JSON value in data like [Tree.SelectBytes],
+// but decodes numbers as [json.Number] values to preserve their precision.
+func (tree *Tree) SelectBytesNumber(data []byte) (any, error) {
+	return tree.selectBytes(data, true)
+}
+
+// SelectReader decodes the JSON value read from r until EOF and returns the
+// value selected from it by [Tree.Select], exactly as [Tree.SelectBytes]
+// would for the bytes read, so that it suits complete documents read from
+// sources such as standard input and HTTP request bodies. Like SelectBytes,
+// it returns an [ErrJSON] error for data after the first JSON value other
+// than whitespace, rather than ignoring it; to select from each of a stream
+// of JSON values, decode them with a [json.Decoder] and pass each to
+// [Tree.Select]. Returns any error returned by r other than [io.EOF].
+func (tree *Tree) SelectReader(r io.Reader) (any, error) {
+	return tree.selectReader(r, false)
+}
+
+// SelectReaderNumber decodes the JSON value read from r like
+// [Tree.SelectReader], but decodes numbers as [json.Number] values to
+// preserve their precision, as [Tree.SelectBytesNumber] does.
+func (tree *Tree) SelectReaderNumber(r io.Reader) (any, error) {
+	return tree.selectReader(r, true)
+}
+
+// selectReader decodes the JSON value read from r and selects tree's paths
+// from the result.
+func (tree *Tree) selectReader(r io.Reader, useNumber bool) (any, error) {
+	if tree.rawLeaves {
+		// Raw leaves need the source of the value to copy from.
+		var src json.RawMessage
+		if err := decodeFrom(r, &src, useNumber); err != nil {
+			return nil, nilEOF(err)
+		}
+
+		return tree.selectBytes(src, useNumber)
+	}
+
+	var value any
+	if err := decodeFrom(r, &value, useNumber); err != nil {
+		return nil, nilEOF(err)
+	}
+
+	return tree.Select(value), nil
+}
+
+// nilEOF returns nil if err is [io.EOF], since input with no JSON value
+// selects nothing, and err otherwise.
+func nilEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	return err
+}
+
+// selectBytes decodes data and selects tree's paths from the result.
+func (tree *Tree) selectBytes(data []byte, useNumber bool) (any, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil //nolint:nilnil // empty input selects nothing
+	}
+
+	if tree.rawLeaves && json.Valid(data) {
+		return tree.selectRawLeaves(data, useNumber)
+	}
+
+	value, err := decodeJSON(data, useNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	return tree.Select(value), nil
+}
+
+// selectRawLeaves selects tree's paths from the valid JSON in data as
+// [Tree.Select] selects from a [json.RawMessage], decoding only the objects
+// and arrays it selects from, so that it returns each value selected at the
+// end of a path as a json.RawMessage without decoding it. Fully decodes
+// data only to evaluate filter selectors that query the root ($).
+func (tree *Tree) selectRawLeaves(data []byte, useNumber bool) (any, error) {
+	src := json.RawMessage(bytes.TrimSpace(data))
+	if len(tree.root.children) == 0 {
+		// Copy src, since the caller owns it.
+		return json.RawMessage(bytes.Clone(src)), nil
+	}
+
+	// Decoding one level of src copies its members or items out of it.
+	from := decodeRaw(src)
+	if !queriesRoot(tree.root) {
+		return tree.SelectFromRoot(from, from), nil
+	}
+
+	root, err := decodeJSON(src, useNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	return tree.SelectFromRoot(from, root), nil
+}
+
+// queriesRoot returns true if any filter selector in seg or its descendants
+// may query the root ($) of the value it selects from. A filter whose string
+// literals contain "$" also counts, so that it errs on the side of decoding
+// the root.
+func queriesRoot(seg *segment) bool {
+	for _, sel := range seg.selectors {
+		if f, ok := sel.(*spec.FilterSelector); ok && strings.Contains(f.String(), "$") {
+			return true
+		}
+	}
+
+	return slices.ContainsFunc(seg.children, queriesRoot)
+}
+
+// decodeJSON decodes the single JSON value in data, decoding numbers as
+// [json.Number] values if useNumber is true. Returns [ErrJSON] if data is
+// not a single valid JSON value.
+func decodeJSON(data []byte, useNumber bool) (any, error) {
+	var value any
+	if err := decodeFrom(bytes.NewReader(data), &value, useNumber); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: %w", ErrJSON, err)
+		}
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// decodeFrom decodes the single JSON value read from r into v, decoding
+// numbers as [json.Number] values if useNumber is true. Returns [io.EOF] if
+// r contains only whitespace, [ErrJSON] if it does not contain a single
+// valid JSON value, and any other error returned by r as is.
+func decodeFrom(r io.Reader, v any, useNumber bool) error {
+	src := &errReader{r: r}
+	dec := json.NewDecoder(src)
+	if useNumber {
+		dec.UseNumber()
+	}
+
+	if err := dec.Decode(v); err != nil {
+		if src.err != nil || errors.Is(err, io.EOF) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrJSON, err)
+	}
+
+	// More reports any value after the first, and read errors, but not a
+	// stray closing bracket or brace, for which Token returns an error.
+	if !dec.More() {
+		if _, err := dec.Token(); errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+
+	if src.err != nil {
+		return src.err
+	}
+
+	return fmt.Errorf("%w: unexpected data after top-level value", ErrJSON)
+}
+
+// errReader wraps an [io.Reader] to record the first error it returns other
+// than [io.EOF], so that decodeFrom can distinguish read errors from invalid
+// JSON.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+// Read reads from the wrapped reader into p and records any error other
+// than [io.EOF].
+func (er *errReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) && er.err == nil {
+		er.err = err
+	}
+	return n, err
+}
+
+// isRawContainer returns true if raw encodes a JSON object or array.
+func isRawContainer(raw json.RawMessage) bool {
+	raw = bytes.TrimLeft(raw, " \t\r\n")
+	return len(raw) > 0 && (raw[0] == '{' || raw[0] == '[')
+}
+
+// decodeRaw decodes a single level of val if it's a [json.RawMessage] that
+// encodes a JSON object or array, or a map[string]json.RawMessage or
+// []json.RawMessage, and returns the result as a map[string]any or []any
+// whose values remain undecoded json.RawMessages. Otherwise returns val.
+func decodeRaw(val any) any {
+	switch val := val.(type) {
+	case json.RawMessage:
+		if !isRawContainer(val) {
+			return val
+		}
+
+		if bytes.TrimLeft(val, " \t\r\n")[0] == '{' {
+			var members map[string]json.RawMessage
+			if err := json.Unmarshal(val, &members); err != nil {
+				return val
+			}
+
+			return decodeRaw(members)
+		}
+
+		var items []json.RawMessage
+		if err := json.Unmarshal(val, &items); err != nil {
+			return val
+		}
+
+		return decodeRaw(items)
+	case map[string]json.RawMessage:
+		obj := make(map[string]any, len(val))
+		for k, v := range val {
+			obj[k] = v
+		}
+
+		return obj
+	case []json.RawMessage:
+		ary := make([]any, len(val))
+		for i, v := range val {
+			ary[i] = v
+		}
+
+		return ary
+	default:
+		return val
+	}
+}
+
+// unmarshalRaw returns the fully decoded value of val if it's a
+// [json.RawMessage] that encodes valid JSON, and otherwise returns val.
+func unmarshalRaw(val any) any {
+	raw, ok := val.(json.RawMessage)
+	if !ok {
+		return val
+	}
+
+	var res any
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return val
+	}
+
+	return res
+}
+
+// jsonTree is the JSON representation of a [Tree].
+type jsonTree struct {
+	Mode string       `json:"mode"`
+	Root *jsonSegment `json:"root"`
+}
+
+// jsonSegment is the JSON representation of a segment.
+type jsonSegment struct {
+	Selectors  []string       `json:"selectors"`
+	Descendant bool           `json:"descendant"`
+	Children   []*jsonSegment `json:"children"`
+}
+
+// MarshalJSON encodes the structure of tree as a JSON object with two
+// fields: "mode", either "fixed" or "ordered", and "root", the root segment.
+// Each segment is a JSON object with three fields: "selectors", an array of
+// the string representations of its selectors; "descendant", true for a
+// descendant segment; and "children", an array of its child segments. The
+// options configuring tree are not encoded. Returns [ErrJSON] if tree
+// contains a selector created by [FilterFunc] or [RegexpName], which JSON
+// cannot encode.
+func (tree *Tree) MarshalJSON() ([]byte, error) {
+	root, err := tree.root.toJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(jsonTree{
+		Mode: modeName(tree.index),
+		Root: root,
+	})
+}
+
+// UnmarshalJSON decodes the structure of a Tree encoded by
+// [Tree.MarshalJSON] into tree, replacing its segments and array handling
+// mode but retaining its options. Parses each selector with [jsonpath.Parse],
+// so filter selectors may use only its standard function extensions. Returns
+// [ErrJSON] if data is not a valid encoding of a Tree, and [ErrFrozen] if
+// tree has been frozen by [Tree.Freeze].
+func (tree *Tree) UnmarshalJSON(data []byte) error {
+	if tree.frozen {
+		return ErrFrozen
+	}
+
+	var jt jsonTree
+	if err := json.Unmarshal(data, &jt); err != nil {
+		return fmt.Errorf("%w: %w", ErrJSON, err)
+	}
+
+	var index bool
+	switch jt.Mode {
+	case "fixed":
+		index = true
+	case "ordered":
+	default:
+		return fmt.Errorf("%w: invalid mode %q", ErrJSON, jt.Mode)
+	}
+
+	root := child()
+	if jt.Root != nil {
+		if len(jt.Root.Selectors) > 0 || jt.Root.Descendant {
+			return fmt.Errorf("%w: root segment must have no selectors", ErrJSON)
+		}
+
+		var err error
+		if root, err = jt.Root.toSegment(); err != nil {
+			return err
+		}
+	}
+
+	root.index()
+	tree.root = root
+	tree.sources = nil
+	tree.index = index
+
+	return nil
+}
+
+// toJSON converts seg and its children to their JSON representations.
+// Returns [ErrJSON] if seg or its children contain a selector created by
+// [FilterFunc] or [RegexpName].
+func (seg *segment) toJSON() (*jsonSegment, error) {
+	js := &jsonSegment{
+		Selectors:  make([]string, len(seg.selectors)),
+		Descendant: seg.descendant,
+		Children:   make([]*jsonSegment, len(seg.children)),
+	}
+
+	for i, sel := range seg.selectors {
+		switch sel.(type) {
+		case *funcSelector:
+			return nil, fmt.Errorf("%w: cannot encode function selector", ErrJSON)
+		case *regexpSelector:
+			return nil, fmt.Errorf("%w: cannot encode regular expression selector %v", ErrJSON, sel)
+		}
+
+		js.Selectors[i] = selectorString(sel)
+	}
+
+	for i, c := range seg.children {
+		child, err := c.toJSON()
+		if err != nil {
+			return nil, err
+		}
+
+		js.Children[i] = child
+	}
+
+	return js, nil
+}
+
+// toSegment converts js and its children to segments.
+func (js *jsonSegment) toSegment() (*segment, error) {
+	seg := &segment{
+		selectors:  make([]spec.Selector, len(js.Selectors)),
+		children:   make([]*segment, len(js.Children)),
+		descendant: js.Descendant,
+	}
+
+	for i, str := range js.Selectors {
+		sel, err := parseSelector(str)
+		if err != nil {
+			return nil, err
+		}
+
+		seg.selectors[i] = sel
+	}
+
+	for i, c := range js.Children {
+		if c == nil {
+			return nil, fmt.Errorf("%w: null segment", ErrJSON)
+		}
+
+		child, err := c.toSegment()
+		if err != nil {
+			return nil, err
+		}
+
+		seg.children[i] = child
+	}
+
+	return seg, nil
+}
+
+// parseSelector parses the string representation of a single selector.
+func parseSelector(str string) (spec.Selector, error) {
+	path, err := jsonpath.Parse("$[" + str + "]")
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid selector %q: %w", ErrJSON, str, err)
+	}
+
+	// Reject strings such as `"b"]["c"` that close the brackets and parse
+	// as additional segments.
+	segs := path.Query().Segments()
+	if len(segs) != 1 || segs[0].IsDescendant() || len(segs[0].Selectors()) != 1 {
+		return nil, fmt.Errorf("%w: invalid selector %q", ErrJSON, str)
+	}
+
+	return segs[0].Selectors()[0], nil
+}
@@ -0,0 +1,800 @@
+package jsontree
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theory/jsonpath"
+)
+
+func TestSelectRaw(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		src   string
+		exp   string
+		err   string
+		is    error
+	}{
+		{
+			test:  "root",
+			paths: []string{"$"},
+			src:   `{"a": 1, "b": ["x", "z"]}`,
+			exp:   `{"a":1,"b":["x","z"]}`,
+		},
+		{
+			test:  "nested_index",
+			paths: []string{"$.b[1]", "$.c.d"},
+			src:   `{"a": 1, "b": ["x", "z"], "c": {"d": true, "e": false}}`,
+			exp:   `{"b":["z"],"c":{"d":true}}`,
+		},
+		{
+			test:  "array",
+			paths: []string{"$[0].a"},
+			src:   `[{"a": null, "b": 2}, {"a": 3}]`,
+			exp:   `[{"a":null}]`,
+		},
+		{
+			test:  "precise_numbers",
+			paths: []string{"$.n"},
+			src:   `{"n": [12345678901234567890, 1.10], "x": 1}`,
+			exp:   `{"n":[12345678901234567890,1.10]}`,
+		},
+		{
+			test:  "number_filter",
+			paths: []string{"$[?@.n > 1].id"},
+			src:   `[{"id": 1, "n": 1}, {"id": 2, "n": 2.5}]`,
+			exp:   `[{"id":2}]`,
+		},
+		{
+			test:  "scalar",
+			paths: []string{"$.a"},
+			src:   `42`,
+			err:   "jsontree: cannot select from value of type json.Number",
+			is:    ErrUnsupported,
+		},
+		{
+			test:  "invalid",
+			paths: []string{"$"},
+			src:   `{"a":`,
+			err:   "jsontree: json: unexpected EOF",
+			is:    ErrJSON,
+		},
+		{
+			test:  "trailing_data",
+			paths: []string{"$"},
+			src:   `{"a": 1} {"b": 2}`,
+			err:   "jsontree: json: unexpected data after top-level value",
+			is:    ErrJSON,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			res, err := New(paths...).SelectRaw(json.RawMessage(tc.src))
+			if tc.err != "" {
+				r.EqualError(err, tc.err)
+				r.ErrorIs(err, tc.is)
+				a.Nil(res)
+
+				return
+			}
+
+			r.NoError(err)
+			a.Equal(tc.exp, string(res))
+		})
+	}
+}
+
+func TestSelectString(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		input any
+		exp   string
+	}{
+		{
+			test:  "object",
+			paths: []string{"$.a", "$.c[1]"},
+			input: map[string]any{"a": 1, "b": 2, "c": []any{"x", "y"}},
+			exp:   "{\n  \"a\": 1,\n  \"c\": [\n    \"y\"\n  ]\n}",
+		},
+		{
+			test:  "no_html_escape",
+			paths: []string{"$.html"},
+			input: map[string]any{"html": "<b>Tom & Jerry</b>"},
+			exp:   "{\n  \"html\": \"<b>Tom & Jerry</b>\"\n}",
+		},
+		{
+			test:  "empty",
+			paths: []string{"$.x"},
+			input: []any{1},
+			exp:   "[]",
+		},
+		{
+			test:  "scalar",
+			paths: []string{"$.x"},
+			input: "hi",
+			exp:   "null",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			str, err := New(paths...).SelectString(tc.input)
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, str)
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+		str, err := New().SelectString(func() {})
+		require.ErrorIs(t, err, ErrJSON)
+		assert.Empty(t, str)
+	})
+}
+
+func TestSelectTyped(t *testing.T) {
+	t.Parallel()
+
+	type user struct {
+		Name  string   `json:"name"`
+		Email string   `json:"email"`
+		Tags  []string `json:"tags"`
+	}
+
+	type account struct {
+		User user `json:"user"`
+	}
+
+	input := map[string]any{
+		"user": map[string]any{
+			"name":  "Kim",
+			"email": "kim@example.com",
+			"ssn":   "123-45-6789",
+			"tags":  []any{"admin", 42, "ops"},
+		},
+	}
+
+	t.Run("struct", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		tree := MustNewFromStrings("$.user.name", "$.user.email")
+		acct, err := SelectTyped[account](tree, input)
+		r.NoError(err)
+		a.Equal(account{User: user{Name: "Kim", Email: "kim@example.com"}}, acct)
+
+		ptr, err := SelectTyped[*account](tree, input)
+		r.NoError(err)
+		a.Equal(&acct, ptr)
+	})
+
+	t.Run("array", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		tags, err := SelectTyped[[]string](MustNewFromStrings("$[0,2]"), input["user"].(map[string]any)["tags"])
+		r.NoError(err)
+		a.Equal([]string{"admin", "ops"}, tags)
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		tree := MustNewFromStrings("$.user.name")
+		acct, err := SelectTyped[account](tree, json.RawMessage(`{"user": {"name": "Lee", "ssn": "x"}}`))
+		r.NoError(err)
+		a.Equal(account{User: user{Name: "Lee"}}, acct)
+	})
+
+	t.Run("decode_error", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		acct, err := SelectTyped[account](MustNewFromStrings("$.user.name", "$.user.tags"), input)
+		r.ErrorIs(err, ErrJSON)
+		var typeErr *json.UnmarshalTypeError
+		r.ErrorAs(err, &typeErr)
+		a.Zero(acct)
+	})
+
+	t.Run("encode_error", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		res, err := SelectTyped[map[string]any](MustNewFromStrings("$.f"), map[string]any{"f": func() {}})
+		r.ErrorIs(err, ErrJSON)
+		a.Nil(res)
+	})
+}
+
+func TestSelectRawEmbedded(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+	r := require.New(t)
+
+	type envelope struct {
+		ID      int             `json:"id"`
+		Payload json.RawMessage `json:"payload"`
+	}
+
+	var env envelope
+	r.NoError(json.Unmarshal(
+		[]byte(`{"id": 1, "payload": {"user": {"name": "Kim", "ssn": "123"}}}`),
+		&env,
+	))
+
+	tree := New(jsonpath.MustParse("$.user.name"))
+	payload, err := tree.SelectRaw(env.Payload)
+	r.NoError(err)
+	env.Payload = payload
+
+	out, err := json.Marshal(env)
+	r.NoError(err)
+	a.JSONEq(`{"id": 1, "payload": {"user": {"name": "Kim"}}}`, string(out))
+}
+
+func TestSelectBytes(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test   string
+		paths  []string
+		src    string
+		exp    any
+		expNum any
+		err    string
+	}{
+		{
+			test:   "object",
+			paths:  []string{"$.a", "$.c[1]"},
+			src:    `{"a": 1, "b": 2, "c": [true, false]}`,
+			exp:    map[string]any{"a": float64(1), "c": []any{false}},
+			expNum: map[string]any{"a": json.Number("1"), "c": []any{false}},
+		},
+		{
+			test:   "array",
+			paths:  []string{"$[0].x"},
+			src:    `[{"x": 12345678901234567890, "y": 1}]`,
+			exp:    []any{map[string]any{"x": float64(12345678901234567890)}},
+			expNum: []any{map[string]any{"x": json.Number("12345678901234567890")}},
+		},
+		{
+			test:  "scalar",
+			paths: []string{"$.a"},
+			src:   `"hi"`,
+		},
+		{
+			test:  "empty",
+			paths: []string{"$"},
+		},
+		{
+			test:  "whitespace",
+			paths: []string{"$"},
+			src:   " \n\t ",
+		},
+		{
+			test:  "invalid",
+			paths: []string{"$"},
+			src:   `[1,`,
+			err:   "jsontree: json: unexpected EOF",
+		},
+		{
+			test:  "trailing_data",
+			paths: []string{"$"},
+			src:   `[1] 2`,
+			err:   "jsontree: json: unexpected data after top-level value",
+		},
+		{
+			test:  "trailing_bracket",
+			paths: []string{"$"},
+			src:   `[1]]`,
+			err:   "jsontree: json: unexpected data after top-level value",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+			tree := New(paths...)
+
+			for _, sel := range []struct {
+				fn  func([]byte) (any, error)
+				exp any
+			}{
+				{tree.SelectBytes, tc.exp},
+				{tree.SelectBytesNumber, tc.expNum},
+				{func(data []byte) (any, error) { return tree.SelectReader(bytes.NewReader(data)) }, tc.exp},
+				{func(data []byte) (any, error) { return tree.SelectReaderNumber(bytes.NewReader(data)) }, tc.expNum},
+			} {
+				res, err := sel.fn([]byte(tc.src))
+				if tc.err != "" {
+					r.EqualError(err, tc.err)
+					r.ErrorIs(err, ErrJSON)
+					a.Nil(res)
+
+					continue
+				}
+
+				r.NoError(err)
+				a.Equal(sel.exp, res)
+			}
+		})
+	}
+}
+
+func TestSelectReader(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+	r := require.New(t)
+
+	tree := New(jsonpath.MustParse("$.a"))
+
+	// Reads in small chunks.
+	res, err := tree.SelectReader(iotest.OneByteReader(strings.NewReader(`{"a": [1, 2], "b": 3}` + "\n")))
+	r.NoError(err)
+	a.Equal(map[string]any{"a": []any{float64(1), float64(2)}}, res)
+
+	// Returns read errors.
+	readErr := errors.New("oops")
+	res, err = tree.SelectReader(iotest.ErrReader(readErr))
+	r.ErrorIs(err, readErr)
+	r.NotErrorIs(err, ErrJSON)
+	a.Nil(res)
+
+	// Returns read errors after the value.
+	res, err = tree.SelectReader(io.MultiReader(strings.NewReader(`{"a": 1} `), iotest.ErrReader(readErr)))
+	r.ErrorIs(err, readErr)
+	r.NotErrorIs(err, ErrJSON)
+	a.Nil(res)
+
+	// Stops reading at the first value after the top-level value.
+	res, err = tree.SelectReader(io.MultiReader(strings.NewReader(`{"a": 1} 2`), iotest.ErrReader(readErr)))
+	r.ErrorIs(err, ErrJSON)
+	r.NotErrorIs(err, readErr)
+	a.Nil(res)
+
+	// Supports raw leaves.
+	raw := NewCompiler(WithRawLeaves()).New(jsonpath.MustParse("$.a"))
+	res, err = raw.SelectReader(strings.NewReader(`{"a": [1.10, 2], "b": 3}`))
+	r.NoError(err)
+	a.Equal(map[string]any{"a": json.RawMessage(`[1.10, 2]`)}, res)
+
+	// Returns ErrJSON for raw leaves with trailing data.
+	res, err = raw.SelectReader(strings.NewReader(`{"a": 1} 2`))
+	r.ErrorIs(err, ErrJSON)
+	a.Nil(res)
+
+	// Empty input selects nothing.
+	res, err = raw.SelectReaderNumber(strings.NewReader(" \n"))
+	r.NoError(err)
+	a.Nil(res)
+}
+
+func TestSelectBytesRawLeaves(t *testing.T) {
+	t.Parallel()
+
+	src := `{
+		"a": {"z": 1, "y": [1.10, 2]},
+		"b": [{"id": 1, "v": "one"}, {"id": 2, "v": "two"}, {"id": 3}],
+		"c": "\u00e9"
+	}`
+
+	for _, tc := range []struct {
+		test    string
+		paths   []string
+		ordered any
+		fixed   any
+	}{
+		{
+			test:    "root",
+			paths:   []string{"$"},
+			ordered: json.RawMessage(strings.TrimSpace(src)),
+			fixed:   json.RawMessage(strings.TrimSpace(src)),
+		},
+		{
+			test:    "whole_object",
+			paths:   []string{"$.a", "$.c"},
+			ordered: map[string]any{"a": json.RawMessage(`{"z": 1, "y": [1.10, 2]}`), "c": json.RawMessage(`"\u00e9"`)},
+			fixed:   map[string]any{"a": json.RawMessage(`{"z": 1, "y": [1.10, 2]}`), "c": json.RawMessage(`"\u00e9"`)},
+		},
+		{
+			test:  "nested",
+			paths: []string{"$.a.y[0]", "$.b[1,2].id"},
+			ordered: map[string]any{
+				"a": map[string]any{"y": []any{json.RawMessage(`1.10`)}},
+				"b": []any{
+					map[string]any{"id": json.RawMessage(`2`)},
+					map[string]any{"id": json.RawMessage(`3`)},
+				},
+			},
+			fixed: map[string]any{
+				"a": map[string]any{"y": []any{json.RawMessage(`1.10`)}},
+				"b": []any{
+					nil,
+					map[string]any{"id": json.RawMessage(`2`)},
+					map[string]any{"id": json.RawMessage(`3`)},
+				},
+			},
+		},
+		{
+			test:    "filter",
+			paths:   []string{`$.b[?@.v == "two"]`},
+			ordered: map[string]any{"b": []any{json.RawMessage(`{"id": 2, "v": "two"}`)}},
+			fixed:   map[string]any{"b": []any{nil, json.RawMessage(`{"id": 2, "v": "two"}`)}},
+		},
+		{
+			test:    "root_filter",
+			paths:   []string{`$.b[?@.id == $.a.z].v`},
+			ordered: map[string]any{"b": []any{map[string]any{"v": json.RawMessage(`"one"`)}}},
+			fixed:   map[string]any{"b": []any{map[string]any{"v": json.RawMessage(`"one"`)}}},
+		},
+		{
+			test:    "no_match",
+			paths:   []string{"$.x"},
+			ordered: map[string]any{},
+			fixed:   map[string]any{},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			c := NewCompiler(WithRawLeaves())
+			data := []byte(src)
+
+			res, err := c.New(paths...).SelectBytes(data)
+			r.NoError(err)
+			a.Equal(tc.ordered, res)
+
+			res, err = c.NewFixedModeTree(paths...).SelectBytesNumber(data)
+			r.NoError(err)
+			a.Equal(tc.fixed, res)
+
+			// Results must not share storage with data.
+			clear(data)
+			a.Equal(tc.fixed, res)
+		})
+	}
+
+	t.Run("gap_value", func(t *testing.T) {
+		t.Parallel()
+		tree := NewCompiler(WithRawLeaves(), WithGapValue("GAP")).
+			NewFixedModeTree(jsonpath.MustParse("$[1]"))
+		res, err := tree.SelectBytes([]byte(`[1, {"a": 2}]`))
+		require.NoError(t, err)
+		assert.Equal(t, []any{"GAP", json.RawMessage(`{"a": 2}`)}, res)
+
+		out, err := json.Marshal(res)
+		require.NoError(t, err)
+		assert.JSONEq(t, `["GAP", {"a": 2}]`, string(out))
+	})
+}
+
+func BenchmarkSelectBytesRawLeaves(b *testing.B) {
+	big := make([]any, 20_000)
+	for i := range big {
+		big[i] = map[string]any{"id": i, "name": "x", "tags": []any{"a", "b"}}
+	}
+
+	data, err := json.Marshal(map[string]any{"big": big, "small": map[string]any{"id": 1}})
+	require.NoError(b, err)
+
+	for _, bc := range []struct {
+		name string
+		path string
+	}{
+		{"big", "$.big"},
+		{"small", "$.small.id"},
+	} {
+		path := jsonpath.MustParse(bc.path)
+
+		for _, mode := range []struct {
+			name string
+			tree *Tree
+		}{
+			{"decoded", New(path)},
+			{"raw", NewCompiler(WithRawLeaves()).New(path)},
+		} {
+			b.Run(bc.name+"_"+mode.name, func(b *testing.B) {
+				b.ReportAllocs()
+
+				for range b.N {
+					if _, err := mode.tree.SelectBytes(data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestTreeJSON(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		fixed bool
+		json  string
+	}{
+		{
+			test: "root_only",
+			json: `{"mode":"ordered","root":{"selectors":[],"descendant":false,"children":[]}}`,
+		},
+		{
+			test:  "fixed",
+			paths: []string{`$.a[1]`},
+			fixed: true,
+			json: `{"mode":"fixed","root":{"selectors":[],"descendant":false,"children":[` +
+				`{"selectors":["\"a\""],"descendant":false,"children":[` +
+				`{"selectors":["1"],"descendant":false,"children":[]}]}]}}`,
+		},
+		{
+			test:  "control_characters",
+			paths: []string{`$["a\u0000\u0007\tb"]`},
+			json: `{"mode":"ordered","root":{"selectors":[],"descendant":false,"children":[` +
+				`{"selectors":["\"a\\u0000\\u0007\\tb\""],"descendant":false,"children":[]}]}}`,
+		},
+		{
+			test:  "all_selectors",
+			paths: []string{`$..x["y",2,1:5:2].z`, `$.w[*].v`, `$[?@.z == "hi" && length(@.a) > 1]`},
+			json: `{"mode":"ordered","root":{"selectors":[],"descendant":false,"children":[` +
+				`{"selectors":["\"x\""],"descendant":true,"children":[` +
+				`{"selectors":["1:5:2","\"y\"","2"],"descendant":false,"children":[` +
+				`{"selectors":["\"z\""],"descendant":false,"children":[]}]}]},` +
+				`{"selectors":["\"w\""],"descendant":false,"children":[` +
+				`{"selectors":["*"],"descendant":false,"children":[` +
+				`{"selectors":["\"v\""],"descendant":false,"children":[]}]}]},` +
+				`{"selectors":["?@[\"z\"] == \"hi\" && length(@[\"a\"]) > 1"],` +
+				`"descendant":false,"children":[]}]}}`,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := New(paths...)
+			if tc.fixed {
+				tree = NewFixedModeTree(paths...)
+			}
+
+			js, err := json.Marshal(tree)
+			r.NoError(err)
+			a.JSONEq(tc.json, string(js))
+
+			// Round trip.
+			res := New()
+			r.NoError(json.Unmarshal(js, res))
+			a.Equal(tree.String(), res.String())
+			a.Equal(tree.index, res.index)
+			a.Equal(tree.Queries(), res.Queries())
+		})
+	}
+}
+
+func TestTreeUnmarshalJSONErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test string
+		json string
+		err  string
+	}{
+		{
+			test: "invalid_json",
+			json: `{"mode":`,
+			err:  "jsontree: json: unexpected end of JSON input",
+		},
+		{
+			test: "invalid_mode",
+			json: `{"mode":"fast"}`,
+			err:  `jsontree: json: invalid mode "fast"`,
+		},
+		{
+			test: "root_selectors",
+			json: `{"mode":"fixed","root":{"selectors":["1"]}}`,
+			err:  "jsontree: json: root segment must have no selectors",
+		},
+		{
+			test: "invalid_selector",
+			json: `{"mode":"fixed","root":{"children":[{"selectors":["x"]}]}}`,
+			err:  `jsontree: json: invalid selector "x": jsonpath: unexpected identifier at position 3`,
+		},
+		{
+			test: "multiple_selectors",
+			json: `{"mode":"fixed","root":{"children":[{"selectors":["1,2"]}]}}`,
+			err:  `jsontree: json: invalid selector "1,2"`,
+		},
+		{
+			test: "multiple_segments",
+			json: `{"mode":"fixed","root":{"children":[{"selectors":["\"b\"][\"c\""]}]}}`,
+			err:  `jsontree: json: invalid selector "\"b\"][\"c\""`,
+		},
+		{
+			test: "descendant_segment",
+			json: `{"mode":"fixed","root":{"children":[{"selectors":["1]..[2"]}]}}`,
+			err:  `jsontree: json: invalid selector "1]..[2"`,
+		},
+		{
+			test: "null_segment",
+			json: `{"mode":"fixed","root":{"children":[null]}}`,
+			err:  "jsontree: json: null segment",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			tree := New(jsonpath.MustParse("$.a"))
+			str := tree.String()
+			err := tree.UnmarshalJSON([]byte(tc.json))
+			require.EqualError(t, err, tc.err)
+			require.ErrorIs(t, err, ErrJSON)
+			assert.Equal(t, str, tree.String())
+		})
+	}
+
+	t.Run("frozen", func(t *testing.T) {
+		t.Parallel()
+		tree := New()
+		tree.Freeze()
+		require.ErrorIs(t, tree.UnmarshalJSON([]byte(`{"mode":"fixed"}`)), ErrFrozen)
+	})
+}
+
+func TestSelectRawMessages(t *testing.T) {
+	t.Parallel()
+
+	doc := []byte(`{
+		"a": {"b": 1, "c": [1, 2, {"d": true}]},
+		"e": "big",
+		"f": [{"x": 1}, {"x": 2}],
+		"bad": "later"
+	}`)
+
+	var decoded any
+	require.NoError(t, json.Unmarshal(doc, &decoded))
+
+	var members map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(doc, &members))
+
+	// Selection must not decode values it does not select from, and
+	// selects nothing from invalid values.
+	members["bad"] = json.RawMessage(`{nope`)
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		exp   any
+	}{
+		{
+			test:  "raw_leaf",
+			paths: []string{"$.e"},
+			exp:   map[string]any{"e": json.RawMessage(`"big"`)},
+		},
+		{
+			test:  "nested",
+			paths: []string{"$.a.b", "$.a.c[2].d"},
+			exp: map[string]any{"a": map[string]any{
+				"b": json.RawMessage(`1`),
+				"c": []any{map[string]any{"d": json.RawMessage(`true`)}},
+			}},
+		},
+		{
+			test:  "filter",
+			paths: []string{"$.f[?@.x > 1]"},
+			exp:   map[string]any{"f": []any{json.RawMessage(`{"x": 2}`)}},
+		},
+		{
+			test:  "descendant",
+			paths: []string{"$.f..x"},
+			exp: map[string]any{"f": []any{
+				map[string]any{"x": json.RawMessage(`1`)},
+				map[string]any{"x": json.RawMessage(`2`)},
+			}},
+		},
+		{
+			test:  "invalid_container",
+			paths: []string{"$.bad.x", "$.e"},
+			exp:   map[string]any{"e": json.RawMessage(`"big"`)},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := New(paths...)
+			a.Equal(tc.exp, tree.Select(members))
+
+			// Should encode the same as selecting from the decoded value.
+			exp, err := json.Marshal(tree.Select(decoded))
+			require.NoError(t, err)
+			got, err := json.Marshal(tree.Select(members))
+			require.NoError(t, err)
+			a.JSONEq(string(exp), string(got))
+
+			var buf bytes.Buffer
+			require.NoError(t, tree.SelectStream(members, &buf))
+			a.JSONEq(string(exp), buf.String())
+		})
+	}
+
+	t.Run("raw_message", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		tree := New(jsonpath.MustParse("$[1].x"))
+		a.Equal(
+			[]any{map[string]any{"x": json.RawMessage(`2`)}},
+			tree.Select(json.RawMessage(`[{"x": 1}, {"x": 2}]`)),
+		)
+		a.Equal(
+			[]any{map[string]any{"x": json.RawMessage(`2`)}},
+			tree.Select([]json.RawMessage{json.RawMessage(`{"x": 1}`), json.RawMessage(`{"x": 2}`)}),
+		)
+		a.Equal(
+			map[string]any{"a": []any{map[string]any{"x": json.RawMessage(`2`)}}},
+			New(jsonpath.MustParse("$.a[1].x")).Select(map[string]any{"a": json.RawMessage(`[{"x": 1}, {"x": 2}]`)}),
+		)
+		a.Nil(tree.Select(json.RawMessage(`42`)))
+
+		_, err := tree.SelectE(json.RawMessage(`42`))
+		a.ErrorIs(err, ErrUnsupported)
+
+		res, err := tree.SelectE(json.RawMessage(`[]`))
+		a.NoError(err)
+		a.Equal([]any{}, res)
+	})
+}
@@ -0,0 +1,226 @@
+package jsontree
+
+// Option defines a configuration option for a [Tree]. Pass options to
+// [NewCompiler].
+type Option func(*Tree)
+
+// WithYAMLCodec configures a [Tree] to use codec to decode and encode YAML
+// values in [Tree.SelectYAML].
+func WithYAMLCodec(codec Codec) Option {
+	return func(tree *Tree) { tree.yaml = codec }
+}
+
+// WithExcludeKeys configures a [Tree] to skip keys when selecting all of an
+// object's values with a child wildcard selector, e.g., $.* or $.a[*].b.
+// Useful for redacting sensitive fields, a "wildcard except" RFC 9535
+// JSONPath cannot express. Trailing child wildcards, which would otherwise
+// be discarded as equivalent to selecting their parents, are retained so
+// that exclusions apply to them.
+//
+// Exclusions do not affect name or filter selectors, descendant wildcards,
+// or the contents of values selected in their entirety.
+func WithExcludeKeys(keys ...string) Option {
+	return func(tree *Tree) {
+		if tree.exclude == nil {
+			tree.exclude = make(map[string]struct{}, len(keys))
+		}
+
+		for _, k := range keys {
+			tree.exclude[k] = struct{}{}
+		}
+	}
+}
+
+// WithKeepTrailingWildcard configures a [Tree] to retain trailing wildcard
+// selectors, which it would otherwise discard as equivalent to selecting
+// their parents. With it, $.a.* selects the members or items of a, rather
+// than a itself, and so selects nothing when a is a scalar, or an empty
+// object or array. In fixed mode, $.items[*] thus selects items only when it
+// is an array, preserving the index of each of its items, while in ordered
+// mode it selects the same items in the same order. It also retains
+// trailing descendant wildcards, so that $..* selects nothing from a scalar
+// rather than the scalar itself.
+func WithKeepTrailingWildcard() Option {
+	return func(tree *Tree) { tree.keepWild = true }
+}
+
+// WithRecoverFilters configures a [Tree] to recover from panics raised while
+// evaluating filter selectors, such as by a function extension passed an
+// unexpected value or by a function passed to [FilterFunc], and to treat
+// them as non-matches. Useful for selecting
+// from untrusted input.
+func WithRecoverFilters() Option {
+	return func(tree *Tree) { tree.recover = true }
+}
+
+// WithStrictSliceBounds configures a [Tree] to detect slice selectors with
+// explicit start or end bounds outside the arrays they select from, such as
+// $[2:5] applied to an array of three values. [Tree.SelectE] returns
+// [ErrSliceBounds] for such slices, which may indicate a query written for
+// data of a different shape. [Tree.Select] continues to clamp slice bounds to
+// the array as specified by RFC 9535.
+func WithStrictSliceBounds() Option {
+	return func(tree *Tree) { tree.strict = true }
+}
+
+// WithGapValue configures a fixed mode [Tree], as created by
+// [Compiler.NewFixedModeTree], to use v instead of nil for array positions
+// it does not select, so that they can be distinguished from selected null
+// values. Selected nulls remain nil. Has no effect on ordered mode Trees,
+// which omit unselected positions, nor when v is nil.
+func WithGapValue(v any) Option {
+	return func(tree *Tree) { tree.gap = v }
+}
+
+// WithNullGapThreshold configures an ordered mode [Tree], as created by
+// [Compiler.New], to preserve each run of no more than n unselected array
+// items that precedes a selected item, as null values, rather than omit
+// them, so that selected items separated by small gaps keep their relative
+// positions. For example, with a threshold of 1, $[0,2,5] selects
+// [0,null,2,5] from an array of the integers 0 through 5. They still omit
+// longer runs and unselected items following the last selected item. Has no
+// effect on fixed mode Trees, which preserve every unselected position, nor
+// when n is less than 1, the default.
+func WithNullGapThreshold(n int) Option {
+	return func(tree *Tree) { tree.nullGap = n }
+}
+
+// WithNullMissingNames configures a [Tree] to select null for each name
+// selector in a child segment that names no member of an object it selects
+// from, rather than omit it, so that selected objects have stable sets of
+// keys. For example, $["a","b"] selects {"a":1,"b":null} from {"a":1}. Only
+// name selectors select missing members: wildcards, filters, and descendant
+// segments select only members that exist. The null is a selected value in
+// both fixed and ordered mode Trees, so an object that lacks every name
+// still selects an object of nulls, and an array item from which a path
+// selects such an object is no longer unselected.
+func WithNullMissingNames() Option {
+	return func(tree *Tree) { tree.nullNames = true }
+}
+
+// WithCopyLeaves configures a [Tree] to deep copy each object, array, and
+// [encoding/json.RawMessage] it selects at the end of a path, as well as
+// the input to a root-only Tree, so that the values returned by
+// [Tree.Select], [Tree.SelectTo], [Tree.SelectIntoMode], and the methods
+// based on them share no data with the input. Otherwise callers that modify
+// a selected object or array also modify the input value it came from.
+func WithCopyLeaves() Option {
+	return func(tree *Tree) { tree.copyLeaves = true }
+}
+
+// WithObserver configures a [Tree] to call fn each time the selectors of a
+// segment select an object member or array item, so that callers can record
+// metrics on how often each segment matches. Segment values compare equal
+// when they represent the same segment, so fn can use them as map keys.
+// fn reports only matches: selection skips members and items that no
+// segment can select, such as those not named by any name selector, so the
+// number of values it evaluates a segment against depends on the other
+// segments of a Tree, while the values a segment selects do not.
+// [Tree.Select], [Tree.SelectTo], [Tree.Walk], [Tree.SelectStream],
+// [Tree.Delete], and the methods based on them call fn. fn must not modify
+// the value being selected, and must be safe for concurrent use if tree
+// selects concurrently.
+func WithObserver(fn func(seg *Segment)) Option {
+	return func(tree *Tree) { tree.observer = fn }
+}
+
+// WithSortedKeys configures a [Tree] to iterate over the members of objects
+// in sorted key order wherever it selects or deletes all of their members
+// with wildcard, filter, or descendant selectors, rather than in random map
+// iteration order. It never changes the values a Tree selects or deletes,
+// but guarantees that repeated calls on the same input evaluate filter
+// expressions, including function extensions, in the same order, and that
+// [Tree.SelectE] reports the same error. It also makes the order of the
+// values appended by [Tree.SelectTo] and passed to [Tree.Walk] deterministic.
+// Has no effect on the objects returned by [Tree.Select], whose keys have no
+// order.
+func WithSortedKeys() Option {
+	return func(tree *Tree) { tree.sorted = true }
+}
+
+// WithSortedWildcardValues configures a [Tree] to visit object values in
+// sorted key order when selecting them with wildcard, filter, and descendant
+// selectors in [Tree.SelectTo], so that the order of its results is
+// deterministic.
+//
+// Deprecated: Use [WithSortedKeys], which does the same and more.
+func WithSortedWildcardValues() Option {
+	return WithSortedKeys()
+}
+
+// WithCoalescedIndexes configures a [Tree] to replace each run of three or
+// more adjacent index selectors in a segment that select contiguous,
+// ascending, non-negative indexes, such as [0,1,2], with a slice selector
+// that selects the same indexes, such as [0:3], to simplify the selectors
+// of Trees compiled from queries that enumerate ranges of indexes. The Tree
+// selects the same values either way. Has no effect on Trees configured by
+// [WithStrictSliceBounds], for which [Tree.SelectE] reports slices that
+// exceed the bounds of an array but not indexes.
+func WithCoalescedIndexes() Option {
+	return func(tree *Tree) { tree.coalesce = true }
+}
+
+// WithSourceKeyOrder configures a [Tree] to encode the objects returned by
+// [Tree.SelectRaw] with their keys in the order they appear in the source
+// JSON, rather than in the sorted order of [encoding/json.Marshal], so that
+// the output is stable for golden files and diffs. Has no effect on the
+// objects returned by [Tree.Select], whose keys have no order.
+func WithSourceKeyOrder() Option {
+	return func(tree *Tree) { tree.keyOrder = true }
+}
+
+// WithMaxDepth configures a [Tree] to select no values nested more than
+// depth levels below the root of an input value, where the members or items
+// of the root are at depth 1. [Tree.Select] and [Tree.SelectTo] stop
+// descending at the maximum depth and return the values selected above it,
+// while [Tree.SelectE] returns [ErrMaxDepth]. Useful to bound the recursion
+// of descendant segments on deeply nested untrusted input. A depth less
+// than 1, the default, means no limit. [Tree.Delete] likewise deletes no
+// values nested more deeply, so do not rely on it to redact them.
+func WithMaxDepth(depth int) Option {
+	return func(tree *Tree) { tree.maxDepth = depth }
+}
+
+// WithMaxDescendDepth configures a [Tree] to stop descendant segments, such
+// as ..["a"], from recursing into values nested depth or more levels below
+// the root of an input value, where the members or items of the root are at
+// depth 1, so that they select no values nested more than depth levels below
+// the root by recursion. A descendant segment still selects from the
+// members or items of the value it applies to, however deeply nested.
+// Unlike [WithMaxDepth], it limits only descendant segments and never
+// causes [Tree.SelectE] to return an error. Useful to save the cost of
+// searching entire deeply nested values for descendants known to appear near
+// the top, but selects nothing nested more deeply, even if a path would
+// otherwise select it, and [Tree.Delete] deletes nothing nested more deeply
+// by recursion, either. A depth less than 1, the default, means no limit.
+func WithMaxDescendDepth(depth int) Option {
+	return func(tree *Tree) { tree.maxDescend = depth }
+}
+
+// WithRawLeaves configures a [Tree] to return each value that
+// [Tree.SelectBytes] and [Tree.SelectBytesNumber] select at the end of a
+// path as a [encoding/json.RawMessage] containing a copy of its original
+// encoding, rather than as a decoded value. Objects and arrays that contain
+// selected values remain map[string]any and []any values. Rather than
+// decoding the entire input, these methods select from it as [Tree.Select]
+// selects from a json.RawMessage, decoding only the objects and arrays that
+// paths select from and the values that filter selectors evaluate, so that
+// they decode neither the selected values nor the parts of the input that
+// paths do not reach. A filter selector that queries the root ($) requires
+// decoding the entire input, however. Useful for forwarding large selected
+// values without paying to decode and encode them again.
+func WithRawLeaves() Option {
+	return func(tree *Tree) { tree.rawLeaves = true }
+}
+
+// WithCaseInsensitiveNames configures a [Tree] to match name selectors to
+// object keys without regard to case, using Unicode case folding, when
+// selecting values with [Tree.Select], [Tree.SelectTo], and [Tree.Delete]
+// and the methods based on them. For example, $.firstName selects the
+// FirstName and firstname members of an object. When several keys of an
+// object differ only by case, a name selector selects all of them. Compiling
+// and merging paths still compares names exactly, so $.a and $.A remain
+// distinct branches of the Tree.
+func WithCaseInsensitiveNames() Option {
+	return func(tree *Tree) { tree.fold = true }
+}
@@ -0,0 +1,1496 @@
+package jsontree
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theory/jsonpath"
+	"github.com/theory/jsonpath/registry"
+	"github.com/theory/jsonpath/spec"
+)
+
+func TestWithExcludeKeys(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"name":   "Kim",
+		"ssn":    "123-45-6789",
+		"pin":    1234,
+		"office": map[string]any{"city": "Albuquerque", "pin": 9876},
+		"cases":  []any{map[string]any{"id": 1, "ssn": "987-65-4321"}},
+	}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		keys  []string
+		exp   any
+	}{
+		{
+			test:  "no_exclusions",
+			paths: []string{"$.*"},
+			exp:   input,
+		},
+		{
+			test:  "wildcard",
+			paths: []string{"$.*"},
+			keys:  []string{"ssn", "pin"},
+			exp: map[string]any{
+				"name":   "Kim",
+				"office": map[string]any{"city": "Albuquerque", "pin": 9876},
+				"cases":  []any{map[string]any{"id": 1, "ssn": "987-65-4321"}},
+			},
+		},
+		{
+			test:  "nested_wildcard",
+			paths: []string{"$.office.*"},
+			keys:  []string{"pin"},
+			exp:   map[string]any{"office": map[string]any{"city": "Albuquerque"}},
+		},
+		{
+			test:  "descendant_then_wildcard",
+			paths: []string{"$..cases[*].*"},
+			keys:  []string{"ssn"},
+			exp:   map[string]any{"cases": []any{map[string]any{"id": 1}}},
+		},
+		{
+			test:  "descendant_wildcard",
+			paths: []string{"$..*"},
+			keys:  []string{"ssn", "pin"},
+			exp:   input,
+		},
+		{
+			test:  "wildcard_not_trailing",
+			paths: []string{"$.*.city"},
+			keys:  []string{"office"},
+			exp:   map[string]any{},
+		},
+		{
+			test:  "name_not_excluded",
+			paths: []string{"$.ssn", "$.name"},
+			keys:  []string{"ssn"},
+			exp:   map[string]any{"name": "Kim", "ssn": "123-45-6789"},
+		},
+		{
+			test:  "unknown_key",
+			paths: []string{"$.office.*"},
+			keys:  []string{"nonesuch"},
+			exp:   map[string]any{"office": map[string]any{"city": "Albuquerque", "pin": 9876}},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := NewCompiler(WithExcludeKeys(tc.keys...)).New(paths...)
+			a.Equal(tc.exp, tree.Select(input))
+		})
+	}
+}
+
+func TestWithKeepTrailingWildcard(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"a":     map[string]any{"x": 1, "y": []any{2, 3}},
+		"items": []any{"a", "b", "c"},
+		"empty": []any{},
+		"name":  "Kim",
+	}
+
+	for _, tc := range []struct {
+		test    string
+		paths   []string
+		tree    *Tree
+		ordered any
+		fixed   any
+	}{
+		{
+			test:    "child_wildcard",
+			paths:   []string{"$.a.*"},
+			tree:    &Tree{root: child().Append(child(spec.Name("a")).Append(child(spec.Wildcard())))},
+			ordered: map[string]any{"a": map[string]any{"x": 1, "y": []any{2, 3}}},
+			fixed:   map[string]any{"a": map[string]any{"x": 1, "y": []any{2, 3}}},
+		},
+		{
+			test:    "array",
+			paths:   []string{"$.items[*]"},
+			tree:    &Tree{root: child().Append(child(spec.Name("items")).Append(child(spec.Wildcard())))},
+			ordered: map[string]any{"items": []any{"a", "b", "c"}},
+			fixed:   map[string]any{"items": []any{"a", "b", "c"}},
+		},
+		{
+			test:    "scalar",
+			paths:   []string{"$.name[*]"},
+			tree:    &Tree{root: child().Append(child(spec.Name("name")).Append(child(spec.Wildcard())))},
+			ordered: map[string]any{},
+			fixed:   map[string]any{},
+		},
+		{
+			test:    "empty",
+			paths:   []string{"$.empty[*]"},
+			ordered: map[string]any{},
+			fixed:   map[string]any{},
+		},
+		{
+			test:    "root",
+			paths:   []string{"$.*"},
+			tree:    &Tree{root: child().Append(child(spec.Wildcard()))},
+			ordered: input,
+			fixed:   input,
+		},
+		{
+			test:    "nested",
+			paths:   []string{"$.*[*]"},
+			tree:    &Tree{root: child().Append(child(spec.Wildcard()).Append(child(spec.Wildcard())))},
+			ordered: map[string]any{"a": map[string]any{"x": 1, "y": []any{2, 3}}, "items": []any{"a", "b", "c"}},
+			fixed:   map[string]any{"a": map[string]any{"x": 1, "y": []any{2, 3}}, "items": []any{"a", "b", "c"}},
+		},
+		{
+			test:    "descendant",
+			paths:   []string{"$..*"},
+			tree:    &Tree{root: child().Append(descendant(spec.Wildcard()))},
+			ordered: input,
+			fixed:   input,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			compiler := NewCompiler(WithKeepTrailingWildcard())
+			tree := compiler.New(paths...)
+			if tc.tree != nil {
+				a.Equal(tc.tree.String(), tree.String())
+			}
+
+			a.Equal(tc.ordered, tree.Select(input))
+			a.Equal(tc.fixed, compiler.NewFixedModeTree(paths...).Select(input))
+
+			// Selects nothing from a scalar.
+			a.Nil(tree.Select("hi"))
+
+			// Without the option, the trailing wildcard selects its parent.
+			if tc.test == "scalar" {
+				a.Equal(map[string]any{"name": "Kim"}, New(paths...).Select(input))
+			}
+		})
+	}
+}
+
+func TestWithRecoverFilters(t *testing.T) {
+	t.Parallel()
+
+	// Function that panics on anything but a string.
+	upper := spec.Extension(
+		"upper",
+		spec.FuncValue,
+		func([]spec.FuncExprArg) error { return nil },
+		func(args []spec.PathValue) spec.PathValue {
+			//nolint:forcetypeassert
+			return spec.Value(strings.ToUpper(args[0].(*spec.ValueType).Value().(string)))
+		},
+	)
+
+	// $[?upper(@.name) == "KIM"]
+	filter := spec.Filter(spec.And(spec.Comparison(
+		spec.Function(upper, spec.SingularQuery(false, spec.Name("name"))),
+		spec.EqualTo,
+		spec.Literal("KIM"),
+	)))
+
+	for _, tc := range []struct {
+		test   string
+		input  any
+		exp    any
+		values int
+	}{
+		{
+			test: "array",
+			input: []any{
+				map[string]any{"name": "Kim"},
+				map[string]any{"name": 42},
+				map[string]any{"name": "kim"},
+			},
+			exp:    []any{map[string]any{"name": "Kim"}, map[string]any{"name": "kim"}},
+			values: 2,
+		},
+		{
+			test: "object",
+			input: map[string]any{
+				"x": map[string]any{"name": "Kim"},
+				"y": map[string]any{"name": true},
+			},
+			exp:    map[string]any{"x": map[string]any{"name": "Kim"}},
+			values: 1,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			tree := &Tree{root: child().Append(child(filter))}
+			a.Panics(func() { tree.Select(tc.input) })
+			a.Panics(func() { tree.SelectTo(nil, tc.input) })
+
+			WithRecoverFilters()(tree)
+			a.Equal(tc.exp, tree.Select(tc.input))
+			a.Len(tree.SelectTo(nil, tc.input), tc.values)
+		})
+	}
+}
+
+func TestWithStrictSliceBounds(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{"x": []any{"a", "b", "c"}}
+
+	for _, tc := range []struct {
+		test  string
+		path  string
+		exp   any
+		fixed any
+		err   string
+	}{
+		{
+			test:  "in_bounds",
+			path:  "$.x[1:3]",
+			exp:   map[string]any{"x": []any{"b", "c"}},
+			fixed: map[string]any{"x": []any{nil, "b", "c"}},
+		},
+		{
+			test: "defaults",
+			path: "$.x[:]",
+			exp:  input,
+		},
+		{
+			test: "negative_in_bounds",
+			path: "$.x[-3:-1]",
+			exp:  map[string]any{"x": []any{"a", "b"}},
+		},
+		{
+			test: "backward_in_bounds",
+			path: "$.x[2:-4:-1]",
+			exp:  map[string]any{"x": []any{"a", "b", "c"}},
+		},
+		{
+			test:  "end_over_len",
+			path:  "$.x[2:5]",
+			exp:   map[string]any{"x": []any{"c"}},
+			fixed: map[string]any{"x": []any{nil, nil, "c"}},
+			err:   "jsontree: slice bounds out of range: [2:5] on array of length 3",
+		},
+		{
+			test: "start_over_len",
+			path: "$.x[4:]",
+			exp:  map[string]any{},
+			err:  "jsontree: slice bounds out of range: [4:] on array of length 3",
+		},
+		{
+			test: "start_under_neg_len",
+			path: "$.x[-4:]",
+			exp:  input,
+			err:  "jsontree: slice bounds out of range: [-4:] on array of length 3",
+		},
+		{
+			test: "backward_start_over_len",
+			path: "$.x[3::-1]",
+			exp:  input,
+			err:  "jsontree: slice bounds out of range: [3::-1] on array of length 3",
+		},
+		{
+			test: "backward_end_under_neg_len",
+			path: "$.x[:-5:-1]",
+			exp:  input,
+			err:  "jsontree: slice bounds out of range: [:-5:-1] on array of length 3",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			path := jsonpath.MustParse(tc.path)
+			if tc.fixed == nil {
+				tc.fixed = tc.exp
+			}
+
+			// Default is lenient.
+			tree := New(path)
+			res, err := tree.SelectE(input)
+			a.NoError(err)
+			a.Equal(tc.exp, res)
+			a.Equal(tc.exp, tree.Select(input))
+
+			// Strict ordered and fixed modes.
+			compiler := NewCompiler(WithStrictSliceBounds())
+			for _, tree := range []*Tree{compiler.New(path), compiler.NewFixedModeTree(path)} {
+				exp := tc.exp
+				if tree.index {
+					exp = tc.fixed
+				}
+
+				a.Equal(exp, tree.Select(input))
+				res, err := tree.SelectE(input)
+				if tc.err == "" {
+					a.NoError(err)
+					a.Equal(exp, res)
+				} else {
+					a.EqualError(err, tc.err)
+					a.ErrorIs(err, ErrSliceBounds)
+					a.Nil(res)
+				}
+			}
+		})
+	}
+}
+
+func TestWithGapValue(t *testing.T) {
+	t.Parallel()
+
+	type gap struct{}
+
+	mkInput := func() map[string]any {
+		return map[string]any{
+			"x": []any{"a", nil, "c", nil, "e"},
+			"y": []any{
+				map[string]any{"id": 1},
+				[]any{nil, 2},
+				map[string]any{"id": 3},
+				[]any{4, nil, 6},
+			},
+		}
+	}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		fixed any
+	}{
+		{
+			test:  "gaps_and_nulls",
+			paths: []string{"$.x[1,2]"},
+			fixed: map[string]any{"x": []any{gap{}, nil, "c"}},
+		},
+		{
+			test:  "step",
+			paths: []string{"$.x[::2]"},
+			fixed: map[string]any{"x": []any{"a", gap{}, "c", gap{}, "e"}},
+		},
+		{
+			test:  "no_gaps",
+			paths: []string{"$.x"},
+			fixed: map[string]any{"x": []any{"a", nil, "c", nil, "e"}},
+		},
+		{
+			test:  "nested",
+			paths: []string{"$.y[2].id", "$.y[3][2]"},
+			fixed: map[string]any{"y": []any{
+				gap{}, gap{},
+				map[string]any{"id": 3},
+				[]any{gap{}, gap{}, 6},
+			}},
+		},
+		{
+			test:  "selected_array_unchanged",
+			paths: []string{"$.y[1]", "$.y[3][1]"},
+			fixed: map[string]any{"y": []any{
+				gap{},
+				[]any{nil, 2},
+				gap{},
+				[]any{gap{}, nil},
+			}},
+		},
+		{
+			test:  "filled_gap",
+			paths: []string{"$.y[3][2]", "$.y[1,3][0]"},
+			fixed: map[string]any{"y": []any{
+				gap{},
+				[]any{nil},
+				gap{},
+				[]any{4, gap{}, 6},
+			}},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			c := NewCompiler(WithGapValue(gap{}))
+			input := mkInput()
+			a.Equal(tc.fixed, c.NewFixedModeTree(paths...).Select(input))
+			a.Equal(mkInput(), input, "input modified")
+
+			// No effect on ordered mode.
+			a.Equal(New(paths...).Select(mkInput()), c.New(paths...).Select(mkInput()))
+
+			// Default gap is nil.
+			a.Equal(
+				strings.ReplaceAll(fmt.Sprint(tc.fixed), "{}", "<nil>"),
+				fmt.Sprint(NewFixedModeTree(paths...).Select(mkInput())),
+			)
+		})
+	}
+}
+
+func TestWithSortedKeysSelectTo(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"obj": map[string]any{
+			"e": map[string]any{"id": 5},
+			"b": map[string]any{"id": 2},
+			"d": map[string]any{"id": 4},
+			"a": map[string]any{"id": 1},
+			"c": map[string]any{"id": 3},
+		},
+	}
+
+	for _, tc := range []struct {
+		test string
+		path string
+		exp  []any
+	}{
+		{
+			test: "wildcard",
+			path: "$.obj.*.id",
+			exp:  []any{1, 2, 3, 4, 5},
+		},
+		{
+			test: "filter",
+			path: "$.obj[?@.id > 1].id",
+			exp:  []any{2, 3, 4, 5},
+		},
+		{
+			test: "descendant",
+			path: "$..id",
+			exp:  []any{1, 2, 3, 4, 5},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			path := jsonpath.MustParse(tc.path)
+			tree := NewCompiler(WithSortedKeys()).New(path)
+			for range 20 {
+				a.Equal(tc.exp, tree.SelectTo(nil, input))
+			}
+
+			// Deprecated alias sorts the same way.
+			tree = NewCompiler(WithSortedWildcardValues()).New(path)
+			a.Equal(tc.exp, tree.SelectTo(nil, input))
+
+			// Unsorted by default.
+			a.ElementsMatch(tc.exp, New(path).SelectTo(nil, input))
+		})
+	}
+}
+
+func TestWithSortedKeys(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"obj": map[string]any{
+			"e": map[string]any{"id": 5},
+			"b": map[string]any{"id": 2},
+			"d": map[string]any{"id": 4},
+			"a": map[string]any{"id": 1},
+			"c": map[string]any{"id": 3, "x": map[string]any{"id": 6}},
+		},
+	}
+
+	for _, tc := range []struct {
+		test string
+		path string
+		exp  []any
+	}{
+		{
+			test: "filter",
+			path: "$.obj[?seen(@.id)]",
+			exp:  []any{1, 2, 3, 4, 5},
+		},
+		{
+			test: "descendant_filter",
+			path: "$..[?seen(@.id)]",
+			// Selects c in its entirety, so never evaluates c.x.
+			exp: []any{1, 2, 3, 4, 5},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			// Register seen() to record the order in which filters
+			// evaluate values.
+			var seen []any
+
+			reg := registry.New()
+			require.NoError(t, reg.Register(
+				"seen",
+				spec.FuncLogical,
+				func([]spec.FuncExprArg) error { return nil },
+				func(args []spec.PathValue) spec.PathValue {
+					val := spec.ValueFrom(args[0])
+					if val == nil {
+						return spec.LogicalFalse
+					}
+					seen = append(seen, val.Value())
+					return spec.LogicalTrue
+				},
+			))
+			path := jsonpath.NewParser(jsonpath.WithRegistry(reg)).MustParse(tc.path)
+			tree := NewCompiler(WithSortedKeys()).New(path)
+			exp := New(path).Select(input)
+
+			for range 10 {
+				seen = nil
+				a.Equal(exp, tree.Select(input))
+				a.Equal(tc.exp, seen)
+
+				seen = nil
+				tree.Delete(input)
+				a.Equal(tc.exp, seen)
+
+				seen = nil
+				tree.SelectTo(nil, input)
+				a.Equal(tc.exp, seen)
+			}
+		})
+	}
+}
+
+func TestWithCoalescedIndexes(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"a": []any{0, 1, 2, 3, 4, 5, 6, 7},
+		"b": map[string]any{"c": []any{[]any{0, 1, 2, 3}, []any{4, 5, 6, 7}}},
+	}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		str   string
+	}{
+		{
+			test:  "three",
+			paths: []string{"$.a[0,1,2]"},
+			str:   "$\n└── [\"a\"]\n    └── [:3]\n",
+		},
+		{
+			test:  "two",
+			paths: []string{"$.a[3,4]"},
+			str:   "$\n└── [\"a\"]\n    └── [3,4]\n",
+		},
+		{
+			test:  "runs",
+			paths: []string{"$.a[2,3,4,5,0,6,7,1]"},
+			str:   "$\n└── [\"a\"]\n    └── [2:6,0,1,6,7]\n",
+		},
+		{
+			test:  "descending",
+			paths: []string{"$.a[3,2,1]"},
+			str:   "$\n└── [\"a\"]\n    └── [1,2,3]\n",
+		},
+		{
+			test:  "negative",
+			paths: []string{"$.a[-3,-2,-1]"},
+			str:   "$\n└── [\"a\"]\n    └── [-3,-2,-1]\n",
+		},
+		{
+			test:  "mixed_selectors",
+			paths: []string{`$.b["c",1,2,3].*[5:,0,1,2]`},
+			str:   "$\n└── [\"b\"]\n    └── [1:4,\"c\"]\n        └── [*]\n            └── [:3,5:]\n",
+		},
+		{
+			test:  "merged_paths",
+			paths: []string{"$.a[0]", "$.a[1]", "$.a[2]"},
+			str:   "$\n└── [\"a\"]\n    └── [:3]\n",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			strs := make([]string, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+				strs[i] = paths[i].String()
+			}
+
+			c := NewCompiler(WithCoalescedIndexes())
+			for _, mode := range []struct {
+				tree *Tree
+				exp  *Tree
+			}{
+				{c.New(paths...), New(paths...)},
+				{c.NewFixedModeTree(paths...), NewFixedModeTree(paths...)},
+			} {
+				a.Equal(tc.str, mode.tree.String())
+				a.Equal(mode.exp.Select(input), mode.tree.Select(input))
+				a.Equal(mode.exp.SelectTo(nil, input), mode.tree.SelectTo(nil, input))
+			}
+
+			// Paths must be unchanged.
+			for i, p := range paths {
+				a.Equal(strs[i], p.String())
+			}
+		})
+	}
+
+	t.Run("add_path", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		tree := NewCompiler(WithCoalescedIndexes()).New(jsonpath.MustParse("$[0,1]"))
+		a.Equal("$\n└── [0,1]\n", tree.String())
+		a.NoError(tree.AddPath(jsonpath.MustParse("$[2]")))
+		a.Equal("$\n└── [:3]\n", tree.String())
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		tree := NewCompiler(WithCoalescedIndexes(), WithStrictSliceBounds()).New(jsonpath.MustParse("$[0,1,2]"))
+		a.Equal("$\n└── [0,1,2]\n", tree.String())
+		_, err := tree.SelectE([]any{1})
+		a.NoError(err)
+	})
+}
+
+func TestWithSourceKeyOrder(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test    string
+		paths   []string
+		src     string
+		ordered string
+		fixed   string
+	}{
+		{
+			test:    "root",
+			paths:   []string{"$"},
+			src:     `{"z": 1, "a": {"y": 2, "b": 3}, "m": [{"q": 1, "c": 2}]}`,
+			ordered: `{"z":1,"a":{"y":2,"b":3},"m":[{"q":1,"c":2}]}`,
+			fixed:   `{"z":1,"a":{"y":2,"b":3},"m":[{"q":1,"c":2}]}`,
+		},
+		{
+			test:    "names",
+			paths:   []string{"$.a", "$.z", "$.m.y"},
+			src:     `{"z": 1, "x": 0, "m": {"y": true, "b": false}, "a": 2}`,
+			ordered: `{"z":1,"m":{"y":true},"a":2}`,
+			fixed:   `{"z":1,"m":{"y":true},"a":2}`,
+		},
+		{
+			test:    "array_gaps",
+			paths:   []string{"$[1,3]"},
+			src:     `[{"b": 1, "a": 2}, {"d": 3, "c": 4}, 5, {"f": 6, "e": 7}]`,
+			ordered: `[{"d":3,"c":4},{"f":6,"e":7}]`,
+			fixed:   `[null,{"d":3,"c":4},null,{"f":6,"e":7}]`,
+		},
+		{
+			test:    "nested_in_array",
+			paths:   []string{"$.list[*].z", "$.list[*].a"},
+			src:     `{"list": [{"z": 1, "m": 0, "a": 2}, {"a": 3}, {"m": 4}]}`,
+			ordered: `{"list":[{"z":1,"a":2},{"a":3}]}`,
+			fixed:   `{"list":[{"z":1,"a":2},{"a":3}]}`,
+		},
+		{
+			test:    "filter",
+			paths:   []string{`$[?@.n > 1]`},
+			src:     `[{"n": 1, "id": "a"}, {"n": 2, "id": "b"}]`,
+			ordered: `[{"n":2,"id":"b"}]`,
+			fixed:   `[null,{"n":2,"id":"b"}]`,
+		},
+		{
+			test:    "duplicate_keys",
+			paths:   []string{"$.b", "$.a"},
+			src:     `{"b": 1, "a": 2, "b": 3}`,
+			ordered: `{"b":3,"a":2}`,
+			fixed:   `{"b":3,"a":2}`,
+		},
+		{
+			test:    "precise_numbers",
+			paths:   []string{"$.n"},
+			src:     `{"n": [12345678901234567890, 1.10]}`,
+			ordered: `{"n":[12345678901234567890,1.10]}`,
+			fixed:   `{"n":[12345678901234567890,1.10]}`,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			c := NewCompiler(WithSourceKeyOrder())
+			for range 10 {
+				res, err := c.New(paths...).SelectRaw(json.RawMessage(tc.src))
+				r.NoError(err)
+				a.Equal(tc.ordered, string(res))
+
+				res, err = c.NewFixedModeTree(paths...).SelectRaw(json.RawMessage(tc.src))
+				r.NoError(err)
+				a.Equal(tc.fixed, string(res))
+			}
+		})
+	}
+
+	t.Run("gap_value", func(t *testing.T) {
+		t.Parallel()
+		tree := NewCompiler(WithSourceKeyOrder(), WithGapValue("GAP")).
+			NewFixedModeTree(jsonpath.MustParse("$[2]"))
+		res, err := tree.SelectRaw(json.RawMessage(`[1, null, {"b": 1, "a": 2}]`))
+		require.NoError(t, err)
+		assert.Equal(t, `["GAP","GAP",{"b":1,"a":2}]`, string(res))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		t.Parallel()
+		tree := NewCompiler(WithSourceKeyOrder(), WithStrictSliceBounds()).
+			New(jsonpath.MustParse("$[1:9]"))
+		_, err := tree.SelectRaw(json.RawMessage(`[1, 2]`))
+		require.ErrorIs(t, err, ErrSliceBounds)
+
+		_, err = tree.SelectRaw(json.RawMessage(`[1, 2`))
+		require.ErrorIs(t, err, ErrJSON)
+	})
+}
+
+func TestWithMaxDepth(t *testing.T) {
+	t.Parallel()
+
+	// {"a": {"a": {"a": {"a": 1}}}, "b": [[[2]]]}
+	input := map[string]any{
+		"a": map[string]any{"a": map[string]any{"a": map[string]any{"a": 1}}},
+		"b": []any{[]any{[]any{2}}},
+	}
+
+	for _, tc := range []struct {
+		test   string
+		path   string
+		depth  int
+		exp    any
+		values []any
+		err    bool
+	}{
+		{
+			test:   "unlimited",
+			path:   "$..a",
+			exp:    map[string]any{"a": input["a"]},
+			values: []any{input["a"]},
+		},
+		{
+			test:   "within_depth",
+			path:   "$.a.a.a",
+			depth:  3,
+			exp:    map[string]any{"a": map[string]any{"a": map[string]any{"a": map[string]any{"a": 1}}}},
+			values: []any{map[string]any{"a": 1}},
+		},
+		{
+			test:   "beyond_depth",
+			path:   "$.a.a.a.a",
+			depth:  3,
+			exp:    map[string]any{},
+			values: []any{},
+			err:    true,
+		},
+		{
+			test:  "descendant",
+			path:  "$..a",
+			depth: 2,
+			exp:   map[string]any{"a": input["a"]},
+			err:   true,
+		},
+		{
+			test:   "descendant_array",
+			path:   "$..[0]",
+			depth:  2,
+			exp:    map[string]any{"b": []any{[]any{[]any{2}}}},
+			values: []any{[]any{[]any{2}}},
+			err:    true,
+		},
+		{
+			test:   "leaf_at_depth",
+			path:   "$.b[0][0]",
+			depth:  3,
+			exp:    map[string]any{"b": []any{[]any{[]any{2}}}},
+			values: []any{[]any{2}},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			tree := NewCompiler(WithMaxDepth(tc.depth)).NewFixedModeTree(jsonpath.MustParse(tc.path))
+			a.Equal(tc.exp, tree.Select(input))
+
+			res, err := tree.SelectE(input)
+			if tc.err {
+				r.EqualError(err, fmt.Sprintf("jsontree: maximum depth exceeded: %d", tc.depth))
+				r.ErrorIs(err, ErrMaxDepth)
+				a.Nil(res)
+			} else {
+				r.NoError(err)
+				a.Equal(tc.exp, res)
+			}
+
+			if tc.values != nil {
+				a.ElementsMatch(tc.values, tree.SelectTo(nil, input))
+			}
+		})
+	}
+}
+
+func TestWithMaxDescendDepth(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"x":    map[string]any{"id": 1, "y": map[string]any{"id": 2, "z": map[string]any{"id": 3}}},
+		"list": []any{map[string]any{"id": 4}, []any{[]any{5}}},
+	}
+
+	for _, tc := range []struct {
+		test  string
+		path  string
+		depth int
+		exp   any
+		paths []string
+	}{
+		{
+			test: "unlimited",
+			path: "$..id",
+			exp: map[string]any{
+				"x":    map[string]any{"id": 1, "y": map[string]any{"id": 2, "z": map[string]any{"id": 3}}},
+				"list": []any{map[string]any{"id": 4}},
+			},
+			paths: []string{"$['x']['id']", "$['x']['y']['id']", "$['x']['y']['z']['id']", "$['list'][0]['id']"},
+		},
+		{
+			test:  "depth_1",
+			path:  "$..id",
+			depth: 1,
+			exp:   map[string]any{},
+			paths: []string{},
+		},
+		{
+			test:  "depth_2",
+			path:  "$..id",
+			depth: 2,
+			exp:   map[string]any{"x": map[string]any{"id": 1}},
+			paths: []string{"$['x']['id']"},
+		},
+		{
+			test:  "depth_3",
+			path:  "$..id",
+			depth: 3,
+			exp: map[string]any{
+				"x":    map[string]any{"id": 1, "y": map[string]any{"id": 2}},
+				"list": []any{map[string]any{"id": 4}},
+			},
+			paths: []string{"$['x']['id']", "$['x']['y']['id']", "$['list'][0]['id']"},
+		},
+		{
+			test:  "beyond_document",
+			path:  "$..id",
+			depth: 10,
+			exp: map[string]any{
+				"x":    map[string]any{"id": 1, "y": map[string]any{"id": 2, "z": map[string]any{"id": 3}}},
+				"list": []any{map[string]any{"id": 4}},
+			},
+			paths: []string{"$['x']['id']", "$['x']['y']['id']", "$['x']['y']['z']['id']", "$['list'][0]['id']"},
+		},
+		{
+			test:  "nested_descendant",
+			path:  "$.x.y..id",
+			depth: 1,
+			exp:   map[string]any{"x": map[string]any{"y": map[string]any{"id": 2}}},
+			paths: []string{"$['x']['y']['id']"},
+		},
+		{
+			test:  "array",
+			path:  "$.list..[0]",
+			depth: 2,
+			exp:   map[string]any{"list": []any{map[string]any{"id": 4}}},
+			paths: []string{"$['list'][0]"},
+		},
+		{
+			test:  "child_segments",
+			path:  "$.x.y.z.id",
+			depth: 1,
+			exp:   map[string]any{"x": map[string]any{"y": map[string]any{"z": map[string]any{"id": 3}}}},
+			paths: []string{"$['x']['y']['z']['id']"},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			tree := NewCompiler(WithMaxDescendDepth(tc.depth)).New(jsonpath.MustParse(tc.path))
+			a.Equal(tc.exp, tree.Select(input))
+			a.ElementsMatch(tc.paths, tree.SelectPaths(input))
+			a.Len(tree.SelectTo(nil, input), len(tc.paths))
+
+			// Never an error.
+			res, err := tree.SelectE(input)
+			r.NoError(err)
+			a.Equal(tc.exp, res)
+		})
+	}
+}
+
+func TestWithCaseInsensitiveNames(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"Person": map[string]any{
+			"FirstName": "Kim",
+			"firstname": "kim",
+			"LastName":  "Lee",
+			"Émile":     true,
+		},
+		"people": []any{
+			map[string]any{"FIRSTNAME": "Sam"},
+			map[string]any{"age": 42},
+		},
+	}
+
+	for _, tc := range []struct {
+		test    string
+		paths   []string
+		exp     any
+		exact   any
+		values  []any
+		deleted any
+	}{
+		{
+			test:  "name",
+			paths: []string{"$.person.firstName"},
+			exp: map[string]any{"Person": map[string]any{
+				"FirstName": "Kim",
+				"firstname": "kim",
+			}},
+			exact:  map[string]any{},
+			values: []any{"Kim", "kim"},
+			deleted: map[string]any{
+				"Person": map[string]any{"LastName": "Lee", "Émile": true},
+				"people": input["people"],
+			},
+		},
+		{
+			test:   "unicode",
+			paths:  []string{"$.PERSON.éMILE"},
+			exp:    map[string]any{"Person": map[string]any{"Émile": true}},
+			exact:  map[string]any{},
+			values: []any{true},
+			deleted: map[string]any{
+				"Person": map[string]any{"FirstName": "Kim", "firstname": "kim", "LastName": "Lee"},
+				"people": input["people"],
+			},
+		},
+		{
+			test:   "array_items",
+			paths:  []string{"$.People[*].FirstName"},
+			exp:    map[string]any{"people": []any{map[string]any{"FIRSTNAME": "Sam"}}},
+			exact:  map[string]any{},
+			values: []any{"Sam"},
+			deleted: map[string]any{
+				"Person": input["Person"],
+				"people": []any{map[string]any{}, map[string]any{"age": 42}},
+			},
+		},
+		{
+			test:  "descendant",
+			paths: []string{"$..lastname"},
+			exp: map[string]any{"Person": map[string]any{
+				"LastName": "Lee",
+			}},
+			exact:  map[string]any{},
+			values: []any{"Lee"},
+			deleted: map[string]any{
+				"Person": map[string]any{"FirstName": "Kim", "firstname": "kim", "Émile": true},
+				"people": input["people"],
+			},
+		},
+		{
+			test:  "exact_and_folded",
+			paths: []string{"$.Person.LastName", "$.person.lastname"},
+			exp: map[string]any{"Person": map[string]any{
+				"LastName": "Lee",
+			}},
+			exact:  map[string]any{"Person": map[string]any{"LastName": "Lee"}},
+			values: []any{"Lee"},
+			deleted: map[string]any{
+				"Person": map[string]any{"FirstName": "Kim", "firstname": "kim", "Émile": true},
+				"people": input["people"],
+			},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := NewCompiler(WithCaseInsensitiveNames()).New(paths...)
+			a.Equal(tc.exp, tree.Select(input))
+			a.ElementsMatch(tc.values, tree.SelectTo(nil, input))
+			a.Equal(tc.deleted, tree.Delete(input))
+
+			// Compilation still compares names exactly.
+			a.Equal(New(paths...).root, tree.root)
+
+			// Exact by default.
+			a.Equal(tc.exact, New(paths...).Select(input))
+		})
+	}
+}
+
+func TestWithNullMissingNames(t *testing.T) {
+	t.Parallel()
+
+	src := `{"z": 0, "a": {"x": 1, "y": [{"b": 2}, {"c": 3}]}, "B": 4}`
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		fixed bool
+		fold  bool
+		exp   any
+	}{
+		{
+			test:  "present",
+			paths: []string{"$.a.x"},
+			exp:   map[string]any{"a": map[string]any{"x": 1}},
+		},
+		{
+			test:  "missing",
+			paths: []string{`$["z","q","p"]`},
+			exp:   map[string]any{"z": 0, "q": nil, "p": nil},
+		},
+		{
+			test:  "missing_parent",
+			paths: []string{"$.q.x", "$.z"},
+			exp:   map[string]any{"q": nil, "z": 0},
+		},
+		{
+			test:  "nested",
+			paths: []string{"$.a.w", "$.a.x"},
+			exp:   map[string]any{"a": map[string]any{"x": 1, "w": nil}},
+		},
+		{
+			test:  "array_items",
+			paths: []string{"$.a.y[*].b"},
+			exp: map[string]any{"a": map[string]any{"y": []any{
+				map[string]any{"b": 2}, map[string]any{"b": nil},
+			}}},
+		},
+		{
+			test:  "fixed_mode",
+			paths: []string{"$.a.y[1].b"},
+			fixed: true,
+			exp: map[string]any{"a": map[string]any{"y": []any{
+				nil, map[string]any{"b": nil},
+			}}},
+		},
+		{
+			test:  "with_wildcard",
+			paths: []string{"$.*.x", "$.a.w"},
+			exp:   map[string]any{"a": map[string]any{"x": 1, "w": nil}},
+		},
+		{
+			test:  "not_filters",
+			paths: []string{"$.a.y[?@.b]"},
+			exp:   map[string]any{"a": map[string]any{"y": []any{map[string]any{"b": 2}}}},
+		},
+		{
+			test:  "not_descendants",
+			paths: []string{"$..w"},
+			exp:   map[string]any{},
+		},
+		{
+			test:  "case_insensitive",
+			paths: []string{"$.b", "$.Z", "$.q"},
+			fold:  true,
+			exp:   map[string]any{"B": 4, "z": 0, "q": nil},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			opts := []Option{WithNullMissingNames()}
+			if tc.fold {
+				opts = append(opts, WithCaseInsensitiveNames())
+			}
+
+			compiler := NewCompiler(opts...)
+			tree := compiler.New(paths...)
+			ordered := NewCompiler(append(opts, WithSourceKeyOrder())...).New(paths...)
+
+			if tc.fixed {
+				tree = compiler.NewFixedModeTree(paths...)
+				ordered = NewCompiler(append(opts, WithSourceKeyOrder())...).NewFixedModeTree(paths...)
+			}
+
+			exp, err := json.Marshal(tc.exp)
+			r.NoError(err)
+
+			// Select, SelectStream, and SelectRaw agree.
+			var value any
+			r.NoError(json.Unmarshal([]byte(src), &value))
+			got, err := json.Marshal(tree.Select(value))
+			r.NoError(err)
+			a.JSONEq(string(exp), string(got))
+
+			var buf bytes.Buffer
+			r.NoError(tree.SelectStream(value, &buf))
+			a.Equal(string(got), buf.String())
+
+			raw, err := tree.SelectRaw(json.RawMessage(src))
+			r.NoError(err)
+			a.JSONEq(string(exp), string(raw))
+
+			raw, err = ordered.SelectRaw(json.RawMessage(src))
+			r.NoError(err)
+			a.JSONEq(string(exp), string(raw))
+		})
+	}
+}
+
+func TestWithNullGapThreshold(t *testing.T) {
+	t.Parallel()
+
+	src := `{"x": [0, 1, 2, 3, 4, 5, 6], "y": [{"a": 1}, {"b": 2}, {"a": 3}, {"b": 4}, {"b": 5}, {"a": 6}]}`
+
+	for _, tc := range []struct {
+		test      string
+		threshold int
+		paths     []string
+		exp       any
+	}{
+		{
+			test:      "default",
+			threshold: 0,
+			paths:     []string{"$.x[0,2,5]"},
+			exp:       map[string]any{"x": []any{0, 2, 5}},
+		},
+		{
+			test:      "short_gaps",
+			threshold: 1,
+			paths:     []string{"$.x[0,2,5]"},
+			exp:       map[string]any{"x": []any{0, nil, 2, 5}},
+		},
+		{
+			test:      "longer_gaps",
+			threshold: 2,
+			paths:     []string{"$.x[0,2,5]"},
+			exp:       map[string]any{"x": []any{0, nil, 2, nil, nil, 5}},
+		},
+		{
+			test:      "leading_gap",
+			threshold: 2,
+			paths:     []string{"$.x[2,3]"},
+			exp:       map[string]any{"x": []any{nil, nil, 2, 3}},
+		},
+		{
+			test:      "long_leading_gap",
+			threshold: 2,
+			paths:     []string{"$.x[3,4]"},
+			exp:       map[string]any{"x": []any{3, 4}},
+		},
+		{
+			test:      "no_trailing_gap",
+			threshold: 4,
+			paths:     []string{"$.x[4]"},
+			exp:       map[string]any{"x": []any{nil, nil, nil, nil, 4}},
+		},
+		{
+			test:      "filter",
+			threshold: 1,
+			paths:     []string{"$.y[?@.a].a"},
+			exp:       map[string]any{"y": []any{map[string]any{"a": 1}, nil, map[string]any{"a": 3}, map[string]any{"a": 6}}},
+		},
+		{
+			test:      "nested",
+			threshold: 1,
+			paths:     []string{"$.y[?@.b]", "$.x[1,3]"},
+			exp: map[string]any{
+				"x": []any{nil, 1, nil, 3},
+				"y": []any{nil, map[string]any{"b": 2}, nil, map[string]any{"b": 4}, map[string]any{"b": 5}},
+			},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := NewCompiler(WithNullGapThreshold(tc.threshold)).New(paths...)
+			exp, err := json.Marshal(tc.exp)
+			r.NoError(err)
+
+			// Select, SelectStream, and SelectRaw agree.
+			var value any
+			r.NoError(json.Unmarshal([]byte(src), &value))
+			got, err := json.Marshal(tree.Select(value))
+			r.NoError(err)
+			a.JSONEq(string(exp), string(got))
+
+			var buf bytes.Buffer
+			r.NoError(tree.SelectStream(value, &buf))
+			a.Equal(string(got), buf.String())
+
+			raw, err := tree.SelectRaw(json.RawMessage(src))
+			r.NoError(err)
+			a.JSONEq(string(exp), string(raw))
+
+			ordered := NewCompiler(WithNullGapThreshold(tc.threshold), WithSourceKeyOrder()).New(paths...)
+			raw, err = ordered.SelectRaw(json.RawMessage(src))
+			r.NoError(err)
+			a.JSONEq(string(exp), string(raw))
+
+			leaves := NewCompiler(WithNullGapThreshold(tc.threshold), WithRawLeaves()).New(paths...)
+			res, err := leaves.SelectBytes([]byte(src))
+			r.NoError(err)
+			got, err = json.Marshal(res)
+			r.NoError(err)
+			a.JSONEq(string(exp), string(got))
+
+			// Fixed mode ignores the threshold.
+			fixed := NewCompiler(WithNullGapThreshold(tc.threshold)).NewFixedModeTree(paths...)
+			a.Equal(NewFixedModeTree(paths...).Select(value), fixed.Select(value))
+		})
+	}
+}
+
+func TestWithCopyLeaves(t *testing.T) {
+	t.Parallel()
+
+	mkInput := func() map[string]any {
+		return map[string]any{
+			"a": map[string]any{"b": []any{1, map[string]any{"c": 2}}},
+			"x": []any{[]any{1, 2}, map[string]any{"y": "z"}},
+			"r": json.RawMessage(`{"s":1}`),
+		}
+	}
+
+	// mutate modifies every object, array, and raw message in val.
+	var mutate func(val any)
+	mutate = func(val any) {
+		switch val := val.(type) {
+		case map[string]any:
+			for k, v := range val {
+				mutate(v)
+				val[k] = "changed"
+			}
+			val["new"] = true
+		case []any:
+			for i, v := range val {
+				mutate(v)
+				val[i] = "changed"
+			}
+		case json.RawMessage:
+			for i := range val {
+				val[i] = ' '
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		fixed bool
+		sel   func(tree *Tree, from map[string]any) any
+	}{
+		{
+			test:  "select",
+			paths: []string{"$.a", "$.x[1]", "$.r"},
+			sel:   func(tree *Tree, from map[string]any) any { return tree.Select(from) },
+		},
+		{
+			test:  "select_fixed",
+			paths: []string{"$.a.b", "$.x[1]"},
+			fixed: true,
+			sel:   func(tree *Tree, from map[string]any) any { return tree.Select(from) },
+		},
+		{
+			test: "root_only",
+			sel:  func(tree *Tree, from map[string]any) any { return tree.Select(from) },
+		},
+		{
+			test:  "select_to",
+			paths: []string{"$.a.b", "$.x[*]", "$.r"},
+			sel:   func(tree *Tree, from map[string]any) any { return tree.SelectTo(nil, from) },
+		},
+		{
+			test: "select_to_root_only",
+			sel:  func(tree *Tree, from map[string]any) any { return tree.SelectTo(nil, from) },
+		},
+		{
+			test: "select_into_root_only",
+			sel: func(tree *Tree, from map[string]any) any {
+				dst := map[string]any{}
+				_ = tree.SelectIntoMode(from, dst, false)
+				return dst
+			},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			compile := func(opts ...Option) *Tree {
+				if tc.fixed {
+					return NewCompiler(opts...).NewFixedModeTree(paths...)
+				}
+				return NewCompiler(opts...).New(paths...)
+			}
+
+			// Copies select the same values and share nothing with the input.
+			input := mkInput()
+			res := tc.sel(compile(WithCopyLeaves()), input)
+			a.Equal(tc.sel(compile(), mkInput()), res)
+			mutate(res)
+			a.Equal(mkInput(), input)
+
+			// Without copies, modifying the result modifies the input.
+			mutate(tc.sel(compile(), input))
+			a.NotEqual(mkInput(), input)
+		})
+	}
+}
+
+func TestWithObserver(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	seen := map[Segment]int{}
+	observe := func(seg *Segment) { seen[*seg]++ }
+
+	paths := []*jsonpath.Path{jsonpath.MustParse("$.a[?@ > 1]"), jsonpath.MustParse("$.b.c")}
+	tree := NewCompiler(WithObserver(observe)).New(paths...)
+	input := map[string]any{
+		"a": []any{1, 2, 3},
+		"b": map[string]any{"c": 1, "d": 2},
+		"e": 0,
+	}
+
+	// The observer does not alter selection.
+	a.Equal(New(paths...).Select(input), tree.Select(input))
+
+	branches := tree.Root().Children()
+	a.Len(branches, 2)
+	filter, name := branches[0].Children()[0], branches[1].Children()[0]
+	a.Equal(map[Segment]int{
+		*branches[0]: 1,
+		*branches[1]: 1,
+		*filter:      2,
+		*name:        1,
+	}, seen)
+
+	t.Run("siblings", func(t *testing.T) {
+		t.Parallel()
+
+		input := map[string]any{
+			"a": map[string]any{"v": 1, "w": 2},
+			"b": map[string]any{"v": 3, "w": 4},
+			"c": []any{map[string]any{"v": 5}, map[string]any{"w": 6}, map[string]any{"v": 7}},
+		}
+
+		// count selects from input with Trees compiled from paths in both
+		// modes and returns the number of times segments with the selectors
+		// sels select a value.
+		count := func(sels string, paths ...string) int {
+			matched := 0
+			compiler := NewCompiler(WithObserver(func(seg *Segment) {
+				if FormatSelectors(seg.Selectors()) == sels {
+					matched++
+				}
+			}))
+
+			parsed := make([]*jsonpath.Path, len(paths))
+			for i, p := range paths {
+				parsed[i] = jsonpath.MustParse(p)
+			}
+
+			compiler.New(parsed...).Select(input)
+			compiler.NewFixedModeTree(parsed...).Select(input)
+
+			return matched
+		}
+
+		for _, tc := range []struct {
+			test     string
+			path     string
+			sels     string
+			siblings []string
+		}{
+			{"name", "$.a.v", `["a"]`, []string{"$.b.w", "$[*].w", "$[?@.v].w", "$..w"}},
+			{"child_name", "$.a.v", `["v"]`, []string{"$.a.w.x", "$.a[*].x", "$.a[?@.x].y", "$.a..w"}},
+			{"index", "$.c[0].v", "[0]", []string{"$.c[2].w", "$.c[*].w", "$.c[1:].w", "$.c[?@.w].w"}},
+			{"filter", "$[?@.v > 1].v", `[?@["v"] > 1]`, []string{"$.a.w", "$[*].w", "$..v"}},
+		} {
+			t.Run(tc.test, func(t *testing.T) {
+				t.Parallel()
+				a := assert.New(t)
+
+				alone := count(tc.sels, tc.path)
+				a.Positive(alone)
+
+				// Adding a sibling path leaves the count unchanged.
+				for _, sib := range tc.siblings {
+					a.Equal(alone, count(tc.sels, tc.path, sib), sib)
+				}
+			})
+		}
+	})
+}
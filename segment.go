@@ -1,10 +1,13 @@
 package jsontree
 
 import (
+	"fmt"
+	"io"
 	"math"
 	"slices"
 	"strings"
 
+	"github.com/theory/jsonpath"
 	"github.com/theory/jsonpath/spec"
 )
 
@@ -13,6 +16,77 @@ type segment struct {
 	selectors  []spec.Selector
 	children   []*segment
 	descendant bool
+
+	// names, when set, indexes selectors, which must all be [spec.Name]s,
+	// for matching member names without iterating over them. Set by index.
+	names map[string]struct{}
+}
+
+// minIndexedNames is the minimum number of name selectors a segment must
+// have for index to index them, below which iterating over them is cheap.
+const minIndexedNames = 16
+
+// index indexes the names selected by seg and its descendants that have
+// only name selectors, at least minIndexedNames of them, so that markMember
+// can match a member name to each of them in constant time. Must be called
+// again after changing their selectors.
+func (seg *segment) index() {
+	seg.names = nil
+
+	if len(seg.selectors) >= minIndexedNames {
+		names := make(map[string]struct{}, len(seg.selectors))
+		for _, sel := range seg.selectors {
+			name, ok := sel.(spec.Name)
+			if !ok {
+				names = nil
+				break
+			}
+
+			names[string(name)] = struct{}{}
+		}
+
+		seg.names = names
+	}
+
+	for _, c := range seg.children {
+		c.index()
+	}
+}
+
+// Segment provides read-only access to a segment of a compiled [Tree], for
+// tools that inspect or render its structure. Get the root Segment from
+// [Tree.Root]. Segment values compare equal when they represent the same
+// segment of the same Tree.
+type Segment struct {
+	seg *segment
+}
+
+// Selectors returns a copy of the selectors of s.
+func (s *Segment) Selectors() []spec.Selector {
+	return slices.Clone(s.seg.selectors)
+}
+
+// Children returns the child Segments of s, which apply to the values
+// selected by s.
+func (s *Segment) Children() []*Segment {
+	children := make([]*Segment, len(s.seg.children))
+	for i, c := range s.seg.children {
+		children[i] = &Segment{c}
+	}
+
+	return children
+}
+
+// IsDescendant returns true if s is a descendant segment, which applies its
+// selectors to a value and all of its descendants.
+func (s *Segment) IsDescendant() bool {
+	return s.seg.descendant
+}
+
+// String returns a string representation of s and its children as a tree
+// diagram.
+func (s *Segment) String() string {
+	return s.seg.String()
 }
 
 // child creates and returns a child ([<selectors>]) Segment.
@@ -41,7 +115,8 @@ func abs(x int) int {
 
 // hasSelector returns true if seg contains sel and false if it does not.
 // Accounts for [spec.Index]es in [spec.SliceSelector]s, [spec.SliceSelector]
-// overlap, and compares [*spec.FilterSelector] strings.
+// overlap, and compares [*spec.FilterSelector]s after normalization, so that
+// logically equivalent filters compare equal.
 func (seg *segment) hasSelector(sel spec.Selector) bool {
 	return selectorsContain(seg.selectors, sel)
 }
@@ -64,7 +139,8 @@ func (seg *segment) hasSameSelectors(selectors []spec.Selector) bool {
 
 // selectorsContain returns true if selectors contains sel and false if it
 // does not. Accounts for [spec.Index]es in [spec.SliceSelector]s,
-// [spec.SliceSelector] overlap, and compares [*spec.FilterSelector] strings.
+// [spec.SliceSelector] overlap, and compares [*spec.FilterSelector]s after
+// normalization, so that logically equivalent filters compare equal.
 func selectorsContain(selectors []spec.Selector, sel spec.Selector) bool {
 	if len(selectors) == 1 {
 		// A wildcard selector should always be the only selector.
@@ -93,6 +169,15 @@ func selectorsContain(selectors []spec.Selector, sel spec.Selector) bool {
 		if containsFilter(selectors, sel) {
 			return true
 		}
+	case *funcSelector:
+		// Equals only itself.
+		if slices.Contains(selectors, spec.Selector(sel)) {
+			return true
+		}
+	case *regexpSelector:
+		if containsRegexp(selectors, sel) {
+			return true
+		}
 	}
 
 	return false
@@ -101,7 +186,7 @@ func selectorsContain(selectors []spec.Selector, sel spec.Selector) bool {
 // hasExactSelector returns true if seg's selectors contains the same selector
 // as sel and false if it does not. [spec.Index]es do not match
 // [spec.SliceSelector]s, [spec.SliceSelector]s must be identical, and
-// compares [*spec.FilterSelector] strings.
+// [*spec.FilterSelector]s must be logically equivalent.
 func (seg *segment) hasExactSelector(sel spec.Selector) bool {
 	// Search for the segment by type.
 	switch sel := sel.(type) {
@@ -129,6 +214,14 @@ func (seg *segment) hasExactSelector(sel spec.Selector) bool {
 		if containsFilter(seg.selectors, sel) {
 			return true
 		}
+	case *funcSelector:
+		if slices.Contains(seg.selectors, spec.Selector(sel)) {
+			return true
+		}
+	case *regexpSelector:
+		if containsRegexp(seg.selectors, sel) {
+			return true
+		}
 	}
 
 	return false
@@ -136,7 +229,8 @@ func (seg *segment) hasExactSelector(sel spec.Selector) bool {
 
 // hasExactSelectors returns true seg contains exactly selectors.
 // [spec.Index]es do not match [spec.SliceSelector]s, [spec.SliceSelector]s
-// must be identical, and compares [*spec.FilterSelector] strings.
+// must be identical, and [*spec.FilterSelector]s are compared after
+// normalization, so that logically equivalent filters compare equal.
 func (seg *segment) hasExactSelectors(selectors []spec.Selector) bool {
 	if len(seg.selectors) != len(selectors) {
 		return false
@@ -166,8 +260,10 @@ func containsName(selectors []spec.Selector, name spec.Name) bool {
 
 // containsIndex returns true if selectors contains idx. It evaluates both
 // [spec.Index] values and [spec.SliceSelector]s with positive start and end
-// values and positive steps or a -1 step where end < start. Supports both
-// positive and negative idx values within those constraints.
+// values and positive steps or a -1 step where end < start. A negative idx
+// matches the same [spec.Index] or a slice open to the end of the array,
+// because the element it selects otherwise depends on the length of the
+// input; see sliceSelectsFromEnd and containsIndexForLen.
 func containsIndex(selectors []spec.Selector, idx spec.Index) bool {
 	for _, s := range selectors {
 		switch s := s.(type) {
@@ -176,34 +272,37 @@ func containsIndex(selectors []spec.Selector, idx spec.Index) bool {
 				return true
 			}
 		case spec.SliceSelector:
+			if idx < 0 {
+				if sliceSelectsFromEnd(s, int(idx)) {
+					return true
+				}
+				continue
+			}
+
 			// Negative bounds and backward slice without -1 step depend on
 			// input length, so cannot be determined independently.
 			if s.Start() < 0 || (s.End() < s.Start() && s.Step() != -1) {
-				return false
+				continue
 			}
 
 			// Set sized based on the slice params and determine the bounds.
 			sel := int(idx)
 
-			size := max(abs(sel), s.Start(), s.End())
+			size := max(sel, s.Start(), s.End())
 			if size != math.MaxInt {
 				size++
 			}
 
 			lower, upper := s.Bounds(size)
 
-			if sel < 0 {
-				// Subtract the index from the upper bound.
-				sel = upper + sel
-			}
-
 			step := s.Step()
 			switch {
 			// step == 0 never selects values.
 			case step > 0 && sel >= lower && sel < upper && ((sel-lower)%step == 0):
 				return true
 			case step == -1 && sel <= upper && sel > lower:
-				// All other negative steps depend on input length.
+				// All other negative steps depend on input length; see
+				// containsIndexForLen.
 				return true
 			}
 		}
@@ -212,18 +311,104 @@ func containsIndex(selectors []spec.Selector, idx spec.Index) bool {
 	return false
 }
 
+// sliceSelectsFromEnd returns true if slice selects the negative index idx
+// from an array of any length. Only slices with a step of 1 or -1 that
+// extend to the end of the array qualify, such as [:], [-3:], and [::-1].
+func sliceSelectsFromEnd(slice spec.SliceSelector, idx int) bool {
+	switch slice.Step() {
+	case 1:
+		return slice.End() == math.MaxInt && (slice.Start() == 0 || (slice.Start() < 0 && idx >= slice.Start()))
+	case -1:
+		return (slice.Start() == math.MaxInt || slice.Start() == -1) && slice.End() < 0 && idx > slice.End()
+	default:
+		return false
+	}
+}
+
+// containsIndexForLen returns true if selectors select idx from an array of
+// length length. Unlike containsIndex, it resolves negative indexes and
+// slices with negative bounds or backward steps against length, so it can
+// determine containment for any of them.
+func containsIndexForLen(selectors []spec.Selector, idx spec.Index, length int) bool {
+	sel := int(idx)
+	if sel < 0 {
+		sel += length
+	}
+
+	if sel < 0 || sel >= length {
+		return false
+	}
+
+	for _, s := range selectors {
+		switch s := s.(type) {
+		case spec.WildcardSelector:
+			return true
+		case spec.Index:
+			i := int(s)
+			if i < 0 {
+				i += length
+			}
+
+			if i == sel {
+				return true
+			}
+		case spec.SliceSelector:
+			if sliceSelects(s, sel, length) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// sliceSelects returns true if slice selects idx, which must be between 0
+// and length-1, from an array of length length.
+func sliceSelects(slice spec.SliceSelector, idx, length int) bool {
+	lower, upper := slice.Bounds(length)
+
+	step := slice.Step()
+	switch {
+	// step == 0 never selects values.
+	case step > 0:
+		return idx >= lower && idx < upper && (idx-lower)%step == 0
+	case step < 0:
+		// Backward slices start from upper.
+		return idx <= upper && idx > lower && (upper-idx)%step == 0
+	}
+
+	return false
+}
+
+// sliceIsEmpty returns true if slice selects nothing from an array of any
+// length: if its step is 0, or if its start and end are both relative to the
+// start of an array or both relative to its end and it steps away from its
+// end. Bounds relative to different ends, such as those of [1:-1], select
+// values from sufficiently long arrays.
+func sliceIsEmpty(slice spec.SliceSelector) bool {
+	start, end, step := slice.Start(), slice.End(), slice.Step()
+
+	switch {
+	case step == 0 || start == end:
+		return true
+	case relativeBound(start) != relativeBound(end) || end == math.MinInt || end == math.MaxInt:
+		// Depends on the length of the array.
+		return false
+	case step > 0:
+		return start > end
+	default:
+		return start < end
+	}
+}
+
 // containsSlice returns true if selectors contains slice. To qualify, slice's
 // start and end must come between the start and end of a slice in seg, and
 // the step of that slice must be a multiple of slice's step. Or, slice must
-// select a single element that is the same as a [spec.Index] in seg.
-//
-// In theory, all the spec.Index values in seg could account for all the
-// indexes in the slice. But this is good enough for now.
+// select a single element that is the same as a [spec.Index] in seg. Or,
+// the [spec.Index] values in seg must account for every index selected by a
+// forward slice with non-negative bounds.
 func containsSlice(selectors []spec.Selector, slice spec.SliceSelector) bool {
-	if slice.Step() == 0 ||
-		slice.Start() == slice.End() ||
-		(slice.Step() > 0 && slice.Start() > slice.End()) ||
-		(slice.Step() < 0 && slice.Start() < slice.End()) {
+	if sliceIsEmpty(slice) {
 		// Never selects anything, so true.
 		return true
 	}
@@ -243,7 +428,65 @@ func containsSlice(selectors []spec.Selector, slice spec.SliceSelector) bool {
 		}
 	}
 
-	return false
+	return indexesCoverSlice(selectors, slice)
+}
+
+// indexesCoverSlice returns true if the [spec.Index] values in selectors
+// include every index selected by slice. Always returns false unless slice
+// has a positive step and non-negative start and end, because otherwise the
+// indexes it selects depend on the length of the input.
+func indexesCoverSlice(selectors []spec.Selector, slice spec.SliceSelector) bool {
+	start, end, step := slice.Start(), slice.End(), slice.Step()
+	if step <= 0 || start < 0 || end < 0 || end == math.MaxInt {
+		return false
+	}
+
+	// Cannot cover the slice with fewer indexes than it selects.
+	indexes := 0
+	for _, s := range selectors {
+		if _, ok := s.(spec.Index); ok {
+			indexes++
+		}
+	}
+
+	if (end-start+step-1)/step > indexes {
+		return false
+	}
+
+	for i := start; i < end; i += step {
+		if !slices.Contains(selectors, spec.Selector(spec.Index(i))) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// crossBounds returns true if the start of sub can be compared to the end of
+// sup and the end of sub to the start of sup, as required to compare slices
+// that step in opposite directions. Both bounds of a pair must be relative
+// to the same end of the input, unless sup's bound is a default that
+// extends to the end of the input of any length.
+func crossBounds(sub, sup spec.SliceSelector) bool {
+	comparable := func(a, b int) bool {
+		return b == math.MaxInt || b == math.MinInt || relativeBound(a) == relativeBound(b)
+	}
+
+	return comparable(sub.Start(), sup.End()) && comparable(sub.End(), sup.Start())
+}
+
+// canonicalSlice returns the canonical form of slice, so that slices that
+// select the same indexes from inputs of any length compare equal. The
+// [spec.Slice] constructor already replaces omitted bounds with their
+// defaults, so that [0::1] equals [:], but a backward slice starting at -1
+// starts at the last item exactly as the default start does, so
+// canonicalSlice replaces that start with the default.
+func canonicalSlice(slice spec.SliceSelector) spec.SliceSelector {
+	if slice.Step() < 0 && slice.Start() == -1 {
+		return spec.Slice(nil, slice.End(), slice.Step())
+	}
+
+	return slice
 }
 
 // sliceInSlice returns true if sub is a subset of or equal to sup. Always
@@ -251,11 +494,31 @@ func containsSlice(selectors []spec.Selector, slice spec.SliceSelector) bool {
 // logical subsets where the steps for one slice are positive and the other
 // negative.
 func sliceInSlice(sub, sup spec.SliceSelector) bool {
+	switch {
+	case sliceIsEmpty(sub):
+		return true
+	case sliceIsEmpty(sup):
+		return false
+	}
+
+	sub, sup = canonicalSlice(sub), canonicalSlice(sup)
+	if relativeBound(sub.Start()) != relativeBound(sup.Start()) ||
+		relativeBound(sub.End()) != relativeBound(sup.End()) {
+		// Bounds relative to the end of the input depend on input length,
+		// so cannot be compared to bounds relative to its start.
+		return false
+	}
+
 	if sub.Step()%sup.Step() != 0 {
 		// Non overlapping if s.Step() is not a multiple of slice.Step()1.
 		return false
 	}
 
+	if abs(sup.Step()) != 1 && !alignedSlices(sub, sup) {
+		// Sub selects indexes between sup's steps.
+		return false
+	}
+
 	switch {
 	case sub.Step() > 0 && sup.Step() > 0:
 		// Most common case: is sub between sup start and end?
@@ -269,12 +532,12 @@ func sliceInSlice(sub, sup spec.SliceSelector) bool {
 		}
 	case sub.Step() <= 1 && sup.Step() > 0:
 		// sub backward vs sup forward: is sub between sup end and start?
-		if sub.Start() < sup.End() && sub.End() >= sup.Start()-1 {
+		if crossBounds(sub, sup) && sub.Start() < sup.End() && sub.End() >= sup.Start()-1 {
 			return true
 		}
 	case sub.Step() > 0 && sup.Step() < 0:
 		// sub forward vs sup backward: is sub between sup end and start?
-		if sub.Start() > sup.End() && sub.End()-1 <= sup.Start() {
+		if crossBounds(sub, sup) && sub.Start() > sup.End() && sub.End()-1 <= sup.Start() {
 			return true
 		}
 	}
@@ -282,16 +545,49 @@ func sliceInSlice(sub, sup spec.SliceSelector) bool {
 	return false
 }
 
-// containsFilter returns true if selectors contains filter. Currently relies on
-// string comparison, but could be improved by implementing [sort.Interface]
-// for [spec.LogicalOr] and [spec.LogicalAnd], as well as operand and operator
-// normalization in [spec.ComparisonExpr].
-//
-// [sort.Interface]: https://pkg.go.dev/sort#Interface
+// relativeBound returns true if bound is a slice bound relative to the end
+// of an array. Excludes math.MinInt, the default end of a backward slice.
+func relativeBound(bound int) bool {
+	return bound < 0 && bound != math.MinInt
+}
+
+// alignedSlices returns true if the first index selected by sub is also
+// selected by sup, assuming sub's step is a multiple of sup's step. Returns
+// false when alignment depends on input length: when the steps go in
+// opposite directions or when either slice starts from a negative index.
+func alignedSlices(sub, sup spec.SliceSelector) bool {
+	switch {
+	case (sub.Step() > 0) != (sup.Step() > 0), sub.Start() < 0, sup.Start() < 0:
+		return false
+	case sub.Start() == sup.Start():
+		return true
+	case sup.Step() < 0 && (sub.Start() == math.MaxInt || sup.Start() == math.MaxInt):
+		// Default backward start depends on input length.
+		return false
+	}
+
+	return (sub.Start()-sup.Start())%sup.Step() == 0
+}
+
+// containsFilter returns true if selectors contains filter or a filter
+// logically equivalent to it, as determined by comparing their normalized
+// string representations. See normalizeFilter for details.
 func containsFilter(selectors []spec.Selector, filter *spec.FilterSelector) bool {
+	var norm string
+
 	for _, s := range selectors {
 		if s, ok := s.(*spec.FilterSelector); ok {
-			if s.String() == filter.String() {
+			// Don't compare String()s: spec renders negated functions
+			// without the "!".
+			if s == filter {
+				return true
+			}
+
+			if norm == "" {
+				norm = normalizeFilter(filter)
+			}
+
+			if normalizeFilter(s) == norm {
 				return true
 			}
 		}
@@ -327,6 +623,12 @@ func (seg *segment) isBranch(specSeg []*spec.Segment) bool {
 // mergeSelectors merges selectors into seg.selectors and return seg.
 func (seg *segment) mergeSelectors(selectors []spec.Selector) *segment {
 	for _, sel := range selectors {
+		if _, ok := sel.(spec.WildcardSelector); ok {
+			// A wildcard selects everything, so replaces all other selectors.
+			seg.selectors = []spec.Selector{sel}
+			return seg
+		}
+
 		if !seg.hasSelector(sel) {
 			seg.selectors = append(seg.selectors, sel)
 		}
@@ -335,6 +637,136 @@ func (seg *segment) mergeSelectors(selectors []spec.Selector) *segment {
 	return seg
 }
 
+// intersectSegments returns the segments that select the values selected by
+// both p and q, as described for [Intersect]. Returns false if they
+// select no values in common.
+func intersectSegments(p, q []*spec.Segment) ([]*spec.Segment, bool) {
+	switch {
+	case len(p) == 0:
+		return q, true
+	case len(q) == 0:
+		return p, true
+	case p[0].IsDescendant() != q[0].IsDescendant():
+		return nil, false
+	}
+
+	sels := intersectSelectors(p[0].Selectors(), q[0].Selectors())
+	if len(sels) == 0 {
+		return nil, false
+	}
+
+	rest, ok := intersectSegments(p[1:], q[1:])
+	if !ok {
+		return nil, false
+	}
+
+	seg := spec.Child(sels...)
+	if p[0].IsDescendant() {
+		seg = spec.Descendant(sels...)
+	}
+
+	return append([]*spec.Segment{seg}, rest...), true
+}
+
+// intersectSelectors returns the selectors that select the values selected
+// by both left and right: the intersection of each selector of left with
+// each selector of right, as determined by intersectSelector.
+func intersectSelectors(left, right []spec.Selector) []spec.Selector {
+	var res []spec.Selector
+
+	for _, l := range left {
+		for _, r := range right {
+			if sel, ok := intersectSelector(l, r); ok && !selectorsContain(res, sel) {
+				res = append(res, sel)
+			}
+		}
+	}
+
+	return res
+}
+
+// intersectSelector returns the selector that selects the values selected by
+// both left and right, as described for [Intersect]. Returns false if they
+// select no values in common or if their intersection depends on the input,
+// such as a negative index and a bounded slice.
+func intersectSelector(left, right spec.Selector) (spec.Selector, bool) {
+	if _, ok := left.(spec.WildcardSelector); ok {
+		return right, true
+	}
+
+	switch r := right.(type) {
+	case spec.WildcardSelector:
+		return left, true
+	case spec.Name:
+		switch l := left.(type) {
+		case spec.Name:
+			return l, l == r
+		case *regexpSelector:
+			return r, l.re.MatchString(string(r))
+		}
+	case *regexpSelector:
+		switch l := left.(type) {
+		case spec.Name:
+			return l, r.re.MatchString(string(l))
+		case *regexpSelector:
+			return l, l.re.String() == r.re.String()
+		}
+	case spec.Index:
+		switch l := left.(type) {
+		case spec.Index:
+			return l, l == r
+		case spec.SliceSelector:
+			return r, containsIndex([]spec.Selector{l}, r)
+		}
+	case spec.SliceSelector:
+		switch l := left.(type) {
+		case spec.Index:
+			return l, containsIndex([]spec.Selector{r}, l)
+		case spec.SliceSelector:
+			switch {
+			case sliceInSlice(l, r):
+				return l, true
+			case sliceInSlice(r, l):
+				return r, true
+			default:
+				return intersectSlices(l, r)
+			}
+		}
+	case *spec.FilterSelector:
+		if l, ok := left.(*spec.FilterSelector); ok {
+			return l, containsFilter([]spec.Selector{r}, l)
+		}
+	case *funcSelector:
+		// Equals only itself.
+		return r, left == spec.Selector(r)
+	}
+
+	return nil, false
+}
+
+// intersectSlices returns the slice that selects the indexes selected by
+// both left and right, provided both are forward slices with non-negative
+// bounds and a step of 1. Returns false if either is not or if their ranges
+// do not overlap.
+func intersectSlices(left, right spec.SliceSelector) (spec.SliceSelector, bool) {
+	for _, s := range []spec.SliceSelector{left, right} {
+		if s.Step() != 1 || s.Start() < 0 || s.End() < 0 {
+			return spec.SliceSelector{}, false
+		}
+	}
+
+	start, end := max(left.Start(), right.Start()), min(left.End(), right.End())
+	switch {
+	case start >= end:
+		return spec.SliceSelector{}, false
+	case end == math.MaxInt:
+		// Default to the end of the array.
+		return spec.Slice(start), true
+	default:
+		return spec.Slice(start, end), true
+	}
+}
+
 // deduplicate recursively deduplicates seg. In other words, for any child
 // segment with all of its selectors and descendant branches also held by
 // another child segment, the former will be merged into the latter. It also
@@ -380,7 +812,9 @@ func (seg *segment) deduplicate() {
 }
 
 // mergeSlices compares [spec.SliceSelector]s in seg.selectors, and eliminates
-// any that are clear subsets of another.
+// any that are clear subsets of another. It then eliminates non-negative
+// [spec.Index] selectors that a remaining forward slice selects from an
+// array of any length, as determined by containsIndex.
 func (seg *segment) mergeSlices() {
 	merged := seg.selectors
 
@@ -415,9 +849,69 @@ func (seg *segment) mergeSlices() {
 		}
 	}
 
+	// Collect the forward slices, whose indexes do not depend on length.
+	var forward []spec.Selector
+
+	for _, sel := range merged {
+		if sel, ok := sel.(spec.SliceSelector); ok && sel.Step() > 0 {
+			forward = append(forward, sel)
+		}
+	}
+
+	if len(forward) > 0 {
+		merged = slices.DeleteFunc(merged, func(sel spec.Selector) bool {
+			idx, ok := sel.(spec.Index)
+			return ok && idx >= 0 && containsIndex(forward, idx)
+		})
+	}
+
 	seg.selectors = slices.Clip(merged)
 }
 
+// minCoalescedIndexes is the minimum number of contiguous indexes that
+// coalesceIndexes coalesces into a slice.
+const minCoalescedIndexes = 3
+
+// coalesceIndexes recursively replaces each run of three or more adjacent
+// [spec.Index] selectors in seg and its descendants that select contiguous,
+// ascending, non-negative indexes with a single [spec.SliceSelector] that
+// selects the same indexes, in the same position, so that the order of
+// selectors is unchanged.
+func (seg *segment) coalesceIndexes() {
+	// Allocate, since seg.selectors may share storage with a path.
+	coalesced := make([]spec.Selector, 0, len(seg.selectors))
+
+	for i := 0; i < len(seg.selectors); {
+		start, ok := seg.selectors[i].(spec.Index)
+		if !ok || start < 0 {
+			coalesced = append(coalesced, seg.selectors[i])
+			i++
+
+			continue
+		}
+
+		// Find the end of the run of contiguous indexes.
+		end := i + 1
+		for end < len(seg.selectors) && seg.selectors[end] == spec.Selector(start+spec.Index(end-i)) {
+			end++
+		}
+
+		if end-i < minCoalescedIndexes {
+			coalesced = append(coalesced, seg.selectors[i:end]...)
+		} else {
+			coalesced = append(coalesced, spec.Slice(int(start), int(start)+end-i))
+		}
+
+		i = end
+	}
+
+	seg.selectors = coalesced
+
+	for _, c := range seg.children {
+		c.coalesceIndexes()
+	}
+}
+
 // removeCommonSelectorsFrom removes selectors from seg2 that are present in
 // seg. Returns true if all selectors are removed from seg2 and can be pruned
 // from the tree.
@@ -438,9 +932,10 @@ func (seg *segment) removeCommonSelectorsFrom(seg2 *segment) bool {
 
 // sameBranches returns true if seg has the same branches as seg2. It
 // recursively compares seg's children to seg2's children to ensure they have
-// the exactly the same selectors and descendants. [spec.Index]es do not match
+// the exactly the same selectors, descendant flags, and descendants,
+// regardless of their order. [spec.Index]es do not match
 // [spec.SliceSelector]s, [spec.SliceSelector]s must be identical, and
-// compares [*spec.FilterSelector] strings.
+// [*spec.FilterSelector]s must be logically equivalent.
 func (seg *segment) sameBranches(seg2 *segment) bool {
 	if len(seg.children) != len(seg2.children) {
 		// Let leaf nodes merge?
@@ -450,7 +945,9 @@ func (seg *segment) sameBranches(seg2 *segment) bool {
 C1:
 	for _, c1 := range seg.children {
 		for _, c2 := range seg2.children {
-			if c1.hasExactSelectors(c2.selectors) && c1.sameBranches(c2) {
+			if c1.descendant == c2.descendant &&
+				c1.hasExactSelectors(c2.selectors) &&
+				c1.sameBranches(c2) {
 				continue C1
 			}
 		}
@@ -461,6 +958,198 @@ C1:
 	return true
 }
 
+// appendPaths appends to paths a [jsonpath.Path] for each branch from seg to
+// its leaf segments, prefixed by parents, and returns the result.
+func (seg *segment) appendPaths(paths []*jsonpath.Path, parents []*spec.Segment) []*jsonpath.Path {
+	// Copy the selectors so that the path shares no storage with seg.
+	var cur *spec.Segment
+	if seg.descendant {
+		cur = spec.Descendant(slices.Clone(seg.selectors)...)
+	} else {
+		cur = spec.Child(slices.Clone(seg.selectors)...)
+	}
+
+	parents = append(parents, cur)
+	if len(seg.children) == 0 {
+		return append(paths, jsonpath.New(spec.Query(true, slices.Clone(parents)...)))
+	}
+
+	for _, c := range seg.children {
+		paths = c.appendPaths(paths, parents)
+	}
+
+	return paths
+}
+
+// freeze recursively replaces the selectors of seg and its descendants with
+// copies that share no storage with the [spec.Segment]s from which they were
+// compiled.
+func (seg *segment) freeze() {
+	seg.selectors = slices.Clone(seg.selectors)
+	for _, c := range seg.children {
+		c.freeze()
+	}
+}
+
+// clone returns a deep copy of seg and its children. The copies share no
+// selector or child slices with seg, although they share the selectors
+// themselves, which are immutable.
+func (seg *segment) clone() *segment {
+	res := &segment{
+		selectors:  slices.Clone(seg.selectors),
+		children:   make([]*segment, len(seg.children)),
+		descendant: seg.descendant,
+		names:      seg.names,
+	}
+
+	for i, c := range seg.children {
+		res.children[i] = c.clone()
+	}
+
+	return res
+}
+
+// resolve returns a deep copy of seg and its children in which slices and
+// negative indexes are replaced by the non-negative indexes they select from
+// an array of length length. Drops indexes out of range for length and
+// duplicate indexes.
+func (seg *segment) resolve(length int) *segment {
+	res := &segment{
+		selectors:  make([]spec.Selector, 0, len(seg.selectors)),
+		children:   make([]*segment, len(seg.children)),
+		descendant: seg.descendant,
+		// Resolving changes only index and slice selectors.
+		names: seg.names,
+	}
+
+	seen := map[int]struct{}{}
+	addIndex := func(idx int) {
+		if _, dup := seen[idx]; !dup {
+			seen[idx] = struct{}{}
+			res.selectors = append(res.selectors, spec.Index(idx))
+		}
+	}
+
+	for _, sel := range seg.selectors {
+		switch sel := sel.(type) {
+		case spec.Index:
+			idx := int(sel)
+			if idx < 0 {
+				idx += length
+			}
+
+			if idx >= 0 && idx < length {
+				addIndex(idx)
+			}
+		case spec.SliceSelector:
+			lower, upper := sel.Bounds(length)
+			switch {
+			case sel.Step() > 0:
+				for i := lower; i < upper; i += sel.Step() {
+					addIndex(i)
+				}
+			case sel.Step() < 0:
+				for i := upper; lower < i; i += sel.Step() {
+					addIndex(i)
+				}
+			}
+		default:
+			res.selectors = append(res.selectors, sel)
+		}
+	}
+
+	for i, c := range seg.children {
+		res.children[i] = c.resolve(length)
+	}
+
+	return res
+}
+
+// selectsNothing returns true if seg can never select a value, as described
+// for [Tree.Prune].
+func (seg *segment) selectsNothing() bool {
+	return len(seg.selectors) == 0 || seg.childrenSelectNothing()
+}
+
+// childrenSelectNothing returns true if seg has children and none of them
+// can select a value.
+func (seg *segment) childrenSelectNothing() bool {
+	return len(seg.children) > 0 && !slices.ContainsFunc(seg.children, func(c *segment) bool {
+		return !c.selectsNothing()
+	})
+}
+
+// prune recursively removes the children of seg that select nothing.
+func (seg *segment) prune() {
+	seg.children = slices.DeleteFunc(seg.children, (*segment).selectsNothing)
+	for _, c := range seg.children {
+		c.prune()
+	}
+}
+
+// depth returns the number of segments in the longest branch below seg.
+func (seg *segment) depth() int {
+	depth := 0
+	for _, c := range seg.children {
+		depth = max(depth, c.depth()+1)
+	}
+
+	return depth
+}
+
+// size returns the number of segments below seg.
+func (seg *segment) size() int {
+	size := len(seg.children)
+	for _, c := range seg.children {
+		size += c.size()
+	}
+
+	return size
+}
+
+// validate returns an [ErrInvalidTree] error for each violation of the
+// invariants described for [Tree.Validate] by seg's descendants, where path
+// is the query from the root to seg.
+func (seg *segment) validate(path string) []error {
+	var errs []error
+
+	for i, c := range seg.children {
+		cPath := path + c.selectorString()
+
+		for _, prev := range seg.children[:i] {
+			if prev.descendant == c.descendant && prev.hasExactSelectors(c.selectors) {
+				errs = append(errs, fmt.Errorf("%w: duplicate sibling segments at %v", ErrInvalidTree, cPath))
+			}
+		}
+
+		if len(c.selectors) == 0 && len(c.children) > 0 {
+			errs = append(errs, fmt.Errorf("%w: empty segment with children at %v", ErrInvalidTree, cPath))
+		}
+
+		if len(c.selectors) > 1 && slices.ContainsFunc(c.selectors, isWildcardSelector) {
+			errs = append(errs, fmt.Errorf("%w: wildcard with other selectors at %v", ErrInvalidTree, cPath))
+		}
+
+		errs = append(errs, c.validate(cPath)...)
+	}
+
+	return errs
+}
+
+// selectorString returns the string representation of seg's selectors.
+func (seg *segment) selectorString() string {
+	buf := new(strings.Builder)
+	seg.writeSelectors(&diagramWriter{w: buf})
+
+	return buf.String()
+}
+
+// isWildcardSelector returns true if sel is a [spec.WildcardSelector].
+func isWildcardSelector(sel spec.Selector) bool {
+	_, ok := sel.(spec.WildcardSelector)
+	return ok
+}
+
 // isWildcard returns true if seg is a wildcard selector.
 func (seg *segment) isWildcard() bool {
 	if len(seg.selectors) != 1 {
@@ -476,60 +1165,122 @@ func (seg *segment) isWildcard() bool {
 // diagram.
 func (seg *segment) String() string {
 	buf := new(strings.Builder)
-	seg.writeSelectors(buf)
+	dw := &diagramWriter{w: buf}
+	seg.writeSelectors(dw)
+	dw.writeByte('\n')
 
 	lastIndex := len(seg.children) - 1
 	for i, c := range seg.children {
-		c.writeTo(buf, "", i == lastIndex)
+		c.writeTo(dw, &UnicodeTreeStyle, "", i == lastIndex)
 	}
 
 	return buf.String()
 }
 
-const (
-	elbow = "└── "
-	pipe  = "│   "
-	tee   = "├── "
-	blank = "    "
-)
-
 // writeSelectors writes a string representation of seg.selectors to buf.
-func (seg *segment) writeSelectors(buf *strings.Builder) {
+func (seg *segment) writeSelectors(buf *diagramWriter) {
+	seg.writeSelectorsWith(buf, "..")
+}
+
+// writeSelectorsWith writes a string representation of seg.selectors to buf,
+// preceded by prefix if seg is a descendant segment, or by ".." if prefix is
+// empty. Writes the selectors in the order returned by displayOrder, so that
+// the output does not depend on the order in which paths merged.
+func (seg *segment) writeSelectorsWith(buf *diagramWriter, prefix string) {
 	if seg.descendant {
-		buf.WriteString("..")
+		if prefix == "" {
+			prefix = ".."
+		}
+
+		buf.writeString(prefix)
+	}
+
+	buf.writeByte('[')
+
+	for i, sel := range displayOrder(seg.selectors) {
+		if i > 0 {
+			buf.writeByte(',')
+		}
+
+		buf.writeString(selectorString(sel))
+	}
+
+	buf.writeByte(']')
+}
+
+// writeCompact writes a single-line string representation of seg's child
+// segments to buf, as described for [Tree.Compact].
+func (seg *segment) writeCompact(buf *diagramWriter) {
+	if len(seg.children) == 1 {
+		seg.children[0].writeSelectors(buf)
+		seg.children[0].writeCompact(buf)
+
+		return
+	}
+
+	if len(seg.children) == 0 {
+		return
 	}
 
-	buf.WriteByte('[')
+	buf.writeByte('(')
 
-	for i, sel := range seg.selectors {
+	for i, c := range seg.children {
 		if i > 0 {
-			buf.WriteByte(',')
+			buf.writeByte(',')
 		}
 
-		buf.WriteString(sel.String())
+		c.writeSelectors(buf)
+		c.writeCompact(buf)
 	}
 
-	buf.WriteString("]\n")
+	buf.writeByte(')')
 }
 
-// writeTo writes the string representation of seg to buf.
-func (seg *segment) writeTo(buf *strings.Builder, prefix string, last bool) {
-	buf.WriteString(prefix)
+// writeTo writes the string representation of seg to buf, drawing the tree
+// with the glyphs in style.
+func (seg *segment) writeTo(buf *diagramWriter, style *TreeStyle, prefix string, last bool) {
+	buf.writeString(prefix)
 
 	if last {
-		buf.WriteString(elbow)
+		buf.writeString(style.Elbow)
 	} else {
-		buf.WriteString(tee)
+		buf.writeString(style.Tee)
 	}
 
-	seg.writeSelectors(buf)
+	seg.writeSelectorsWith(buf, style.DescendantPrefix)
+	buf.writeByte('\n')
 
 	lastIndex := len(seg.children) - 1
 	for i, sub := range seg.children {
 		if last {
-			sub.writeTo(buf, prefix+blank, i == lastIndex)
+			sub.writeTo(buf, style, prefix+style.Blank, i == lastIndex)
 		} else {
-			sub.writeTo(buf, prefix+pipe, i == lastIndex)
+			sub.writeTo(buf, style, prefix+style.Pipe, i == lastIndex)
 		}
 	}
 }
+
+// diagramWriter writes tree diagrams to an [io.Writer], counting the bytes
+// written and recording the first error, after which it ignores subsequent
+// writes.
+type diagramWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+// writeString writes s to dw's writer unless a previous write failed.
+func (dw *diagramWriter) writeString(s string) {
+	if dw.err != nil {
+		return
+	}
+
+	n, err := io.WriteString(dw.w, s)
+	dw.n += int64(n)
+	dw.err = err
+}
+
+// writeByte writes c to dw's writer unless a previous write failed.
+func (dw *diagramWriter) writeByte(c byte) {
+	dw.writeString(string(c))
+}
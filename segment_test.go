@@ -2,10 +2,12 @@ package jsontree
 
 import (
 	"fmt"
+	"regexp"
 	"slices"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/theory/jsonpath"
 	"github.com/theory/jsonpath/spec"
 )
@@ -41,7 +43,7 @@ func TestWriteSeg(t *testing.T) {
 		{
 			test: "two_keys",
 			seg:  child(spec.Name("foo"), spec.Name("bar")),
-			str:  "[\"foo\",\"bar\"]\n",
+			str:  "[\"bar\",\"foo\"]\n",
 		},
 		{
 			test: "parent_child",
@@ -78,7 +80,7 @@ func TestWriteSeg(t *testing.T) {
 │   ├── ["y"]
 │   └── ..["z"]
 └── ["bar"]
-    ├── ["a",42,:8:2]
+    ├── [:8:2,"a",42]
     ├── ["b"]
     └── ["c"]
 `,
@@ -228,6 +230,257 @@ func TestWriteSeg(t *testing.T) {
 	}
 }
 
+func TestQuoteName(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		name  string
+		exp   string
+		lossy bool
+	}{
+		{test: "plain", name: "hi", exp: `"hi"`},
+		{test: "empty", name: "", exp: `""`},
+		{test: "tab", name: "a\tb", exp: `"a\tb"`},
+		{test: "newline", name: "a\nb\r", exp: `"a\nb\r"`},
+		{test: "backspace_form_feed", name: "\b\f", exp: `"\b\f"`},
+		{test: "backslash", name: `a\b`, exp: `"a\\b"`},
+		{test: "quotes", name: `a"b'c`, exp: `"a\"b'c"`},
+		{test: "slash", name: "a/b", exp: `"a/b"`},
+		{test: "emoji", name: "\U0001F600", exp: "\"\U0001F600\""},
+		{test: "accent", name: "caf\u00e9", exp: "\"caf\u00e9\""},
+		{test: "nul", name: "a\x00b", exp: `"a\u0000b"`},
+		{test: "bell_vtab", name: "\a\v", exp: `"\u0007\u000b"`},
+		{test: "unit_separator", name: "\x1f", exp: `"\u001f"`},
+		{test: "delete", name: "\x7f", exp: `"\u007f"`},
+		{test: "nbsp", name: "\u00a0", exp: `"\u00a0"`},
+		{test: "line_separator", name: "\u2028", exp: `"\u2028"`},
+		{test: "bom", name: "\ufeff", exp: `"\ufeff"`},
+		{test: "astral_format", name: "\U000e0001", exp: `"\udb40\udc01"`},
+		{test: "invalid_utf8", name: "a\xffb", exp: "\"a\ufffdb\"", lossy: true},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			str := quoteName(spec.Name(tc.name))
+			a.Equal(tc.exp, str)
+			a.Equal(str, selectorString(spec.Name(tc.name)))
+
+			// Must round-trip through the JSONPath parser.
+			sel, err := parseSelector(str)
+			r.NoError(err)
+			if tc.lossy {
+				a.Equal(spec.Name(string([]rune(tc.name))), sel)
+			} else {
+				a.Equal(spec.Name(tc.name), sel)
+			}
+		})
+	}
+}
+
+func TestFormatSelectors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test string
+		sels []spec.Selector
+		exp  string
+	}{
+		{"empty", nil, "[]"},
+		{"name", []spec.Selector{spec.Name("a")}, `["a"]`},
+		{"control_name", []spec.Selector{spec.Name("a\x00")}, `["a\u0000"]`},
+		{"mixed", []spec.Selector{spec.Name("a"), spec.Index(42), spec.Slice(nil, 8, 2)}, `[:8:2,"a",42]`},
+		{"negative", []spec.Selector{spec.Index(-1), spec.Slice(-3, nil, -1)}, `[-3::-1,-1]`},
+		{"wildcard", []spec.Selector{spec.Wildcard()}, "[*]"},
+		{
+			"filter",
+			[]spec.Selector{spec.Filter(spec.And(spec.Existence(spec.Query(false, spec.Child(spec.Name("x"))))))},
+			`[?@["x"]]`,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			a.Equal(tc.exp, FormatSelectors(tc.sels))
+
+			// Consistent with diagrams.
+			tree := &Tree{root: child().Append(child(tc.sels...))}
+			a.Equal("$\n└── "+tc.exp+"\n", tree.String())
+		})
+	}
+}
+
+func TestDisplayOrder(t *testing.T) {
+	t.Parallel()
+
+	filter := func(name string) spec.Selector {
+		return spec.Filter(spec.And(spec.Existence(spec.Query(false, spec.Child(spec.Name(name))))))
+	}
+	fn := FilterFunc(nil)
+	re := RegexpName(regexp.MustCompile(`^a`))
+
+	for _, tc := range []struct {
+		test string
+		sels []spec.Selector
+		exp  []spec.Selector
+	}{
+		{
+			test: "empty",
+		},
+		{
+			test: "single",
+			sels: []spec.Selector{spec.Index(2)},
+			exp:  []spec.Selector{spec.Index(2)},
+		},
+		{
+			test: "names",
+			sels: []spec.Selector{spec.Name("y"), spec.Name("x"), spec.Name("b")},
+			exp:  []spec.Selector{spec.Name("b"), spec.Name("x"), spec.Name("y")},
+		},
+		{
+			test: "indexes",
+			sels: []spec.Selector{spec.Index(3), spec.Index(-1), spec.Index(0)},
+			exp:  []spec.Selector{spec.Index(-1), spec.Index(0), spec.Index(3)},
+		},
+		{
+			test: "slices",
+			sels: []spec.Selector{spec.Slice(2, 8), spec.Slice(2, 4, 2), spec.Slice(nil, 3), spec.Slice(2, 4)},
+			exp:  []spec.Selector{spec.Slice(nil, 3), spec.Slice(2, 4), spec.Slice(2, 4, 2), spec.Slice(2, 8)},
+		},
+		{
+			test: "filters",
+			sels: []spec.Selector{filter("y"), filter("x")},
+			exp:  []spec.Selector{filter("x"), filter("y")},
+		},
+		{
+			test: "mixed",
+			sels: []spec.Selector{
+				fn, filter("a"), spec.Index(42), re, spec.Name("x"), spec.Slice(nil, 8, 2), spec.Wildcard(),
+			},
+			exp: []spec.Selector{
+				spec.Wildcard(), spec.Slice(nil, 8, 2), spec.Name("x"), re, spec.Index(42), filter("a"), fn,
+			},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			orig := slices.Clone(tc.sels)
+			a.Equal(tc.exp, displayOrder(tc.sels))
+			a.Equal(orig, tc.sels)
+
+			// Any order displays the same.
+			rev := slices.Clone(tc.sels)
+			slices.Reverse(rev)
+			a.Equal(FormatSelectors(tc.sels), FormatSelectors(rev))
+		})
+	}
+
+	t.Run("merge_order", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		input := map[string]any{"x": []any{"a", "b", "c"}, "y": map[string]any{"z": 1}, "42": true}
+		paths := []*jsonpath.Path{
+			jsonpath.MustParse(`$["x","42"]`),
+			jsonpath.MustParse("$[0:2]"),
+			jsonpath.MustParse("$.y"),
+			jsonpath.MustParse("$[4]"),
+		}
+		rev := slices.Clone(paths)
+		slices.Reverse(rev)
+
+		for _, mk := range []func(...*jsonpath.Path) *Tree{New, NewFixedModeTree} {
+			tree := mk(paths...)
+			a.Equal("$\n└── [:2,\"42\",\"x\",\"y\",4]\n", tree.String())
+			a.Equal(tree.String(), mk(rev...).String())
+			a.Equal(tree.Select(input), mk(rev...).Select(input))
+		}
+	})
+}
+
+func TestSegmentIndex(t *testing.T) {
+	t.Parallel()
+
+	names := func(n int) []spec.Selector {
+		sels := make([]spec.Selector, n)
+		for i := range sels {
+			sels[i] = spec.Name(fmt.Sprintf("n%d", i))
+		}
+
+		return sels
+	}
+
+	for _, tc := range []struct {
+		test    string
+		seg     *segment
+		indexed []bool
+	}{
+		{
+			test:    "few_names",
+			seg:     child(names(minIndexedNames - 1)...),
+			indexed: []bool{false},
+		},
+		{
+			test:    "names",
+			seg:     child(names(minIndexedNames)...),
+			indexed: []bool{true},
+		},
+		{
+			test:    "descendant_names",
+			seg:     descendant(names(minIndexedNames + 3)...),
+			indexed: []bool{true},
+		},
+		{
+			test:    "mixed",
+			seg:     child(append(names(minIndexedNames), spec.Index(0))...),
+			indexed: []bool{false},
+		},
+		{
+			test: "children",
+			seg: child(spec.Name("a")).Append(
+				child(names(minIndexedNames)...),
+				child(append(names(minIndexedNames), spec.Wildcard())...),
+			),
+			indexed: []bool{false, true, false},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			tc.seg.index()
+			segs := append([]*segment{tc.seg}, tc.seg.children...)
+			for i, seg := range segs {
+				a.Equal(tc.indexed[i], seg.names != nil, "segment %d", i)
+
+				if seg.names != nil {
+					a.Len(seg.names, len(seg.selectors))
+					for _, sel := range seg.selectors {
+						a.Contains(seg.names, string(sel.(spec.Name)))
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("reindex", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		seg := child(names(minIndexedNames)...)
+		seg.index()
+		a.NotNil(seg.names)
+		seg.selectors = seg.selectors[:2]
+		seg.index()
+		a.Nil(seg.names)
+	})
+}
+
 func TestIsWildcard(t *testing.T) {
 	t.Parallel()
 
@@ -424,17 +677,60 @@ func TestHasSelector(t *testing.T) {
 			exp:  true,
 		},
 		{
-			test: "neg_one_in_explicit",
+			test: "neg_one_not_in_explicit",
 			list: []spec.Selector{spec.Slice(0, 5)},
 			sel:  spec.Index(-1),
-			exp:  true,
 		},
 		{
-			test: "neg_just_in_explicit",
+			test: "neg_two_not_in_explicit",
 			list: []spec.Selector{spec.Slice(0, 2)},
 			sel:  spec.Index(-2),
+		},
+		{
+			test: "neg_not_in_open_from_one",
+			list: []spec.Selector{spec.Slice(1)},
+			sel:  spec.Index(-5),
+		},
+		{
+			test: "neg_not_in_open_step_two",
+			list: []spec.Selector{spec.Slice(nil, nil, 2)},
+			sel:  spec.Index(-1),
+		},
+		{
+			test: "neg_in_neg_open",
+			list: []spec.Selector{spec.Slice(-3)},
+			sel:  spec.Index(-3),
+			exp:  true,
+		},
+		{
+			test: "neg_not_in_neg_open",
+			list: []spec.Selector{spec.Slice(-3)},
+			sel:  spec.Index(-4),
+		},
+		{
+			test: "neg_in_backward",
+			list: []spec.Selector{spec.Slice(nil, nil, -1)},
+			sel:  spec.Index(-7),
+			exp:  true,
+		},
+		{
+			test: "neg_in_backward_neg_end",
+			list: []spec.Selector{spec.Slice(-1, -3, -1)},
+			sel:  spec.Index(-2),
 			exp:  true,
 		},
+		{
+			test: "neg_not_in_backward_neg_end",
+			list: []spec.Selector{spec.Slice(-1, -3, -1)},
+			sel:  spec.Index(-3),
+		},
+		{
+			test:  "neg_after_neg_slice",
+			list:  []spec.Selector{spec.Slice(-4, -1), spec.Index(-7)},
+			sel:   spec.Index(-7),
+			exp:   true,
+			exact: true,
+		},
 		{
 			test: "neg_not_in_explicit",
 			list: []spec.Selector{spec.Slice(0, 2)},
@@ -731,6 +1027,187 @@ func TestContainsIndex(t *testing.T) {
 	}
 }
 
+func TestContainsIndexForLen(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test   string
+		sel    spec.Selector
+		idx    int
+		length int
+		exp    bool
+	}{
+		{
+			test:   "in_neg_two_step",
+			sel:    spec.Slice(5, 0, -2),
+			idx:    3,
+			length: 8,
+			exp:    true,
+		},
+		{
+			test:   "not_in_neg_two_step",
+			sel:    spec.Slice(5, 0, -2),
+			idx:    2,
+			length: 8,
+			exp:    false,
+		},
+		{
+			test:   "in_neg_two_step_clamped_start",
+			sel:    spec.Slice(5, 0, -2),
+			idx:    2,
+			length: 3,
+			exp:    true,
+		},
+		{
+			test:   "not_in_neg_two_step_clamped_start",
+			sel:    spec.Slice(5, 0, -2),
+			idx:    3,
+			length: 5,
+			exp:    false,
+		},
+		{
+			test:   "in_neg_three_step",
+			sel:    spec.Slice(9, 1, -3),
+			idx:    3,
+			length: 10,
+			exp:    true,
+		},
+		{
+			test:   "not_in_neg_three_step",
+			sel:    spec.Slice(9, 1, -3),
+			idx:    4,
+			length: 10,
+			exp:    false,
+		},
+		{
+			test:   "exclude_end_neg_three_step",
+			sel:    spec.Slice(9, 0, -3),
+			idx:    0,
+			length: 10,
+			exp:    false,
+		},
+		{
+			test:   "in_neg_two_step_defaults",
+			sel:    spec.Slice(nil, nil, -2),
+			idx:    4,
+			length: 7,
+			exp:    true,
+		},
+		{
+			test:   "not_in_neg_two_step_defaults",
+			sel:    spec.Slice(nil, nil, -2),
+			idx:    4,
+			length: 6,
+			exp:    false,
+		},
+		{
+			test:   "in_neg_start",
+			sel:    spec.Slice(-4, 20),
+			idx:    2,
+			length: 5,
+			exp:    true,
+		},
+		{
+			test:   "not_in_neg_start",
+			sel:    spec.Slice(-4, 20),
+			idx:    2,
+			length: 8,
+			exp:    false,
+		},
+		{
+			test:   "in_neg_end",
+			sel:    spec.Slice(0, -1),
+			idx:    2,
+			length: 4,
+			exp:    true,
+		},
+		{
+			test:   "not_in_neg_end",
+			sel:    spec.Slice(0, -1),
+			idx:    3,
+			length: 4,
+			exp:    false,
+		},
+		{
+			test:   "neg_idx_in_slice",
+			sel:    spec.Slice(2, 5),
+			idx:    -1,
+			length: 5,
+			exp:    true,
+		},
+		{
+			test:   "neg_idx_out_of_range",
+			sel:    spec.Slice(nil, nil, 1),
+			idx:    -6,
+			length: 5,
+			exp:    false,
+		},
+		{
+			test:   "idx_out_of_range",
+			sel:    spec.Slice(nil, nil, 1),
+			idx:    5,
+			length: 5,
+			exp:    false,
+		},
+		{
+			test:   "step_zero",
+			sel:    spec.Slice(0, 5, 0),
+			idx:    0,
+			length: 5,
+			exp:    false,
+		},
+		{
+			test:   "neg_index_equals_idx",
+			sel:    spec.Index(-2),
+			idx:    3,
+			length: 5,
+			exp:    true,
+		},
+		{
+			test:   "neg_index_not_idx",
+			sel:    spec.Index(-2),
+			idx:    3,
+			length: 6,
+			exp:    false,
+		},
+		{
+			test:   "wildcard",
+			sel:    spec.Wildcard(),
+			idx:    -6,
+			length: 6,
+			exp:    true,
+		},
+		{
+			test:   "wildcard_out_of_range",
+			sel:    spec.Wildcard(),
+			idx:    6,
+			length: 6,
+			exp:    false,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			a.Equal(tc.exp, containsIndexForLen([]spec.Selector{tc.sel}, spec.Index(tc.idx), tc.length))
+
+			// Test with actual data.
+			input := make([]any, tc.length)
+			switch {
+			case tc.idx >= tc.length || tc.idx < -tc.length:
+				// Out of range.
+			case tc.idx >= 0:
+				input[tc.idx] = true
+			default:
+				input[tc.length+tc.idx] = true
+			}
+
+			res := tc.sel.Select(input, nil)
+			a.Equal(tc.exp, slices.Contains(res, true))
+		})
+	}
+}
+
 func TestContainsFilter(t *testing.T) {
 	t.Parallel()
 
@@ -785,38 +1262,106 @@ func TestContainsFilter(t *testing.T) {
 			filter: mkFilter("$[?@.x || @.y]"),
 			exp:    false,
 		},
-		// These examples could be equivalent if spec.ComparisonExpr
-		// normalized its expressions for deterministic order.
 		{
 			test:   "reversed_operands",
 			list:   []spec.Selector{mkFilter("$[?@.x > @.y]")},
 			filter: mkFilter("$[?@.y > @.x]"),
 			exp:    false,
 		},
+		{
+			test:   "reversed_operands_and_op",
+			list:   []spec.Selector{mkFilter("$[?@.x > @.y]")},
+			filter: mkFilter("$[?@.y < @.x]"),
+			exp:    true,
+		},
+		{
+			test:   "reversed_operands_and_op_eq",
+			list:   []spec.Selector{mkFilter("$[?@.x <= 42]")},
+			filter: mkFilter("$[?42 >= @.x]"),
+			exp:    true,
+		},
+		{
+			test:   "reversed_operands_lt_le",
+			list:   []spec.Selector{mkFilter("$[?@.x < @.y]")},
+			filter: mkFilter("$[?@.y <= @.x]"),
+			exp:    false,
+		},
 		{
 			test:   "reversed_eq_operands",
 			list:   []spec.Selector{mkFilter("$[?@.x == @.y]")},
 			filter: mkFilter("$[?@.y == @.x]"),
-			exp:    false,
+			exp:    true,
 		},
 		{
 			test:   "reversed_ne_operands",
 			list:   []spec.Selector{mkFilter("$[?@.x != @.y]")},
 			filter: mkFilter("$[?@.y != @.x]"),
-			exp:    false,
+			exp:    true,
 		},
-		// These examples could be equal if spec.LogicalOr and spec.LogicalAnd
-		// normalized adopted https://pkg.go.dev/sort#Interface.
 		{
 			test:   "reversed_or_operands",
 			list:   []spec.Selector{mkFilter("$[?@.x || @.y]")},
 			filter: mkFilter("$[?@.y || @.x]"),
-			exp:    false,
+			exp:    true,
 		},
 		{
 			test:   "reversed_and_operands",
 			list:   []spec.Selector{mkFilter("$[?@.x && @.y]")},
 			filter: mkFilter("$[?@.y && @.x]"),
+			exp:    true,
+		},
+		{
+			test:   "nested_reversed_operands",
+			list:   []spec.Selector{mkFilter(`$[?(@.a == "x" || @.b) && !(@.c > 1 && @.d)]`)},
+			filter: mkFilter(`$[?!(@.d && 1 < @.c) && (@.b || "x" == @.a)]`),
+			exp:    true,
+		},
+		{
+			test:   "not_exists",
+			list:   []spec.Selector{mkFilter(`$[?@.a]`)},
+			filter: mkFilter(`$[?!@.a]`),
+			exp:    false,
+		},
+		{
+			test:   "exists_not",
+			list:   []spec.Selector{mkFilter(`$[?!@.a]`)},
+			filter: mkFilter(`$[?@.a]`),
+			exp:    false,
+		},
+		{
+			test:   "same_not_exists",
+			list:   []spec.Selector{mkFilter(`$[?!@.a && @.x]`)},
+			filter: mkFilter(`$[?@.x && !@.a]`),
+			exp:    true,
+		},
+		{
+			test:   "not_exists_in_and",
+			list:   []spec.Selector{mkFilter(`$[?@.x && @.a]`)},
+			filter: mkFilter(`$[?@.x && !@.a]`),
+			exp:    false,
+		},
+		{
+			test:   "not_function",
+			list:   []spec.Selector{mkFilter(`$[?match(@.a, "x")]`)},
+			filter: mkFilter(`$[?!match(@.a, "x")]`),
+			exp:    false,
+		},
+		{
+			test:   "paren_not_paren",
+			list:   []spec.Selector{mkFilter(`$[?(@.a || @.b)]`)},
+			filter: mkFilter(`$[?!(@.a || @.b)]`),
+			exp:    false,
+		},
+		{
+			test:   "duplicate_operands",
+			list:   []spec.Selector{mkFilter("$[?@.x || @.x]")},
+			filter: mkFilter("$[?@.x]"),
+			exp:    true,
+		},
+		{
+			test:   "mixed_and_or",
+			list:   []spec.Selector{mkFilter("$[?@.x && @.y || @.z]")},
+			filter: mkFilter("$[?@.x && (@.y || @.z)]"),
 			exp:    false,
 		},
 	} {
@@ -1029,10 +1574,45 @@ func TestContainsSlice(t *testing.T) {
 			exp:   false,
 		},
 		{
-			// XXX Compare all indexes to slice range?
 			test:  "equals_all_indexes",
 			list:  []spec.Selector{spec.Index(3), spec.Index(4)},
 			slice: spec.Slice(3, 5),
+			exp:   true,
+		},
+		{
+			test:  "all_step_indexes",
+			list:  []spec.Selector{spec.Name("x"), spec.Index(6), spec.Index(2), spec.Index(4)},
+			slice: spec.Slice(2, 7, 2),
+			exp:   true,
+		},
+		{
+			test:  "missing_index",
+			list:  []spec.Selector{spec.Index(3), spec.Index(5)},
+			slice: spec.Slice(3, 6),
+			exp:   false,
+		},
+		{
+			test:  "indexes_default_start",
+			list:  []spec.Selector{spec.Index(0), spec.Index(1)},
+			slice: spec.Slice(nil, 2),
+			exp:   true,
+		},
+		{
+			test:  "indexes_open_end",
+			list:  []spec.Selector{spec.Index(3), spec.Index(4)},
+			slice: spec.Slice(3),
+			exp:   false,
+		},
+		{
+			test:  "indexes_negative_slice",
+			list:  []spec.Selector{spec.Index(-2), spec.Index(-1)},
+			slice: spec.Slice(-2),
+			exp:   false,
+		},
+		{
+			test:  "indexes_backward_slice",
+			list:  []spec.Selector{spec.Index(3), spec.Index(4)},
+			slice: spec.Slice(4, 2, -1),
 			exp:   false,
 		},
 		{
@@ -1041,6 +1621,72 @@ func TestContainsSlice(t *testing.T) {
 			slice: spec.Slice(0, 2),
 			exp:   true,
 		},
+		{
+			test:  "neg_start_vs_start",
+			list:  []spec.Selector{spec.Slice(-2, nil)},
+			slice: spec.Slice(0, nil, 3),
+			exp:   false,
+		},
+		{
+			test:  "neg_starts",
+			list:  []spec.Selector{spec.Slice(-4, nil)},
+			slice: spec.Slice(-2, nil),
+			exp:   true,
+		},
+		{
+			test:  "neg_end_vs_end",
+			list:  []spec.Selector{spec.Slice(0, -1)},
+			slice: spec.Slice(0, 3),
+			exp:   false,
+		},
+		{
+			test:  "backward_last_start",
+			list:  []spec.Selector{spec.Slice(nil, nil, -1)},
+			slice: spec.Slice(-1, 0, -1),
+			exp:   true,
+		},
+		{
+			test:  "backward_default_start",
+			list:  []spec.Selector{spec.Slice(-1, nil, -1)},
+			slice: spec.Slice(nil, nil, -1),
+			exp:   true,
+		},
+		{
+			test:  "aligned_steps",
+			list:  []spec.Selector{spec.Slice(0, 10, 2)},
+			slice: spec.Slice(2, 6, 4),
+			exp:   true,
+		},
+		{
+			test:  "misaligned_steps",
+			list:  []spec.Selector{spec.Slice(0, 10, 2)},
+			slice: spec.Slice(1, 5, 2),
+			exp:   false,
+		},
+		{
+			test:  "bounds_relative_to_both_ends",
+			list:  []spec.Selector{},
+			slice: spec.Slice(1, -1),
+			exp:   false,
+		},
+		{
+			test:  "backward_bounds_relative_to_both_ends",
+			list:  []spec.Selector{},
+			slice: spec.Slice(-1, 1, -1),
+			exp:   false,
+		},
+		{
+			test:  "in_step_0",
+			list:  []spec.Selector{spec.Slice(0, 5, 0)},
+			slice: spec.Slice(1, 2),
+			exp:   false,
+		},
+		{
+			test:  "empty_in_step_0",
+			list:  []spec.Selector{spec.Slice(0, 5, 0)},
+			slice: spec.Slice(4, 2),
+			exp:   true,
+		},
 	} {
 		t.Run(tc.test, func(t *testing.T) {
 			t.Parallel()
@@ -1049,6 +1695,50 @@ func TestContainsSlice(t *testing.T) {
 	}
 }
 
+func TestSliceIsEmpty(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		slice spec.SliceSelector
+		exp   bool
+	}{
+		{"default", spec.Slice(), false},
+		{"step_0", spec.Slice(1, 3, 0), true},
+		{"default_step_0", spec.Slice(nil, nil, 0), true},
+		{"start_end_equal", spec.Slice(2, 2), true},
+		{"negative_start_end_equal", spec.Slice(-2, -2, -1), true},
+		{"forward", spec.Slice(1, 3), false},
+		{"forward_reversed", spec.Slice(3, 1), true},
+		{"forward_negative_reversed", spec.Slice(-1, -3), true},
+		{"forward_mixed", spec.Slice(1, -1), false},
+		{"forward_mixed_reversed", spec.Slice(-1, 2), false},
+		{"forward_default_end", spec.Slice(5), false},
+		{"backward", spec.Slice(3, 1, -1), false},
+		{"backward_reversed", spec.Slice(1, 3, -1), true},
+		{"backward_negative_reversed", spec.Slice(-3, -1, -1), true},
+		{"backward_mixed", spec.Slice(-1, 1, -1), false},
+		{"backward_default_start", spec.Slice(nil, 3, -1), false},
+		{"backward_default_end", spec.Slice(3, nil, -1), false},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			a.Equal(tc.exp, SliceIsEmpty(tc.slice))
+
+			// Must agree with selection from arrays of many lengths.
+			if tc.exp {
+				for n := range 10 {
+					lower, upper := tc.slice.Bounds(n)
+					for i := range n {
+						a.False(sliceSelects(tc.slice, i, n), "selects %d from %d (%d:%d)", i, n, lower, upper)
+					}
+				}
+			}
+		})
+	}
+}
+
 func TestIsBranch(t *testing.T) {
 	t.Parallel()
 
@@ -1267,6 +1957,18 @@ func TestMergeSelectors(t *testing.T) {
 			merge:     []spec.Selector{spec.Index(0), spec.Index(2)},
 			exp:       []spec.Selector{spec.Slice(nil, nil, -1)},
 		},
+		{
+			test:      "wildcard_replaces_all",
+			selectors: []spec.Selector{spec.Name("x"), spec.Index(1)},
+			merge:     []spec.Selector{spec.Name("y"), spec.Wildcard()},
+			exp:       []spec.Selector{spec.Wildcard()},
+		},
+		{
+			test:      "into_wildcard",
+			selectors: []spec.Selector{spec.Wildcard()},
+			merge:     []spec.Selector{spec.Name("y"), spec.Index(1)},
+			exp:       []spec.Selector{spec.Wildcard()},
+		},
 	} {
 		t.Run(tc.test, func(t *testing.T) {
 			t.Parallel()
@@ -1673,6 +2375,18 @@ func TestSameBranches(t *testing.T) {
 			),
 			exp: false,
 		},
+		{
+			test: "diff_descendant",
+			seg1: child().Append(child(spec.Name("x"))),
+			seg2: child().Append(descendant(spec.Name("x"))),
+			exp:  false,
+		},
+		{
+			test: "diff_nested_descendant",
+			seg1: child().Append(child(spec.Name("x")).Append(descendant(spec.Name("y")))),
+			seg2: child().Append(child(spec.Name("x")).Append(child(spec.Name("y")))),
+			exp:  false,
+		},
 	} {
 		t.Run(tc.test, func(t *testing.T) {
 			t.Parallel()
@@ -1734,6 +2448,51 @@ func TestMergeSlices(t *testing.T) {
 			seg:  child(spec.Name("x"), spec.Slice(4, 5), spec.Slice(2, 5), spec.Slice(8), spec.Slice(12, 18)),
 			exp:  child(spec.Name("x"), spec.Slice(2, 5), spec.Slice(8)),
 		},
+		{
+			test: "index_in_slice",
+			seg:  child(spec.Index(1), spec.Name("x"), spec.Slice(0, 5)),
+			exp:  child(spec.Name("x"), spec.Slice(0, 5)),
+		},
+		{
+			test: "index_in_open_slice",
+			seg:  child(spec.Slice(2), spec.Index(7), spec.Index(1)),
+			exp:  child(spec.Slice(2), spec.Index(1)),
+		},
+		{
+			test: "index_between_steps",
+			seg:  child(spec.Slice(0, 10, 2), spec.Index(4), spec.Index(5)),
+			exp:  child(spec.Slice(0, 10, 2), spec.Index(5)),
+		},
+		{
+			test: "index_at_end",
+			seg:  child(spec.Slice(0, 3), spec.Index(3)),
+			exp:  child(spec.Slice(0, 3), spec.Index(3)),
+		},
+		{
+			test: "index_in_merged_slice",
+			seg:  child(spec.Slice(2, 4), spec.Index(4), spec.Slice(1, 6)),
+			exp:  child(spec.Slice(1, 6)),
+		},
+		{
+			test: "keep_negative_index",
+			seg:  child(spec.Slice(0), spec.Index(-1)),
+			exp:  child(spec.Slice(0), spec.Index(-1)),
+		},
+		{
+			test: "keep_index_in_negative_bounds",
+			seg:  child(spec.Slice(-3), spec.Slice(0, -1), spec.Index(0)),
+			exp:  child(spec.Slice(-3), spec.Slice(0, -1), spec.Index(0)),
+		},
+		{
+			test: "keep_index_in_backward_slice",
+			seg:  child(spec.Slice(5, 0, -1), spec.Index(3)),
+			exp:  child(spec.Slice(5, 0, -1), spec.Index(3)),
+		},
+		{
+			test: "backward_last_start",
+			seg:  child(spec.Slice(-1, nil, -1), spec.Slice(nil, nil, -1)),
+			exp:  child(spec.Slice(-1, nil, -1)),
+		},
 		{
 			test: "three_in_one",
 			seg:  child(spec.Slice(2, 4), spec.Slice(1, 3), spec.Slice(0, 5)),
@@ -1747,3 +2506,42 @@ func TestMergeSlices(t *testing.T) {
 		})
 	}
 }
+
+func TestSegment(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	tree := New(
+		jsonpath.MustParse(`$.a["b","c"]`),
+		jsonpath.MustParse(`$..x[0]`),
+	)
+
+	root := tree.Root()
+	a.Empty(root.Selectors())
+	a.False(root.IsDescendant())
+	a.Equal(tree.root.String(), root.String())
+
+	children := root.Children()
+	a.Len(children, 2)
+
+	a.Equal([]spec.Selector{spec.Name("a")}, children[0].Selectors())
+	a.False(children[0].IsDescendant())
+	a.Equal(`["a"]`+"\n"+`└── ["b","c"]`+"\n", children[0].String())
+	grand := children[0].Children()
+	a.Len(grand, 1)
+	a.Equal([]spec.Selector{spec.Name("b"), spec.Name("c")}, grand[0].Selectors())
+	a.Empty(grand[0].Children())
+
+	a.Equal([]spec.Selector{spec.Name("x")}, children[1].Selectors())
+	a.True(children[1].IsDescendant())
+	a.Equal([]spec.Selector{spec.Index(0)}, children[1].Children()[0].Selectors())
+
+	// Modifying the selectors must not change the tree.
+	str := tree.String()
+	sels := grand[0].Selectors()
+	sels[0] = spec.Name("z")
+	a.Equal(str, tree.String())
+
+	// Root-only tree.
+	a.Empty(New().Root().Children())
+}
@@ -0,0 +1,367 @@
+package jsontree
+
+import (
+	"cmp"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/theory/jsonpath/spec"
+)
+
+// SelectorContains returns true if the selectors in set select every value
+// selected by sel, and false if they do not or if it cannot be determined
+// independent of the length of an input array. It takes into account
+// wildcards, [spec.Index]es in [spec.SliceSelector]s, [spec.SliceSelector]
+// overlap, and compares [*spec.FilterSelector]s after normalizing their
+// expressions, so that logically equivalent filters compare equal.
+func SelectorContains(set []spec.Selector, sel spec.Selector) bool {
+	return selectorsContain(set, sel)
+}
+
+// SelectorContainsIndex returns true if the selectors in set select idx from
+// an array of length length. Unlike [SelectorContains], it resolves negative
+// indexes and slices with negative bounds or backward steps against length,
+// so it can determine containment for any of them. Always returns false when
+// idx is out of range for length.
+func SelectorContainsIndex(set []spec.Selector, idx spec.Index, length int) bool {
+	return containsIndexForLen(set, idx, length)
+}
+
+// SliceContainsSlice returns true if sup selects every index selected by sub.
+// A slice that never selects any values, such as one with a step of 0, is
+// contained by any slice. Otherwise, sub's step must be a multiple of sup's
+// step, its first index must align with sup's steps, and its bounds must fall
+// within sup's bounds.
+func SliceContainsSlice(sup, sub spec.SliceSelector) bool {
+	return containsSlice([]spec.Selector{sup}, sub)
+}
+
+// SliceIsEmpty returns true if slice selects nothing from an array of any
+// length, such as a slice with a step of 0, as in [1:3:0], equal start and
+// end bounds, as in [2:2], or bounds relative to the same end of an array
+// that step away from its end, as in [3:1] or [-1:-3]. Returns false for
+// slices whose selections depend on the length of an array, such as [1:-1],
+// which selects nothing from an array of length 2 but selects from longer
+// arrays. Trees drop empty slices when compiling paths.
+func SliceIsEmpty(slice spec.SliceSelector) bool {
+	return sliceIsEmpty(slice)
+}
+
+// FormatSelectors returns the bracketed string representation of sels, such
+// as [:8:2,"a",42], exactly as [Tree.String] displays the selectors of a
+// child segment. Quotes names as valid JSONPath name selectors and returns
+// [] for no selectors.
+func FormatSelectors(sels []spec.Selector) string {
+	return child(sels...).selectorString()
+}
+
+// displayOrder returns sels in a deterministic order for display: wildcards,
+// then slices ordered by start, end, and step, then names, regular
+// expression names, and indexes in ascending order, then filters ordered by
+// their string representations, and finally filter functions and any other
+// selectors in their original order. Returns a sorted copy, leaving sels
+// unchanged, since the order of selectors never affects selection.
+func displayOrder(sels []spec.Selector) []spec.Selector {
+	if len(sels) < 2 {
+		return sels
+	}
+
+	sorted := slices.Clone(sels)
+	slices.SortStableFunc(sorted, compareSelectors)
+
+	return sorted
+}
+
+// compareSelectors compares a and b for displayOrder.
+func compareSelectors(a, b spec.Selector) int {
+	if c := cmp.Compare(displayRank(a), displayRank(b)); c != 0 {
+		return c
+	}
+
+	switch a := a.(type) {
+	case spec.SliceSelector:
+		b, _ := b.(spec.SliceSelector)
+		if c := cmp.Compare(a.Start(), b.Start()); c != 0 {
+			return c
+		}
+
+		if c := cmp.Compare(a.End(), b.End()); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(a.Step(), b.Step())
+	case spec.Name:
+		b, _ := b.(spec.Name)
+		return cmp.Compare(a, b)
+	case spec.Index:
+		b, _ := b.(spec.Index)
+		return cmp.Compare(a, b)
+	case *regexpSelector, *spec.FilterSelector:
+		return cmp.Compare(a.String(), b.String())
+	default:
+		return 0
+	}
+}
+
+// displayRank returns the position of the kind of sel in displayOrder.
+func displayRank(sel spec.Selector) int {
+	switch sel.(type) {
+	case spec.WildcardSelector:
+		return 0
+	case spec.SliceSelector:
+		return 1
+	case spec.Name:
+		return 2
+	case *regexpSelector:
+		return 3
+	case spec.Index:
+		return 4
+	case *spec.FilterSelector:
+		return 5
+	default:
+		return 6
+	}
+}
+
+// selectorString returns the string representation of sel. It quotes
+// [spec.Name] selectors with quoteName rather than their String methods,
+// which use [strconv.Quote] and so may produce escapes that are not valid
+// JSONPath.
+func selectorString(sel spec.Selector) string {
+	if name, ok := sel.(spec.Name); ok {
+		return quoteName(name)
+	}
+
+	return sel.String()
+}
+
+// quoteName returns name quoted as an RFC 9535 name selector. It matches
+// [strconv.Quote] for printable characters and the escapes JSONPath shares
+// with Go, but escapes other control and non-printable characters as \uXXXX,
+// using surrogate pairs outside the Basic Multilingual Plane, rather than
+// the \a, \v, \xXX, and \UXXXXXXXX escapes JSONPath does not support.
+// Replaces bytes that are not valid UTF-8, which JSONPath cannot represent,
+// with U+FFFD.
+func quoteName(name spec.Name) string {
+	const hex = "0123456789abcdef"
+
+	buf := new(strings.Builder)
+	buf.Grow(len(name) + 2)
+	buf.WriteByte('"')
+
+	for _, r := range string(name) {
+		switch r {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if strconv.IsPrint(r) {
+				buf.WriteRune(r)
+				continue
+			}
+
+			units := []rune{r}
+			if r1, r2 := utf16.EncodeRune(r); r1 != '\uFFFD' {
+				units = []rune{r1, r2}
+			}
+
+			for _, u := range units {
+				buf.WriteString(`\u`)
+				buf.WriteByte(hex[u>>12&0xf])
+				buf.WriteByte(hex[u>>8&0xf])
+				buf.WriteByte(hex[u>>4&0xf])
+				buf.WriteByte(hex[u&0xf])
+			}
+		}
+	}
+
+	buf.WriteByte('"')
+
+	return buf.String()
+}
+
+// FilterFunc creates a selector that selects each object member value and
+// array item for which fn returns true, for conditions too dynamic to
+// express as JSONPath filter selectors. Use it to build Trees with
+// [Branch]es, such as:
+//
+//	active := jsontree.FilterFunc(func(val any) bool {
+//		user, ok := val.(map[string]any)
+//		return ok && isActive(user)
+//	})
+//	tree := jsontree.Build(jsontree.Child(spec.Name("users")).Child(active))
+//
+// Selection passes fn values decoded from [encoding/json.RawMessage]s, as
+// for filter selectors. If tree was configured by [WithRecoverFilters], it
+// treats a panic in fn as a non-match. fn must be safe for concurrent use
+// if the Tree selects concurrently.
+//
+// Each call to FilterFunc returns a distinct selector that equals only
+// itself, so that paths merge its segments only with segments that contain
+// the same selector. JSONPath cannot express such selectors, so Trees that
+// contain them do not round-trip through strings: diagrams and
+// [Tree.Queries] render them as ?<func>, which [jsonpath.Parse] rejects,
+// [jsonpath.Path] strings, including those of the paths returned by
+// [Tree.Paths], render them as wildcards, and [Tree.MarshalJSON] returns an
+// [ErrJSON] error.
+func FilterFunc(fn func(val any) bool) spec.Selector {
+	return &funcSelector{fn: fn}
+}
+
+// funcSelector is a [spec.Selector] that selects the values for which a Go
+// function returns true. It embeds [spec.WildcardSelector] to implement the
+// unexported methods of the [spec.Selector] interface, so [spec] renders it
+// as a wildcard.
+type funcSelector struct {
+	spec.WildcardSelector
+
+	fn func(val any) bool
+}
+
+// String returns "?<func>". Defined by [fmt.Stringer].
+func (*funcSelector) String() string {
+	return "?<func>"
+}
+
+// Select selects the values of input, if it is an object, or its items, if
+// it is an array, for which sel's function returns true. Defined by the
+// [spec.Selector] interface.
+func (sel *funcSelector) Select(input, _ any) []any {
+	ret := []any{}
+
+	switch input := input.(type) {
+	case []any:
+		for _, v := range input {
+			if sel.fn(v) {
+				ret = append(ret, v)
+			}
+		}
+	case map[string]any:
+		for _, k := range slices.Sorted(maps.Keys(input)) {
+			if v := input[k]; sel.fn(v) {
+				ret = append(ret, v)
+			}
+		}
+	}
+
+	return ret
+}
+
+// SelectLocated selects values as [funcSelector.Select] does and returns
+// them with their normalized paths, appended to parent. Defined by the
+// [spec.Selector] interface.
+func (sel *funcSelector) SelectLocated(input, _ any, parent spec.NormalizedPath) []*spec.LocatedNode {
+	ret := []*spec.LocatedNode{}
+
+	switch input := input.(type) {
+	case []any:
+		for i, v := range input {
+			if sel.fn(v) {
+				ret = append(ret, &spec.LocatedNode{Path: append(slices.Clip(parent), spec.Index(i)), Node: v})
+			}
+		}
+	case map[string]any:
+		for _, k := range slices.Sorted(maps.Keys(input)) {
+			if v := input[k]; sel.fn(v) {
+				ret = append(ret, &spec.LocatedNode{Path: append(slices.Clip(parent), spec.Name(k)), Node: v})
+			}
+		}
+	}
+
+	return ret
+}
+
+// RegexpName creates a selector that selects the values of the object
+// members whose names match re, for use with [Branch] and [Build], as
+// [spec.Name] selects the value of the member with a single name:
+//
+//	// $["addr_.*"]
+//	addr := jsontree.RegexpName(regexp.MustCompile(`^addr_`))
+//	tree := jsontree.Build(jsontree.Child(addr).Child(spec.Name("city")))
+//
+// It selects nothing from arrays. Matching respects re alone: neither
+// [WithCaseInsensitiveNames] nor [WithExcludeKeys] applies to it, so use
+// (?i) for case-insensitive matching.
+//
+// Selectors with the same pattern equal one another, so that paths merge
+// their segments. JSONPath cannot express such selectors, so Trees that
+// contain them do not round-trip through strings: diagrams and
+// [Tree.Queries] render them as ~/pattern/, which [jsonpath.Parse] rejects,
+// [jsonpath.Path] strings, including those of the paths returned by
+// [Tree.Paths], render them as wildcards, and [Tree.MarshalJSON] returns an
+// [ErrJSON] error.
+func RegexpName(re *regexp.Regexp) spec.Selector {
+	return &regexpSelector{re: re}
+}
+
+// regexpSelector is a [spec.Selector] that selects the values of object
+// members whose names match a regular expression. It embeds
+// [spec.WildcardSelector] to implement the unexported methods of the
+// [spec.Selector] interface, so [spec] renders it as a wildcard.
+type regexpSelector struct {
+	spec.WildcardSelector
+
+	re *regexp.Regexp
+}
+
+// String returns "~/pattern/". Defined by [fmt.Stringer].
+func (sel *regexpSelector) String() string {
+	return "~/" + sel.re.String() + "/"
+}
+
+// Select selects the values of the members of input, if it is an object,
+// whose names match sel's regular expression, in sorted name order. Defined
+// by the [spec.Selector] interface.
+func (sel *regexpSelector) Select(input, _ any) []any {
+	ret := []any{}
+
+	if obj, ok := input.(map[string]any); ok {
+		for _, k := range slices.Sorted(maps.Keys(obj)) {
+			if sel.re.MatchString(k) {
+				ret = append(ret, obj[k])
+			}
+		}
+	}
+
+	return ret
+}
+
+// SelectLocated selects values as [regexpSelector.Select] does and returns
+// them with their normalized paths, appended to parent. Defined by the
+// [spec.Selector] interface.
+func (sel *regexpSelector) SelectLocated(input, _ any, parent spec.NormalizedPath) []*spec.LocatedNode {
+	ret := []*spec.LocatedNode{}
+
+	if obj, ok := input.(map[string]any); ok {
+		for _, k := range slices.Sorted(maps.Keys(obj)) {
+			if sel.re.MatchString(k) {
+				ret = append(ret, &spec.LocatedNode{Path: append(slices.Clip(parent), spec.Name(k)), Node: obj[k]})
+			}
+		}
+	}
+
+	return ret
+}
+
+// containsRegexp returns true if selectors contains a selector created by
+// [RegexpName] with the same pattern as re.
+func containsRegexp(selectors []spec.Selector, re *regexpSelector) bool {
+	return slices.ContainsFunc(selectors, func(s spec.Selector) bool {
+		other, ok := s.(*regexpSelector)
+		return ok && other.re.String() == re.re.String()
+	})
+}
@@ -0,0 +1,342 @@
+package jsontree
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/theory/jsonpath/spec"
+)
+
+// SelectStream selects tree's paths from the from JSON value and writes the
+// selected value encoded as JSON to w as it selects, without building the
+// selected value in memory. It writes the same bytes as [json.Marshal] for
+// the value returned by [Tree.Select]: objects with sorted keys, HTML
+// characters escaped, and no trailing newline. Selects from
+// [json.RawMessage] values as Select does. Returns the errors returned by
+// [Tree.SelectE], an [ErrJSON] error if a selected value cannot be encoded,
+// and any error returned by w, in which case w may have received partial
+// output.
+//
+// SelectStream writes to an [io.Writer] rather than a [json.Encoder],
+// because an Encoder can only encode complete values, which SelectStream
+// would have to build in memory.
+func (tree *Tree) SelectStream(from any, w io.Writer) error {
+	gap, err := json.Marshal(tree.gap)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrJSON, err)
+	}
+
+	sel := *tree
+	sel.errp = &err
+	out := &jsonStream{w: bufio.NewWriter(w), gap: string(gap), errp: &err}
+
+	if len(tree.root.children) > 0 {
+		// Select from the members or items of raw JSON values.
+		from = decodeRaw(from)
+	}
+
+	switch entity := from.(type) {
+	case map[string]any:
+		if len(tree.root.children) == 0 {
+			sel.streamRoot(out, from)
+		} else if !sel.streamObject(out, tree.root.children, entity, entity, 0) {
+			out.write("{}")
+		}
+	case []any:
+		if len(tree.root.children) == 0 {
+			sel.streamRoot(out, from)
+		} else if !sel.streamArray(out, tree.root.children, entity, entity, 0) {
+			out.write("[]")
+		}
+	default:
+		if len(tree.root.children) > 0 {
+			return fmt.Errorf("%w of type %T", ErrUnsupported, from)
+		}
+
+		sel.streamRoot(out, from)
+	}
+
+	if tree.cancel != nil && tree.cancel.err != nil {
+		return tree.cancel.err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return out.w.Flush()
+}
+
+// SelectStreamN writes the value [Tree.SelectN] would select from the from
+// JSON value to w as [Tree.SelectStream] does, stopping once it has selected
+// n values at the end of a path. A root-only Tree writes null when n is
+// less than 1.
+func (tree *Tree) SelectStreamN(from any, n int, w io.Writer) error {
+	sel := *tree
+	sel.limit = &n
+
+	return sel.SelectStream(from, w)
+}
+
+// SelectStreamContext selects tree's paths from the from JSON value and
+// writes them to w as [Tree.SelectStream] does, but stops selecting and
+// returns ctx.Err() once ctx is done, as [Tree.SelectContext] does. w may
+// have received partial output when it returns an error.
+func (tree *Tree) SelectStreamContext(ctx context.Context, from any, w io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sel := *tree
+	sel.cancel = &canceler{ctx: ctx}
+
+	return sel.SelectStream(from, w)
+}
+
+// streamRoot writes val, selected by a root-only Tree, to out, or null if
+// tree.limit allows no values.
+func (tree *Tree) streamRoot(out *jsonStream, val any) {
+	if !tree.take() {
+		out.write("null")
+		return
+	}
+
+	out.value(tree.leafValue(val))
+}
+
+// jsonStream writes JSON to w as it's selected. It defers writing the
+// opening of each object and array, its members' keys, and its items'
+// separators until selection writes a value inside them, so that it can
+// drop them if selection selects nothing from them.
+type jsonStream struct {
+	w *bufio.Writer
+
+	// pending holds the deferred output not yet written to w.
+	pending []byte
+
+	// gap holds the JSON encoding of the value for unselected array
+	// positions in fixed mode.
+	gap string
+
+	// errp records the first error encountered while selecting.
+	errp *error
+}
+
+// prefix appends text to the pending output and returns the length of the
+// pending output before the append, to pass to drop.
+func (out *jsonStream) prefix(text ...string) int {
+	mark := len(out.pending)
+	for _, t := range text {
+		out.pending = append(out.pending, t...)
+	}
+
+	return mark
+}
+
+// drop drops the output deferred since [jsonStream.prefix] returned mark,
+// provided that out has not since written it.
+func (out *jsonStream) drop(mark int) {
+	out.pending = out.pending[:min(mark, len(out.pending))]
+}
+
+// write writes the pending output followed by text. Writes nothing once
+// selection has recorded an error. The bufio.Writer retains write errors
+// for SelectStream to return when it flushes.
+func (out *jsonStream) write(text string) {
+	if *out.errp != nil {
+		return
+	}
+
+	_, _ = out.w.Write(out.pending)
+	_, _ = out.w.WriteString(text)
+	out.pending = out.pending[:0]
+}
+
+// value writes the JSON encoding of val and returns true, or records an
+// [ErrJSON] error and returns false if it cannot be encoded.
+func (out *jsonStream) value(val any) bool {
+	data, err := json.Marshal(val)
+	if err != nil {
+		if *out.errp == nil {
+			*out.errp = fmt.Errorf("%w: %w", ErrJSON, err)
+		}
+
+		return false
+	}
+
+	out.write(string(data))
+
+	return true
+}
+
+// streamValue writes val, selected by s, to out: val itself (or its
+// replacement) if s.leaf is true, and otherwise the object or array selected
+// from val by the segments in s, as for [Tree.selectValue]. Returns false
+// if it writes nothing.
+func (tree *Tree) streamValue(out *jsonStream, s *selection, root, val any) bool {
+	if s.leaf {
+		if !tree.take() {
+			return false
+		}
+
+		return out.value(tree.leafValue(val))
+	}
+
+	if len(s.segs) == 0 || tree.tooDeep(s.depth) {
+		return false
+	}
+
+	// Copy the segments, since the caller reuses s.
+	var buf [4]*segment
+	segs := append(buf[:0], s.segs...)
+
+	switch val := decodeRaw(val).(type) {
+	case map[string]any:
+		return tree.streamObject(out, segs, root, val, s.depth)
+	case []any:
+		return tree.streamArray(out, segs, root, val, s.depth)
+	default:
+		return false
+	}
+}
+
+// streamObject writes the object selected from cur, nested depth levels
+// below root, by the selectors of each segment in segs to out, as for
+// [Tree.selectObject], with its keys in sorted order. Returns false and
+// writes nothing if it selects nothing from cur.
+func (tree *Tree) streamObject(out *jsonStream, segs []*segment, root any, cur map[string]any, depth int) bool {
+	s := selection{depth: depth + 1}
+	mark := out.prefix("{")
+	sep := ""
+
+	keys := tree.streamKeys(segs, cur)
+	if tree.nullNames {
+		// Merge in the missing names, selected as nulls.
+		keys = append(keys, tree.missingNames(segs, cur)...)
+		slices.Sort(keys)
+	}
+
+	for _, k := range keys {
+		if tree.exhausted() {
+			break
+		}
+
+		v, ok := cur[k]
+		if !ok {
+			key, _ := json.Marshal(k)
+			out.prefix(sep, string(key), ":")
+			out.write("null")
+			sep = ","
+
+			continue
+		}
+
+		s.reset(v)
+		tree.markMember(segs, root, k, v, &s)
+
+		key, _ := json.Marshal(k)
+		member := out.prefix(sep, string(key), ":")
+
+		if tree.streamValue(out, &s, root, v) {
+			sep = ","
+		} else {
+			out.drop(member)
+		}
+	}
+
+	if sep == "" {
+		out.drop(mark)
+		return false
+	}
+
+	out.write("}")
+
+	return true
+}
+
+// streamKeys returns the keys of the members of cur that segs may select, in
+// sorted order.
+func (tree *Tree) streamKeys(segs []*segment, cur map[string]any) []string {
+	if tree.selectsAllMembers(segs) {
+		return slices.Sorted(maps.Keys(cur))
+	}
+
+	var keys []string
+
+	for _, seg := range segs {
+		for _, sel := range seg.selectors {
+			if name, ok := sel.(spec.Name); ok {
+				if _, ok := cur[string(name)]; ok {
+					keys = append(keys, string(name))
+				}
+			}
+		}
+	}
+
+	slices.Sort(keys)
+
+	return slices.Compact(keys)
+}
+
+// streamArray writes the array selected from cur, nested depth levels below
+// root, by the selectors of each segment in segs to out, as for
+// [Tree.selectArray]. Returns false and writes nothing if it selects nothing
+// from cur.
+func (tree *Tree) streamArray(out *jsonStream, segs []*segment, root any, cur []any, depth int) bool {
+	s := selection{depth: depth + 1}
+	mark := out.prefix("[")
+
+	// The number of items written, including gaps in fixed mode.
+	n := 0
+	sep := func(pos int) string {
+		if pos == 0 {
+			return ""
+		}
+
+		return ","
+	}
+
+	prev := -1
+	lower, upper := tree.selectedRange(segs, len(cur))
+	for i := lower; i < upper && !tree.exhausted(); i++ {
+		s.reset(cur[i])
+		tree.markItem(segs, root, i, cur, &s)
+
+		pos := n
+		item := out.prefix()
+
+		if tree.index {
+			for ; pos < i; pos++ {
+				out.prefix(sep(pos), out.gap)
+			}
+		} else if gap := i - prev - 1; gap <= tree.nullGap {
+			for ; gap > 0; gap-- {
+				out.prefix(sep(pos), "null")
+				pos++
+			}
+		}
+
+		out.prefix(sep(pos))
+
+		if tree.streamValue(out, &s, root, cur[i]) {
+			n = pos + 1
+			prev = i
+		} else {
+			out.drop(item)
+		}
+	}
+
+	if n == 0 {
+		out.drop(mark)
+		return false
+	}
+
+	out.write("]")
+
+	return true
+}
@@ -0,0 +1,166 @@
+package jsontree
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theory/jsonpath"
+	"github.com/theory/jsonpath/spec"
+)
+
+func TestSelectStream(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"name":   map[string]any{"first": "Kim", "last": "Wexler", "<tag>": "&"},
+		"ssn":    "123-45-6789",
+		"emails": []any{"kim@example.com", nil, "kw@example.net", map[string]any{}},
+		"clients": []any{
+			map[string]any{"name": "Mesa Verde", "ssn": nil, "size": 12},
+			map[string]any{"name": "Sandpiper", "size": 8, "tags": []any{1, 2, 3}},
+			[]any{[]any{}, map[string]any{"ssn": "x"}},
+		},
+		"empty": map[string]any{},
+	}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		input any
+	}{
+		{"root", []string{"$"}, nil},
+		{"root_scalar", []string{"$"}, "hi"},
+		{"root_array", []string{"$"}, []any{1, "<", nil}},
+		{"names", []string{"$.ssn", "$.name.first", "$.nonesuch"}, nil},
+		{"html", []string{"$.name['<tag>']"}, nil},
+		{"container", []string{"$.name"}, nil},
+		{"empty_container", []string{"$.empty", "$.emails[3]"}, nil},
+		{"no_match", []string{"$.x.y", "$.name.x"}, nil},
+		{"indexes", []string{"$.emails[1,2]"}, nil},
+		{"index_gaps", []string{"$.emails[2]", "$.clients[1].tags[2,0]"}, nil},
+		{"nothing_after_gap", []string{"$.emails[0]", "$.emails[2].x"}, nil},
+		{"slice", []string{"$.emails[3:0:-2]", "$.clients[1:].name"}, nil},
+		{"wildcard", []string{"$.clients[*].name", "$.name.*"}, nil},
+		{"descendant", []string{"$..ssn"}, nil},
+		{"descendant_array", []string{"$..[0]"}, nil},
+		{"filter", []string{"$.clients[?@.size > 10].name", `$.emails[?@ == "kw@example.net"]`}, nil},
+		{"array_root", []string{"$[1:]", "$[0].x"}, []any{map[string]any{"x": 1}, 2, 3}},
+		{"array_no_match", []string{"$[5]"}, []any{1, 2}},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			if tc.input == nil {
+				tc.input = input
+			}
+
+			for _, tree := range []*Tree{
+				New(paths...),
+				NewFixedModeTree(paths...),
+				NewCompiler(WithGapValue("-")).NewFixedModeTree(paths...),
+			} {
+				exp, err := json.Marshal(tree.Select(tc.input))
+				require.NoError(t, err)
+
+				var buf bytes.Buffer
+				require.NoError(t, tree.SelectStream(tc.input, &buf))
+				a.Equal(string(exp), buf.String())
+			}
+		})
+	}
+}
+
+type errWriter struct{}
+
+var errWrite = errors.New("write failed")
+
+func (errWriter) Write([]byte) (int, error) { return 0, errWrite }
+
+func TestSelectStreamErrors(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	tree := New(jsonpath.MustParse("$.a"))
+
+	var buf bytes.Buffer
+	err := tree.SelectStream("hi", &buf)
+	a.ErrorIs(err, ErrUnsupported)
+	a.Empty(buf.String())
+
+	err = tree.SelectStream(map[string]any{"a": func() {}}, &buf)
+	a.ErrorIs(err, ErrJSON)
+
+	err = tree.SelectStream(map[string]any{"a": 1}, errWriter{})
+	a.ErrorIs(err, errWrite)
+
+	err = NewCompiler(WithMaxDepth(1)).New(jsonpath.MustParse("$.a.b.c")).SelectStream(
+		map[string]any{"a": map[string]any{"b": map[string]any{"c": 1}}}, &buf,
+	)
+	a.ErrorIs(err, ErrMaxDepth)
+
+	err = NewCompiler(WithGapValue(func() {})).NewFixedModeTree(jsonpath.MustParse("$[1]")).SelectStream(
+		[]any{1, 2}, &buf,
+	)
+	a.ErrorIs(err, ErrJSON)
+}
+
+func TestSelectStreamContext(t *testing.T) {
+	t.Parallel()
+
+	items := make([]any, cancelInterval*8)
+	for i := range items {
+		items[i] = map[string]any{"id": i}
+	}
+	value := map[string]any{"items": items}
+	tree := New(jsonpath.MustParse("$.items[*].id"))
+
+	t.Run("not_cancelled", func(t *testing.T) {
+		t.Parallel()
+		exp, err := json.Marshal(tree.Select(value))
+		require.NoError(t, err)
+
+		buf := new(bytes.Buffer)
+		require.NoError(t, tree.SelectStreamContext(context.Background(), value, buf))
+		assert.Equal(t, string(exp), buf.String())
+	})
+
+	t.Run("already_cancelled", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		buf := new(bytes.Buffer)
+		require.ErrorIs(t, tree.SelectStreamContext(ctx, value, buf), context.Canceled)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("cancel_mid_selection", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		visited := 0
+		cancelling := Build(Child(spec.Name("items")).Child(FilterFunc(func(any) bool {
+			visited++
+			if visited == cancelInterval {
+				cancel()
+			}
+
+			return true
+		})).Child(spec.Name("id")))
+
+		require.ErrorIs(t, cancelling.SelectStreamContext(ctx, value, new(bytes.Buffer)), context.Canceled)
+		assert.Less(t, visited, len(items))
+	})
+}
@@ -4,7 +4,14 @@ Package jsontree selects RFC 9535 JSONPaths from one JSON value into a new value
 package jsontree
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math"
 	"slices"
 	"strings"
 
@@ -12,18 +19,103 @@ import (
 	"github.com/theory/jsonpath/spec"
 )
 
+// ErrFrozen errors are returned when attempting to modify a Tree frozen by
+// [Tree.Freeze].
+var ErrFrozen = errors.New("jsontree: tree is frozen")
+
+// ErrSliceBounds errors are returned by [Tree.SelectE] when a Tree
+// configured by [WithStrictSliceBounds] selects a slice with explicit bounds
+// outside an array.
+var ErrSliceBounds = errors.New("jsontree: slice bounds out of range")
+
+// ErrUnsupported errors are returned by [Tree.SelectE] when asked to select
+// paths from a value other than an object or array.
+var ErrUnsupported = errors.New("jsontree: cannot select from value")
+
+// ErrMaxDepth errors are returned by [Tree.SelectE] when a Tree configured
+// by [WithMaxDepth] stops selecting from values nested deeper than its
+// maximum depth.
+var ErrMaxDepth = errors.New("jsontree: maximum depth exceeded")
+
+// ErrInvalidTree errors are returned by [Tree.Validate] when a Tree violates
+// an invariant of compiled Trees.
+var ErrInvalidTree = errors.New("jsontree: invalid tree")
+
+// ErrInternal errors are returned by [Tree.SelectE] when selection violates
+// an internal invariant of the jsontree package, which indicates a bug.
+// [Tree.Select] panics instead.
+var ErrInternal = errors.New("jsontree: internal error")
+
 // Tree represents a tree of JSONPath query expressions.
+//
+// A Tree is safe for concurrent use by multiple goroutines that select
+// values with it, since selection never modifies a Tree: each call keeps
+// its state in its own copy of the Tree's configuration, and allocates the
+// objects and arrays it returns. Methods that modify a Tree, such as
+// [Tree.AddPath], must not run concurrently with any other method; use
+// [Tree.Freeze] to prevent such modification.
 type Tree struct {
-	root  *segment
-	index bool
+	root       *segment
+	sources    []*jsonpath.Path
+	index      bool
+	frozen     bool
+	recover    bool
+	strict     bool
+	sorted     bool
+	keyOrder   bool
+	rawLeaves  bool
+	fold       bool
+	coalesce   bool
+	copyLeaves bool
+	nullNames  bool
+	keepWild   bool
+	maxDepth   int
+	maxDescend int
+	nullGap    int
+	yaml       Codec
+	exclude    map[string]struct{}
+	gap        any
+	observer   func(seg *Segment)
+
+	// leaf, when set, replaces each value selected at the end of a path.
+	leaf func(val any) any
+
+	// errp, when set, records the first error encountered while selecting.
+	errp *error
+
+	// matched, when set, records whether selection selected any value.
+	matched *bool
+
+	// limit, when set, records how many more values selection may select at
+	// the end of a path.
+	limit *int
+
+	// cancel, when set, stops selection once its context is done.
+	cancel *canceler
+
+	// explain, when set, records each decision made while merging segment
+	// seg of path into a tree, and whether it changed what the path selects
+	// or how the tree represents it. seg is -1 for decisions about the path
+	// as a whole.
+	explain func(path, seg int, msg string, changed bool)
 }
 
-// selectorsFor returns the selectors from seg, eliminating duplicates. Slices
+// selectorsFor returns the selectors from seg, eliminating duplicates and
+// replacing slices with their canonical forms (see canonicalSlice). Slices
 // are listed first, so that subsequent indexes can be checked for inclusion
 // in them. It also returns true if the returned selectors are a wildcard.
+//
+// Collapsing a segment with a wildcard to just the wildcard is always safe,
+// because children belong to segments rather than to selectors: every
+// selector in a segment shares the same remaining branch, and the wildcard
+// selects every value the other selectors select. A name or index with
+// children that differ from the wildcard's, such as $.a.x and $.*.y, occupies
+// a separate sibling segment that merge never combines with the wildcard,
+// and selection applies the children of both to the values both select.
 func selectorsFor(seg *spec.Segment) ([]spec.Selector, bool) {
-	// Sort wildcards and slices first.
-	selectors := seg.Selectors()
+	// Sort wildcards and slices first. Clone the selectors, since seg may be
+	// part of a path owned by the caller.
+	selectors := slices.Clone(seg.Selectors())
 	slices.SortFunc(selectors, func(a, b spec.Selector) int {
 		switch a.(type) {
 		case spec.WildcardSelector:
@@ -53,6 +145,10 @@ func selectorsFor(seg *spec.Segment) ([]spec.Selector, bool) {
 			return []spec.Selector{spec.Wildcard()}, true
 		}
 
+		if slice, ok := sel.(spec.SliceSelector); ok {
+			sel = canonicalSlice(slice)
+		}
+
 		if !selectorsContain(ret, sel) {
 			ret = append(ret, sel)
 		}
@@ -61,36 +157,381 @@ func selectorsFor(seg *spec.Segment) ([]spec.Selector, bool) {
 	return ret, false
 }
 
+// Compiler compiles JSONPath queries into Trees configured with its
+// options.
+type Compiler struct {
+	opts []Option
+}
+
+// NewCompiler creates and returns a new Compiler that configures the Trees it
+// compiles with opt.
+func NewCompiler(opt ...Option) *Compiler {
+	return &Compiler{opts: opt}
+}
+
+// New compiles paths into an ordered mode Tree configured with c's options.
+// See [New] for details.
+func (c *Compiler) New(paths ...*jsonpath.Path) *Tree {
+	tree := &Tree{}
+	for _, opt := range c.opts {
+		opt(tree)
+	}
+
+	tree.root = tree.compile(paths)
+	tree.sources = paths
+
+	return tree
+}
+
+// NewFixedModeTree compiles paths into a fixed mode Tree configured with c's
+// options. See [NewFixedModeTree] for details.
+func (c *Compiler) NewFixedModeTree(paths ...*jsonpath.Path) *Tree {
+	tree := c.New(paths...)
+	tree.index = true
+
+	return tree
+}
+
 // NewFixedModeTree compiles paths into a fixed mode Tree that selects all of
 // its paths. Array items selected by the paths will be preserved at the index
 // in which they appear in the input value passed to [Tree.Select]; Any
 // preceding unselected array indexes will be nil.
 func NewFixedModeTree(paths ...*jsonpath.Path) *Tree {
-	tree := New(paths...)
-	tree.index = true
-
-	return tree
+	return NewCompiler().NewFixedModeTree(paths...)
 }
 
 // New compiles paths into an ordered mode Tree that selects of its paths.
 // Array items selected by the paths will be preserved in the order in which
 // they appear in the input value passed to [Tree.Select]. Unselected array
 // indexes will be omitted.
+//
+// Just as a shorter path such as $.a subsumes longer paths such as $.a.b, a
+// path that selects the entire value, such as $ or $.*, subsumes all other
+// paths, so that New returns a root-only Tree regardless of their order.
 func New(paths ...*jsonpath.Path) *Tree {
+	return NewCompiler().New(paths...)
+}
+
+// NewFromStrings parses paths with [jsonpath.Parse] and compiles them into an
+// ordered mode Tree configured with c's options. See [NewFromStrings] for
+// details.
+func (c *Compiler) NewFromStrings(paths ...string) (*Tree, error) {
+	parsed, err := parsePaths(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.New(parsed...), nil
+}
+
+// NewFixedModeTreeFromStrings parses paths with [jsonpath.Parse] and
+// compiles them into a fixed mode Tree configured with c's options. See
+// [NewFromStrings] for details.
+func (c *Compiler) NewFixedModeTreeFromStrings(paths ...string) (*Tree, error) {
+	parsed, err := parsePaths(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.NewFixedModeTree(parsed...), nil
+}
+
+// NewFromStrings parses paths, JSONPath query strings, with [jsonpath.Parse]
+// and compiles them into an ordered mode Tree as [New] does. If any of paths
+// fails to parse, it returns no Tree and an error joining a
+// [jsonpath.ErrPathParse] error for each such path, prefixed with its index
+// in paths, starting from 0, and the path itself.
+func NewFromStrings(paths ...string) (*Tree, error) {
+	return NewCompiler().NewFromStrings(paths...)
+}
+
+// MustNewFromStrings parses paths and compiles them into an ordered mode Tree
+// as [NewFromStrings] does, but panics with its error if any of paths fails
+// to parse. Useful for initializing Trees from constant queries.
+func MustNewFromStrings(paths ...string) *Tree {
+	tree, err := NewFromStrings(paths...)
+	if err != nil {
+		panic(err)
+	}
+
+	return tree
+}
+
+// NewFixedModeTreeFromStrings parses paths and compiles them into a fixed
+// mode Tree as [NewFixedModeTree] does. Returns errors as [NewFromStrings]
+// does.
+func NewFixedModeTreeFromStrings(paths ...string) (*Tree, error) {
+	return NewCompiler().NewFixedModeTreeFromStrings(paths...)
+}
+
+// parsePaths parses each of paths with [jsonpath.Parse]. Returns an error
+// joining the errors for all of paths that fail to parse.
+func parsePaths(paths []string) ([]*jsonpath.Path, error) {
+	parsed := make([]*jsonpath.Path, len(paths))
+	var errs []error
+
+	for i, str := range paths {
+		path, err := jsonpath.Parse(str)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("path %d %q: %w", i, str, err))
+			continue
+		}
+
+		parsed[i] = path
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return parsed, nil
+}
+
+// compile compiles paths into a tree of segments and returns its root.
+func (tree *Tree) compile(paths []*jsonpath.Path) *segment {
 	root := child()
+	tree.merge(root, paths)
+	root.deduplicate()
+	tree.coalesceIndexes(root)
+	root.index()
+
+	return root
+}
+
+// AddPath merges path into tree, just as if tree had been compiled with it,
+// respecting tree's array handling mode and options. Adding a path that
+// [Tree.Contains] reports tree already contains leaves the values tree
+// selects unchanged, while adding a path that selects the entire value,
+// such as $, makes tree root-only. A root-only Tree, including one compiled
+// from no paths, already selects the entire value, so adding a path to it
+// changes only the paths selected by [Tree.SelectByPath]; to build a Tree
+// one path at a time, compile it from the first. Returns [ErrFrozen] if tree
+// has been frozen by [Tree.Freeze].
+func (tree *Tree) AddPath(path *jsonpath.Path) error {
+	if tree.frozen {
+		return ErrFrozen
+	}
+
+	if len(tree.root.children) > 0 {
+		tree.merge(tree.root, []*jsonpath.Path{path})
+		tree.root.deduplicate()
+		tree.coalesceIndexes(tree.root)
+		tree.root.index()
+	}
+
+	tree.sources = append(tree.sources[:len(tree.sources):len(tree.sources)], path)
+
+	return nil
+}
+
+// Contains returns true if tree already selects every value selected by
+// path, so that [Tree.AddPath] would not change the values tree selects. It
+// merges path with tree's paths exactly as AddPath would, so it recognizes
+// paths that merging subsumes, such as $.a.b in a Tree that selects $.a or
+// $[*].b, but not paths only selected by other means, such as $.a.b in a
+// Tree that selects $..b. Nor does it recognize paths whose containment
+// depends on the lengths of arrays, such as $[-1] in a Tree that selects
+// $[1:]. A root-only Tree contains every path, while only a root-only Tree
+// contains a path that selects the entire value, such as $.
+func (tree *Tree) Contains(path *jsonpath.Path) bool {
+	if len(tree.root.children) == 0 {
+		return true
+	}
+
+	if len(tree.compile([]*jsonpath.Path{path}).children) == 0 {
+		// The path selects the entire value.
+		return false
+	}
+
+	paths := tree.Paths()
+	before := tree.compile(paths)
+
+	return before.sameBranches(tree.compile(append(paths, path)))
+}
+
+// Merge returns a new Tree that selects the paths selected by both tree and
+// other, with overlapping branches merged exactly as if their paths had all
+// been compiled together. The new Tree has tree's options and array handling
+// mode and is not frozen; neither tree nor other is modified. If either is
+// root-only, so is the new Tree, since it selects the entire value. Panics if
+// tree and other use different array handling modes, as created by [New]
+// and [NewFixedModeTree], because the merged tree could not select
+// consistently for both.
+func (tree *Tree) Merge(other *Tree) *Tree {
+	if tree.index != other.index {
+		panic(fmt.Sprintf(
+			"jsontree: cannot merge %v mode tree into %v mode tree",
+			modeName(other.index), modeName(tree.index),
+		))
+	}
+
+	res := *tree
+	res.frozen = false
+
+	if len(tree.root.children) == 0 || len(other.root.children) == 0 {
+		res.root = child()
+	} else {
+		res.root = res.compile(append(tree.Paths(), other.Paths()...))
+	}
+
+	res.sources = append(tree.sourcePaths(), other.sourcePaths()...)
+
+	return &res
+}
+
+// Intersect returns a new Tree that selects only the values selected by both
+// a and b. It intersects each path of a with each path of b, segment by
+// segment, and compiles the results as [Tree.Merge] does. Where one path is
+// shorter than the other, the longer path's remaining segments select from
+// the values the shorter path selects in full. Selectors intersect as
+// follows:
+//
+//   - A wildcard intersects any selector as that selector: [*] and ["a"]
+//     intersect as ["a"]
+//   - A name intersects the same name or a [RegexpName] selector that
+//     matches it as that name
+//   - A slice intersects an index it contains as that index, a slice it
+//     contains as that slice, and another forward slice with non-negative
+//     bounds and a step of 1 as the overlap of their ranges
+//   - Indexes intersect only themselves or slices that contain them, and
+//     negative indexes intersect only slices that reach the end of every
+//     array, such as [:], since Intersect cannot know the lengths of arrays
+//   - Filters intersect only logically equivalent filters
+//
+// Descendant segments intersect only descendant segments in the same
+// position, so Intersect omits values both trees select via a descendant
+// segment in one and child segments in the other, such as $..a and $.a.
+// Intersecting a root-only Tree returns a copy of the other Tree. If no
+// paths intersect, the new Tree has a single segment with no selectors, which
+// selects nothing. The new Tree has a's options and array handling mode and
+// is not frozen; neither a nor b is modified. Panics if a and b use
+// different array handling modes, as for [Tree.Merge].
+func Intersect(a, b *Tree) *Tree {
+	if a.index != b.index {
+		panic(fmt.Sprintf(
+			"jsontree: cannot intersect %v mode tree with %v mode tree",
+			modeName(a.index), modeName(b.index),
+		))
+	}
+
+	res := *a
+	res.frozen = false
+
+	switch {
+	case len(a.root.children) == 0:
+		res.root = res.compile(b.Paths())
+		res.sources = b.sources
+		return &res
+	case len(b.root.children) == 0:
+		res.root = res.compile(a.Paths())
+		return &res
+	}
+
+	var paths []*jsonpath.Path
+	bPaths := b.Paths()
+
+	for _, p := range a.Paths() {
+		for _, q := range bPaths {
+			if segs, ok := intersectSegments(p.Query().Segments(), q.Query().Segments()); ok {
+				paths = append(paths, jsonpath.New(spec.Query(true, segs...)))
+			}
+		}
+	}
+
+	res.sources = paths
+	if len(paths) == 0 {
+		// Select nothing rather than compiling a root-only Tree.
+		paths = append(paths, jsonpath.New(spec.Query(true, spec.Child())))
+	}
+
+	res.root = res.compile(paths)
+
+	return &res
+}
+
+// Explain compiles paths into a new Tree with tree's array handling mode and
+// options, exactly as [Compiler.New] or [Compiler.NewFixedModeTree] would,
+// and returns a plain text description of how it merged each segment of each
+// path, followed by a diagram of the resulting Tree. Useful for debugging
+// why paths did or did not merge. Describes the segments of each path by
+// their index, starting from 0. Does not modify tree.
+func (tree *Tree) Explain(paths ...*jsonpath.Path) string {
+	buf := new(strings.Builder)
+	last := -1
+
+	sel := *tree
+	sel.explain = func(path, seg int, msg string, _ bool) {
+		if path != last {
+			fmt.Fprintf(buf, "path %d: %v\n", path, paths[path])
+			last = path
+		}
+
+		if seg < 0 {
+			fmt.Fprintf(buf, "  %v\n", msg)
+		} else {
+			fmt.Fprintf(buf, "  segment %d %v: %v\n", seg, paths[path].Query().Segments()[seg], msg)
+		}
+	}
+
+	sel.root = sel.compile(paths)
+	sel.explain = nil
+
+	buf.WriteString("result:\n")
+	buf.WriteString(sel.String())
+
+	return buf.String()
+}
+
+// Equal returns true if tree and other have the same array handling mode,
+// as created by [New] and [NewFixedModeTree], and the same branches. It
+// compares segments semantically, regardless of the order of their
+// selectors and children, and treats logically equivalent filter selectors
+// as equal. Equal does not compare options or whether the Trees are frozen.
+func (tree *Tree) Equal(other *Tree) bool {
+	return tree.index == other.index && tree.root.sameBranches(other.root)
+}
+
+// modeName returns the name of the array handling mode for index.
+func modeName(index bool) string {
+	if index {
+		return "fixed"
+	}
+
+	return "ordered"
+}
+
+// merge merges paths into the tree of segments starting at root. Call
+// deduplicate on root once all paths have been merged.
+func (tree *Tree) merge(root *segment, paths []*jsonpath.Path) {
 	cur := root
+	whole := false
 
 PATH:
-	for _, path := range paths {
+	for p, path := range paths {
 		// Iterate over the sequence of spec.Segments in the path.
 		segs := path.Query().Segments()
+		note := func(i int, msg string) {
+			if tree.explain != nil {
+				tree.explain(p, i, msg, false)
+			}
+		}
+		change := func(i int, msg string) {
+			if tree.explain != nil {
+				tree.explain(p, i, msg, true)
+			}
+		}
 
 	SEG:
 		for i, seg := range segs {
 			selectors, isWild := selectorsFor(seg)
-			if isWild && i == len(segs)-1 {
+			if len(selectors) < len(seg.Selectors()) {
+				change(i, "removed duplicate and redundant selectors, leaving "+FormatSelectors(selectors))
+			}
+
+			if isWild && i == len(segs)-1 && !tree.keepWildcard(seg) {
 				// Trailing wildcard is the same as selecting the parent, so
 				// discard it and continue with the next path.
+				change(i, "discarded trailing wildcard, which selects the same values as its parent")
 				continue
 			}
 
@@ -101,6 +542,8 @@ PATH:
 					switch {
 					case child.isBranch(segs[i+1:]):
 						// Sub-branches equal; merge selectors and continue.
+						note(i, "merged selectors into existing segment "+child.selectorString()+
+							" with the same remaining branch")
 						cur = child.mergeSelectors(selectors)
 						continue SEG
 
@@ -109,19 +552,26 @@ PATH:
 						switch {
 						case len(child.children) == 0:
 							// Discard remaining segments and go to next path.
+							change(i, "discarded remaining segments, since existing leaf segment "+
+								child.selectorString()+" selects their values")
 							continue PATH
 						case i == len(segs)-1:
 							// Discard existing children and go to next path.
+							change(i, "discarded the children of existing segment "+child.selectorString()+
+								", since the path ends and selects their values")
 							child.children = []*segment{}
 							continue PATH
 						default:
 							// Branches continue in sub-segments.
+							note(i, "continued into existing segment "+child.selectorString()+
+								" with the same selectors")
 							cur = child
 							continue SEG
 						}
 					}
 				case isWild && !child.descendant && child.isWildcard() && child.isBranch(segs[i+1:]):
 					// Descendant wildcard with same descendants wins.
+					change(i, "descendant wildcard absorbed existing child wildcard with the same remaining branch")
 					child.descendant = true
 					cur = child
 
@@ -131,16 +581,50 @@ PATH:
 			}
 
 			// No matching child, append a new one.
+			note(i, "appended new segment")
 			cur = newChild(cur, seg, selectors)
 		}
 
+		switch {
+		case cur == root:
+			// The path has no segments other than a trailing wildcard, so
+			// it selects the entire value, whatever the other paths select.
+			change(-1, "selects the entire value, making the tree root-only")
+			whole = true
+		case len(cur.children) > 0:
+			// The path discarded a trailing wildcard after continuing into
+			// an existing segment, so it selects all of that segment's
+			// values.
+			change(len(segs)-1, "discarded the children of existing segment "+cur.selectorString()+
+				", since the path ends and selects their values")
+			cur.children = []*segment{}
+		}
+
 		// Continue to the next path.
 		cur = root
 	}
 
-	root.deduplicate()
+	if whole {
+		root.children = []*segment{}
+	}
+}
 
-	return &Tree{root: root}
+// coalesceIndexes coalesces contiguous indexes into slices in the segments
+// beneath root if tree was configured by [WithCoalescedIndexes]. It does
+// nothing if tree was configured by [WithStrictSliceBounds], since
+// [Tree.SelectE] returns errors for slices beyond the bounds of an array
+// but not for indexes.
+func (tree *Tree) coalesceIndexes(root *segment) {
+	if tree.coalesce && !tree.strict {
+		root.coalesceIndexes()
+	}
+}
+
+// keepWildcard returns true if tree must retain the trailing wildcard seg,
+// either because it was configured by [WithKeepTrailingWildcard] or because
+// it has keys to exclude from child wildcard selection.
+func (tree *Tree) keepWildcard(seg *spec.Segment) bool {
+	return tree.keepWild || (len(tree.exclude) > 0 && !seg.IsDescendant())
 }
 
 // newChild creates a new child, appends it to cur.children, and returns it.
@@ -152,410 +636,1530 @@ func newChild(cur *segment, seg *spec.Segment, selectors []spec.Selector) *segme
 	return child
 }
 
-// String returns a string representation of tree, starting from "$" for the
-// root, and including all of its child segments as a tree diagram.
-func (tree *Tree) String() string {
-	buf := new(strings.Builder)
-	buf.WriteString("$\n")
-
-	lastIndex := len(tree.root.children) - 1
-	for i, c := range tree.root.children {
-		c.writeTo(buf, "", i == lastIndex)
+// Freeze makes tree immutable. It copies the selectors of each of tree's
+// segments, which may otherwise share storage with the [jsonpath.Path]s from
+// which it was compiled, so that subsequent changes to those paths do not
+// affect tree. Methods that modify a Tree return [ErrFrozen] once it has
+// been frozen. A frozen Tree is safe to share between goroutines
+// indefinitely.
+func (tree *Tree) Freeze() {
+	if !tree.frozen {
+		tree.root.freeze()
+		// Reindex in case the selectors changed since compiling.
+		tree.root.index()
+		tree.frozen = true
 	}
-
-	return buf.String()
 }
 
-// Select selects tree's paths from the from JSON value into a new value. A
-// root-only JSONTree that contains no children simply returns from. All other
-// JSONTree queries will select from the from value if it's an array ([]any)
-// or object (map[string]any), and return nil for any other values.
-func (tree *Tree) Select(from any) any {
-	if len(tree.root.children) == 0 {
-		return from
-	}
-
-	switch entity := from.(type) {
-	case map[string]any:
-		ret := map[string]any{}
-		tree.selectObjectSegment(tree.root, entity, entity, ret)
-
-		if tree.index {
-			return ret
-		}
+// Clone returns a deep copy of tree with the same options and array
+// handling mode, so that modifying the copy, such as by [Tree.AddPath] or
+// [Tree.Prune], does not affect tree, and vice versa. The copy is not
+// frozen, even if tree is.
+func (tree *Tree) Clone() *Tree {
+	res := *tree
+	res.root = tree.root.clone()
+	res.sources = slices.Clone(tree.sources)
+	res.frozen = false
+
+	return &res
+}
 
-		return compressObject(ret)
-	case []any:
-		ret := make([]any, 0, cap(entity))
-		if sel := tree.selectArraySegment(tree.root, entity, entity, ret); sel != nil {
-			if tree.index {
-				return sel
-			}
+// ResolveForLength returns a copy of tree in which every slice and negative
+// index selector is replaced by the non-negative index selectors it selects
+// from an array of length length, dropping indexes out of range. The
+// resulting tree selects the same values as tree from arrays of that length,
+// but no longer depends on array length to determine which indexes to select,
+// making it useful for caching selection plans for data whose arrays all
+// have a known, stable length. It selects different values from arrays of
+// other lengths. The copy is not frozen, even if tree is.
+func (tree *Tree) ResolveForLength(length int) *Tree {
+	res := *tree
+	res.root = tree.root.resolve(length)
+	res.sources = nil
+	res.frozen = false
+
+	return &res
+}
 
-			return compressArray(sel)
-		}
+// Prune removes the branches of tree that can never select a value,
+// simplifying its diagram and saving [Tree.Select] from evaluating them. A
+// segment selects nothing, and Prune removes it along with its descendants,
+// if:
+//
+//   - It has no selectors, as results from compiling a segment whose only
+//     selectors are slices with a step of 0, such as [::0], from
+//     [Tree.ResolveForLength] dropping every index out of range, or from a
+//     [Branch] segment created without selectors
+//   - All of its child segments select nothing
+//
+// A Tree whose branches all select nothing remains unchanged, since removing
+// them would leave a root-only Tree that selects entire values. Returns
+// [ErrFrozen] if tree has been frozen by [Tree.Freeze].
+func (tree *Tree) Prune() error {
+	if tree.frozen {
+		return ErrFrozen
+	}
 
-		return ret
-	default:
-		// Cannot select from any other type. Following RFC 9535, return nil.
+	if tree.root.childrenSelectNothing() {
 		return nil
 	}
-}
 
-// compressArray recursively removes all unselected indexes from array and its
-// array descendants and returns the result. Used by [Select] for Trees
-// created by [New], but not those created by [NewFixedModeTree].
-//
-// It would be nice to find a way to enable this behavior without iterating
-// over the entire selected value before returning it. An attempt to use a
-// struct that automatically handled both append and index-preserving modes
-// failed because arrays can be processed multiple times, but should not be
-// appended multiple times. Thus this solution simply records when a selected
-// value is nil (see [Tree.insert]), and then recursively iterates over all
-// arrays to remove them.
-func compressArray(array []any) []any {
-	ret := array[:0]
-	for _, v := range array {
-		switch v := v.(type) {
-		case nullVal:
-			// null was selected, keep it as a nil.
-			ret = append(ret, nil)
-		case []any:
-			ret = append(ret, compressArray(v))
-		case map[string]any:
-			ret = append(ret, compressObject(v))
-		case nil:
-			// Skip it.
-		default:
-			ret = append(ret, v)
-		}
-	}
+	tree.root.prune()
+	tree.root.deduplicate()
+	tree.root.index()
 
-	return slices.Clip(ret)
+	return nil
 }
 
-// compressArray recursively removes all unselected indexes from arrays in
-// object and its array descendants and returns the result. Used by [Select]
-// for Trees created by [New], but not those created by [NewFixedModeTree].
-func compressObject(object map[string]any) map[string]any {
-	for k, v := range object {
-		switch v := v.(type) {
-		case []any:
-			object[k] = compressArray(v)
-		case map[string]any:
-			object[k] = compressObject(v)
-		}
-	}
-
-	return object
+// Validate checks that tree satisfies the invariants of compiled Trees and
+// returns an [ErrInvalidTree] error describing each segment that violates
+// them, identified by the query from the root to the segment. It reports:
+//
+//   - Sibling segments with identical selectors and descendant flags, which
+//     compiling merges into a single segment
+//   - Segments with child segments but no selectors, which select nothing;
+//     use [Tree.Prune] to remove them
+//   - Wildcard segments with additional selectors, which the wildcard makes
+//     redundant
+//
+// Validate never modifies tree, and returns nil if it finds no violations.
+func (tree *Tree) Validate() error {
+	return errors.Join(tree.root.validate("$")...)
 }
 
-// selectObjectSegment uses the selectors in seg to select paths from src into
-// dst and recurses into its children.
-func (tree *Tree) selectObjectSegment(seg *segment, root any, cur, dst map[string]any) {
-	tree.selectObject(seg, root, cur, dst)
+// Root returns the root of tree, whose children are the first segments of
+// each of tree's paths. The root of a root-only Tree has no children.
+func (tree *Tree) Root() *Segment {
+	return &Segment{tree.root}
+}
 
-	for _, seg := range seg.children {
-		tree.selectObject(seg, root, cur, dst)
-	}
+// Depth returns the number of segments in the longest branch of tree, from
+// the root to a leaf segment. A root-only Tree has a depth of 0.
+func (tree *Tree) Depth() int {
+	return tree.root.depth()
 }
 
-// selectObject uses the selectors in seg to select paths from src to dst. If
-// seg is a descendant Segment, it recursively selects from seg into all of
-// src's values.
-func (tree *Tree) selectObject(seg *segment, root any, cur, dst map[string]any) {
-	for _, sel := range seg.selectors {
-		switch sel := sel.(type) {
-		case spec.Name:
-			tree.processKey(string(sel), seg, root, cur, dst)
-		case spec.WildcardSelector:
-			for k := range cur {
-				tree.processKey(k, seg, root, cur, dst)
-			}
-		case *spec.FilterSelector:
-			for k, v := range cur {
-				if sel.Eval(v, root) {
-					tree.processKey(k, seg, root, cur, dst)
-				}
-			}
-		}
-	}
+// Size returns the total number of segments in tree, not counting the root.
+// A root-only Tree has a size of 0.
+func (tree *Tree) Size() int {
+	return tree.root.size()
+}
 
-	if seg.descendant {
-		tree.descendObject(seg, root, cur, dst)
-	}
+// IsFixedMode returns true if tree preserves the indexes of selected array
+// items, as created by [NewFixedModeTree] or [Compiler.NewFixedModeTree],
+// and false if it preserves their order, as created by [New] or
+// [Compiler.New]. Trees derived from tree, such as by [Tree.Merge] or
+// [Tree.Clone], have the same mode.
+func (tree *Tree) IsFixedMode() bool {
+	return tree.index
 }
 
-// descendObject selects the paths from seg from each value from src into
-// dst.
-func (tree *Tree) descendObject(seg *segment, root any, cur, dst map[string]any) {
-	for k, v := range cur {
-		switch v := v.(type) {
-		case map[string]any:
-			if sub := tree.dispatchObject(seg, root, v, dst[k]); sub != nil {
-				dst[k] = sub
-			}
-		case []any:
-			if sub := tree.dispatchArray(seg, root, v, dst[k]); sub != nil {
-				dst[k] = sub
-			}
-		}
-	}
+// TreeStyle defines the strings used to draw the tree diagrams returned by
+// [Tree.StringWith].
+type TreeStyle struct {
+	// Root labels the root of the diagram.
+	Root string
+	// Tee precedes a segment followed by a sibling.
+	Tee string
+	// Elbow precedes the last segment among its siblings.
+	Elbow string
+	// Pipe indents the children of a segment followed by a sibling.
+	Pipe string
+	// Blank indents the children of the last segment among its siblings.
+	Blank string
+	// DescendantPrefix precedes the selectors of descendant segments. If
+	// empty, diagrams use "..", as in JSONPath.
+	DescendantPrefix string
 }
 
-// processKey fetches the value for key from src and, if the value exists,
-// stores it in dst. If the value is a JSON object (map[string]any) or array
-// ([]any), it dispatches selection for that value so that seg's children can
-// select from the value.
-func (tree *Tree) processKey(key string, seg *segment, root any, cur, dst map[string]any) {
-	// Do we have a value?
-	val, ok := cur[key]
-	if !ok {
-		return
+var (
+	// UnicodeTreeStyle draws tree diagrams with Unicode box-drawing
+	// characters. [Tree.String] uses this style.
+	UnicodeTreeStyle = TreeStyle{
+		Root:  "$",
+		Tee:   "├── ",
+		Elbow: "└── ",
+		Pipe:  "│   ",
+		Blank: "    ",
+
+		DescendantPrefix: "..",
 	}
 
-	// Keep the value if it's the end of the path.
-	if len(seg.children) == 0 {
-		dst[key] = val
-		return
-	}
+	// ASCIITreeStyle draws tree diagrams with ASCII characters only, for
+	// environments that cannot render box-drawing characters.
+	ASCIITreeStyle = TreeStyle{
+		Root:  "$",
+		Tee:   "+-- ",
+		Elbow: "`-- ",
+		Pipe:  "|   ",
+		Blank: "    ",
 
-	// Allow the child segments to select from an object or array.
-	switch val := val.(type) {
-	case map[string]any:
-		sub := tree.dispatchObject(seg, root, val, dst[key])
-		if sub != nil {
-			dst[key] = sub
-		}
-	case []any:
-		sub := tree.dispatchArray(seg, root, val, dst[key])
-		if sub != nil {
-			dst[key] = sub
-		}
+		DescendantPrefix: "..",
 	}
-}
+)
 
-// dispatchObject determines whether dst is a map or is nil to decide what to
-// submit to selectObject. If dst is nil it creates a new map, calls
-// selectObject, and returns the result if it contains any values and nil when
-// it does not. Otherwise it converts dst to a map and calls selectObject.
-func (tree *Tree) dispatchObject(seg *segment, root any, cur map[string]any, dst any) map[string]any {
-	var sub map[string]any
+// String returns a string representation of tree, starting from "$" for the
+// root, and including all of its child segments as a tree diagram drawn in
+// [UnicodeTreeStyle].
+func (tree *Tree) String() string {
+	buf := new(strings.Builder)
+	_, _ = tree.WriteTo(buf)
 
-	if dst != nil {
-		var ok bool
-		if sub, ok = dst.(map[string]any); !ok {
-			// This should not happen.
-			panic(fmt.Sprintf("jsontree: expected destination object but got %T", dst))
-		}
+	return buf.String()
+}
 
-		tree.selectObjectSegment(seg, root, cur, sub)
+// WriteTo writes the tree diagram returned by [Tree.String] to w, without
+// first building the entire diagram in memory. Returns the number of bytes
+// written and any error returned by w, after which it stops writing.
+// Implements [io.WriterTo].
+func (tree *Tree) WriteTo(w io.Writer) (int64, error) {
+	dw := &diagramWriter{w: w}
+	tree.writeDiagram(dw, &UnicodeTreeStyle, UnicodeTreeStyle.Root)
 
-		return sub
-	}
+	return dw.n, dw.err
+}
 
-	// Set up the destination object.
-	sub = map[string]any{}
-	tree.selectObjectSegment(seg, root, cur, sub)
+// MarshalText returns the tree diagram returned by [Tree.String], so that
+// loggers such as [log/slog] that use [encoding.TextMarshaler] render
+// tree as its diagram. Never returns an error. Implements
+// [encoding.TextMarshaler]. [Tree.MarshalJSON] takes precedence when
+// encoding tree as JSON.
+func (tree *Tree) MarshalText() ([]byte, error) {
+	return []byte(tree.String()), nil
+}
 
-	// Don't bother to keep the object if there's nothing in it.
-	if len(sub) > 0 {
-		return sub
-	}
+// StringWith returns a string representation of tree as a tree diagram like
+// that returned by [Tree.String], but labels the root and draws the diagram
+// with the strings defined by style.
+func (tree *Tree) StringWith(style TreeStyle) string {
+	return tree.diagram(&style, style.Root)
+}
 
-	return nil
+// StringWithMode returns the same string representation as [Tree.String],
+// but annotates the root with the tree's array handling mode: "$ (fixed)" for
+// a tree created by [NewFixedModeTree] and "$ (ordered)" for a tree created
+// by [New].
+func (tree *Tree) StringWithMode() string {
+	return tree.diagram(&UnicodeTreeStyle, "$ ("+modeName(tree.index)+")")
 }
 
-type nullVal struct{}
+// diagram returns a tree diagram of tree's segments beneath root, drawn with
+// style.
+func (tree *Tree) diagram(style *TreeStyle, root string) string {
+	buf := new(strings.Builder)
+	tree.writeDiagram(&diagramWriter{w: buf}, style, root)
 
-//nolint:gochecknoglobals
-var null = nullVal{}
+	return buf.String()
+}
 
-// insert inserts val into dst at idx. If tree.index is false and val is nil,
-// it inserts null, so that it will not be removed by [compressArray].
-func (tree *Tree) insert(idx int, dst []any, val any) []any {
-	if idx >= len(dst) {
-		dst = dst[:idx+1]
-	}
+// writeDiagram writes a tree diagram of tree's segments beneath root to buf,
+// drawn with style.
+func (tree *Tree) writeDiagram(buf *diagramWriter, style *TreeStyle, root string) {
+	buf.writeString(root)
+	buf.writeByte('\n')
 
-	if !tree.index && val == nil {
-		dst[idx] = null
-	} else {
-		dst[idx] = val
+	lastIndex := len(tree.root.children) - 1
+	for i, c := range tree.root.children {
+		c.writeTo(buf, style, "", i == lastIndex)
 	}
-
-	return dst
 }
 
-// processIndex fetches the value for idx from src and, if the value exists,
-// stores it in dst. Indexes are always preserved: when idx is 2, the value
-// will be stored in index 2 in dst, even if its length was 0.
-//
-// If the value is a JSON object (map[string]any) or array ([]any), it
-// dispatches selection for that value so that seg's children can select from
-// the value. Returns the updated dst.
+// Compact returns a single-line string representation of tree, suitable for
+// log lines. It starts with "$" for the root and follows each segment with
+// its child segments, separating sibling segments with commas and enclosing
+// them in parentheses. A segment with a single child is followed directly by
+// the child, so that a Tree with a single path renders as a JSONPath query:
 //
-// Note: cap(dst) MUST be equal to len(src). Callers should create dst like
-// so:
-//
-//	dst := make([]any, 0, cap(src))
-func (tree *Tree) processIndex(idx int, seg *segment, root any, cur, dst []any) []any {
-	prevLen := len(dst)
-	// Grow the destination to the index, if necessary.
-	if idx >= prevLen {
-		dst = dst[:idx+1]
-	} else {
-		prevLen = -1
-	}
+//	$["profile"](..["last"],..["contacts"]["primary"])
+func (tree *Tree) Compact() string {
+	buf := new(strings.Builder)
+	dw := &diagramWriter{w: buf}
+	dw.writeString("$")
+	tree.root.writeCompact(dw)
 
-	// Keep the value if it's the end of the path.
-	if len(seg.children) == 0 {
-		return tree.insert(idx, dst, cur[idx])
+	return buf.String()
+}
+
+// Queries returns the JSONPath query strings for each branch of tree, from
+// the root to each leaf segment. Compiling the queries into a new Tree
+// produces a Tree equivalent to tree, although the queries will not
+// necessarily be the same as the paths from which tree was compiled, thanks
+// to merging. A root-only Tree returns a single query, "$". Queries renders
+// selectors created by [FilterFunc] and [RegexpName] as ?<func> and
+// ~/pattern/, which [jsonpath.Parse] rejects, rather than as the wildcards
+// rendered by [jsonpath.Path.String], so that such queries never silently
+// select more than tree.
+func (tree *Tree) Queries() []string {
+	paths := tree.Paths()
+	queries := make([]string, len(paths))
+	for i, p := range paths {
+		queries[i] = queryString(p)
 	}
 
-	// Allow the child segments to select from an object or array. Return the
-	// updated dst.
-	switch val := cur[idx].(type) {
-	case map[string]any:
-		if sub := tree.dispatchObject(seg, root, val, dst[idx]); sub != nil {
-			return tree.insert(idx, dst, sub)
-		}
-	case []any:
-		if sub := tree.dispatchArray(seg, root, val, dst[idx]); sub != nil {
-			return tree.insert(idx, dst, sub)
+	return queries
+}
+
+// queryString returns the string representation of path, rendering each
+// selector with its String method.
+func queryString(path *jsonpath.Path) string {
+	buf := new(strings.Builder)
+	buf.WriteByte('$')
+
+	for _, seg := range path.Query().Segments() {
+		if seg.IsDescendant() {
+			buf.WriteString("..")
 		}
-	}
 
-	// Nothing found, restore dst to its original length and return.
-	if prevLen > -1 {
-		dst = dst[:prevLen]
-	}
+		buf.WriteByte('[')
 
-	return dst
-}
+		for i, sel := range seg.Selectors() {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
 
-// dispatchArray determines whether dst is a slice or is nil to decide what to
-// submit to selectArray. If dst is nil it creates a new slice and passes it
-// to selectArray. Otherwise it converts dst to a slice and passes it to
-// selectArray.
-func (tree *Tree) dispatchArray(seg *segment, root any, cur []any, dstVal any) []any {
-	var sub []any
-	if dstVal == nil {
-		// Set up the destination slice.
-		sub = make([]any, 0, cap(cur))
-	} else {
-		// Make sure dst is a slice.
-		var ok bool
-		if sub, ok = dstVal.([]any); !ok {
-			// This should not happen.
-			panic(fmt.Sprintf("jsontree: expected destination array but got %T", dstVal))
+			buf.WriteString(sel.String())
 		}
+
+		buf.WriteByte(']')
 	}
 
-	// Select seg from src into sub and return the updated slice.
-	return tree.selectArraySegment(seg, root, cur, sub)
+	return buf.String()
 }
 
-// selectArraySegment uses the selectors in seg to select paths from src into
-// dst and recurses into its children. Returns the updated dst or nil if it's
-// empty.
-func (tree *Tree) selectArraySegment(seg *segment, root any, cur, dst []any) []any {
-	dst = tree.selectArray(seg, root, cur, dst)
-	for _, seg := range seg.children {
-		dst = tree.selectArray(seg, root, cur, dst)
+// Paths returns a [jsonpath.Path] for each branch of tree, from the root to
+// each leaf segment, with each descendant segment expressed with ".."
+// syntax. Compiling the paths with [New] or [NewFixedModeTree] produces a
+// Tree equivalent to tree, although the paths will not necessarily be the
+// same as the paths from which tree was compiled, thanks to merging. A
+// root-only Tree returns a single path, "$". The paths do not share storage
+// with tree. They retain selectors created by [FilterFunc] and [RegexpName],
+// but their String methods render them as wildcards; use [Tree.Queries] for
+// strings.
+func (tree *Tree) Paths() []*jsonpath.Path {
+	if len(tree.root.children) == 0 {
+		return []*jsonpath.Path{jsonpath.New(spec.Query(true))}
 	}
 
-	if len(dst) == 0 {
-		return nil
+	paths := []*jsonpath.Path{}
+	for _, c := range tree.root.children {
+		paths = c.appendPaths(paths, nil)
 	}
 
-	return dst
+	return paths
 }
 
-// selectArray uses the selectors in seg to select paths from src to dst. If
-// seg is a descendant Segment, it recursively selects from seg into all of
-// src's values.
-func (tree *Tree) selectArray(n *segment, root any, cur, dst []any) []any {
-	for _, sel := range n.selectors {
-		switch sel := sel.(type) {
-		case spec.Index:
-			idx := int(sel)
-			if idx < len(cur) {
-				dst = tree.processIndex(idx, n, root, cur, dst)
+// Select selects tree's paths from the from JSON value into a new value. A
+// root-only JSONTree that contains no children simply returns from. All other
+// JSONTree queries will select from the from value if it's an array ([]any)
+// or object (map[string]any), and return nil for any other values. Returns
+// an empty array or object if no paths select any values from from.
+//
+// Select also selects from [json.RawMessage] values, wherever they appear,
+// as well as from map[string]json.RawMessage and []json.RawMessage values,
+// which it treats as objects and arrays. It decodes one level of a raw
+// message only to select from its members or items, and fully only to
+// evaluate a filter selector against it, and otherwise selects it undecoded,
+// so that it need not decode the parts of a large document its paths do not
+// select from. Filter queries against the root value ($) see the root's
+// members or items undecoded, however, so decode values fully before
+// selecting if filters use root queries. Filter comparisons treat
+// [json.Number] values as numbers, so Select supports values decoded by a
+// [json.Decoder] configured by [json.Decoder.UseNumber].
+//
+// Select allocates every object and array that contains selected values,
+// but returns the values selected at the end of a path, and the from value
+// itself for a root-only Tree, as they appear in from, so that modifying
+// them modifies from. Configure tree by [WithCopyLeaves] to return copies
+// instead.
+//
+// Select never returns an error; it panics only if selection violates an
+// internal invariant, for which [Tree.SelectE] instead returns
+// [ErrInternal]. Panics raised by filter function extensions propagate
+// unless tree was configured by [WithRecoverFilters].
+func (tree *Tree) Select(from any) any {
+	if len(tree.root.children) > 0 {
+		// Select from and evaluate root queries against the members or items
+		// of raw JSON values.
+		from = decodeRaw(from)
+	}
+
+	return tree.SelectFromRoot(from, from)
+}
+
+// SelectFromRoot selects tree's paths from the from JSON value like
+// [Tree.Select], but evaluates the root queries ($) of filter selectors
+// against root instead of from. Useful for selecting from a value nested in
+// a larger document, such as its payload, while filters continue to refer
+// to the whole document.
+func (tree *Tree) SelectFromRoot(from, root any) any {
+	if len(tree.root.children) == 0 {
+		return tree.leafValue(from)
+	}
+
+	segs := tree.root.children
+
+	switch entity := from.(type) {
+	case map[string]any:
+		return tree.selectObject(segs, root, entity, map[string]any{}, 0)
+	case []any:
+		return tree.selectArray(segs, root, entity, []any{}, 0)
+	case map[string]json.RawMessage, []json.RawMessage, json.RawMessage:
+		switch val := decodeRaw(entity).(type) {
+		case map[string]any, []any:
+			return tree.SelectFromRoot(val, root)
+		}
+
+		return nil
+	default:
+		// Cannot select from any other type. Following RFC 9535, return nil.
+		return nil
+	}
+}
+
+// SelectE selects tree's paths from the from JSON value into a new value
+// like [Tree.Select], but returns an error for selections that Select
+// silently tolerates. Returns:
+//
+//   - [ErrUnsupported] if tree is not root-only and from is neither an
+//     array ([]any) nor an object (map[string]any)
+//   - [ErrSliceBounds] if tree was configured by [WithStrictSliceBounds] and
+//     selects a slice with explicit bounds outside an array
+//   - [ErrMaxDepth] if tree was configured by [WithMaxDepth] and stops
+//     selecting from a value nested deeper than the maximum depth
+//   - [ErrInternal] if selection violates an internal invariant
+func (tree *Tree) SelectE(from any) (any, error) {
+	if len(tree.root.children) > 0 {
+		switch from.(type) {
+		case map[string]any, []any, map[string]json.RawMessage, []json.RawMessage:
+		case json.RawMessage:
+			if _, raw := decodeRaw(from).(json.RawMessage); raw {
+				return nil, fmt.Errorf("%w of type %T", ErrUnsupported, from)
 			}
-		case spec.WildcardSelector:
-			for i := range cur {
-				dst = tree.processIndex(i, n, root, cur, dst)
+		default:
+			return nil, fmt.Errorf("%w of type %T", ErrUnsupported, from)
+		}
+	}
+
+	var err error
+
+	sel := *tree
+	sel.errp = &err
+
+	ret := sel.Select(from)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// SelectContext selects tree's paths from the from JSON value into a new
+// value like [Tree.SelectE], but stops selecting and returns ctx.Err() once
+// ctx is done. It checks ctx before selecting and then periodically as it
+// visits values, so that cancellation takes effect promptly even while
+// selecting from large values. Otherwise returns the same value and errors
+// as SelectE.
+func (tree *Tree) SelectContext(ctx context.Context, from any) (any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sel := *tree
+	sel.cancel = &canceler{ctx: ctx}
+
+	ret, err := sel.SelectE(from)
+	if sel.cancel.err != nil {
+		return nil, sel.cancel.err
+	}
+
+	return ret, err
+}
+
+// cancelInterval is the number of values [Tree.SelectContext] visits between
+// checks of its context.
+const cancelInterval = 256
+
+// canceler checks a context for cancellation every cancelInterval values
+// visited while selecting.
+type canceler struct {
+	ctx context.Context //nolint:containedctx
+
+	// visits counts the values visited since selection started.
+	visits int
+
+	// err records the context's error once it is done.
+	err error
+}
+
+// done returns true once c's context is done, checking it every
+// cancelInterval calls.
+func (c *canceler) done() bool {
+	if c.err == nil {
+		c.visits++
+		if c.visits%cancelInterval == 0 {
+			c.err = c.ctx.Err()
+		}
+	}
+
+	return c.err != nil
+}
+
+// SelectOK selects tree's paths from the from JSON value into a new value
+// like [Tree.Select], and also returns true if any of the paths selected a
+// value. Use it to distinguish a selection that matched nothing from one
+// that selected only empty objects or arrays, for which Select returns the
+// same empty value. A root-only Tree returns from and true.
+func (tree *Tree) SelectOK(from any) (any, bool) {
+	var matched bool
+
+	sel := *tree
+	sel.matched = &matched
+
+	return sel.Select(from), matched
+}
+
+// invariant records an [ErrInternal] error describing a violated internal
+// invariant if tree records errors, and panics otherwise.
+func (tree *Tree) invariant(format string, args ...any) {
+	if tree.errp == nil {
+		panic(fmt.Sprintf("jsontree: "+format, args...))
+	}
+
+	if *tree.errp == nil {
+		*tree.errp = fmt.Errorf("%w: "+format, append([]any{ErrInternal}, args...)...)
+	}
+}
+
+// SelectKeys selects tree's paths from the from JSON value into a new value
+// like [Tree.Select], but replaces each value selected at the end of a path
+// with true. The result describes the shape of the selection without
+// including the selected values, useful when values are large or sensitive.
+// A root-only Tree returns true.
+func (tree *Tree) SelectKeys(from any) any {
+	keys := *tree
+	keys.leaf = func(any) any { return true }
+
+	return keys.Select(from)
+}
+
+// SelectMap selects tree's paths from the from JSON value into a new value
+// like [Tree.Select], but replaces each value selected at the end of a path
+// with the value transform returns for it, in a single pass, to normalize
+// or redact selected values. transform receives only those terminal values,
+// including any object or array selected in its entirety, and never the
+// objects and arrays that selection descends into to reach them, nor the
+// values that fill unselected array positions in fixed mode. It receives
+// copies of the selected values if tree was configured by
+// [WithCopyLeaves], so that it may modify them. A root-only Tree returns
+// transform(from).
+func (tree *Tree) SelectMap(from any, transform func(value any) any) any {
+	sel := *tree
+	sel.leaf = func(val any) any { return transform(tree.ownValue(val)) }
+
+	return sel.Select(from)
+}
+
+// SelectByPath selects each of the paths from which tree was compiled from
+// the from JSON value independently, as if by a Tree compiled from that
+// path alone with tree's options and array handling mode, and returns the
+// selections in a map keyed by the normalized string representation of
+// each path. Useful for keeping track of which path selected which values
+// when a single Tree merges many paths, whose values [Tree.Select] blends
+// together. Paths with the same string representation, such as those that
+// differ only in their [FilterFunc] selectors, share a single entry that
+// holds the values selected by all of them. Compiles each path on every
+// call, so prefer Select when the originating paths do not matter.
+//
+// Trees record the paths passed to their constructors and to
+// [Tree.AddPath], as well as those of both Trees passed to [Tree.Merge] and
+// the intersections of paths computed by [Intersect]. Trees whose
+// original paths are unknown, such as those decoded from JSON or returned by
+// [Tree.ResolveForLength], select each of the paths returned by
+// [Tree.Paths] instead.
+func (tree *Tree) SelectByPath(from any) map[string]any {
+	paths := make(map[string][]*jsonpath.Path)
+	for _, p := range tree.sourcePaths() {
+		key := queryString(p)
+		paths[key] = append(paths[key], p)
+	}
+
+	res := make(map[string]any, len(paths))
+
+	for key, group := range paths {
+		sel := *tree
+		sel.root = sel.compile(group)
+		res[key] = sel.Select(from)
+	}
+
+	return res
+}
+
+// sourcePaths returns the paths from which tree was compiled, or the paths
+// for each of its branches if they are unknown.
+func (tree *Tree) sourcePaths() []*jsonpath.Path {
+	if len(tree.sources) == 0 {
+		return tree.Paths()
+	}
+
+	return tree.sources
+}
+
+// Count returns the number of values tree's paths select from the from JSON
+// value: the number of values at the end of a path that [Tree.Select] would
+// include in its result, counting a value selected by several paths once.
+// Count never copies the selected values, so use it to check the size of a
+// selection before materializing it. A root-only Tree returns 1, and other
+// Trees return 0 when from is neither an array nor an object.
+func (tree *Tree) Count(from any) int {
+	var n int
+
+	count := *tree
+	count.leaf = func(any) any {
+		n++
+		return true
+	}
+
+	count.Select(from)
+
+	return n
+}
+
+// SelectN selects tree's paths from the from JSON value like [Tree.Select],
+// but stops selecting once it has selected n values at the end of a path
+// across the whole tree, so that it can preview the first matches of large
+// inputs. Since Trees treat a trailing wildcard as selecting its parent,
+// SelectN counts the value selected by a path such as $.a[*] as a single
+// value; use a slice such as $.a[0:] to count its items instead. It selects
+// array items in order, so fixed mode Trees preserve the indexes of the
+// items selected before the cutoff, while ordered mode Trees return the
+// first n. It selects object members in random map iteration
+// order, however, so which members it selects from an object before the
+// cutoff is nondeterministic unless tree was configured by
+// [WithSortedKeys]. SelectN selects no values when n is less than 1,
+// returning an empty object or array, or nil for a root-only Tree.
+func (tree *Tree) SelectN(from any, n int) any {
+	sel := *tree
+	sel.limit = &n
+
+	if len(tree.root.children) == 0 && !sel.take() {
+		return nil
+	}
+
+	return sel.Select(from)
+}
+
+// take reports whether selection may select another value at the end of a
+// path, and if so, counts it against tree.limit.
+func (tree *Tree) take() bool {
+	if tree.limit == nil {
+		return true
+	}
+
+	if *tree.limit < 1 {
+		return false
+	}
+
+	*tree.limit--
+
+	return true
+}
+
+// exhausted reports whether selection has selected as many values as
+// tree.limit allows, or has been cancelled, so that it can stop visiting
+// values.
+func (tree *Tree) exhausted() bool {
+	return (tree.limit != nil && *tree.limit < 1) || (tree.cancel != nil && tree.cancel.done())
+}
+
+// leafValue returns val, selected at the end of a path, or its replacement
+// if tree.leaf is set.
+func (tree *Tree) leafValue(val any) any {
+	if tree.matched != nil {
+		*tree.matched = true
+	}
+
+	if tree.leaf == nil {
+		return tree.ownValue(val)
+	}
+
+	return tree.leaf(val)
+}
+
+// ownValue returns a deep copy of val if tree was configured by
+// [WithCopyLeaves], and otherwise val itself.
+func (tree *Tree) ownValue(val any) any {
+	if !tree.copyLeaves {
+		return val
+	}
+
+	return deepCopy(val)
+}
+
+// deepCopy returns a copy of val that shares no objects, arrays, or raw JSON
+// values with it.
+func deepCopy(val any) any {
+	switch val := val.(type) {
+	case map[string]any:
+		if val == nil {
+			return val
+		}
+
+		obj := make(map[string]any, len(val))
+		for k, v := range val {
+			obj[k] = deepCopy(v)
+		}
+
+		return obj
+	case []any:
+		if val == nil {
+			return val
+		}
+
+		ary := make([]any, len(val))
+		for i, v := range val {
+			ary[i] = deepCopy(v)
+		}
+
+		return ary
+	case json.RawMessage:
+		return json.RawMessage(bytes.Clone(val))
+	case map[string]json.RawMessage:
+		if val == nil {
+			return val
+		}
+
+		obj := make(map[string]json.RawMessage, len(val))
+		for k, v := range val {
+			obj[k] = bytes.Clone(v)
+		}
+
+		return obj
+	case []json.RawMessage:
+		if val == nil {
+			return val
+		}
+
+		ary := make([]json.RawMessage, len(val))
+		for i, v := range val {
+			ary[i] = bytes.Clone(v)
+		}
+
+		return ary
+	default:
+		return val
+	}
+}
+
+// selection records how a single object member or array item is selected:
+// in its entirety if leaf is true, because it's at the end of a path, and
+// otherwise by the segments in segs, whose selectors select from its value
+// if it's a container (an object or array). depth is the number of levels
+// the value is nested below the root of the input.
+type selection struct {
+	segs      []*segment
+	leaf      bool
+	container bool
+	depth     int
+}
+
+// mark records the selection of a value by seg: in its entirety if seg is at
+// the end of a path, and otherwise by seg's children, to select from the
+// value.
+func (s *selection) mark(seg *segment) {
+	if len(seg.children) == 0 {
+		s.leaf = true
+		return
+	}
+
+	for _, c := range seg.children {
+		s.apply(c)
+	}
+}
+
+// descend records that seg, a descendant segment, also selects from the
+// value that seg's selectors select from.
+func (s *selection) descend(seg *segment) {
+	if seg.descendant {
+		s.apply(seg)
+	}
+}
+
+// apply records that seg's selectors select from a container value unless
+// they already do.
+func (s *selection) apply(seg *segment) {
+	if !s.container || slices.Contains(s.segs, seg) {
+		return
+	}
+
+	if s.segs == nil {
+		// Allocate once for the typical number of segments.
+		s.segs = make([]*segment, 0, 4) //nolint:mnd
+	}
+
+	s.segs = append(s.segs, seg)
+}
+
+// reset prepares s to record the selection of val, retaining the storage
+// for its segments.
+func (s *selection) reset(val any) {
+	s.segs = s.segs[:0]
+	s.leaf = false
+
+	switch val := val.(type) {
+	case map[string]any, []any, map[string]json.RawMessage, []json.RawMessage:
+		s.container = true
+	case json.RawMessage:
+		s.container = isRawContainer(val)
+	default:
+		s.container = false
+	}
+}
+
+// selectValue selects val, selected by s, into a new value: val itself (or
+// its replacement) if s.leaf is true, and otherwise the object or array
+// selected from val by the segments in s. Returns false if s selects
+// nothing from val.
+func (tree *Tree) selectValue(s *selection, root, val any) (any, bool) {
+	if s.leaf {
+		if !tree.take() {
+			return nil, false
+		}
+
+		return tree.leafValue(val), true
+	}
+
+	if len(s.segs) == 0 || tree.tooDeep(s.depth) {
+		return nil, false
+	}
+
+	// Copy the segments, since the caller reuses s.
+	var buf [4]*segment
+	segs := append(buf[:0], s.segs...)
+
+	switch val := decodeRaw(val).(type) {
+	case map[string]any:
+		if len(val) == 0 {
+			// Nothing to select.
+			return nil, false
+		}
+
+		if obj := tree.selectObject(segs, root, val, nil, s.depth); len(obj) > 0 {
+			return obj, true
+		}
+	case []any:
+		if ary := tree.selectArray(segs, root, val, nil, s.depth); len(ary) > 0 {
+			return ary, true
+		}
+	}
+
+	return nil, false
+}
+
+// selectObject selects from cur, nested depth levels below root, by the
+// selectors of each segment in segs into dst and returns dst. If dst is nil,
+// it allocates dst only once it selects a member, so that it allocates
+// nothing for objects from which it selects nothing. It determines how all
+// of the segments select each member of cur before selecting it, so that it
+// selects from each member just once.
+func (tree *Tree) selectObject(segs []*segment, root any, cur, dst map[string]any, depth int) map[string]any {
+	s := selection{depth: depth + 1}
+
+	if tree.nullNames {
+		for _, key := range tree.missingNames(segs, cur) {
+			if dst == nil {
+				dst = map[string]any{}
 			}
-		case spec.SliceSelector:
-			dst = tree.processSlice(n, sel, root, cur, dst)
-		case *spec.FilterSelector:
-			for i, v := range cur {
-				if sel.Eval(v, root) {
-					dst = tree.processIndex(i, n, root, cur, dst)
-				}
+
+			dst[key] = nil
+		}
+	}
+
+	if !tree.selectsAllMembers(segs) {
+		// Select only the named members.
+		for _, seg := range segs {
+			dst = tree.selectNamed(seg, segs, root, cur, dst, &s)
+		}
+
+		return dst
+	}
+
+	// Iterate directly rather than with tree.entries, since assigning dst in
+	// a range-over-func loop body would move it to the heap.
+	if tree.sorted {
+		for _, k := range slices.Sorted(maps.Keys(cur)) {
+			if tree.exhausted() {
+				break
 			}
+
+			dst = tree.selectMember(segs, root, k, cur[k], dst, &s)
 		}
+
+		return dst
 	}
 
-	if n.descendant {
-		dst = tree.descendArray(n, root, cur, dst)
+	for k, v := range cur {
+		if tree.exhausted() {
+			break
+		}
+
+		dst = tree.selectMember(segs, root, k, v, dst, &s)
 	}
 
 	return dst
 }
 
-// processSlice iterates over the list of array indexes from sel and
-// dispatches them to [processIndex].
-//
-// Note: cap(dst) MUST be equal to len(src). Callers should create dst like
-// so:
-//
-//	dst := make([]any, 0, cap(src))
-func (tree *Tree) processSlice(seg *segment, sel spec.SliceSelector, root any, cur, dst []any) []any {
-	// When step == 0, no elements are selected.
-	switch {
-	case sel.Step() > 0:
-		lower, upper := sel.Bounds(len(cur))
-		for i := lower; i < upper; i += sel.Step() {
-			dst = tree.processIndex(i, seg, root, cur, dst)
+// selectMember selects val, the value of the member of an object named key,
+// into dst, marking in s how each segment in segs selects it. Returns dst,
+// which it allocates if nil once it selects val.
+func (tree *Tree) selectMember(
+	segs []*segment, root any, key string, val any, dst map[string]any, s *selection,
+) map[string]any {
+	s.reset(val)
+	tree.markMember(segs, root, key, val, s)
+
+	if sel, ok := tree.selectValue(s, root, val); ok {
+		if dst == nil {
+			dst = map[string]any{}
 		}
-	case sel.Step() < 0:
-		lower, upper := sel.Bounds(len(cur))
-		for i := upper; lower < i; i += sel.Step() {
-			dst = tree.processIndex(i, seg, root, cur, dst)
+
+		dst[key] = sel
+	}
+
+	return dst
+}
+
+// selectsAllMembers returns true if any segment in segs may select any
+// member of an object: a descendant segment or one with a wildcard or filter
+// selector.
+func (tree *Tree) selectsAllMembers(segs []*segment) bool {
+	all := false
+
+	check := func(seg *segment) {
+		all = all || seg.descendant
+
+		for _, sel := range seg.selectors {
+			switch sel.(type) {
+			case spec.WildcardSelector, *spec.FilterSelector, *funcSelector, *regexpSelector:
+				all = true
+			case spec.Name:
+				// Case-insensitive names may match any member.
+				all = all || tree.fold
+			case spec.Index, spec.SliceSelector:
+			default:
+				tree.invariant("unexpected selector %T", sel)
+			}
+		}
+	}
+
+	for _, seg := range segs {
+		check(seg)
+	}
+
+	return all
+}
+
+// missingNames returns the names selected by the [spec.Name] selectors of
+// the child segments in segs that match no member of cur, in sorted order,
+// for Trees configured by [WithNullMissingNames].
+func (tree *Tree) missingNames(segs []*segment, cur map[string]any) []string {
+	var names []string
+
+	for _, seg := range segs {
+		if seg.descendant {
+			continue
+		}
+
+		for _, sel := range seg.selectors {
+			if name, ok := sel.(spec.Name); ok && !tree.hasMember(cur, name) {
+				names = append(names, string(name))
+			}
+		}
+	}
+
+	slices.Sort(names)
+
+	return slices.Compact(names)
+}
+
+// hasMember returns true if a member of cur matches name.
+func (tree *Tree) hasMember(cur map[string]any, name spec.Name) bool {
+	if _, ok := cur[string(name)]; ok || !tree.fold {
+		return ok
+	}
+
+	for k := range cur {
+		if tree.matchesName(name, k) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectNamed selects each member of cur named by seg's selectors into dst,
+// marking in s how all of segs select it, and returns dst, which it
+// allocates if nil once it selects a member. Skips members already selected
+// into dst.
+func (tree *Tree) selectNamed(
+	seg *segment, segs []*segment, root any, cur, dst map[string]any, s *selection,
+) map[string]any {
+	for _, sel := range seg.selectors {
+		name, ok := sel.(spec.Name)
+		if !ok || tree.exhausted() {
+			continue
+		}
+
+		key := string(name)
+		if _, done := dst[key]; done {
+			continue
+		}
+
+		if v, ok := cur[key]; ok {
+			dst = tree.selectMember(segs, root, key, v, dst, s)
 		}
 	}
 
 	return dst
 }
 
-// descendArray selects the paths from seg from each value from src into
-// dst.
-func (tree *Tree) descendArray(seg *segment, root any, cur, dst []any) []any {
-	dstLen := len(dst)
+// markMember marks in s how each segment in segs selects val, the value of
+// the member of an object named key.
+func (tree *Tree) markMember(segs []*segment, root any, key string, val any, s *selection) {
+	for _, seg := range segs {
+		if seg.names != nil && !tree.fold {
+			_, matched := seg.names[key]
+			tree.markMatched(seg, matched, s)
 
-	for i, v := range cur {
-		// Grab the destination array if it exists.
-		var subDest any
-		if i < dstLen {
-			subDest = dst[i]
+			continue
 		}
 
-		switch v := v.(type) {
-		case map[string]any:
-			if sub := tree.dispatchObject(seg, root, v, subDest); sub != nil {
-				// We have data, insert it into the destination array.
-				dst = tree.insert(i, dst, sub)
+		matched := false
+
+		for _, sel := range seg.selectors {
+			switch sel := sel.(type) {
+			case spec.Name:
+				if tree.matchesName(sel, key) {
+					matched = true
+				}
+			case spec.WildcardSelector:
+				if _, skip := tree.exclude[key]; !skip {
+					matched = true
+				}
+			case *spec.FilterSelector, *funcSelector:
+				if tree.eval(sel, val, root) {
+					matched = true
+				}
+			case *regexpSelector:
+				if sel.re.MatchString(key) {
+					matched = true
+				}
 			}
-		case []any:
-			if sub := tree.dispatchArray(seg, root, v, subDest); sub != nil {
-				// We have data, insert it into the destination array.
-				dst = tree.insert(i, dst, sub)
+		}
+
+		tree.markMatched(seg, matched, s)
+	}
+}
+
+// markMatched marks seg in s and reports the match to tree's observer if
+// seg's selectors matched a value, and records that a descendant seg also
+// selects from the value.
+func (tree *Tree) markMatched(seg *segment, matched bool, s *selection) {
+	if matched {
+		s.mark(seg)
+
+		if tree.observer != nil {
+			tree.observer(&Segment{seg})
+		}
+	}
+
+	if tree.descends(s.depth) {
+		s.descend(seg)
+	}
+}
+
+// matchesName returns true if key matches name, ignoring case if tree was
+// configured by [WithCaseInsensitiveNames].
+func (tree *Tree) matchesName(name spec.Name, key string) bool {
+	if tree.fold {
+		return strings.EqualFold(string(name), key)
+	}
+
+	return string(name) == key
+}
+
+// eval evaluates sel, a [*spec.FilterSelector] or a selector created by
+// [FilterFunc], against val and root. If tree was configured by
+// [WithRecoverFilters], it recovers from a panic in sel and returns false.
+func (tree *Tree) eval(sel spec.Selector, val, root any) bool {
+	// Evaluate filter selectors directly in the common case, so that
+	// selection from large arrays avoids the overhead of evalFilter.
+	if filter, ok := sel.(*spec.FilterSelector); ok && !tree.recover {
+		if _, raw := val.(json.RawMessage); !raw {
+			return filter.Eval(val, root)
+		}
+	}
+
+	return tree.evalFilter(sel, val, root)
+}
+
+// evalFilter evaluates sel like eval, decoding val if it's a
+// [json.RawMessage] and recovering from panics if tree was configured by
+// [WithRecoverFilters].
+func (tree *Tree) evalFilter(sel spec.Selector, val, root any) (ok bool) {
+	if tree.recover {
+		defer func() {
+			if recover() != nil {
+				ok = false
 			}
+		}()
+	}
+
+	switch sel := sel.(type) {
+	case *spec.FilterSelector:
+		return sel.Eval(unmarshalRaw(val), root)
+	case *funcSelector:
+		return sel.fn(unmarshalRaw(val))
+	default:
+		tree.invariant("unexpected filter selector %T", sel)
+		return false
+	}
+}
+
+// selectArray selects from cur, nested depth levels below root, by the
+// selectors of each segment in segs, appends the selected items to dst, and
+// returns the updated slice. It determines how all of the segments select
+// each item of cur before selecting it, and selects items in order, so that
+// it builds the selected array in a single pass. Ordered mode Trees append
+// only the selected items, while fixed mode Trees preserve their indexes by
+// appending tree.gap for each unselected item that precedes a selected item.
+// Ordered mode Trees configured by [WithNullGapThreshold] append nil for
+// each unselected item in a run of no more than tree.nullGap that precedes a
+// selected item.
+func (tree *Tree) selectArray(segs []*segment, root any, cur, dst []any, depth int) []any {
+	lower, upper := tree.selectedRange(segs, len(cur))
+	if len(segs) == 1 && !segs[0].descendant && len(segs[0].children) == 0 {
+		if tree.selectsRange(segs[0]) {
+			return tree.appendRange(cur, dst, lower, upper)
+		}
+
+		return tree.selectLeaves(segs, root, cur, dst, lower, upper)
+	}
+
+	s := selection{depth: depth + 1}
+
+	// Skip items no index or slice selects, unless a segment may select any
+	// item.
+	sparse := !selectsAny(segs)
+	start, prev := len(dst), -1
+
+	for i := lower; i < upper && !tree.exhausted(); i++ {
+		if sparse {
+			if i = nextIndex(segs, i, len(cur)); i >= upper {
+				break
+			}
+		}
+
+		s.reset(cur[i])
+		tree.markItem(segs, root, i, cur, &s)
+
+		val, ok := tree.selectValue(&s, root, cur[i])
+		if !ok {
+			continue
 		}
+
+		dst = tree.appendItem(dst, start, prev, i, upper, val)
+		prev = i
 	}
 
-	// Always return the updated slice.
 	return dst
 }
+
+// selectLeaves selects from cur like selectArray for segs, a single
+// segment at the end of a path that is not a descendant segment, so that
+// it selects each item its selectors match in its entirety. It needs no
+// [selection] to record how segs select each item.
+func (tree *Tree) selectLeaves(segs []*segment, root any, cur, dst []any, lower, upper int) []any {
+	seg := segs[0]
+	sparse := !selectsAny(segs)
+	start, prev := len(dst), -1
+
+	// Evaluate a lone filter selector, as in $[?@.x], without matchesItem's
+	// type switch.
+	var filter *spec.FilterSelector
+	if len(seg.selectors) == 1 {
+		filter, _ = seg.selectors[0].(*spec.FilterSelector)
+	}
+
+	for i := lower; i < upper && !tree.exhausted(); i++ {
+		if sparse {
+			if i = nextIndex(segs, i, len(cur)); i >= upper {
+				break
+			}
+		}
+
+		var matched bool
+		if filter != nil {
+			matched = tree.eval(filter, cur[i], root)
+		} else {
+			matched = tree.matchesItem(seg, root, i, cur)
+		}
+
+		if matched && tree.observer != nil {
+			tree.observer(&Segment{seg})
+		}
+
+		if matched && tree.take() {
+			dst = tree.appendItem(dst, start, prev, i, upper, tree.leafValue(cur[i]))
+			prev = i
+		}
+	}
+
+	return dst
+}
+
+// appendItem appends val, selected from index i of an array whose selected
+// items selectArray appends to dst from index start, and returns the updated
+// slice. prev is the index of the previously selected item, or -1, and upper
+// the upper bound of the indexes selectArray may select. In fixed mode, it
+// first appends tree.gap for each unselected item that precedes i, and in
+// ordered mode, it first appends nil for each unselected item since prev if
+// there are no more than tree.nullGap of them.
+func (tree *Tree) appendItem(dst []any, start, prev, i, upper int, val any) []any {
+	if cap(dst) == 0 {
+		// Allocate for the maximum number of selected items once it selects
+		// the first one, rather than growing dst as items are appended.
+		if tree.index || tree.nullGap > 0 {
+			dst = make([]any, 0, upper)
+		} else {
+			dst = make([]any, 0, upper-i)
+		}
+	}
+
+	if tree.index {
+		for len(dst)-start < i {
+			dst = append(dst, tree.gap)
+		}
+	} else if gap := i - prev - 1; gap <= tree.nullGap {
+		for range gap {
+			dst = append(dst, nil)
+		}
+	}
+
+	return append(dst, val)
+}
+
+// selectsRange returns true if seg, a segment at the end of a path, has a
+// single wildcard selector or slice selector with a step of 1, so that it
+// selects every item between the bounds returned by selectedRange in its
+// entirety, and tree needs to neither replace, count, nor observe the
+// selected items.
+func (tree *Tree) selectsRange(seg *segment) bool {
+	if len(seg.selectors) != 1 || tree.leaf != nil || tree.copyLeaves || tree.observer != nil ||
+		tree.limit != nil || tree.cancel != nil {
+		return false
+	}
+
+	switch sel := seg.selectors[0].(type) {
+	case spec.WildcardSelector:
+		return true
+	case spec.SliceSelector:
+		return sel.Step() == 1
+	default:
+		return false
+	}
+}
+
+// appendRange appends cur[lower:upper], selected in its entirety, to dst
+// and returns the updated slice, preceded by the same gap values that
+// selectArray would append.
+func (tree *Tree) appendRange(cur, dst []any, lower, upper int) []any {
+	if lower >= upper {
+		return dst
+	}
+
+	if tree.matched != nil {
+		*tree.matched = true
+	}
+
+	lead := 0
+	if tree.index || lower <= tree.nullGap {
+		lead = lower
+	}
+
+	dst = slices.Grow(dst, lead+upper-lower)
+	for range lead {
+		if tree.index {
+			dst = append(dst, tree.gap)
+		} else {
+			dst = append(dst, nil)
+		}
+	}
+
+	return append(dst, cur[lower:upper]...)
+}
+
+// selectsAny returns true if a segment in segs may select any item of an
+// array: a descendant segment or one with a wildcard or filter selector.
+func selectsAny(segs []*segment) bool {
+	for _, seg := range segs {
+		if seg.descendant {
+			return true
+		}
+
+		for _, sel := range seg.selectors {
+			switch sel.(type) {
+			case spec.WildcardSelector, *spec.FilterSelector, *funcSelector:
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// nextIndex returns the lowest index not less than i that the index and
+// slice selectors of segs select from an array of length length, or length
+// if they select none.
+func nextIndex(segs []*segment, i, length int) int {
+	next := length
+	for _, seg := range segs {
+		for _, sel := range seg.selectors {
+			switch sel := sel.(type) {
+			case spec.Index:
+				if idx := resolveIndex(sel, length); idx >= i && idx < next {
+					next = idx
+				}
+			case spec.SliceSelector:
+				if idx := nextSliceIndex(sel, i, length); idx < next {
+					next = idx
+				}
+			}
+		}
+	}
+
+	return next
+}
+
+// nextSliceIndex returns the lowest index not less than i that slice
+// selects from an array of length length, or length if it selects none.
+func nextSliceIndex(slice spec.SliceSelector, i, length int) int {
+	lower, upper := slice.Bounds(length)
+
+	switch step := slice.Step(); {
+	case step > 0:
+		// Selects lower, lower+step, ... while less than upper.
+		i = max(i, lower)
+		if idx := lower + (i-lower+step-1)/step*step; idx < upper {
+			return idx
+		}
+	case step < 0:
+		// Selects upper, upper+step, ... while greater than lower.
+		i = max(i, lower+1)
+		if i <= upper {
+			return upper - (upper-i)/-step*-step
+		}
+	}
+
+	return length
+}
+
+// selectedRange returns the lower (inclusive) and upper (exclusive) bounds
+// of the indexes that the segments in segs may select from an array of
+// length length. If tree was configured by
+// [WithStrictSliceBounds], it records an [ErrSliceBounds] error for a slice
+// with explicit bounds outside the array.
+func (tree *Tree) selectedRange(segs []*segment, length int) (int, int) {
+	lower, upper := length, 0
+	include := func(from, to int) {
+		if from < to {
+			lower, upper = min(lower, from), max(upper, to)
+		}
+	}
+
+	check := func(seg *segment) {
+		if seg.descendant {
+			include(0, length)
+		}
+
+		for _, sel := range seg.selectors {
+			switch sel := sel.(type) {
+			case spec.Index:
+				// Check the bounds before adding one, which overflows for
+				// math.MaxInt.
+				if idx := resolveIndex(sel, length); idx >= 0 && idx < length {
+					include(idx, idx+1)
+				}
+			case spec.SliceSelector:
+				tree.checkSlice(sel, length)
+
+				// When step == 0, no elements are selected.
+				switch from, to := sel.Bounds(length); {
+				case sel.Step() > 0:
+					include(from, to)
+				case sel.Step() < 0:
+					// Backward slices select from to down to from+1.
+					include(from+1, to+1)
+				}
+			case spec.WildcardSelector, *spec.FilterSelector, *funcSelector:
+				include(0, length)
+			case spec.Name, *regexpSelector:
+			default:
+				tree.invariant("unexpected selector %T", sel)
+			}
+		}
+	}
+
+	for _, seg := range segs {
+		check(seg)
+	}
+
+	return lower, upper
+}
+
+// descends returns true if a descendant segment may recurse into a value
+// nested depth levels below the root of the input: always, unless tree was
+// configured by [WithMaxDescendDepth] and depth is at or beyond its maximum.
+func (tree *Tree) descends(depth int) bool {
+	return tree.maxDescend <= 0 || depth < tree.maxDescend
+}
+
+// tooDeep returns true if tree was configured by [WithMaxDepth] and a value
+// nested depth levels below the root of the input is at or beyond its
+// maximum depth, so that tree must not select from it. Records an
+// [ErrMaxDepth] error if tree records errors.
+func (tree *Tree) tooDeep(depth int) bool {
+	if tree.maxDepth <= 0 || depth < tree.maxDepth {
+		return false
+	}
+
+	if tree.errp != nil && *tree.errp == nil {
+		*tree.errp = fmt.Errorf("%w: %d", ErrMaxDepth, tree.maxDepth)
+	}
+
+	return true
+}
+
+// checkSlice records an [ErrSliceBounds] error if tree was configured by
+// [WithStrictSliceBounds] and records errors, and sel has explicit bounds
+// outside an array of length length.
+func (tree *Tree) checkSlice(sel spec.SliceSelector, length int) {
+	if tree.strict && tree.errp != nil && *tree.errp == nil && outOfBounds(sel, length) {
+		*tree.errp = fmt.Errorf("%w: [%v] on array of length %d", ErrSliceBounds, sel, length)
+	}
+}
+
+// markItem marks in s how each segment in segs selects the item at idx in
+// cur.
+func (tree *Tree) markItem(segs []*segment, root any, idx int, cur []any, s *selection) {
+	for _, seg := range segs {
+		tree.markMatched(seg, tree.matchesItem(seg, root, idx, cur), s)
+	}
+}
+
+// matchesItem returns true if any of seg's selectors select the item at idx
+// in cur. It evaluates every filter selector, even after another selector
+// matches.
+func (tree *Tree) matchesItem(seg *segment, root any, idx int, cur []any) bool {
+	matched := false
+
+	for _, sel := range seg.selectors {
+		switch sel := sel.(type) {
+		case spec.Index:
+			if resolveIndex(sel, len(cur)) == idx {
+				matched = true
+			}
+		case spec.WildcardSelector:
+			matched = true
+		case spec.SliceSelector:
+			if sliceSelects(sel, idx, len(cur)) {
+				matched = true
+			}
+		case *spec.FilterSelector, *funcSelector:
+			if tree.eval(sel, cur[idx], root) {
+				matched = true
+			}
+		}
+	}
+
+	return matched
+}
+
+// resolveIndex returns the position idx selects from an array of length
+// length, resolving negative indexes from the end of the array. Returns a
+// negative position if idx precedes the start of the array.
+func resolveIndex(idx spec.Index, length int) int {
+	if idx < 0 {
+		return int(idx) + length
+	}
+
+	return int(idx)
+}
+
+// outOfBounds returns true if sel has an explicit start or end outside an
+// array of length. Unlike [spec.SliceSelector.Bounds], it does not clamp
+// bounds to the array.
+func outOfBounds(sel spec.SliceSelector, length int) bool {
+	start, end := sel.Start(), sel.End()
+	if sel.Step() < 0 {
+		// Backward slices start at an index and end before a default of
+		// math.MinInt.
+		return (start != math.MaxInt && (start >= length || start < -length)) ||
+			(end != math.MinInt && (end > length || end < -length-1))
+	}
+
+	// Forward slices start at an index and end before a default of
+	// math.MaxInt.
+	return start > length || start < -length ||
+		(end != math.MaxInt && (end > length || end < -length))
+}
+
+// gapVal marks unselected array positions in fixed mode selections merged
+// by [Tree.SelectInto], until mergeArray or fillGaps replaces them with the
+// gap value.
+type gapVal struct{}
+
+//nolint:gochecknoglobals
+var unselected = gapVal{}
+
+// fillGaps replaces the unselected markers in val and its descendants with
+// tree.gap.
+func (tree *Tree) fillGaps(val any) {
+	switch val := val.(type) {
+	case []any:
+		for i, v := range val {
+			if _, ok := v.(gapVal); ok {
+				val[i] = tree.gap
+			} else {
+				tree.fillGaps(v)
+			}
+		}
+	case map[string]any:
+		for _, v := range val {
+			tree.fillGaps(v)
+		}
+	}
+}
+
+// keepShortGaps replaces with nil each unselected marker in ary, selected in
+// fixed mode on behalf of an ordered mode Tree configured by
+// [WithNullGapThreshold], that belongs to a run of no more than tree.nullGap
+// markers preceding a selected item. Modifies ary.
+func (tree *Tree) keepShortGaps(ary []any) {
+	if tree.index || tree.nullGap < 1 {
+		return
+	}
+
+	run := 0
+	for i, v := range ary {
+		if _, ok := v.(gapVal); ok {
+			run++
+			continue
+		}
+
+		if run <= tree.nullGap {
+			clear(ary[i-run : i])
+		}
+
+		run = 0
+	}
+}
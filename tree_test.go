@@ -1,10 +1,26 @@
 package jsontree
 
 import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/theory/jsonpath"
+	"github.com/theory/jsonpath/registry"
 	"github.com/theory/jsonpath/spec"
 )
 
@@ -305,19 +321,35 @@ func TestObjectSelection(t *testing.T) {
 			obj:  map[string]any{"x": true},
 			exp:  map[string]any{},
 		},
+		{
+			test: "selectors_apply_once",
+			segs: []*segment{child(spec.Name("x")).Append(child(spec.Name("y")))},
+			obj: map[string]any{
+				"x": map[string]any{"x": map[string]any{"y": 1}, "y": 2},
+			},
+			exp: map[string]any{"x": map[string]any{"y": 2}},
+		},
+		{
+			test: "filter_applies_once",
+			segs: []*segment{child(mkFilter("$[?@.y]")).Append(child(spec.Name("y")))},
+			obj: map[string]any{
+				"x": map[string]any{"y": 1, "z": map[string]any{"y": 2}},
+			},
+			exp: map[string]any{"x": map[string]any{"y": 1}},
+		},
 	} {
 		t.Run(tc.test, func(t *testing.T) {
 			t.Parallel()
 			a := assert.New(t)
 
-			tree := Tree{child().Append(tc.segs...), true}
+			tree := Tree{root: child().Append(tc.segs...), index: true}
 			a.Equal(tc.exp, tree.Select(tc.obj))
 			// Test non-indexing tree.
 			if tc.test == "any_key_nonexistent_index" {
 				tc.exp = map[string]any{"x": []any{"go"}}
 			}
 
-			tree = Tree{child().Append(tc.segs...), false}
+			tree = Tree{root: child().Append(tc.segs...), index: false}
 			a.Equal(tc.exp, tree.Select(tc.obj))
 		})
 	}
@@ -326,33 +358,33 @@ func TestObjectSelection(t *testing.T) {
 		test string
 		segs []*segment
 		src  map[string]any
-		dst  map[string]any
 		err  string
 	}{
 		{
-			test: "dest_not_object",
-			segs: []*segment{child(spec.Name("x")).Append(child(spec.Name("y")))},
-			src:  map[string]any{"x": map[string]any{}},
-			dst:  map[string]any{"x": []any{1}},
-			err:  `jsontree: expected destination object but got []interface {}`,
+			test: "unexpected_selector",
+			segs: []*segment{child(spec.Query(true))},
+			src:  map[string]any{"x": 1},
+			err:  `jsontree: unexpected selector *spec.PathQuery`,
 		},
 		{
-			test: "dest_not_array",
-			segs: []*segment{child(spec.Name("x")).Append(child(spec.Index(1)))},
-			src:  map[string]any{"x": []any{}},
-			dst:  map[string]any{"x": map[string]any{"x": 1}},
-			err:  `jsontree: expected destination array but got map[string]interface {}`,
+			test: "nested_unexpected_selector",
+			segs: []*segment{child(spec.Name("x")).Append(child(spec.Query(true)))},
+			src:  map[string]any{"x": map[string]any{"y": 1}},
+			err:  `jsontree: unexpected selector *spec.PathQuery`,
 		},
 	} {
 		t.Run(tc.test, func(t *testing.T) {
 			t.Parallel()
-			// In general a value in dst should only be a map because we sanitize
-			// the segments in advance, but this check ensures it at runtime.
-			tree := &Tree{}
+			// Segments should only contain selectors supported by JSONPath,
+			// but this check ensures it at runtime.
+			tree := &Tree{root: child().Append(tc.segs...)}
 
-			assert.PanicsWithValue(t, tc.err, func() {
-				tree.selectObjectSegment(&segment{children: tc.segs}, nil, tc.src, tc.dst)
-			})
+			assert.PanicsWithValue(t, tc.err, func() { tree.Select(tc.src) })
+
+			// Record an error instead when selecting with SelectE.
+			_, err := tree.SelectE(tc.src)
+			require.EqualError(t, err, strings.Replace(tc.err, ": ", ": internal error: ", 1))
+			require.ErrorIs(t, err, ErrInternal)
 		})
 	}
 }
@@ -426,6 +458,41 @@ func TestArraySelection(t *testing.T) {
 			indexed:  []any{nil, nil, nil, nil},
 			appended: []any{nil, nil},
 		},
+		{
+			test:     "index_neg_one",
+			segs:     []*segment{child(spec.Index(-1))},
+			ary:      []any{"a", "b", "c", "d", "e"},
+			indexed:  []any{nil, nil, nil, nil, "e"},
+			appended: []any{"e"},
+		},
+		{
+			test:     "index_neg_two",
+			segs:     []*segment{child(spec.Index(-2))},
+			ary:      []any{"a", "b", "c", "d", "e"},
+			indexed:  []any{nil, nil, nil, "d"},
+			appended: []any{"d"},
+		},
+		{
+			test:     "neg_and_pos_same_item",
+			segs:     []*segment{child(spec.Index(-1), spec.Index(4), spec.Index(1))},
+			ary:      []any{"a", "b", "c", "d", "e"},
+			indexed:  []any{nil, "b", nil, nil, "e"},
+			appended: []any{"b", "e"},
+		},
+		{
+			test:     "index_neg_out_of_range",
+			segs:     []*segment{child(spec.Index(-6))},
+			ary:      []any{"a", "b", "c", "d", "e"},
+			indexed:  []any{},
+			appended: []any{},
+		},
+		{
+			test:     "nested_neg_index",
+			segs:     []*segment{child(spec.Index(-1)).Append(child(spec.Index(-2)))},
+			ary:      []any{"x", []any{1, 2, 3}},
+			indexed:  []any{nil, []any{nil, 2}},
+			appended: []any{[]any{2}},
+		},
 		{
 			test:    "nested_index",
 			segs:    []*segment{child(spec.Index(0)).Append(child(spec.Index(0)))},
@@ -586,6 +653,20 @@ func TestArraySelection(t *testing.T) {
 			indexed:  []any{[]any{nil, nil, nil, 3}},
 			appended: []any{[]any{3}},
 		},
+		{
+			test:     "descendant_nonexistent_branch_index",
+			segs:     []*segment{descendant(spec.Index(3))},
+			ary:      []any{[]any{0, 1, 2, 3}, []any{0, 1, 2}},
+			indexed:  []any{[]any{nil, nil, nil, 3}},
+			appended: []any{[]any{3}},
+		},
+		{
+			test:     "wildcard_then_descendant_index",
+			segs:     []*segment{child(spec.Wildcard()).Append(descendant(spec.Index(1)))},
+			ary:      []any{[]any{0, []any{5, 6}}, []any{7}, []any{[]any{8, 9}}},
+			indexed:  []any{[]any{nil, []any{5, 6}}, nil, []any{[]any{nil, 9}}},
+			appended: []any{[]any{[]any{5, 6}}, []any{[]any{9}}},
+		},
 		{
 			test:    "not_an_array_index_1",
 			segs:    []*segment{child(spec.Index(1)).Append(child(spec.Index(0)))},
@@ -733,12 +814,19 @@ func TestArraySelection(t *testing.T) {
 			indexed:  []any{map[string]any{"x": []any{nil, 2}}},
 			appended: []any{map[string]any{"x": []any{2}}},
 		},
+		{
+			test:     "selectors_apply_once",
+			segs:     []*segment{child(spec.Index(1)).Append(child(spec.Index(0)))},
+			ary:      []any{"x", []any{[]any{1, 2}, 3}},
+			indexed:  []any{nil, []any{[]any{1, 2}}},
+			appended: []any{[]any{[]any{1, 2}}},
+		},
 	} {
 		t.Run(tc.test, func(t *testing.T) {
 			t.Parallel()
 			a := assert.New(t)
 
-			tree := Tree{child().Append(tc.segs...), true}
+			tree := Tree{root: child().Append(tc.segs...), index: true}
 			a.Equal(tc.indexed, tree.Select(tc.ary))
 			tree.index = false
 
@@ -754,38 +842,60 @@ func TestArraySelection(t *testing.T) {
 		test string
 		segs []*segment
 		src  []any
-		dst  []any
 		err  string
 	}{
 		{
-			test: "dest_not_an_array",
-			segs: []*segment{child(spec.Index(0)).Append(child(spec.Index(1)))},
-			src:  []any{[]any{}},
-			dst:  []any{"x", []any{1}},
-			err:  `jsontree: expected destination array but got string`,
+			test: "unexpected_selector",
+			segs: []*segment{child(spec.Query(true))},
+			src:  []any{1},
+			err:  `jsontree: unexpected selector *spec.PathQuery`,
 		},
 		{
-			test: "dest_not_an_object",
-			segs: []*segment{child(spec.Index(0)).Append(child(spec.Name("x")))},
-			src:  []any{map[string]any{"x": 1}},
-			dst:  []any{[]any{1}},
-			err:  `jsontree: expected destination object but got []interface {}`,
+			test: "nested_unexpected_selector",
+			segs: []*segment{child(spec.Index(0)).Append(child(spec.Query(true)))},
+			src:  []any{[]any{1}},
+			err:  `jsontree: unexpected selector *spec.PathQuery`,
 		},
 	} {
 		t.Run(tc.test, func(t *testing.T) {
 			t.Parallel()
-			// In general a value in dst should only be a slice because we
-			// sanitize the segments in advance, but this check ensures it at
-			// runtime.
-			tree := &Tree{}
+			// Segments should only contain selectors supported by JSONPath,
+			// but this check ensures it at runtime.
+			tree := &Tree{root: child().Append(tc.segs...)}
 
-			assert.PanicsWithValue(t, tc.err, func() {
-				tree.selectArraySegment(&segment{children: tc.segs}, nil, tc.src, tc.dst)
-			})
+			assert.PanicsWithValue(t, tc.err, func() { tree.Select(tc.src) })
+
+			// Record an error instead when selecting with SelectE.
+			_, err := tree.SelectE(tc.src)
+			require.EqualError(t, err, strings.Replace(tc.err, ": ", ": internal error: ", 1))
+			require.ErrorIs(t, err, ErrInternal)
 		})
 	}
 }
 
+func TestSelectWholeArrays(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	// Arrays selected in their entirety must retain their nulls, and
+	// selection must not modify them.
+	mkInput := func() any {
+		return map[string]any{
+			"x": []any{"a", nil, []any{nil, 1}},
+			"y": []any{nil, map[string]any{"z": []any{nil}}},
+		}
+	}
+
+	for _, tree := range []*Tree{
+		New(jsonpath.MustParse("$.x"), jsonpath.MustParse("$.y[*]")),
+		NewFixedModeTree(jsonpath.MustParse("$.x"), jsonpath.MustParse("$.y[*]")),
+	} {
+		input := mkInput()
+		a.Equal(input, tree.Select(input))
+		a.Equal(mkInput(), input)
+	}
+}
+
 func TestSliceSelection(t *testing.T) {
 	t.Parallel()
 
@@ -1134,64 +1244,302 @@ func TestSliceSelection(t *testing.T) {
 		t.Run(tc.test, func(t *testing.T) {
 			t.Parallel()
 
-			tree := Tree{child().Append(tc.segs...), true}
+			tree := Tree{root: child().Append(tc.segs...), index: true}
 			assert.Equal(t, tc.exp, tree.Select(tc.ary))
 		})
 	}
 }
 
+func TestSliceBeyondArray(t *testing.T) {
+	t.Parallel()
+
+	ary := []any{"x", []any{1, 2}, true}
+
+	for _, tc := range []struct {
+		test  string
+		segs  []*segment
+		exp   []any
+		fixed []any
+	}{
+		{
+			test:  "upper_beyond_length",
+			segs:  []*segment{child(spec.Slice(0, 100))},
+			exp:   []any{"x", []any{1, 2}, true},
+			fixed: []any{"x", []any{1, 2}, true},
+		},
+		{
+			test:  "both_beyond_length",
+			segs:  []*segment{child(spec.Slice(50, 100))},
+			exp:   []any{},
+			fixed: []any{},
+		},
+		{
+			test:  "negative_beyond_length",
+			segs:  []*segment{child(spec.Slice(-100, 100, 2))},
+			exp:   []any{"x", true},
+			fixed: []any{"x", nil, true},
+		},
+		{
+			test:  "backward_beyond_length",
+			segs:  []*segment{child(spec.Slice(100, -100, -1))},
+			exp:   []any{"x", []any{1, 2}, true},
+			fixed: []any{"x", []any{1, 2}, true},
+		},
+		{
+			test:  "nested",
+			segs:  []*segment{child(spec.Slice(1, 100)).Append(child(spec.Slice(0, 100)))},
+			exp:   []any{[]any{1, 2}},
+			fixed: []any{nil, []any{1, 2}},
+		},
+		{
+			test:  "index_and_slice",
+			segs:  []*segment{child(spec.Index(5), spec.Slice(2, 100))},
+			exp:   []any{true},
+			fixed: []any{nil, nil, true},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			tree := &Tree{root: child().Append(tc.segs...)}
+			a.Equal(tc.exp, tree.Select(ary))
+
+			exp, err := json.Marshal(tc.exp)
+			require.NoError(t, err)
+
+			var buf strings.Builder
+			a.NoError(tree.SelectStream(ary, &buf))
+			a.JSONEq(string(exp), buf.String())
+
+			a.NotPanics(func() { tree.SelectTo(nil, ary) })
+			a.NotPanics(func() { tree.Delete(ary) })
+
+			tree.index = true
+			a.Equal(tc.fixed, tree.Select(ary))
+			a.NotPanics(func() { tree.Delete(ary) })
+		})
+	}
+}
+
+func TestIndexBeyondArray(t *testing.T) {
+	t.Parallel()
+
+	ary := []any{"x", []any{1, 2}, true}
+
+	for _, tc := range []struct {
+		test  string
+		segs  []*segment
+		exp   []any
+		fixed []any
+	}{
+		{
+			test:  "max_int",
+			segs:  []*segment{child(spec.Index(math.MaxInt))},
+			exp:   []any{},
+			fixed: []any{},
+		},
+		{
+			test:  "min_int",
+			segs:  []*segment{child(spec.Index(math.MinInt))},
+			exp:   []any{},
+			fixed: []any{},
+		},
+		{
+			test:  "max_uint32",
+			segs:  []*segment{child(spec.Index(math.MaxUint32))},
+			exp:   []any{},
+			fixed: []any{},
+		},
+		{
+			test:  "huge_and_valid",
+			segs:  []*segment{child(spec.Index(math.MaxInt), spec.Index(1), spec.Index(math.MinInt))},
+			exp:   []any{[]any{1, 2}},
+			fixed: []any{nil, []any{1, 2}},
+		},
+		{
+			test:  "nested",
+			segs:  []*segment{child(spec.Index(1)).Append(child(spec.Index(math.MaxInt), spec.Index(0)))},
+			exp:   []any{[]any{1}},
+			fixed: []any{nil, []any{1}},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			tree := &Tree{root: child().Append(tc.segs...)}
+			a.Equal(tc.exp, tree.Select(ary))
+
+			exp, err := json.Marshal(tc.exp)
+			require.NoError(t, err)
+
+			var buf strings.Builder
+			a.NoError(tree.SelectStream(ary, &buf))
+			a.JSONEq(string(exp), buf.String())
+
+			a.Len(tree.SelectTo(nil, ary), tree.Count(ary))
+			a.NotPanics(func() { tree.Walk(ary, func(spec.NormalizedPath, any) bool { return true }) })
+			a.NotPanics(func() { tree.Delete(ary) })
+			a.NotPanics(func() { tree.ResolveForLength(len(ary)) })
+
+			tree.index = true
+			a.Equal(tc.fixed, tree.Select(ary))
+			a.NotPanics(func() { tree.Delete(ary) })
+		})
+	}
+}
+
+func TestNegativeIndexMerge(t *testing.T) {
+	t.Parallel()
+
+	ary := []any{"a", "b", "c", "d", "e"}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		exp   string
+		sel   []any
+	}{
+		{
+			test:  "slice_and_neg_one",
+			paths: []string{"$[0:2]", "$[-1]"},
+			exp:   "$\n└── [:2,-1]\n",
+			sel:   []any{"a", "b", "e"},
+		},
+		{
+			test:  "open_slice_and_neg_beyond",
+			paths: []string{"$[1:]", "$[-5]"},
+			exp:   "$\n└── [1:,-5]\n",
+			sel:   []any{"a", "b", "c", "d", "e"},
+		},
+		{
+			test:  "index_and_neg",
+			paths: []string{"$[4]", "$[-1]"},
+			exp:   "$\n└── [-1,4]\n",
+			sel:   []any{"e"},
+		},
+		{
+			test:  "all_and_neg",
+			paths: []string{"$[:]", "$[-2]"},
+			exp:   "$\n└── [:]\n",
+			sel:   ary,
+		},
+		{
+			test:  "neg_open_and_neg",
+			paths: []string{"$[-3:]", "$[-2]"},
+			exp:   "$\n└── [-3:]\n",
+			sel:   []any{"c", "d", "e"},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			tree := MustNewFromStrings(tc.paths...)
+			a.Equal(tc.exp, tree.String())
+			a.Equal(tc.sel, tree.Select(ary))
+
+			// Every path must still select its values from the merged tree.
+			sel := tree.Select(ary).([]any)
+			for _, p := range tc.paths {
+				for _, v := range jsonpath.MustParse(p).Select(ary) {
+					a.Contains(sel, v, p)
+				}
+			}
+		})
+	}
+}
+
 func TestDescendants(t *testing.T) {
 	t.Parallel()
 
-	json := map[string]any{
+	doc := map[string]any{
 		"o": map[string]any{"j": 1, "k": 2},
 		"a": []any{5, 3, []any{map[string]any{"j": 4}, map[string]any{"k": 6}}},
 	}
 
+	profile := map[string]any{
+		"profile": map[string]any{
+			"name": map[string]any{
+				"first": "Barrack",
+				"last":  "Obama",
+			},
+			"contacts": map[string]any{
+				"email": map[string]any{
+					"primary":   "foo@example.com",
+					"secondary": "2nd@example.net",
+				},
+				"phones": map[string]any{
+					"primary":   "123456789",
+					"secondary": "987654321",
+					"fax":       "1029384758",
+				},
+				"addresses": map[string]any{
+					"primary": []any{
+						"123 Main Street",
+						"Whatever", "OR", "98754",
+					},
+					"work": []any{
+						"whatever",
+						"XYZ", "NY", "10093",
+					},
+				},
+			},
+		},
+	}
+
 	for _, tc := range []struct {
-		test  string
-		segs  []*segment
-		input any
-		exp   any
+		test    string
+		segs    []*segment
+		input   any
+		exp     any
+		ordered any
 	}{
 		{
 			test:  "descendant_name",
 			segs:  []*segment{descendant(spec.Name("j"))},
-			input: json,
+			input: doc,
 			exp: map[string]any{
 				"o": map[string]any{"j": 1},
 				"a": []any{nil, nil, []any{map[string]any{"j": 4}}},
 			},
+			ordered: map[string]any{
+				"o": map[string]any{"j": 1},
+				"a": []any{[]any{map[string]any{"j": 4}}},
+			},
 		},
 		{
 			test:  "un_descendant_name",
 			segs:  []*segment{descendant(spec.Name("o"))},
-			input: json,
+			input: doc,
 			exp:   map[string]any{"o": map[string]any{"j": 1, "k": 2}},
 		},
 		{
 			test:  "nested_name",
 			segs:  []*segment{child(spec.Name("o")).Append(descendant(spec.Name("k")))},
-			input: json,
+			input: doc,
 			exp:   map[string]any{"o": map[string]any{"k": 2}},
 		},
 		{
 			test:  "nested_wildcard",
 			segs:  []*segment{child(spec.Name("o")).Append(descendant(spec.Wildcard()))},
-			input: json,
+			input: doc,
 			exp:   map[string]any{"o": map[string]any{"j": 1, "k": 2}},
 		},
 		{
-			test:  "single_index",
-			segs:  []*segment{descendant(spec.Index(0))},
-			input: json,
-			exp:   map[string]any{"a": []any{5, nil, []any{map[string]any{"j": 4}}}},
+			test:    "single_index",
+			segs:    []*segment{descendant(spec.Index(0))},
+			input:   doc,
+			exp:     map[string]any{"a": []any{5, nil, []any{map[string]any{"j": 4}}}},
+			ordered: map[string]any{"a": []any{5, []any{map[string]any{"j": 4}}}},
 		},
 		{
-			test:  "nested_index",
-			segs:  []*segment{child(spec.Name("a")).Append(descendant(spec.Index(0)))},
-			input: json,
-			exp:   map[string]any{"a": []any{5, nil, []any{map[string]any{"j": 4}}}},
+			test:    "nested_index",
+			segs:    []*segment{child(spec.Name("a")).Append(descendant(spec.Index(0)))},
+			input:   doc,
+			exp:     map[string]any{"a": []any{5, nil, []any{map[string]any{"j": 4}}}},
+			ordered: map[string]any{"a": []any{5, []any{map[string]any{"j": 4}}}},
 		},
 		{
 			test: "multiples",
@@ -1204,35 +1552,29 @@ func TestDescendants(t *testing.T) {
 					),
 				),
 			},
-			input: map[string]any{
+			input: profile,
+			exp: map[string]any{
 				"profile": map[string]any{
 					"name": map[string]any{
-						"first": "Barrack",
-						"last":  "Obama",
-					},
-					"contacts": map[string]any{
-						"email": map[string]any{
-							"primary":   "foo@example.com",
-							"secondary": "2nd@example.net",
-						},
-						"phones": map[string]any{
-							"primary":   "123456789",
-							"secondary": "987654321",
-							"fax":       "1029384758",
-						},
-						"addresses": map[string]any{
-							"primary": []any{
-								"123 Main Street",
-								"Whatever", "OR", "98754",
-							},
-							"work": []any{
-								"whatever",
-								"XYZ", "NY", "10093",
-							},
-						},
+						"last": "Obama",
 					},
 				},
 			},
+		},
+		{
+			test: "multiples_wildcard",
+			segs: []*segment{
+				child(spec.Name("profile")).Append(
+					descendant(spec.Name("last")),
+					descendant(spec.Name("contacts")).Append(
+						child(spec.Wildcard()).Append(
+							child(spec.Name("primary")),
+							child(spec.Name("secondary")),
+						),
+					),
+				),
+			},
+			input: profile,
 			exp: map[string]any{
 				"profile": map[string]any{
 					"name": map[string]any{
@@ -1257,6 +1599,17 @@ func TestDescendants(t *testing.T) {
 				},
 			},
 		},
+		{
+			test: "children_apply_to_matches",
+			segs: []*segment{descendant(spec.Name("o")).Append(child(spec.Name("k")))},
+			input: map[string]any{
+				"x": map[string]any{"k": 1, "y": map[string]any{"k": 2}},
+				"y": []any{map[string]any{"o": map[string]any{"k": 3}, "k": 4}},
+			},
+			exp: map[string]any{
+				"y": []any{map[string]any{"o": map[string]any{"k": 3}}},
+			},
+		},
 		{
 			test:  "do_not_include_parent_key",
 			segs:  []*segment{descendant(spec.Name("o")).Append(child(spec.Name("k")))},
@@ -1264,17 +1617,50 @@ func TestDescendants(t *testing.T) {
 			exp:   map[string]any{"o": map[string]any{"k": 2}},
 		},
 		{
-			test:  "do_not_include_parent_index",
-			segs:  []*segment{descendant(spec.Index(0)).Append(child(spec.Index(1)))},
-			input: []any{[]any{42, 98}},
-			exp:   []any{[]any{nil, 98}},
+			test:    "do_not_include_parent_index",
+			segs:    []*segment{descendant(spec.Index(0)).Append(child(spec.Index(1)))},
+			input:   []any{[]any{42, 98}},
+			exp:     []any{[]any{nil, 98}},
+			ordered: []any{[]any{98}},
+		},
+		{
+			test: "nested_arrays",
+			segs: []*segment{descendant(spec.Name("x"))},
+			input: []any{
+				1,
+				[]any{2, map[string]any{"x": 3}, []any{4, map[string]any{"y": 5}}},
+				map[string]any{"x": []any{6}},
+			},
+			exp: []any{
+				nil,
+				[]any{nil, map[string]any{"x": 3}},
+				map[string]any{"x": []any{6}},
+			},
+			ordered: []any{
+				[]any{map[string]any{"x": 3}},
+				map[string]any{"x": []any{6}},
+			},
 		},
 	} {
 		t.Run(tc.test, func(t *testing.T) {
 			t.Parallel()
 
-			tree := Tree{child().Append(tc.segs...), true}
+			tree := Tree{root: child().Append(tc.segs...), index: true}
 			assert.Equal(t, tc.exp, tree.Select(tc.input))
+
+			// Ordered mode omits unselected items at every level.
+			if tc.ordered == nil {
+				tc.ordered = tc.exp
+			}
+			tree.index = false
+			assert.Equal(t, tc.ordered, tree.Select(tc.input))
+
+			// Streaming matches.
+			exp, err := json.Marshal(tc.ordered)
+			require.NoError(t, err)
+			buf := new(strings.Builder)
+			require.NoError(t, tree.SelectStream(tc.input, buf))
+			assert.JSONEq(t, string(exp), buf.String())
 		})
 	}
 }
@@ -1368,78 +1754,208 @@ func TestFilterSelection(t *testing.T) {
 				}
 			}
 
-			tree := Tree{child().Append(segs[0]), true}
+			tree := Tree{root: child().Append(segs[0]), index: true}
 			assert.Equal(t, tc.output, tree.Select(tc.input))
 		})
 	}
 }
 
-func TestTreeString(t *testing.T) {
+func TestFilterFunctions(t *testing.T) {
 	t.Parallel()
 
+	// first() returns the first node passed to it.
+	reg := registry.New()
+	require.NoError(t, reg.Register(
+		"first",
+		spec.FuncValue,
+		func(args []spec.FuncExprArg) error {
+			if len(args) != 1 || !args[0].ConvertsTo(spec.FuncNodes) {
+				return errors.New("first() expects a single nodes argument")
+			}
+			return nil
+		},
+		func(args []spec.PathValue) spec.PathValue {
+			nodes := spec.NodesFrom(args[0])
+			if len(nodes) == 0 {
+				return nil
+			}
+			return spec.Value(nodes[0])
+		},
+	))
+	parser := jsonpath.NewParser(jsonpath.WithRegistry(reg))
+
+	input := map[string]any{
+		"a": map[string]any{"items": []any{1, 2, 3}, "name": "alpha"},
+		"b": map[string]any{"items": []any{4}, "name": "beta"},
+		"c": map[string]any{"items": []any{}, "name": "gamma"},
+	}
+
 	for _, tc := range []struct {
 		test string
-		segs []*segment
-		str  string
+		path *jsonpath.Path
+		exp  any
 	}{
 		{
-			test: "root_only",
-			str:  "$\n",
+			test: "length",
+			path: jsonpath.MustParse("$[?length(@.items) > 1]"),
+			exp:  map[string]any{"a": input["a"]},
 		},
 		{
-			test: "wildcard",
-			segs: []*segment{child(spec.Wildcard())},
-			str:  "$\n└── [*]\n",
+			test: "length_name",
+			path: jsonpath.MustParse("$[?length(@.items) >= 1].name"),
+			exp: map[string]any{
+				"a": map[string]any{"name": "alpha"},
+				"b": map[string]any{"name": "beta"},
+			},
 		},
 		{
-			test: "one_key",
-			segs: []*segment{child(spec.Name("foo"))},
-			str:  "$\n└── [\"foo\"]\n",
+			test: "count",
+			path: jsonpath.MustParse("$[?count(@.items[*]) == 0].name"),
+			exp:  map[string]any{"c": map[string]any{"name": "gamma"}},
 		},
 		{
-			test: "two_keys",
-			segs: []*segment{child(spec.Name("foo"), spec.Name("bar"))},
-			str:  "$\n└── [\"foo\",\"bar\"]\n",
+			test: "match",
+			path: jsonpath.MustParse(`$[?match(@.name, "[ab].*a")].items[0]`),
+			exp: map[string]any{
+				"a": map[string]any{"items": []any{1}},
+				"b": map[string]any{"items": []any{4}},
+			},
 		},
 		{
-			test: "two_segments",
-			segs: []*segment{child(spec.Name("foo")), child(spec.Name("bar"))},
-			str:  "$\n├── [\"foo\"]\n└── [\"bar\"]\n",
+			test: "search",
+			path: jsonpath.MustParse(`$[?search(@.name, "mm")].name`),
+			exp:  map[string]any{"c": map[string]any{"name": "gamma"}},
 		},
 		{
-			test: "two_keys_and_sub_keys",
-			segs: []*segment{
-				child(spec.Name("foo")).Append(
-					child(spec.Name("x")),
-					child(spec.Name("y")),
-					descendant(spec.Name("z")),
-				),
-				child(spec.Name("bar")).Append(
-					child(spec.Name("a"), spec.Index(42), spec.Slice(0, 8, 2)),
-					child(spec.Name("b")),
-					child(spec.Name("c")),
-				),
-			},
-			str: `$
-├── ["foo"]
-│   ├── ["x"]
-│   ├── ["y"]
-│   └── ..["z"]
-└── ["bar"]
-    ├── ["a",42,:8:2]
-    ├── ["b"]
-    └── ["c"]
-`,
+			test: "value",
+			path: jsonpath.MustParse(`$[?value(@.items[0]) == 4].name`),
+			exp:  map[string]any{"b": map[string]any{"name": "beta"}},
 		},
 		{
-			test: "mixed_and_deep",
-			segs: []*segment{
-				child(spec.Name("foo")).Append(
-					child(spec.Name("x")),
-					child(spec.Name("y")).Append(
-						child(spec.Wildcard()).Append(
-							child(spec.Name("a")),
-							child(spec.Name("b")),
+			test: "registered",
+			path: parser.MustParse("$[?first(@.items[*]) < 4].name"),
+			exp:  map[string]any{"a": map[string]any{"name": "alpha"}},
+		},
+		{
+			test: "registered_array",
+			path: parser.MustParse("$.*.items[?first($.b.items[*]) == @]"),
+			exp:  map[string]any{"b": map[string]any{"items": []any{4}}},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			a.Equal(tc.exp, New(tc.path).Select(input))
+			a.Equal(tc.exp, NewFixedModeTree(tc.path).Select(input))
+		})
+	}
+}
+
+func TestFilterJSONNumbers(t *testing.T) {
+	t.Parallel()
+
+	dec := json.NewDecoder(strings.NewReader(`{"n": [1, 42, 43.5, 7, 42e0], "o": [{"a": 100}, {"a": 99}]}`))
+	dec.UseNumber()
+
+	var input any
+	require.NoError(t, dec.Decode(&input))
+
+	for _, tc := range []struct {
+		test string
+		path string
+		exp  any
+	}{
+		{
+			test: "greater_than_equal",
+			path: "$.n[?@ >= 42]",
+			exp:  map[string]any{"n": []any{json.Number("42"), json.Number("43.5"), json.Number("42e0")}},
+		},
+		{
+			test: "less_than",
+			path: "$.n[?@ < 42]",
+			exp:  map[string]any{"n": []any{json.Number("1"), json.Number("7")}},
+		},
+		{
+			test: "equal_member",
+			path: "$.o[?@.a == 100]",
+			exp:  map[string]any{"o": []any{map[string]any{"a": json.Number("100")}}},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			tree := New(jsonpath.MustParse(tc.path))
+			assert.Equal(t, tc.exp, tree.Select(input))
+		})
+	}
+}
+
+func TestTreeString(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test string
+		segs []*segment
+		str  string
+	}{
+		{
+			test: "root_only",
+			str:  "$\n",
+		},
+		{
+			test: "wildcard",
+			segs: []*segment{child(spec.Wildcard())},
+			str:  "$\n└── [*]\n",
+		},
+		{
+			test: "one_key",
+			segs: []*segment{child(spec.Name("foo"))},
+			str:  "$\n└── [\"foo\"]\n",
+		},
+		{
+			test: "two_keys",
+			segs: []*segment{child(spec.Name("foo"), spec.Name("bar"))},
+			str:  "$\n└── [\"bar\",\"foo\"]\n",
+		},
+		{
+			test: "two_segments",
+			segs: []*segment{child(spec.Name("foo")), child(spec.Name("bar"))},
+			str:  "$\n├── [\"foo\"]\n└── [\"bar\"]\n",
+		},
+		{
+			test: "two_keys_and_sub_keys",
+			segs: []*segment{
+				child(spec.Name("foo")).Append(
+					child(spec.Name("x")),
+					child(spec.Name("y")),
+					descendant(spec.Name("z")),
+				),
+				child(spec.Name("bar")).Append(
+					child(spec.Name("a"), spec.Index(42), spec.Slice(0, 8, 2)),
+					child(spec.Name("b")),
+					child(spec.Name("c")),
+				),
+			},
+			str: `$
+├── ["foo"]
+│   ├── ["x"]
+│   ├── ["y"]
+│   └── ..["z"]
+└── ["bar"]
+    ├── [:8:2,"a",42]
+    ├── ["b"]
+    └── ["c"]
+`,
+		},
+		{
+			test: "mixed_and_deep",
+			segs: []*segment{
+				child(spec.Name("foo")).Append(
+					child(spec.Name("x")),
+					child(spec.Name("y")).Append(
+						child(spec.Wildcard()).Append(
+							child(spec.Name("a")),
+							child(spec.Name("b")),
 						),
 					),
 				),
@@ -1571,6 +2087,240 @@ func TestTreeString(t *testing.T) {
 	}
 }
 
+func TestCompact(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test string
+		segs []*segment
+		str  string
+	}{
+		{
+			test: "root_only",
+			str:  "$",
+		},
+		{
+			test: "one_key",
+			segs: []*segment{child(spec.Name("foo"))},
+			str:  `$["foo"]`,
+		},
+		{
+			test: "two_segments",
+			segs: []*segment{child(spec.Name("foo")), child(spec.Name("bar"))},
+			str:  `$(["foo"],["bar"])`,
+		},
+		{
+			test: "single_path",
+			segs: []*segment{child(spec.Name("a")).Append(descendant(spec.Index(1), spec.Wildcard()))},
+			str:  `$["a"]..[*,1]`,
+		},
+		{
+			test: "two_keys_and_sub_keys",
+			segs: []*segment{
+				child(spec.Name("foo")).Append(
+					child(spec.Name("x")),
+					child(spec.Name("y")),
+					descendant(spec.Name("z")),
+				),
+				child(spec.Name("bar")).Append(
+					child(spec.Name("a"), spec.Index(42), spec.Slice(0, 8, 2)),
+				),
+			},
+			str: `$(["foo"](["x"],["y"],..["z"]),["bar"][:8:2,"a",42])`,
+		},
+		{
+			test: "profile",
+			segs: []*segment{
+				child(spec.Name("profile")).Append(
+					descendant(spec.Name("last")),
+					descendant(spec.Name("contacts")).Append(child(spec.Name("primary"))),
+				),
+			},
+			str: `$["profile"](..["last"],..["contacts"]["primary"])`,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+
+			tree := Tree{root: &segment{children: tc.segs}}
+			assert.Equal(t, tc.str, tree.Compact())
+		})
+	}
+}
+
+func TestStringWithMode(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	path := jsonpath.MustParse(`$.a["b","c"]`)
+	tree := New(path)
+	a.Equal("$\n└── [\"a\"]\n    └── [\"b\",\"c\"]\n", tree.String())
+	a.Equal("$ (ordered)\n└── [\"a\"]\n    └── [\"b\",\"c\"]\n", tree.StringWithMode())
+
+	tree = NewFixedModeTree(path)
+	a.Equal("$\n└── [\"a\"]\n    └── [\"b\",\"c\"]\n", tree.String())
+	a.Equal("$ (fixed)\n└── [\"a\"]\n    └── [\"b\",\"c\"]\n", tree.StringWithMode())
+
+	a.Equal("$ (ordered)\n", New().StringWithMode())
+	a.Equal("$ (fixed)\n", NewFixedModeTree().StringWithMode())
+}
+
+func TestIsFixedMode(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+	r := require.New(t)
+
+	path := jsonpath.MustParse("$.a")
+	ordered := New(path)
+	fixed := NewFixedModeTree(path)
+
+	a.False(ordered.IsFixedMode())
+	a.False(New().IsFixedMode())
+	a.False(NewCompiler().New(path).IsFixedMode())
+	a.False(ordered.Merge(New(path)).IsFixedMode())
+	a.False(ordered.Clone().IsFixedMode())
+
+	a.True(fixed.IsFixedMode())
+	a.True(NewFixedModeTree().IsFixedMode())
+	a.True(NewCompiler().NewFixedModeTree(path).IsFixedMode())
+	a.True(fixed.Merge(NewFixedModeTree(path)).IsFixedMode())
+	a.True(fixed.Clone().IsFixedMode())
+
+	// Decoding JSON restores the mode.
+	for _, tree := range []*Tree{ordered, fixed} {
+		data, err := json.Marshal(tree)
+		r.NoError(err)
+		decoded := new(Tree)
+		r.NoError(json.Unmarshal(data, decoded))
+		a.Equal(tree.IsFixedMode(), decoded.IsFixedMode())
+	}
+}
+
+func TestStringWith(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	tree := New(
+		jsonpath.MustParse(`$.a.b`),
+		jsonpath.MustParse(`$.a.c`),
+		jsonpath.MustParse(`$..d`),
+	)
+	a.Equal(tree.String(), tree.StringWith(UnicodeTreeStyle))
+	a.Equal(
+		"$\n├── [\"a\"]\n│\u00a0\u00a0 └── [\"b\",\"c\"]\n└── ..[\"d\"]\n",
+		tree.StringWith(UnicodeTreeStyle),
+	)
+	a.Equal(
+		"$\n+-- [\"a\"]\n|   `-- [\"b\",\"c\"]\n`-- ..[\"d\"]\n",
+		tree.StringWith(ASCIITreeStyle),
+	)
+	a.Equal(
+		"root\n* [\"a\"]\n: - [\"b\",\"c\"]\n- ..[\"d\"]\n",
+		tree.StringWith(TreeStyle{Root: "root", Tee: "* ", Elbow: "- ", Pipe: ": ", Blank: "  "}),
+	)
+	a.Equal(
+		"$\n├── [\"a\"]\n│   └── [\"b\",\"c\"]\n└── ↓↓[\"d\"]\n",
+		tree.StringWith(TreeStyle{
+			Root: "$", Tee: "├── ", Elbow: "└── ", Pipe: "│   ", Blank: "    ",
+			DescendantPrefix: "↓↓",
+		}),
+	)
+	a.Equal("$\n", New().StringWith(ASCIITreeStyle))
+
+	// Both styles define the descendant prefix explicitly.
+	a.Equal("..", UnicodeTreeStyle.DescendantPrefix)
+	a.Equal("..", ASCIITreeStyle.DescendantPrefix)
+}
+
+// limitWriter writes up to n bytes and then returns an error.
+type limitWriter struct {
+	buf strings.Builder
+	n   int
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		w.buf.Write(p[:w.n])
+		n := w.n
+		w.n = 0
+		return n, errors.New("oops")
+	}
+	w.n -= len(p)
+	return w.buf.Write(p)
+}
+
+func TestWriteTo(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+	r := require.New(t)
+
+	tree := New(
+		jsonpath.MustParse(`$.a.b`),
+		jsonpath.MustParse(`$..d[0,1]`),
+	)
+	exp := tree.String()
+
+	buf := new(strings.Builder)
+	n, err := tree.WriteTo(buf)
+	r.NoError(err)
+	a.Equal(int64(len(exp)), n)
+	a.Equal(exp, buf.String())
+
+	var _ io.WriterTo = tree
+
+	// Stop at the first error.
+	w := &limitWriter{n: 10}
+	n, err = tree.WriteTo(w)
+	r.EqualError(err, "oops")
+	a.Equal(int64(10), n)
+	a.Equal(exp[:10], w.buf.String())
+
+	// Root only.
+	buf.Reset()
+	n, err = New().WriteTo(buf)
+	r.NoError(err)
+	a.Equal(int64(2), n)
+	a.Equal("$\n", buf.String())
+}
+
+func TestMarshalText(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+	r := require.New(t)
+
+	tree := New(
+		jsonpath.MustParse(`$.a.b`),
+		jsonpath.MustParse(`$..d[0,1]`),
+	)
+
+	var _ encoding.TextMarshaler = tree
+
+	text, err := tree.MarshalText()
+	r.NoError(err)
+	a.Equal(tree.String(), string(text))
+
+	// slog renders the diagram.
+	buf := new(strings.Builder)
+	logger := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		ReplaceAttr: func(_ []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+
+			return attr
+		},
+	}))
+	logger.Info("compiled", "tree", tree)
+	a.Equal(fmt.Sprintf("level=INFO msg=compiled tree=%q\n", tree.String()), buf.String())
+
+	// JSON encodes the structure.
+	js, err := json.Marshal(tree)
+	r.NoError(err)
+	exp, err := tree.MarshalJSON()
+	r.NoError(err)
+	a.Equal(exp, js)
+}
+
 func TestNew(t *testing.T) {
 	t.Parallel()
 
@@ -1589,11 +2339,46 @@ func TestNew(t *testing.T) {
 			paths: []string{"$", "$"},
 			exp:   &Tree{root: child()},
 		},
+		{
+			test:  "root_only_first",
+			paths: []string{"$", "$.a.b"},
+			exp:   &Tree{root: child()},
+		},
+		{
+			test:  "root_only_last",
+			paths: []string{"$.a.b", "$.c", "$"},
+			exp:   &Tree{root: child()},
+		},
+		{
+			test:  "root_trailing_wildcard",
+			paths: []string{"$.a.b", "$.*"},
+			exp:   &Tree{root: child()},
+		},
+		{
+			test:  "trailing_wildcard_after_children",
+			paths: []string{"$.a.b", "$.a.c.d", "$.a.*"},
+			exp:   &Tree{root: child().Append(child(spec.Name("a")))},
+		},
+		{
+			test:  "trailing_wildcard_before_children",
+			paths: []string{"$.a.*", "$.a.b"},
+			exp:   &Tree{root: child().Append(child(spec.Name("a")))},
+		},
 		{
 			test:  "one_name",
 			paths: []string{"$.a"},
 			exp:   &Tree{root: child().Append(child(spec.Name("a")))},
 		},
+		{
+			test:  "slice_bounds_relative_to_both_ends",
+			paths: []string{"$[1:-1]", "$[0,3:-1]"},
+			exp:   &Tree{root: child().Append(child(spec.Slice(1, -1), spec.Index(0)))},
+		},
+		{
+			test:  "empty_slices",
+			paths: []string{"$[1:3:0,3:1,-1:-3,2]"},
+			exp:   &Tree{root: child().Append(child(spec.Index(2)))},
+		},
 		{
 			test:  "two_names",
 			paths: []string{"$.a.b"},
@@ -1975,6 +2760,29 @@ func TestNew(t *testing.T) {
 				),
 			)},
 		},
+		{
+			test:  "wildcard_and_name_diff_children",
+			paths: []string{"$.a.x", "$.*.y", `$["a",*].z`},
+			exp: &Tree{root: child().Append(
+				child(spec.Name("a")).Append(
+					child(spec.Name("x")),
+				),
+				child(spec.Wildcard()).Append(
+					child(spec.Name("y"), spec.Name("z")),
+				),
+			)},
+		},
+		{
+			test:  "index_before_covering_slice",
+			paths: []string{"$.a[1].x", "$.a[7].x", "$.a[0:5].x"},
+			exp: &Tree{root: child().Append(
+				child(spec.Name("a")).Append(
+					child(spec.Index(7), spec.Slice(0, 5)).Append(
+						child(spec.Name("x")),
+					),
+				),
+			)},
+		},
 		{
 			test:  "wildcard_then_diff_then_same",
 			paths: []string{"$.*.a.c", `$.*.b.c`},
@@ -2263,6 +3071,20 @@ func TestNew(t *testing.T) {
 				),
 			},
 		},
+		{
+			test:  "diff_descendant_branches",
+			paths: []string{"$.a..b", "$.c.b"},
+			exp: &Tree{
+				root: child().Append(
+					child(spec.Name("a")).Append(
+						descendant(spec.Name("b")),
+					),
+					child(spec.Name("c")).Append(
+						child(spec.Name("b")),
+					),
+				),
+			},
+		},
 	} {
 		t.Run(tc.test, func(t *testing.T) {
 			t.Parallel()
@@ -2273,44 +3095,150 @@ func TestNew(t *testing.T) {
 				paths[i] = jsonpath.MustParse(p)
 			}
 
+			tc.exp.sources = paths
 			a.Equal(tc.exp, New(paths...))
+			a.True(tc.exp.Equal(New(paths...)))
 			tc.exp.index = true
 			a.Equal(tc.exp, NewFixedModeTree(paths...))
+			a.True(tc.exp.Equal(NewFixedModeTree(paths...)))
 		})
 	}
 }
 
-func TestSelectorsFor(t *testing.T) {
+func TestEqual(t *testing.T) {
 	t.Parallel()
 
 	for _, tc := range []struct {
-		test   string
-		seg    *spec.Segment
-		expect []spec.Selector
-		wild   bool
+		test  string
+		tree  *Tree
+		other *Tree
+		exp   bool
 	}{
 		{
-			test: "empty",
-			seg:  spec.Child(),
-			wild: false,
+			test:  "root_only",
+			tree:  New(),
+			other: New(jsonpath.MustParse("$")),
+			exp:   true,
 		},
 		{
-			test:   "one_name",
-			seg:    spec.Child(spec.Name("x")),
-			expect: []spec.Selector{spec.Name("x")},
-			wild:   false,
+			test:  "same_paths",
+			tree:  New(jsonpath.MustParse("$.a.b"), jsonpath.MustParse("$.c[0]")),
+			other: New(jsonpath.MustParse("$.c[0]"), jsonpath.MustParse("$.a.b")),
+			exp:   true,
 		},
 		{
-			test:   "wildcard",
-			seg:    spec.Child(spec.Wildcard()),
-			expect: []spec.Selector{spec.Wildcard()},
-			wild:   true,
+			test:  "diff_mode",
+			tree:  New(jsonpath.MustParse("$.a")),
+			other: NewFixedModeTree(jsonpath.MustParse("$.a")),
+			exp:   false,
 		},
 		{
-			test:   "mix_wildcard",
-			seg:    spec.Child(spec.Name("x"), spec.Wildcard()),
-			expect: []spec.Selector{spec.Wildcard()},
-			wild:   true,
+			test:  "diff_paths",
+			tree:  New(jsonpath.MustParse("$.a")),
+			other: New(jsonpath.MustParse("$.b")),
+			exp:   false,
+		},
+		{
+			test:  "root_only_and_paths",
+			tree:  New(),
+			other: New(jsonpath.MustParse("$.a")),
+			exp:   false,
+		},
+		{
+			test:  "diff_depth",
+			tree:  New(jsonpath.MustParse("$.a")),
+			other: New(jsonpath.MustParse("$.a.b")),
+			exp:   false,
+		},
+		{
+			test:  "diff_descendant",
+			tree:  New(jsonpath.MustParse("$.a")),
+			other: New(jsonpath.MustParse("$..a")),
+			exp:   false,
+		},
+		{
+			test: "unordered_selectors",
+			tree: &Tree{root: child().Append(
+				child(spec.Name("a"), spec.Index(1)),
+			)},
+			other: &Tree{root: child().Append(
+				child(spec.Index(1), spec.Name("a")),
+			)},
+			exp: true,
+		},
+		{
+			test: "unordered_children",
+			tree: &Tree{root: child().Append(
+				child(spec.Name("a")).Append(child(spec.Name("x"))),
+				child(spec.Name("b")).Append(descendant(spec.Name("y"))),
+			)},
+			other: &Tree{root: child().Append(
+				child(spec.Name("b")).Append(descendant(spec.Name("y"))),
+				child(spec.Name("a")).Append(child(spec.Name("x"))),
+			)},
+			exp: true,
+		},
+		{
+			test:  "equivalent_filters",
+			tree:  New(jsonpath.MustParse("$[?@.a == 1 && @.b]")),
+			other: New(jsonpath.MustParse("$[?@.b && 1 == @.a]")),
+			exp:   true,
+		},
+		{
+			test: "options_ignored",
+			tree: NewCompiler(WithStrictSliceBounds()).New(
+				jsonpath.MustParse("$[1:3]"),
+			),
+			other: New(jsonpath.MustParse("$[1:3]")),
+			exp:   true,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			a.Equal(tc.exp, tc.tree.Equal(tc.other))
+			a.Equal(tc.exp, tc.other.Equal(tc.tree))
+		})
+	}
+}
+
+func TestSelectorsFor(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test   string
+		seg    *spec.Segment
+		expect []spec.Selector
+		wild   bool
+	}{
+		{
+			test: "empty",
+			seg:  spec.Child(),
+			wild: false,
+		},
+		{
+			test:   "one_name",
+			seg:    spec.Child(spec.Name("x")),
+			expect: []spec.Selector{spec.Name("x")},
+			wild:   false,
+		},
+		{
+			test:   "wildcard",
+			seg:    spec.Child(spec.Wildcard()),
+			expect: []spec.Selector{spec.Wildcard()},
+			wild:   true,
+		},
+		{
+			test:   "canonical_slices",
+			seg:    spec.Child(spec.Slice(-1, nil, -1), spec.Slice(nil, nil, -1), spec.Slice(nil, nil, -2)),
+			expect: []spec.Selector{spec.Slice(nil, nil, -1)},
+			wild:   false,
+		},
+		{
+			test:   "mix_wildcard",
+			seg:    spec.Child(spec.Name("x"), spec.Wildcard()),
+			expect: []spec.Selector{spec.Wildcard()},
+			wild:   true,
 		},
 		{
 			test:   "wildcard_mix",
@@ -2409,9 +3337,2550 @@ func TestSelectorsFor(t *testing.T) {
 			t.Parallel()
 			a := assert.New(t)
 
+			str := tc.seg.String()
 			selectors, wild := selectorsFor(tc.seg)
 			a.Equal(tc.expect, selectors)
 			a.Equal(tc.wild, wild)
+			a.Equal(str, tc.seg.String(), "should not modify segment")
+		})
+	}
+}
+
+func TestAddPath(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		noop  string
+	}{
+		{
+			test:  "one_path",
+			paths: []string{"$.a.b"},
+			noop:  "$.a.b",
+		},
+		{
+			test:  "merge_selectors",
+			paths: []string{"$.a.b", "$.a.c", "$.x"},
+			noop:  "$.a.c",
+		},
+		{
+			test:  "shorter_path_wins",
+			paths: []string{"$.a.b.c", "$.a.b"},
+			noop:  "$.a.b.d",
+		},
+		{
+			test:  "trailing_wildcard",
+			paths: []string{"$.a.b", "$.a.*"},
+			noop:  "$.a.*",
+		},
+		{
+			test:  "descendant_merge",
+			paths: []string{"$.*.a", "$..*.a"},
+			noop:  "$..*.a",
+		},
+		{
+			test:  "trailing_wildcard_after_children",
+			paths: []string{"$.a.b", "$.a.c", "$.a.*"},
+			noop:  "$.a.d",
+		},
+		{
+			test:  "indexes_and_slices",
+			paths: []string{"$[1:4]", "$[2]", "$[5]"},
+			noop:  "$[3]",
+		},
+		{
+			test:  "wildcard_absorbs_names",
+			paths: []string{"$.a.x", "$.b.x", "$[*].x"},
+			noop:  "$.c.x",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			for _, mk := range []func(...*jsonpath.Path) *Tree{New, NewFixedModeTree} {
+				exp := mk(paths...)
+				tree := mk(paths[0])
+				for _, p := range tc.paths[1:] {
+					r.NoError(tree.AddPath(jsonpath.MustParse(p)))
+				}
+				a.Equal(exp.String(), tree.String())
+				a.Equal(exp.index, tree.index)
+
+				// Adding a path already selected changes nothing.
+				r.NoError(tree.AddPath(jsonpath.MustParse(tc.noop)))
+				a.Equal(exp.String(), tree.String())
+			}
+		})
+	}
+
+	t.Run("options", func(t *testing.T) {
+		t.Parallel()
+		tree := NewCompiler(WithExcludeKeys("x")).New(jsonpath.MustParse("$.a.b"))
+		require.NoError(t, tree.AddPath(jsonpath.MustParse("$.a.*")))
+		assert.Equal(t, "$\n└── [\"a\"]\n    └── [*]\n", tree.String())
+	})
+
+	t.Run("root_only", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		input := map[string]any{"a": map[string]any{"b": 1}, "c": 2}
+
+		// Adding a path that selects the entire value makes a Tree root-only.
+		tree := New(jsonpath.MustParse("$.a.b"))
+		r.NoError(tree.AddPath(jsonpath.MustParse("$")))
+		a.Equal("$\n", tree.String())
+		a.True(tree.Contains(jsonpath.MustParse("$")))
+
+		// A root-only Tree already selects the entire value, so adding a
+		// path changes nothing, just as compiling it with $ does.
+		r.NoError(tree.AddPath(jsonpath.MustParse("$.a")))
+		a.Equal("$\n", tree.String())
+		a.Equal(input, tree.Select(input))
+		a.Equal(New(jsonpath.MustParse("$"), jsonpath.MustParse("$.a")).String(), tree.String())
+
+		// Including one compiled from no paths.
+		tree = New()
+		r.NoError(tree.AddPath(jsonpath.MustParse("$.a")))
+		a.Equal(input, tree.Select(input))
+
+		// But it records the path for SelectByPath.
+		a.Equal(map[string]any{`$["a"]`: map[string]any{"a": input["a"]}}, tree.SelectByPath(input))
+	})
+
+	t.Run("contains", func(t *testing.T) {
+		t.Parallel()
+		input := map[string]any{
+			"a": map[string]any{"b": 1, "c": []any{1, 2, 3}},
+			"d": []any{map[string]any{"b": 4}, map[string]any{"e": 5}},
+		}
+
+		// Adding a path the Tree contains never changes what it selects, and
+		// the Tree contains every path added to it.
+		for _, queries := range [][]string{
+			{},
+			{"$"},
+			{"$", "$.a"},
+			{"$.a"},
+			{"$.a.b", "$.d[*].b"},
+			{"$[*].b", "$.a.c[1:]"},
+			{"$..b"},
+		} {
+			paths := make([]*jsonpath.Path, len(queries))
+			for i, q := range queries {
+				paths[i] = jsonpath.MustParse(q)
+			}
+
+			for _, p := range []string{"$", "$.a", "$.a.b", "$.a.c[2]", "$.d[0].b", "$.d[*]", "$..b", "$.x"} {
+				for _, mk := range []func(...*jsonpath.Path) *Tree{New, NewFixedModeTree} {
+					path := jsonpath.MustParse(p)
+					tree := mk(paths...)
+					before := tree.Select(input)
+					contains := tree.Contains(path)
+					require.NoError(t, tree.AddPath(path))
+					if contains {
+						assert.Equal(t, before, tree.Select(input), "%v adding %v", queries, p)
+					}
+					assert.True(t, tree.Contains(path), "%v contains %v", queries, p)
+				}
+			}
+		}
+	})
+
+	t.Run("frozen", func(t *testing.T) {
+		t.Parallel()
+		tree := New(jsonpath.MustParse("$.a"))
+		tree.Freeze()
+		str := tree.String()
+		require.ErrorIs(t, tree.AddPath(jsonpath.MustParse("$.b")), ErrFrozen)
+		assert.Equal(t, str, tree.String())
+	})
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		left  []string
+		right []string
+		exp   string
+	}{
+		{
+			test:  "sibling_names",
+			left:  []string{"$.a.b"},
+			right: []string{"$.a.c"},
+		},
+		{
+			test:  "overlapping",
+			left:  []string{"$.a.b", "$.x[1:3]"},
+			right: []string{"$.a.b.c", "$.x[2]", "$.y"},
+		},
+		{
+			test:  "descendants",
+			left:  []string{"$.a.x.b", "$.a..x.b"},
+			right: []string{"$.a.y.b", "$.a..y.b"},
+		},
+		{
+			test:  "filters",
+			left:  []string{"$[?@.x].y"},
+			right: []string{"$[?@.x].z", "$[0].y"},
+		},
+		{
+			test:  "root_only_left",
+			left:  []string{"$"},
+			right: []string{"$.a"},
+			exp:   "$\n",
+		},
+		{
+			test:  "root_only_right",
+			left:  []string{"$.a"},
+			right: []string{"$"},
+			exp:   "$\n",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			left := make([]*jsonpath.Path, len(tc.left))
+			for i, p := range tc.left {
+				left[i] = jsonpath.MustParse(p)
+			}
+			right := make([]*jsonpath.Path, len(tc.right))
+			for i, p := range tc.right {
+				right[i] = jsonpath.MustParse(p)
+			}
+
+			for _, mk := range []func(...*jsonpath.Path) *Tree{New, NewFixedModeTree} {
+				lTree, rTree := mk(left...), mk(right...)
+				lStr, rStr := lTree.String(), rTree.String()
+				lTree.Freeze()
+
+				merged := lTree.Merge(rTree)
+				if tc.exp == "" {
+					a.Equal(mk(append(left, right...)...).String(), merged.String())
+				} else {
+					a.Equal(tc.exp, merged.String())
+				}
+				a.Equal(lTree.index, merged.index)
+				a.False(merged.frozen)
+
+				// Neither tree changes.
+				a.Equal(lStr, lTree.String())
+				a.Equal(rStr, rTree.String())
+			}
+		})
+	}
+
+	t.Run("options", func(t *testing.T) {
+		t.Parallel()
+		tree := NewCompiler(WithExcludeKeys("x")).New(jsonpath.MustParse("$.a.*"))
+		merged := tree.Merge(New(jsonpath.MustParse("$.b.*")))
+		assert.Equal(t, tree.exclude, merged.exclude)
+		// Other's trailing wildcard was discarded when it was compiled.
+		assert.Equal(t, []string{`$["a"][*]`, `$["b"]`}, merged.Queries())
+	})
+
+	t.Run("mode_mismatch", func(t *testing.T) {
+		t.Parallel()
+		path := jsonpath.MustParse("$.a")
+		assert.PanicsWithValue(t, "jsontree: cannot merge fixed mode tree into ordered mode tree", func() {
+			New(path).Merge(NewFixedModeTree(path))
+		})
+		assert.PanicsWithValue(t, "jsontree: cannot merge ordered mode tree into fixed mode tree", func() {
+			NewFixedModeTree(path).Merge(New(path))
+		})
+	})
+}
+
+func TestIntersect(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		left  []string
+		right []string
+		exp   []string
+	}{
+		{
+			test:  "same",
+			left:  []string{"$.a.b"},
+			right: []string{"$.a.b"},
+			exp:   []string{"$.a.b"},
+		},
+		{
+			test:  "disjoint",
+			left:  []string{"$.a.b"},
+			right: []string{"$.a.c", "$.x"},
+		},
+		{
+			test:  "common_names",
+			left:  []string{`$["a","b","c"].x`},
+			right: []string{`$["b","c","d"]`},
+			exp:   []string{`$["b","c"].x`},
+		},
+		{
+			test:  "wildcard_and_name",
+			left:  []string{"$[*].x"},
+			right: []string{"$.a.x", "$.b.y"},
+			exp:   []string{"$.a.x"},
+		},
+		{
+			test:  "wildcards",
+			left:  []string{"$[*][*].x"},
+			right: []string{"$[*].a[*]"},
+			exp:   []string{"$[*].a.x"},
+		},
+		{
+			test:  "shorter_path_selects_all",
+			left:  []string{"$.a"},
+			right: []string{"$.a.b.c", "$.b.c"},
+			exp:   []string{"$.a.b.c"},
+		},
+		{
+			test:  "slice_and_index",
+			left:  []string{"$[1:4]"},
+			right: []string{"$[2]", "$[5]"},
+			exp:   []string{"$[2]"},
+		},
+		{
+			test:  "overlapping_slices",
+			left:  []string{"$[0:5]"},
+			right: []string{"$[3:8]"},
+			exp:   []string{"$[3:5]"},
+		},
+		{
+			test:  "open_slices",
+			left:  []string{"$[2:]"},
+			right: []string{"$[4:]"},
+			exp:   []string{"$[4:]"},
+		},
+		{
+			test:  "stepped_slices",
+			left:  []string{"$[0:10:2]"},
+			right: []string{"$[4:8]"},
+		},
+		{
+			test:  "negative_index",
+			left:  []string{"$[-1]"},
+			right: []string{"$[4]"},
+		},
+		{
+			test:  "negative_index_and_slice",
+			left:  []string{"$[-1]"},
+			right: []string{"$[0:2]"},
+		},
+		{
+			test:  "negative_index_and_open_slice",
+			left:  []string{"$[-1]"},
+			right: []string{"$[1:]", "$[::2]"},
+		},
+		{
+			test:  "negative_index_and_all",
+			left:  []string{"$[-1]", "$[-3]"},
+			right: []string{"$[-2:]"},
+			exp:   []string{"$[-1]"},
+		},
+		{
+			test:  "same_negative_index",
+			left:  []string{"$[-1].a"},
+			right: []string{"$[-1,2]"},
+			exp:   []string{"$[-1].a"},
+		},
+		{
+			test:  "slice_in_slice",
+			left:  []string{"$[::2]"},
+			right: []string{"$[:]"},
+			exp:   []string{"$[::2]"},
+		},
+		{
+			test:  "filters",
+			left:  []string{"$[?@.x > 1].y", "$[?@.z].y"},
+			right: []string{"$[?1 < @.x]", "$[*].y"},
+			exp:   []string{"$[?@.x > 1].y", "$[?@.z].y"},
+		},
+		{
+			test:  "descendants",
+			left:  []string{"$..a.b", "$.a"},
+			right: []string{`$..["a","c"]`, "$..a"},
+			exp:   []string{"$..a.b"},
+		},
+		{
+			test:  "root_only_left",
+			left:  []string{"$"},
+			right: []string{"$.a", "$.b"},
+			exp:   []string{"$.a", "$.b"},
+		},
+		{
+			test:  "root_only_right",
+			left:  []string{"$.a", "$.b"},
+			right: []string{"$"},
+			exp:   []string{"$.a", "$.b"},
+		},
+		{
+			test:  "root_only_both",
+			left:  []string{"$"},
+			right: []string{"$"},
+			exp:   []string{"$"},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			left := make([]*jsonpath.Path, len(tc.left))
+			for i, p := range tc.left {
+				left[i] = jsonpath.MustParse(p)
+			}
+			right := make([]*jsonpath.Path, len(tc.right))
+			for i, p := range tc.right {
+				right[i] = jsonpath.MustParse(p)
+			}
+			exp := make([]*jsonpath.Path, len(tc.exp))
+			for i, p := range tc.exp {
+				exp[i] = jsonpath.MustParse(p)
+			}
+
+			for _, mk := range []func(...*jsonpath.Path) *Tree{New, NewFixedModeTree} {
+				lTree, rTree := mk(left...), mk(right...)
+				lStr, rStr := lTree.String(), rTree.String()
+				lTree.Freeze()
+
+				for _, res := range []*Tree{Intersect(lTree, rTree), Intersect(rTree, lTree)} {
+					if len(exp) == 0 {
+						// Selects nothing.
+						a.Equal("$\n└── []\n", res.String())
+						a.Equal(map[string]any{}, res.Select(map[string]any{"a": map[string]any{"b": 1}}))
+					} else {
+						// Compare with Equal, which treats equivalent filters as equal.
+						a.True(mk(exp...).Equal(res), res.String())
+					}
+					a.Equal(lTree.index, res.index)
+					a.False(res.frozen)
+				}
+
+				// Neither tree changes.
+				a.Equal(lStr, lTree.String())
+				a.Equal(rStr, rTree.String())
+			}
+		})
+	}
+
+	t.Run("regexp", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		re := Build(Child(RegexpName(regexp.MustCompile(`^b`))))
+		input := map[string]any{"a": 1, "bb": 2, "bc": 3}
+		for _, tc := range []struct {
+			paths []string
+			exp   any
+		}{
+			{[]string{"$.bb"}, map[string]any{"bb": 2}},
+			{[]string{"$.a", "$.bc"}, map[string]any{"bc": 3}},
+			{[]string{"$.a"}, map[string]any{}},
+			{[]string{"$.*"}, map[string]any{"bb": 2, "bc": 3}},
+		} {
+			other := MustNewFromStrings(tc.paths...)
+			a.Equal(tc.exp, Intersect(re, other).Select(input), tc.paths)
+			a.Equal(tc.exp, Intersect(other, re).Select(input), tc.paths)
+		}
+		a.Equal(map[string]any{"bb": 2, "bc": 3}, Intersect(re, re).Select(input))
+	})
+
+	t.Run("options", func(t *testing.T) {
+		t.Parallel()
+		tree := NewCompiler(WithExcludeKeys("x")).New(jsonpath.MustParse("$.a.*"))
+		res := Intersect(tree, New(jsonpath.MustParse("$[*].b")))
+		assert.Equal(t, tree.exclude, res.exclude)
+		assert.Equal(t, []string{`$["a"]["b"]`}, res.Queries())
+	})
+
+	t.Run("mode_mismatch", func(t *testing.T) {
+		t.Parallel()
+		path := jsonpath.MustParse("$.a")
+		assert.PanicsWithValue(t, "jsontree: cannot intersect ordered mode tree with fixed mode tree", func() {
+			Intersect(New(path), NewFixedModeTree(path))
 		})
+	})
+}
+
+func TestFreeze(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	path := jsonpath.MustParse(`$["a","b"][0,1]`)
+	tree := New(path)
+	a.False(tree.frozen)
+	str := tree.String()
+
+	tree.Freeze()
+	a.True(tree.frozen)
+	a.Equal(str, tree.String())
+
+	// Changes to the path must not change the tree.
+	for _, seg := range path.Query().Segments() {
+		sels := seg.Selectors()
+		sels[0], sels[1] = spec.Name("x"), spec.Name("y")
 	}
+	a.Equal(str, tree.String())
+
+	// Freezing again is a no-op.
+	tree.Freeze()
+	a.True(tree.frozen)
+	a.Equal(str, tree.String())
+}
+
+func TestSelectErrors(t *testing.T) {
+	t.Parallel()
+
+	tree := New(jsonpath.MustParse("$.a"))
+	strict := NewCompiler(WithStrictSliceBounds()).New(jsonpath.MustParse("$[5:]"))
+	yamlTree := NewCompiler(WithYAMLCodec(yamlCodec{})).New(jsonpath.MustParse("$.a"))
+
+	for _, tc := range []struct {
+		test string
+		fn   func() error
+		err  string
+		is   error
+	}{
+		{
+			test: "select_e_unsupported",
+			fn:   func() error { _, err := tree.SelectE("hi"); return err },
+			err:  "jsontree: cannot select from value of type string",
+			is:   ErrUnsupported,
+		},
+		{
+			test: "select_e_nil",
+			fn:   func() error { _, err := tree.SelectE(nil); return err },
+			err:  "jsontree: cannot select from value of type <nil>",
+			is:   ErrUnsupported,
+		},
+		{
+			test: "select_e_slice_bounds",
+			fn:   func() error { _, err := strict.SelectE([]any{1}); return err },
+			err:  "jsontree: slice bounds out of range: [5:] on array of length 1",
+			is:   ErrSliceBounds,
+		},
+		{
+			test: "select_into_mode_mismatch",
+			fn:   func() error { return tree.SelectIntoMode([]any{}, map[string]any{}, false) },
+			err:  "jsontree: invalid destination: cannot select []interface {} into map[string]interface {}",
+			is:   ErrDestination,
+		},
+		{
+			test: "select_into_mode_unsupported",
+			fn:   func() error { return tree.SelectIntoMode([]any{}, "x", true) },
+			err:  "jsontree: invalid destination: cannot select into string",
+			is:   ErrDestination,
+		},
+		{
+			test: "select_raw_invalid",
+			fn:   func() error { _, err := tree.SelectRaw([]byte("{")); return err },
+			err:  "jsontree: json: unexpected EOF",
+			is:   ErrJSON,
+		},
+		{
+			test: "select_raw_slice_bounds",
+			fn:   func() error { _, err := strict.SelectRaw([]byte("[1]")); return err },
+			err:  "jsontree: slice bounds out of range: [5:] on array of length 1",
+			is:   ErrSliceBounds,
+		},
+		{
+			test: "select_yaml_no_codec",
+			fn:   func() error { _, err := tree.SelectYAML([]byte("a: 1")); return err },
+			err:  "jsontree: yaml: no codec configured",
+			is:   ErrYAML,
+		},
+		{
+			test: "select_yaml_unsupported",
+			fn:   func() error { _, err := yamlTree.SelectYAML([]byte("hi")); return err },
+			err:  "jsontree: cannot select from value of type string",
+			is:   ErrUnsupported,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			var err error
+			require.NotPanics(t, func() { err = tc.fn() })
+			require.EqualError(t, err, tc.err)
+			require.ErrorIs(t, err, tc.is)
+		})
+	}
+}
+
+func TestClone(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+	r := require.New(t)
+
+	tree := NewCompiler(WithSortedKeys()).NewFixedModeTree(
+		jsonpath.MustParse("$.a[1, 2].b"),
+		jsonpath.MustParse("$..c[?@.d]"),
+	)
+	tree.Freeze()
+	str := tree.String()
+
+	clone := tree.Clone()
+	a.NotSame(tree, clone)
+	a.NotSame(tree.root, clone.root)
+	a.Equal(str, clone.String())
+	a.Equal(tree.Queries(), clone.Queries())
+	a.True(tree.Equal(clone))
+	a.True(clone.sorted)
+	a.False(clone.frozen)
+
+	// Modify the clone's segments.
+	seg := clone.root.children[0]
+	seg.selectors[0] = spec.Name("x")
+	seg.children = append(seg.children, child(spec.Name("y")))
+	seg.children[0].selectors = append(seg.children[0].selectors, spec.Index(3))
+	r.NoError(clone.AddPath(jsonpath.MustParse("$.z")))
+	a.NotEqual(str, clone.String())
+	a.Equal(str, tree.String())
+	a.True(tree.frozen)
+
+	// Clone a root-only tree.
+	tree = New()
+	clone = tree.Clone()
+	a.NotSame(tree.root, clone.root)
+	a.Equal(tree, clone)
+}
+
+func TestResolveForLength(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test   string
+		paths  []string
+		length int
+		str    string
+	}{
+		{
+			test:   "neg_start_slice",
+			paths:  []string{"$[-2:]"},
+			length: 5,
+			str:    "$\n└── [3,4]\n",
+		},
+		{
+			test:   "step_two_slice",
+			paths:  []string{"$[::2]"},
+			length: 5,
+			str:    "$\n└── [0,2,4]\n",
+		},
+		{
+			test:   "step_two_slice_short",
+			paths:  []string{"$[::2]"},
+			length: 2,
+			str:    "$\n└── [0]\n",
+		},
+		{
+			test:   "backward_slice",
+			paths:  []string{"$[::-2]"},
+			length: 6,
+			str:    "$\n└── [1,3,5]\n",
+		},
+		{
+			test:   "neg_indexes",
+			paths:  []string{"$[-1,-2,-9]"},
+			length: 4,
+			str:    "$\n└── [2,3]\n",
+		},
+		{
+			test:   "duplicate_indexes",
+			paths:  []string{"$[1,-4,0:2]"},
+			length: 5,
+			str:    "$\n└── [0,1]\n",
+		},
+		{
+			test:   "out_of_range",
+			paths:  []string{"$[7,2:]"},
+			length: 2,
+			str:    "$\n└── []\n",
+		},
+		{
+			test:   "nested",
+			paths:  []string{`$.a[-1]["x",0:2]`},
+			length: 3,
+			str:    "$\n└── [\"a\"]\n    └── [2]\n        └── [\"x\",0,1]\n",
+		},
+		{
+			test:   "descendant",
+			paths:  []string{`$..[-1]`},
+			length: 3,
+			str:    "$\n└── ..[2]\n",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := NewFixedModeTree(paths...)
+			orig := tree.String()
+			res := tree.ResolveForLength(tc.length)
+			a.Equal(tc.str, res.String())
+			a.Equal(tree.index, res.index)
+
+			// The original must be unchanged.
+			a.Equal(orig, tree.String())
+		})
+	}
+
+	// Resolved trees select the same values from arrays of the length.
+	tree := New(jsonpath.MustParse("$[-2:]"), jsonpath.MustParse("$[::3]"))
+	tree.Freeze()
+	res := tree.ResolveForLength(5)
+	assert.False(t, res.frozen)
+	input := []any{"a", "b", "c", "d", "e"}
+	assert.Equal(t, tree.Select(input), res.Select(input))
+	assert.Equal(t, []any{"a", "d", "e"}, res.Select(input))
+}
+
+func TestFrozenConcurrentSelect(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	tree := New(
+		jsonpath.MustParse("$.profile..last"),
+		jsonpath.MustParse("$.profile..contacts[?@.primary].primary"),
+		jsonpath.MustParse("$.profile.emails[1:]"),
+	)
+	tree.Freeze()
+
+	input := map[string]any{
+		"profile": map[string]any{
+			"name": map[string]any{"first": "Kim", "last": "Wexler"},
+			"contacts": map[string]any{
+				"email": map[string]any{"primary": "kim@example.com", "other": "kw@example.net"},
+				"phone": map[string]any{"primary": "+1-505-555-1234"},
+			},
+			"emails": []any{"a@example.com", "b@example.com", "c@example.com"},
+		},
+	}
+	exp := tree.Select(input)
+
+	const workers = 16
+	results := make(chan any, workers)
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- tree.Select(input)
+		}()
+	}
+
+	wg.Wait()
+	close(results)
+
+	for res := range results {
+		a.Equal(exp, res)
+	}
+}
+
+func TestConcurrentSelect(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	// The query from the package example, unfrozen.
+	tree := NewCompiler(WithSortedKeys()).New(
+		jsonpath.MustParse("$.profile..last"),
+		jsonpath.MustParse("$.profile..contacts.*.primary"),
+		jsonpath.MustParse("$.profile.contacts.addresses.primary[1:]"),
+	)
+
+	input := map[string]any{
+		"profile": map[string]any{
+			"name": map[string]any{"first": "Barrack", "last": "Obama"},
+			"contacts": map[string]any{
+				"email":  map[string]any{"primary": "foo@example.com", "secondary": "2nd@example.net"},
+				"phones": map[string]any{"primary": "+1-234-567-8901", "fax": "+1-293-847-5829"},
+				"addresses": map[string]any{
+					"primary": []any{"123 Main Street", "Chicago", "IL", "90210"},
+				},
+			},
+		},
+	}
+
+	type result struct {
+		sel    any
+		stream string
+		to     []any
+		n      any
+		count  int
+	}
+
+	run := func() result {
+		var buf strings.Builder
+		_ = tree.SelectStream(input, &buf)
+
+		return result{
+			sel:    tree.Select(input),
+			stream: buf.String(),
+			to:     tree.SelectTo(nil, input),
+			n:      tree.SelectN(input, 2),
+			count:  tree.Count(input),
+		}
+	}
+	exp := run()
+
+	const workers = 32
+	results := make(chan result, workers)
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- run()
+		}()
+	}
+
+	wg.Wait()
+	close(results)
+
+	for res := range results {
+		a.Equal(exp, res)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test    string
+		paths   []string
+		queries []string
+	}{
+		{
+			test:    "root_only",
+			paths:   []string{"$"},
+			queries: []string{"$"},
+		},
+		{
+			test:    "one_name",
+			paths:   []string{"$.a"},
+			queries: []string{`$["a"]`},
+		},
+		{
+			test:    "merged_names",
+			paths:   []string{"$.a.x", "$.a.y"},
+			queries: []string{`$["a"]["x","y"]`},
+		},
+		{
+			test:    "merged_branches",
+			paths:   []string{"$.a.b.c.d", "$.a.x.c.d"},
+			queries: []string{`$["a"]["b","x"]["c"]["d"]`},
+		},
+		{
+			test:    "two_branches",
+			paths:   []string{"$.a.b", "$.x[1]"},
+			queries: []string{`$["a"]["b"]`, `$["x"][1]`},
+		},
+		{
+			test:    "descendants",
+			paths:   []string{"$.a.x.b", "$.a.y.b", "$.a..x.b", "$.a..y.b"},
+			queries: []string{`$["a"]..["x","y"]["b"]`},
+		},
+		{
+			test: "mixed",
+			paths: []string{
+				"$.profile..last",
+				"$.profile..contacts.primary",
+				`$.preferences[0, 2]["type", "value"]`,
+				`$.preferences[1, ?@.x]["type", "value"]`,
+			},
+			queries: []string{
+				`$["profile"]..["last"]`,
+				`$["profile"]..["contacts"]["primary"]`,
+				`$["preferences"][0,2,1,?@["x"]]["type","value"]`,
+			},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := New(paths...)
+			queries := tree.Queries()
+			a.Equal(tc.queries, queries)
+
+			// Queries should compile into an equivalent tree.
+			paths = make([]*jsonpath.Path, len(queries))
+			for i, q := range queries {
+				paths[i] = jsonpath.MustParse(q)
+			}
+			a.Equal(tree.root, New(paths...).root)
+		})
+	}
+}
+
+func TestSelectKeys(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"name":    map[string]any{"first": "Kim", "last": "Wexler"},
+		"ssn":     "123-45-6789",
+		"emails":  []any{"kim@example.com", nil, "kw@example.net"},
+		"clients": []any{map[string]any{"name": "Mesa Verde", "ssn": nil}},
+	}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		exp   any
+		fixed any
+	}{
+		{
+			test:  "root",
+			paths: []string{"$"},
+			exp:   true,
+		},
+		{
+			test:  "names",
+			paths: []string{"$.ssn", "$.name.first", "$.nonesuch"},
+			exp:   map[string]any{"ssn": true, "name": map[string]any{"first": true}},
+		},
+		{
+			test:  "container",
+			paths: []string{"$.name"},
+			exp:   map[string]any{"name": true},
+		},
+		{
+			test:  "indexes",
+			paths: []string{"$.emails[1,2]"},
+			exp:   map[string]any{"emails": []any{true, true}},
+			fixed: map[string]any{"emails": []any{nil, true, true}},
+		},
+		{
+			test:  "descendant",
+			paths: []string{"$..ssn"},
+			exp: map[string]any{
+				"ssn":     true,
+				"clients": []any{map[string]any{"ssn": true}},
+			},
+		},
+		{
+			test:  "descendant_container",
+			paths: []string{"$..name"},
+			exp: map[string]any{
+				"name":    true,
+				"clients": []any{map[string]any{"name": true}},
+			},
+		},
+		{
+			test:  "descendant_array",
+			paths: []string{"$..[0]", "$.emails"},
+			exp: map[string]any{
+				"emails":  true,
+				"clients": []any{true},
+			},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := New(paths...)
+			a.Equal(tc.exp, tree.SelectKeys(input))
+
+			if tc.fixed == nil {
+				tc.fixed = tc.exp
+			}
+			a.Equal(tc.fixed, NewFixedModeTree(paths...).SelectKeys(input))
+
+			// Should have the same keys as Select.
+			if exp, ok := tc.exp.(map[string]any); ok {
+				res, ok := tree.Select(input).(map[string]any)
+				a.True(ok)
+				a.Len(res, len(exp))
+				for k := range exp {
+					a.Contains(res, k)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectMap(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"name":    map[string]any{"first": "Kim", "last": "Wexler"},
+		"ssn":     "123-45-6789",
+		"emails":  []any{"Kim@Example.com", nil, "KW@Example.net"},
+		"clients": []any{map[string]any{"name": "Mesa Verde", "ssn": "987-65-4321"}},
+	}
+
+	lower := func(val any) any {
+		if str, ok := val.(string); ok {
+			return strings.ToLower(str)
+		}
+
+		return val
+	}
+
+	redact := func(any) any { return "REDACTED" }
+
+	for _, tc := range []struct {
+		test      string
+		paths     []string
+		transform func(any) any
+		exp       any
+		fixed     any
+	}{
+		{
+			test:      "root",
+			paths:     []string{"$"},
+			transform: redact,
+			exp:       "REDACTED",
+		},
+		{
+			test:      "names",
+			paths:     []string{"$.ssn", "$.name.first"},
+			transform: lower,
+			exp:       map[string]any{"ssn": "123-45-6789", "name": map[string]any{"first": "kim"}},
+		},
+		{
+			test:      "container_leaf",
+			paths:     []string{"$.name"},
+			transform: redact,
+			exp:       map[string]any{"name": "REDACTED"},
+		},
+		{
+			test:      "indexes",
+			paths:     []string{"$.emails[0,2]"},
+			transform: lower,
+			exp:       map[string]any{"emails": []any{"kim@example.com", "kw@example.net"}},
+			fixed:     map[string]any{"emails": []any{"kim@example.com", nil, "kw@example.net"}},
+		},
+		{
+			test:      "descendant",
+			paths:     []string{"$..ssn"},
+			transform: redact,
+			exp: map[string]any{
+				"ssn":     "REDACTED",
+				"clients": []any{map[string]any{"ssn": "REDACTED"}},
+			},
+		},
+		{
+			test:      "descendant_index",
+			paths:     []string{"$..[0]"},
+			transform: redact,
+			exp: map[string]any{
+				"emails":  []any{"REDACTED"},
+				"clients": []any{"REDACTED"},
+			},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			a.Equal(tc.exp, New(paths...).SelectMap(input, tc.transform))
+
+			if tc.fixed == nil {
+				tc.fixed = tc.exp
+			}
+			a.Equal(tc.fixed, NewFixedModeTree(paths...).SelectMap(input, tc.transform))
+		})
+	}
+
+	t.Run("copy_leaves", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		src := map[string]any{"a": map[string]any{"b": "x"}}
+		tree := NewCompiler(WithCopyLeaves()).New(jsonpath.MustParse("$.a"))
+
+		res := tree.SelectMap(src, func(val any) any {
+			obj, _ := val.(map[string]any)
+			obj["b"] = "y"
+
+			return obj
+		})
+		a.Equal(map[string]any{"a": map[string]any{"b": "y"}}, res)
+		a.Equal(map[string]any{"a": map[string]any{"b": "x"}}, src)
+	})
+}
+
+func TestSelectByPath(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"a": map[string]any{"x": 1, "y": 2},
+		"b": []any{"zero", "one", "two"},
+		"c": "see",
+	}
+
+	for _, tc := range []struct {
+		test  string
+		tree  *Tree
+		exp   map[string]any
+		fixed map[string]any
+	}{
+		{
+			test: "root_only",
+			tree: New(),
+			exp:  map[string]any{"$": input},
+		},
+		{
+			test: "root",
+			tree: New(jsonpath.MustParse("$"), jsonpath.MustParse("$.a")),
+			exp:  map[string]any{"$": input, `$["a"]`: map[string]any{"a": input["a"]}},
+		},
+		{
+			test: "overlapping",
+			tree: New(jsonpath.MustParse("$.a"), jsonpath.MustParse("$.a.x"), jsonpath.MustParse("$.b[1,2]")),
+			exp: map[string]any{
+				`$["a"]`:      map[string]any{"a": input["a"]},
+				`$["a"]["x"]`: map[string]any{"a": map[string]any{"x": 1}},
+				`$["b"][1,2]`: map[string]any{"b": []any{"one", "two"}},
+			},
+			fixed: map[string]any{
+				`$["a"]`:      map[string]any{"a": input["a"]},
+				`$["a"]["x"]`: map[string]any{"a": map[string]any{"x": 1}},
+				`$["b"][1,2]`: map[string]any{"b": []any{nil, "one", "two"}},
+			},
+		},
+		{
+			test: "duplicates",
+			tree: New(jsonpath.MustParse("$.c"), jsonpath.MustParse(`$["c"]`)),
+			exp:  map[string]any{`$["c"]`: map[string]any{"c": "see"}},
+		},
+		{
+			test: "add_path",
+			tree: func() *Tree {
+				tree := New(jsonpath.MustParse("$.c"))
+				_ = tree.AddPath(jsonpath.MustParse("$.a.y"))
+				return tree
+			}(),
+			exp: map[string]any{
+				`$["c"]`:      map[string]any{"c": "see"},
+				`$["a"]["y"]`: map[string]any{"a": map[string]any{"y": 2}},
+			},
+		},
+		{
+			test: "merge",
+			tree: New(jsonpath.MustParse("$.c")).Merge(New(jsonpath.MustParse("$.a"), jsonpath.MustParse("$.a.x"))),
+			exp: map[string]any{
+				`$["c"]`:      map[string]any{"c": "see"},
+				`$["a"]`:      map[string]any{"a": input["a"]},
+				`$["a"]["x"]`: map[string]any{"a": map[string]any{"x": 1}},
+			},
+		},
+		{
+			test: "intersect",
+			tree: Intersect(New(jsonpath.MustParse("$.a"), jsonpath.MustParse("$.b")), New(jsonpath.MustParse("$[*].x"))),
+			exp:  map[string]any{`$["a","b"]["x"]`: map[string]any{"a": map[string]any{"x": 1}}},
+		},
+		{
+			test: "unknown_sources",
+			tree: New(jsonpath.MustParse("$.b[-1]"), jsonpath.MustParse("$.c")).ResolveForLength(3),
+			exp: map[string]any{
+				`$["b"][2]`: map[string]any{"b": []any{"two"}},
+				`$["c"]`:    map[string]any{"c": "see"},
+			},
+			fixed: map[string]any{
+				`$["b"][2]`: map[string]any{"b": []any{nil, nil, "two"}},
+				`$["c"]`:    map[string]any{"c": "see"},
+			},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			a.Equal(tc.exp, tc.tree.SelectByPath(input))
+
+			if tc.fixed == nil {
+				tc.fixed = tc.exp
+			}
+
+			fixed := *tc.tree
+			fixed.index = true
+			a.Equal(tc.fixed, fixed.SelectByPath(input))
+		})
+	}
+
+	t.Run("options", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		tree := NewCompiler(WithNullMissingNames()).New(jsonpath.MustParse("$.a.z"), jsonpath.MustParse("$.c"))
+		a.Equal(map[string]any{
+			`$["a"]["z"]`: map[string]any{"a": map[string]any{"z": nil}},
+			`$["c"]`:      map[string]any{"c": "see"},
+		}, tree.SelectByPath(input))
+	})
+
+	t.Run("clone", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		tree := New(jsonpath.MustParse("$.c"))
+		clone := tree.Clone()
+		a.NoError(clone.AddPath(jsonpath.MustParse("$.a.x")))
+		a.Equal(map[string]any{`$["c"]`: map[string]any{"c": "see"}}, tree.SelectByPath(input))
+		a.Len(clone.SelectByPath(input), 2)
+	})
+}
+
+func TestPaths(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		exp   []string
+	}{
+		{
+			test:  "root_only",
+			paths: []string{"$"},
+			exp:   []string{"$"},
+		},
+		{
+			test:  "multi_selector_segments",
+			paths: []string{"$.a.x", "$.a.y", "$.b[*].x", "$.c[1:3]"},
+			exp:   []string{`$["a"]["x","y"]`, `$["b"][*]["x"]`, `$["c"][1:3]`},
+		},
+		{
+			test:  "descendants",
+			paths: []string{"$..a", "$.b..c[?@.d]"},
+			exp:   []string{`$..["a"]`, `$["b"]..["c"][?@["d"]]`},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			for _, mk := range []func(...*jsonpath.Path) *Tree{New, NewFixedModeTree} {
+				tree := mk(paths...)
+				res := tree.Paths()
+				strs := make([]string, len(res))
+				for i, p := range res {
+					strs[i] = p.String()
+				}
+				a.Equal(tc.exp, strs)
+
+				// Paths should compile into an equivalent tree.
+				a.Equal(tree.root, mk(res...).root)
+
+				// Paths must not share storage with tree.
+				str := tree.String()
+				for _, p := range res {
+					for _, seg := range p.Query().Segments() {
+						sels := seg.Selectors()
+						for i := range sels {
+							sels[i] = spec.Name("z")
+						}
+					}
+				}
+				a.Equal(str, tree.String())
+			}
+		})
+	}
+}
+
+func TestSelectArrayItems(t *testing.T) {
+	t.Parallel()
+
+	// Exhaustively compare the items selected from arrays by index and
+	// slice selectors, alone and in pairs, against jsonpath selection, in
+	// both modes, with and without child segments.
+	sels := []string{
+		"0", "2", "-1", "-3", "5",
+		"*", ":", "1:", ":3", "-2:", "1:-1", "::2", "1::3", "-4::2", "::-1", "::-2",
+		"3::-1", "-1:0:-2", "4:1:-1", ":-3:-1", "0:0", "::0",
+	}
+
+	// Trees configured by WithObserver select each item individually, so
+	// must select the same items as Trees that copy ranges of items.
+	observe := WithObserver(func(*Segment) {})
+	options := [][]Option{
+		{WithNullGapThreshold(2)},
+		{WithGapValue("gap")},
+		{WithKeepTrailingWildcard()},
+	}
+
+	for n := range 7 {
+		ary := make([]any, n)
+		for i := range n {
+			ary[i] = map[string]any{"v": i}
+		}
+
+		for i, x := range sels {
+			for _, y := range append([]string{""}, sels[i:]...) {
+				sel := x
+				if y != "" {
+					sel += "," + y
+				}
+
+				for _, suffix := range []string{"", ".v"} {
+					query := "$[" + sel + "]" + suffix
+					path := jsonpath.MustParse(query)
+
+					// Select each item once, in index order.
+					idx := map[int]bool{}
+					for _, node := range path.SelectLocated(ary) {
+						idx[int(node.Path[0].(spec.Index))] = true
+					}
+
+					ordered, fixed := []any{}, []any{}
+					for _, i := range slices.Sorted(maps.Keys(idx)) {
+						ordered = append(ordered, ary[i])
+						for len(fixed) < i {
+							fixed = append(fixed, nil)
+						}
+						fixed = append(fixed, ary[i])
+					}
+
+					assert.Equal(t, ordered, New(path).Select(ary), "%v on %v", query, ary)
+					assert.Equal(t, fixed, NewFixedModeTree(path).Select(ary), "%v on %v", query, ary)
+
+					for _, opts := range options {
+						c, o := NewCompiler(opts...), NewCompiler(append(opts, observe)...)
+						assert.Equal(t, o.New(path).Select(ary), c.New(path).Select(ary), "%v on %v", query, ary)
+						assert.Equal(t, o.NewFixedModeTree(path).Select(ary), c.NewFixedModeTree(path).Select(ary),
+							"%v on %v", query, ary)
+					}
+				}
+			}
+		}
+	}
+
+	// Compare nextSliceIndex with sliceSelects for every slice bound and
+	// step over small arrays.
+	bounds := []any{nil, -5, -2, -1, 0, 1, 3, 6}
+	for _, start := range bounds {
+		for _, end := range bounds {
+			for _, step := range []any{nil, -3, -2, -1, 1, 2, 3} {
+				slice := spec.Slice(start, end, step)
+				for n := range 7 {
+					for i := range n + 1 {
+						exp := n
+						for j := i; j < n; j++ {
+							if sliceSelects(slice, j, n) {
+								exp = j
+								break
+							}
+						}
+
+						assert.Equal(t, exp, nextSliceIndex(slice, i, n), "[%v] from %v in %v", slice, i, n)
+					}
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkSelect(b *testing.B) {
+	input := make([]any, 10_000)
+	for i := range input {
+		input[i] = map[string]any{
+			"id": i, "name": nil, "tags": []any{"a", nil, "c"},
+			"meta": map[string]any{"labels": map[string]any{}, "attrs": map[string]any{}},
+		}
+	}
+
+	for _, bc := range []struct {
+		name  string
+		paths []string
+	}{
+		{"wildcard", []string{"$[*].tags"}},
+		{"wildcard_nested", []string{"$[*].id", "$[*].tags[1,2]"}},
+		{"sparse", []string{"$[1000:2000:10].name"}},
+		{"most", []string{"$[1:]"}},
+		{"filter_most", []string{"$[?@.id > 10]"}},
+		{"descendant_filter", []string{"$..[?@.x]"}},
+	} {
+		paths := make([]*jsonpath.Path, len(bc.paths))
+		for i, p := range bc.paths {
+			paths[i] = jsonpath.MustParse(p)
+		}
+
+		for _, mode := range []struct {
+			name string
+			tree *Tree
+		}{
+			{"ordered", New(paths...)},
+			{"fixed", NewFixedModeTree(paths...)},
+		} {
+			b.Run(bc.name+"_"+mode.name, func(b *testing.B) {
+				b.ReportAllocs()
+
+				for range b.N {
+					mode.tree.Select(input)
+				}
+			})
+		}
+	}
+}
+
+func TestSelectIndexedNames(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+	r := require.New(t)
+
+	input := map[string]any{}
+	for i := range 40 {
+		input[fmt.Sprintf("k%d", i)] = map[string]any{"v": i, "w": i}
+	}
+
+	paths := make([]*jsonpath.Path, 20)
+	exp := map[string]any{}
+	for i := range paths {
+		key := fmt.Sprintf("k%d", i*2)
+		paths[i] = jsonpath.MustParse(fmt.Sprintf("$.%v.v", key))
+		exp[key] = map[string]any{"v": i * 2}
+	}
+
+	tree := New(paths...)
+	r.NotNil(tree.root.children[0].names)
+	a.Equal(exp, tree.Select(input))
+	a.Len(tree.SelectTo(nil, input), 20)
+	a.Equal(exp, NewFixedModeTree(paths...).Select(input))
+
+	buf := new(strings.Builder)
+	r.NoError(tree.SelectStream(input, buf))
+	data, err := json.Marshal(exp)
+	r.NoError(err)
+	a.JSONEq(string(data), buf.String())
+
+	t.Run("case_insensitive", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		upper := map[string]any{"K2": map[string]any{"v": 2}, "k4": map[string]any{"v": 4}}
+		a.Equal(upper, NewCompiler(WithCaseInsensitiveNames()).New(paths...).Select(upper))
+	})
+
+	t.Run("add_path", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		tree := New(paths...)
+		r.NoError(tree.AddPath(jsonpath.MustParse("$.k1.w")))
+		a.Equal(map[string]any{"w": 1}, tree.Select(input).(map[string]any)["k1"])
+		a.Len(tree.Select(input), 21)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		data, err := json.Marshal(tree)
+		r.NoError(err)
+		decoded := new(Tree)
+		r.NoError(json.Unmarshal(data, decoded))
+		a.NotNil(decoded.root.children[0].names)
+		a.Equal(exp, decoded.Select(input))
+	})
+
+	t.Run("clone_and_resolve", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		a.Equal(exp, tree.Clone().Select(input))
+		a.Equal(exp, tree.ResolveForLength(3).Select(input))
+	})
+
+	t.Run("freeze", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		tree := New(paths...)
+		tree.Freeze()
+		a.NotNil(tree.root.children[0].names)
+		a.Equal(exp, tree.Select(input))
+	})
+}
+
+func BenchmarkSelectNames(b *testing.B) {
+	input := make(map[string]any, 500)
+	for i := range 500 {
+		input[fmt.Sprintf("k%d", i)] = map[string]any{"v": i, "w": i}
+	}
+
+	leaves := make([]*jsonpath.Path, 100)
+	children := make([]*jsonpath.Path, 100)
+	for i := range 100 {
+		leaves[i] = jsonpath.MustParse(fmt.Sprintf("$.k%d", i*5))
+		children[i] = jsonpath.MustParse(fmt.Sprintf("$.k%d.v", i*5))
+	}
+
+	for _, bc := range []struct {
+		name string
+		tree *Tree
+	}{
+		{"leaves", New(leaves...)},
+		{"children", New(children...)},
+		{"case_insensitive", NewCompiler(WithCaseInsensitiveNames()).New(children...)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for range b.N {
+				bc.tree.Select(input)
+			}
+		})
+	}
+}
+
+func TestSelectContext(t *testing.T) {
+	t.Parallel()
+
+	items := make([]any, cancelInterval*8)
+	for i := range items {
+		items[i] = map[string]any{"id": i, "tags": []any{"x", "y"}}
+	}
+	value := map[string]any{"items": items}
+	tree := New(jsonpath.MustParse("$.items[*].id"))
+
+	t.Run("not_cancelled", func(t *testing.T) {
+		t.Parallel()
+		got, err := tree.SelectContext(context.Background(), value)
+		require.NoError(t, err)
+		assert.Equal(t, tree.Select(value), got)
+	})
+
+	t.Run("already_cancelled", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		got, err := tree.SelectContext(ctx, value)
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Nil(t, got)
+	})
+
+	t.Run("cancel_mid_selection", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		visited := 0
+		cancelling := Build(Child(spec.Name("items")).Child(FilterFunc(func(any) bool {
+			visited++
+			if visited == cancelInterval {
+				cancel()
+			}
+
+			return true
+		})).Child(spec.Name("id")))
+
+		got, err := cancelling.SelectContext(ctx, value)
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Nil(t, got)
+		assert.Less(t, visited, len(items))
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		_, err := tree.SelectContext(ctx, value)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("select_errors", func(t *testing.T) {
+		t.Parallel()
+		_, err := tree.SelectContext(context.Background(), "hi")
+		require.ErrorIs(t, err, ErrUnsupported)
+	})
+}
+
+func TestSelectOK(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"a": map[string]any{},
+		"b": []any{},
+		"c": map[string]any{"d": []any{nil, map[string]any{"e": nil}}},
+		"f": 1,
+	}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		input any
+		exp   any
+		ok    bool
+	}{
+		{
+			test:  "root",
+			paths: []string{"$"},
+			input: "hi",
+			exp:   "hi",
+			ok:    true,
+		},
+		{
+			test:  "no_match",
+			paths: []string{"$.x"},
+			input: input,
+			exp:   map[string]any{},
+			ok:    false,
+		},
+		{
+			test:  "empty_object",
+			paths: []string{"$.a"},
+			input: input,
+			exp:   map[string]any{"a": map[string]any{}},
+			ok:    true,
+		},
+		{
+			test:  "empty_array",
+			paths: []string{"$.b"},
+			input: input,
+			exp:   map[string]any{"b": []any{}},
+			ok:    true,
+		},
+		{
+			test:  "empty_array_item",
+			paths: []string{"$[0]"},
+			input: []any{[]any{}},
+			exp:   []any{[]any{}},
+			ok:    true,
+		},
+		{
+			test:  "nested_null",
+			paths: []string{"$.c.d[1].e"},
+			input: input,
+			exp:   map[string]any{"c": map[string]any{"d": []any{map[string]any{"e": nil}}}},
+			ok:    true,
+		},
+		{
+			test:  "nested_no_match",
+			paths: []string{"$.c.d[1].x", "$.f.g"},
+			input: input,
+			exp:   map[string]any{},
+			ok:    false,
+		},
+		{
+			test:  "descendant",
+			paths: []string{"$..e"},
+			input: input,
+			exp:   map[string]any{"c": map[string]any{"d": []any{map[string]any{"e": nil}}}},
+			ok:    true,
+		},
+		{
+			test:  "not_container",
+			paths: []string{"$.x"},
+			input: 42,
+			exp:   nil,
+			ok:    false,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := New(paths...)
+			res, ok := tree.SelectOK(tc.input)
+			a.Equal(tc.exp, res)
+			a.Equal(tc.ok, ok)
+			a.Equal(res, tree.Select(tc.input))
+		})
+	}
+}
+
+func TestCount(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"name":    map[string]any{"first": "Kim", "last": "Wexler"},
+		"ssn":     "123-45-6789",
+		"emails":  []any{"kim@example.com", nil, "kw@example.net"},
+		"clients": []any{map[string]any{"name": "Mesa Verde", "ssn": nil, "size": 12}},
+	}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		input any
+		exp   int
+	}{
+		{
+			test:  "root",
+			paths: []string{"$"},
+			exp:   1,
+		},
+		{
+			test:  "root_scalar",
+			paths: []string{"$"},
+			input: "hi",
+			exp:   1,
+		},
+		{
+			test:  "scalar",
+			paths: []string{"$.x"},
+			input: "hi",
+			exp:   0,
+		},
+		{
+			test:  "names",
+			paths: []string{"$.ssn", "$.name.first", "$.nonesuch"},
+			exp:   2,
+		},
+		{
+			test:  "container",
+			paths: []string{"$.name"},
+			exp:   1,
+		},
+		{
+			test:  "selected_null",
+			paths: []string{"$.emails[1,2]"},
+			exp:   2,
+		},
+		{
+			test:  "wildcard",
+			paths: []string{"$.emails[*]"},
+			exp:   1,
+		},
+		{
+			test:  "wildcard_members",
+			paths: []string{"$.name[*]", "$.emails[0]"},
+			exp:   2,
+		},
+		{
+			test:  "descendant",
+			paths: []string{"$..ssn"},
+			exp:   2,
+		},
+		{
+			test:  "descendant_container",
+			paths: []string{"$..name"},
+			exp:   2,
+		},
+		{
+			test:  "filter",
+			paths: []string{"$.clients[?@.size > 10].name", `$.emails[?@ == "kw@example.net"]`},
+			exp:   2,
+		},
+		{
+			test:  "duplicate_paths",
+			paths: []string{"$.ssn", "$..ssn", "$['ssn']"},
+			exp:   2,
+		},
+		{
+			test:  "no_match",
+			paths: []string{"$.x.y"},
+			exp:   0,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			if tc.input == nil {
+				tc.input = input
+			}
+
+			for _, tree := range []*Tree{New(paths...), NewFixedModeTree(paths...)} {
+				a.Equal(tc.exp, tree.Count(tc.input))
+				a.Equal(tc.exp, countKeys(tree.SelectKeys(tc.input)))
+			}
+		})
+	}
+}
+
+// countKeys returns the number of true values in the result of
+// [Tree.SelectKeys].
+func countKeys(val any) int {
+	switch val := val.(type) {
+	case bool:
+		return 1
+	case map[string]any:
+		n := 0
+		for _, v := range val {
+			n += countKeys(v)
+		}
+		return n
+	case []any:
+		n := 0
+		for _, v := range val {
+			n += countKeys(v)
+		}
+		return n
+	default:
+		return 0
+	}
+}
+
+func TestPrune(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test   string
+		paths  []string
+		length int
+		str    string
+	}{
+		{
+			test:  "nothing_to_prune",
+			paths: []string{"$.a.b", "$..c[1]"},
+			str:   "$\n├── [\"a\"]\n│\u00a0\u00a0 └── [\"b\"]\n└── ..[\"c\"]\n    └── [1]\n",
+		},
+		{
+			test:   "resolved_out_of_range",
+			paths:  []string{"$.a[7].x", "$.b"},
+			length: 2,
+			str:    "$\n└── [\"b\"]\n",
+		},
+		{
+			test:   "all_children_pruned",
+			paths:  []string{"$.a[7].x", "$.a[8,9]", "$.b"},
+			length: 2,
+			str:    "$\n└── [\"b\"]\n",
+		},
+		{
+			test:   "some_children_pruned",
+			paths:  []string{"$.a[7].x", "$.a[1].y", "$.b"},
+			length: 2,
+			str:    "$\n├── [\"a\"]\n│\u00a0\u00a0 └── [1]\n│\u00a0\u00a0     └── [\"y\"]\n└── [\"b\"]\n",
+		},
+		{
+			test:  "zero_step_slice",
+			paths: []string{"$.a[::0].x", "$..b[::0]", "$.c[::0,1]"},
+			str:   "$\n└── [\"c\"]\n    └── [1]\n",
+		},
+		{
+			test:  "all_select_nothing",
+			paths: []string{"$.a[::0]", "$..b[::0]"},
+			str:   "$\n├── [\"a\"]\n│\u00a0\u00a0 └── []\n└── ..[\"b\"]\n    └── []\n",
+		},
+		{
+			test:  "root_only",
+			paths: []string{"$"},
+			str:   "$\n",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := New(paths...)
+			if tc.length > 0 {
+				tree = tree.ResolveForLength(tc.length)
+			}
+
+			input := map[string]any{
+				"a": []any{map[string]any{"x": 1}, map[string]any{"x": 2, "y": 3}},
+				"b": map[string]any{"c": []any{4, 5}},
+				"c": []any{6, 7},
+			}
+			exp := tree.Select(input)
+
+			a.NoError(tree.Prune())
+			a.Equal(tc.str, tree.String())
+			a.Equal(exp, tree.Select(input))
+		})
+	}
+
+	t.Run("built", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		tree := Build(
+			Child(spec.Name("a")).Append(Child(), Child(spec.Name("b"))),
+			Child(spec.Name("c")).Descendant(),
+		)
+		a.Equal("$\n├── [\"a\"]\n│\u00a0\u00a0 └── [\"b\"]\n└── [\"c\"]\n    └── ..[]\n", tree.String())
+		a.NoError(tree.Prune())
+		a.Equal("$\n└── [\"a\"]\n    └── [\"b\"]\n", tree.String())
+	})
+
+	t.Run("frozen", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		tree := New(jsonpath.MustParse("$.a[::0]"), jsonpath.MustParse("$.b"))
+		tree.Freeze()
+		a.ErrorIs(tree.Prune(), ErrFrozen)
+		a.Equal("$\n├── [\"a\"]\n│\u00a0\u00a0 └── []\n└── [\"b\"]\n", tree.String())
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test string
+		segs []*segment
+		errs []string
+	}{
+		{
+			test: "root_only",
+		},
+		{
+			test: "valid",
+			segs: []*segment{
+				child(spec.Name("a")).Append(child(spec.Wildcard()), descendant(spec.Wildcard())),
+				descendant(spec.Name("a")),
+				child(spec.Index(1)).Append(child()),
+			},
+		},
+		{
+			test: "duplicate_siblings",
+			segs: []*segment{
+				child(spec.Name("a"), spec.Index(1)).Append(child(spec.Name("x"))),
+				child(spec.Index(1), spec.Name("a")).Append(child(spec.Name("y"))),
+			},
+			errs: []string{`duplicate sibling segments at $["a",1]`},
+		},
+		{
+			test: "empty_with_children",
+			segs: []*segment{child(spec.Name("a")).Append(descendant().Append(child(spec.Name("x"))))},
+			errs: []string{`empty segment with children at $["a"]..[]`},
+		},
+		{
+			test: "wildcard_with_others",
+			segs: []*segment{child(spec.Name("a")).Append(child(spec.Wildcard(), spec.Index(0)))},
+			errs: []string{`wildcard with other selectors at $["a"][*,0]`},
+		},
+		{
+			test: "multiple",
+			segs: []*segment{
+				child(spec.Wildcard(), spec.Name("a")).Append(
+					child(spec.Name("x")),
+					child(spec.Name("x")),
+				),
+			},
+			errs: []string{
+				`wildcard with other selectors at $[*,"a"]`,
+				`duplicate sibling segments at $[*,"a"]["x"]`,
+			},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			tree := &Tree{root: child().Append(tc.segs...)}
+			orig := tree.String()
+			err := tree.Validate()
+			a.Equal(orig, tree.String())
+
+			if len(tc.errs) == 0 {
+				a.NoError(err)
+				return
+			}
+
+			a.ErrorIs(err, ErrInvalidTree)
+			msgs := make([]string, len(tc.errs))
+			for i, msg := range tc.errs {
+				msgs[i] = "jsontree: invalid tree: " + msg
+			}
+			a.EqualError(err, strings.Join(msgs, "\n"))
+		})
+	}
+
+	// Compiled Trees are valid.
+	for _, tree := range []*Tree{
+		New(jsonpath.MustParse("$.a[*,1]"), jsonpath.MustParse("$..a"), jsonpath.MustParse("$.a[1]")),
+		NewFixedModeTree(jsonpath.MustParse(`$[0:3,?@.x]..["a","b"]`), jsonpath.MustParse("$[0:2]..b")),
+	} {
+		assert.NoError(t, tree.Validate())
+	}
+}
+
+func TestExplain(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	paths := []*jsonpath.Path{
+		jsonpath.MustParse("$.a.b"),
+		jsonpath.MustParse("$.a.c"),
+		jsonpath.MustParse("$.a.b.d"),
+		jsonpath.MustParse("$.x.*"),
+		jsonpath.MustParse("$.y.*.z"),
+		jsonpath.MustParse("$.y..*.z"),
+	}
+
+	tree := New(jsonpath.MustParse("$.q"))
+	orig := tree.String()
+	a.Equal(`path 0: $["a"]["b"]
+  segment 0 ["a"]: appended new segment
+  segment 1 ["b"]: appended new segment
+path 1: $["a"]["c"]
+  segment 0 ["a"]: continued into existing segment ["a"] with the same selectors
+  segment 1 ["c"]: merged selectors into existing segment ["b"] with the same remaining branch
+path 2: $["a"]["b"]["d"]
+  segment 0 ["a"]: continued into existing segment ["a"] with the same selectors
+  segment 1 ["b"]: appended new segment
+  segment 2 ["d"]: appended new segment
+path 3: $["x"][*]
+  segment 0 ["x"]: appended new segment
+  segment 1 [*]: discarded trailing wildcard, which selects the same values as its parent
+path 4: $["y"][*]["z"]
+  segment 0 ["y"]: appended new segment
+  segment 1 [*]: appended new segment
+  segment 2 ["z"]: appended new segment
+path 5: $["y"]..[*]["z"]
+  segment 0 ["y"]: continued into existing segment ["y"] with the same selectors
+  segment 1 ..[*]: descendant wildcard absorbed existing child wildcard with the same remaining branch
+  segment 2 ["z"]: merged selectors into existing segment ["z"] with the same remaining branch
+result:
+`+New(paths...).String(), tree.Explain(paths...))
+	a.Equal(orig, tree.String())
+
+	// A trailing wildcard discards the children of the segment it ends in.
+	paths = []*jsonpath.Path{jsonpath.MustParse("$.a.b"), jsonpath.MustParse("$.a.*")}
+	a.Equal(`path 0: $["a"]["b"]
+  segment 0 ["a"]: appended new segment
+  segment 1 ["b"]: appended new segment
+path 1: $["a"][*]
+  segment 0 ["a"]: continued into existing segment ["a"] with the same selectors
+  segment 1 [*]: discarded trailing wildcard, which selects the same values as its parent
+  segment 1 [*]: discarded the children of existing segment ["a"], since the path ends and selects their values
+result:
+$
+└── ["a"]
+`, tree.Explain(paths...))
+
+	// A path that selects the entire value has no segment to describe.
+	paths = []*jsonpath.Path{jsonpath.MustParse("$")}
+	a.Equal("path 0: $\n  selects the entire value, making the tree root-only\nresult:\n$\n", tree.Explain(paths...))
+}
+
+func TestNewFromStrings(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		err   string
+	}{
+		{
+			test: "no_paths",
+		},
+		{
+			test:  "valid",
+			paths: []string{"$.a.b", "$.a[0,1]", "$..c"},
+		},
+		{
+			test:  "one_invalid",
+			paths: []string{"$.a", "$.b[", "$.c"},
+			err:   `path 1 "$.b[": jsonpath: unexpected eof at position 5`,
+		},
+		{
+			test:  "several_invalid",
+			paths: []string{"a", "$.b", "$[?]"},
+			err: "path 0 \"a\": jsonpath: unexpected identifier at position 1\n" +
+				"path 2 \"$[?]\": jsonpath: unexpected ']' at position 4",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			tree, err := NewFromStrings(tc.paths...)
+			fixed, fixedErr := NewFixedModeTreeFromStrings(tc.paths...)
+
+			if tc.err != "" {
+				a.Nil(tree)
+				a.ErrorIs(err, jsonpath.ErrPathParse)
+				a.EqualError(err, tc.err)
+				a.Nil(fixed)
+				a.Equal(err, fixedErr)
+				a.PanicsWithError(tc.err, func() { MustNewFromStrings(tc.paths...) })
+
+				return
+			}
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			a.NoError(err)
+			a.Equal(New(paths...), tree)
+			a.Equal(New(paths...), MustNewFromStrings(tc.paths...))
+			a.NoError(fixedErr)
+			a.Equal(NewFixedModeTree(paths...), fixed)
+
+			tree, err = NewCompiler(WithSortedKeys()).NewFromStrings(tc.paths...)
+			a.NoError(err)
+			a.Equal(NewCompiler(WithSortedKeys()).New(paths...), tree)
+		})
+	}
+}
+
+func TestSelectN(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"a": []any{"x", "y", "z", "w"},
+		"b": []any{
+			map[string]any{"id": 1, "tags": []any{"p", "q"}},
+			map[string]any{"id": 2, "tags": []any{"r"}},
+			map[string]any{"id": 3, "tags": []any{}},
+		},
+		"c": map[string]any{"d": 1, "e": 2, "f": 3},
+	}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		from  any
+		n     int
+		exp   any
+		fixed any
+	}{
+		{
+			test:  "root_only",
+			from:  input,
+			n:     1,
+			exp:   input,
+			fixed: input,
+		},
+		{
+			test:  "root_only_zero",
+			from:  input,
+			n:     0,
+			exp:   nil,
+			fixed: nil,
+		},
+		{
+			test:  "zero",
+			paths: []string{"$.a[*]"},
+			from:  input,
+			n:     0,
+			exp:   map[string]any{},
+			fixed: map[string]any{},
+		},
+		{
+			test:  "trailing_wildcard_selects_parent",
+			paths: []string{"$.a[*]"},
+			from:  input,
+			n:     1,
+			exp:   map[string]any{"a": []any{"x", "y", "z", "w"}},
+			fixed: map[string]any{"a": []any{"x", "y", "z", "w"}},
+		},
+		{
+			test:  "first_items",
+			paths: []string{"$.a[0:]"},
+			from:  input,
+			n:     2,
+			exp:   map[string]any{"a": []any{"x", "y"}},
+			fixed: map[string]any{"a": []any{"x", "y"}},
+		},
+		{
+			test:  "sparse_items",
+			paths: []string{"$.a[1,3]"},
+			from:  input,
+			n:     1,
+			exp:   map[string]any{"a": []any{"y"}},
+			fixed: map[string]any{"a": []any{nil, "y"}},
+		},
+		{
+			test:  "more_than_selected",
+			paths: []string{"$.a[1:3]"},
+			from:  input,
+			n:     10,
+			exp:   map[string]any{"a": []any{"y", "z"}},
+			fixed: map[string]any{"a": []any{nil, "y", "z"}},
+		},
+		{
+			test:  "across_branches",
+			paths: []string{"$.b[*].tags[0:]"},
+			from:  input,
+			n:     2,
+			exp: map[string]any{"b": []any{
+				map[string]any{"tags": []any{"p", "q"}},
+			}},
+			fixed: map[string]any{"b": []any{
+				map[string]any{"tags": []any{"p", "q"}},
+			}},
+		},
+		{
+			test:  "across_branches_cutoff",
+			paths: []string{"$.b[*].tags[0:]"},
+			from:  input,
+			n:     3,
+			exp: map[string]any{"b": []any{
+				map[string]any{"tags": []any{"p", "q"}},
+				map[string]any{"tags": []any{"r"}},
+			}},
+			fixed: map[string]any{"b": []any{
+				map[string]any{"tags": []any{"p", "q"}},
+				map[string]any{"tags": []any{"r"}},
+			}},
+		},
+		{
+			test:  "filter",
+			paths: []string{"$.b[?@.id > 1].id"},
+			from:  input,
+			n:     1,
+			exp:   map[string]any{"b": []any{map[string]any{"id": 2}}},
+			fixed: map[string]any{"b": []any{nil, map[string]any{"id": 2}}},
+		},
+		{
+			test:  "named_members",
+			paths: []string{"$.c.d", "$.a[0]"},
+			from:  input,
+			n:     5,
+			exp:   map[string]any{"a": []any{"x"}, "c": map[string]any{"d": 1}},
+			fixed: map[string]any{"a": []any{"x"}, "c": map[string]any{"d": 1}},
+		},
+		{
+			test:  "array_root",
+			paths: []string{"$[0:]"},
+			from:  []any{1, 2, 3},
+			n:     2,
+			exp:   []any{1, 2},
+			fixed: []any{1, 2},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := New(paths...)
+			fixed := NewFixedModeTree(paths...)
+			a.Equal(tc.exp, tree.SelectN(tc.from, tc.n))
+			a.Equal(tc.fixed, fixed.SelectN(tc.from, tc.n))
+
+			// SelectStreamN writes the same values.
+			for tree, exp := range map[*Tree]any{tree: tc.exp, fixed: tc.fixed} {
+				data, err := json.Marshal(exp)
+				require.NoError(t, err)
+				buf := new(strings.Builder)
+				require.NoError(t, tree.SelectStreamN(tc.from, tc.n, buf))
+				a.Equal(string(data), buf.String())
+			}
+
+			// SelectN does not limit later selections.
+			a.Equal(New(paths...).Select(tc.from), tree.Select(tc.from))
+		})
+	}
+
+	// Sorted keys make the members selected before the cutoff deterministic.
+	tree := NewCompiler(WithSortedKeys()).New(jsonpath.MustParse("$.c[?@]"))
+	for range 10 {
+		assert.Equal(t, map[string]any{"c": map[string]any{"d": 1, "e": 2}}, tree.SelectN(input, 2))
+	}
+}
+
+func TestDepthAndSize(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		depth int
+		size  int
+	}{
+		{
+			test: "root_only",
+		},
+		{
+			test:  "trailing_wildcard",
+			paths: []string{"$.*"},
+		},
+		{
+			test:  "one_segment",
+			paths: []string{"$.a"},
+			depth: 1,
+			size:  1,
+		},
+		{
+			test:  "one_branch",
+			paths: []string{"$.a[0]..b"},
+			depth: 3,
+			size:  3,
+		},
+		{
+			test:  "merged",
+			paths: []string{"$.a.x", "$.b.x", "$.c"},
+			depth: 2,
+			size:  3,
+		},
+		{
+			test:  "branches",
+			paths: []string{"$.a.b.c.d", "$.a.x", "$.y[1]"},
+			depth: 4,
+			size:  7,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := New(paths...)
+			a.Equal(tc.depth, tree.Depth())
+			a.Equal(tc.size, tree.Size())
+		})
+	}
+}
+
+func TestContains(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		path  string
+		exp   bool
+	}{
+		{
+			test: "root_only_contains_all",
+			path: "$.a..b",
+			exp:  true,
+		},
+		{
+			test:  "root_only_path",
+			paths: []string{"$.a"},
+			path:  "$",
+			exp:   false,
+		},
+		{
+			test:  "root_only_wildcard",
+			paths: []string{"$.a"},
+			path:  "$.*",
+			exp:   false,
+		},
+		{
+			test:  "same_path",
+			paths: []string{"$.a.b", "$.c"},
+			path:  "$.a.b",
+			exp:   true,
+		},
+		{
+			test:  "parent_selected",
+			paths: []string{"$.a"},
+			path:  "$.a.b[0]",
+			exp:   true,
+		},
+		{
+			test:  "child_of_selected",
+			paths: []string{"$.a.b"},
+			path:  "$.a",
+			exp:   false,
+		},
+		{
+			test:  "wildcard_selects_name",
+			paths: []string{"$[*].b"},
+			path:  "$.x.b",
+			exp:   true,
+		},
+		{
+			test:  "index_in_slice",
+			paths: []string{"$.a[0:5]"},
+			path:  "$.a[3]",
+			exp:   true,
+		},
+		{
+			test:  "index_outside_slice",
+			paths: []string{"$.a[0:5]"},
+			path:  "$.a[7]",
+			exp:   false,
+		},
+		{
+			test:  "trailing_wildcard",
+			paths: []string{"$.a"},
+			path:  "$.a.*",
+			exp:   true,
+		},
+		{
+			test:  "equivalent_filter",
+			paths: []string{"$.a[?@.x > 1 && @.y]"},
+			path:  "$.a[?@.y && 1 < @.x]",
+			exp:   true,
+		},
+		{
+			test:  "different_name",
+			paths: []string{"$.a.b"},
+			path:  "$.a.c",
+			exp:   false,
+		},
+		{
+			test:  "descendant_not_recognized",
+			paths: []string{"$..b"},
+			path:  "$.a.b",
+			exp:   false,
+		},
+		{
+			test:  "neg_index_not_in_slice",
+			paths: []string{"$[0:2]"},
+			path:  "$[-1]",
+			exp:   false,
+		},
+		{
+			test:  "neg_index_not_in_open_slice",
+			paths: []string{"$[1:]"},
+			path:  "$[-1]",
+			exp:   false,
+		},
+		{
+			test:  "descendant_neg_index_not_in_step",
+			paths: []string{"$..[::2]"},
+			path:  "$..[-1]",
+			exp:   false,
+		},
+		{
+			test:  "neg_index_not_in_index",
+			paths: []string{"$[4]"},
+			path:  "$[-1]",
+			exp:   false,
+		},
+		{
+			test:  "neg_index_in_all",
+			paths: []string{"$[:]"},
+			path:  "$[-1]",
+			exp:   true,
+		},
+		{
+			test:  "neg_index_in_neg_slice",
+			paths: []string{"$[-3:]"},
+			path:  "$[-3]",
+			exp:   true,
+		},
+		{
+			test:  "same_neg_index",
+			paths: []string{"$[-2]"},
+			path:  "$[-2]",
+			exp:   true,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := New(paths...)
+			orig := tree.String()
+			a.Equal(tc.exp, tree.Contains(jsonpath.MustParse(tc.path)))
+			a.Equal(orig, tree.String())
+		})
+	}
+}
+
+func TestContainsArrays(t *testing.T) {
+	t.Parallel()
+
+	// Exhaustively compare Contains against jsonpath selection for pairs of
+	// array selectors, to make sure it never claims to contain a path that
+	// selects values the tree does not for some array length.
+	sels := []string{
+		"0", "1", "3", "-1", "-2", "-4",
+		":", "0:2", "1:", "-3:", "::2", "::-1", "-1:-3:-1", "1:4", ":-1", "3:0:-1",
+	}
+
+	arrays := make([][]any, 8)
+	for n := range arrays {
+		arrays[n] = make([]any, n)
+		for i := range n {
+			arrays[n][i] = i
+		}
+	}
+
+	for _, prefix := range []string{"$", "$.."} {
+		for i, x := range sels {
+			for _, y := range sels[i:] {
+				tree := MustNewFromStrings(prefix+"["+x+"]", prefix+"["+y+"]")
+				for _, z := range sels {
+					path := jsonpath.MustParse(prefix + "[" + z + "]")
+					if !tree.Contains(path) {
+						continue
+					}
+
+					for _, ary := range arrays {
+						got := tree.Select(ary)
+						for _, v := range path.Select(ary) {
+							assert.Contains(t, got, v, "%v contains %v for %v", tree.Paths(), path, ary)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSelectFromRoot(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	payload := map[string]any{"items": []any{1, 2, 3}, "limit": 3}
+	doc := map[string]any{"limit": 2, "payload": payload, "list": []any{4, 1, 5}}
+
+	// Root queries refer to root rather than the value selected from.
+	tree := New(jsonpath.MustParse("$.items[?@ >= $.limit]"))
+	a.Equal(map[string]any{"items": []any{2, 3}}, tree.SelectFromRoot(payload, doc))
+	a.Equal(map[string]any{"items": []any{3}}, tree.Select(payload))
+	a.Equal(tree.Select(payload), tree.SelectFromRoot(payload, payload))
+
+	// Select from an array.
+	tree = New(jsonpath.MustParse("$[?@ > $.limit]"))
+	a.Equal([]any{4, 5}, tree.SelectFromRoot(doc["list"], doc))
+
+	// Select from a raw JSON value.
+	raw := json.RawMessage(`{"items": [1, 2, 3]}`)
+	tree = New(jsonpath.MustParse("$.items[?@ >= $.limit]"))
+	got, err := json.Marshal(tree.SelectFromRoot(raw, doc))
+	require.NoError(t, err)
+	a.JSONEq(`{"items": [2, 3]}`, string(got))
+
+	// Root-only Trees select from in its entirety.
+	a.Equal(payload, New().SelectFromRoot(payload, doc))
+	a.Nil(tree.SelectFromRoot(42, doc))
 }
@@ -0,0 +1,276 @@
+package jsontree
+
+import (
+	"iter"
+	"maps"
+	"slices"
+
+	"github.com/theory/jsonpath/spec"
+)
+
+// SelectTo selects tree's paths from the from JSON value and appends the
+// values they select to dst, discarding the structure of from, and returns
+// the extended slice. Use it to accumulate the values selected from many
+// inputs into a single, reusable buffer:
+//
+//	buf = tree.SelectTo(buf[:0], doc)
+//
+// SelectTo appends the values [Tree.Walk] passes to its function, in the
+// same order: document order for array items and object members selected
+// only by name, and map iteration order for other object members, unless
+// tree was configured by [WithSortedKeys]. Each selected value appears
+// once, no matter how many paths select it, and values nested in another
+// selected value appear only as part of it, as in the value returned by
+// [Tree.Select]. A root-only Tree appends from itself, as do paths with a
+// single trailing wildcard, such as $.*, because Trees treat a trailing
+// wildcard as selecting its parent.
+// Otherwise SelectTo appends nothing when from is neither an array nor an
+// object, or when tree selects no values from it.
+func (tree *Tree) SelectTo(dst []any, from any) []any {
+	tree.Walk(from, func(_ spec.NormalizedPath, val any) bool {
+		dst = append(dst, tree.ownValue(val))
+		return true
+	})
+
+	return dst
+}
+
+// SelectValues selects tree's paths from the from JSON value and returns the
+// scalar values in the selection, discarding its structure. Where
+// [Tree.SelectTo] returns values in the order the query selects them,
+// SelectValues returns them in document order: depth first, with array items
+// in index order and object members in key order, since Go maps retain no
+// source order. It returns each selected value once, no matter how many
+// paths select it, and returns the scalar values nested in selected objects
+// and arrays, so that empty objects and arrays contribute nothing. It omits
+// array positions that tree did not select, including in fixed mode and for
+// Trees configured by [WithNullGapThreshold]. Returns nil when tree selects
+// no scalar values from from.
+func (tree *Tree) SelectValues(from any) []any {
+	sel := *tree
+	sel.gap = unselected
+	sel.nullGap = 0
+
+	val := sel.Select(from)
+	if len(tree.root.children) > 0 {
+		switch val.(type) {
+		case map[string]any, []any:
+		default:
+			// Selected nothing from a scalar.
+			return nil
+		}
+	}
+
+	return appendScalars(nil, val)
+}
+
+// appendScalars appends the scalar values in val to dst, depth first and in
+// document order, and returns the extended slice. Skips unselected array
+// positions.
+func appendScalars(dst []any, val any) []any {
+	switch val := val.(type) {
+	case map[string]any:
+		for _, k := range slices.Sorted(maps.Keys(val)) {
+			dst = appendScalars(dst, val[k])
+		}
+	case []any:
+		for _, v := range val {
+			dst = appendScalars(dst, v)
+		}
+	case gapVal:
+	default:
+		dst = append(dst, val)
+	}
+
+	return dst
+}
+
+// entries returns an iterator over the keys and values of obj, in sorted key
+// order if tree was configured by [WithSortedKeys] and in map iteration order
+// otherwise.
+func (tree *Tree) entries(obj map[string]any) iter.Seq2[string, any] {
+	if !tree.sorted {
+		return maps.All(obj)
+	}
+
+	return func(yield func(string, any) bool) {
+		for _, k := range slices.Sorted(maps.Keys(obj)) {
+			if !yield(k, obj[k]) {
+				return
+			}
+		}
+	}
+}
+
+// Walk selects tree's paths from the from JSON value like [Tree.Select], but
+// rather than building the selected value, it calls fn with each value
+// Select would select at the end of a path, and with the normalized path
+// that locates the value in from. Walk stops when fn returns false. It visits
+// array items in index order, object members selected only by name in the
+// order of the names, and other object members in map iteration order,
+// unless tree was configured by [WithSortedKeys]. A root-only Tree calls fn
+// once with an empty path and from itself; otherwise Walk calls nothing when
+// from is neither an array nor an object. Walk reuses the storage of path
+// for subsequent calls, so fn must copy it to retain it.
+func (tree *Tree) Walk(from any, fn func(path spec.NormalizedPath, val any) bool) {
+	if len(tree.root.children) == 0 {
+		fn(spec.NormalizedPath{}, from)
+		return
+	}
+
+	path := make(spec.NormalizedPath, 0, 8) //nolint:mnd
+
+	switch cur := decodeRaw(from).(type) {
+	case map[string]any:
+		tree.walkObject(tree.root.children, cur, cur, path, 0, fn)
+	case []any:
+		tree.walkArray(tree.root.children, cur, cur, path, 0, fn)
+	}
+}
+
+// SelectFirst returns the first value [Tree.Walk] passes to its function,
+// and true, stopping selection as soon as it finds it, or nil and false if
+// tree selects no value from from. So that the first value is predictable,
+// SelectFirst walks as if tree was configured by [WithSortedKeys]: depth
+// first, visiting array items in index order, object members selected only
+// by name selectors in the order of the names in tree's segments, as shown
+// by [Tree.String], and all other object members in sorted key order. A
+// root-only Tree returns from itself. Returns a copy of the value if tree
+// was configured by [WithCopyLeaves].
+func (tree *Tree) SelectFirst(from any) (any, bool) {
+	sel := *tree
+	sel.sorted = true
+
+	var first any
+
+	found := false
+
+	sel.Walk(from, func(_ spec.NormalizedPath, val any) bool {
+		first, found = val, true
+		return false
+	})
+
+	if !found {
+		return nil, false
+	}
+
+	return tree.ownValue(first), true
+}
+
+// SelectPaths returns the RFC 9535 normalized path, such as $['a'][0], of
+// each value [Tree.Walk] passes to its function, resolving the wildcards,
+// slices, filters, and descendant segments of tree's paths to the names and
+// indexes of the values they select. So that the order of the paths is
+// deterministic, SelectPaths walks as [Tree.SelectFirst] does. A root-only
+// Tree returns a single path, "$". Returns nil if tree selects no values
+// from from.
+func (tree *Tree) SelectPaths(from any) []string {
+	sel := *tree
+	sel.sorted = true
+
+	var paths []string
+
+	sel.Walk(from, func(path spec.NormalizedPath, _ any) bool {
+		paths = append(paths, path.String())
+		return true
+	})
+
+	return paths
+}
+
+// walkValue passes val, selected by s and located at path, to fn if s.leaf
+// is true, and otherwise walks the object or array selected from val by the
+// segments in s, as for [Tree.selectValue]. Returns false if fn returns
+// false to stop walking.
+func (tree *Tree) walkValue(
+	s *selection, root, val any, path spec.NormalizedPath, fn func(spec.NormalizedPath, any) bool,
+) bool {
+	if s.leaf {
+		return fn(path, val)
+	}
+
+	if len(s.segs) == 0 || tree.tooDeep(s.depth) {
+		return true
+	}
+
+	// Copy the segments, since the caller reuses s.
+	var buf [4]*segment
+	segs := append(buf[:0], s.segs...)
+
+	switch val := decodeRaw(val).(type) {
+	case map[string]any:
+		return tree.walkObject(segs, root, val, path, s.depth, fn)
+	case []any:
+		return tree.walkArray(segs, root, val, path, s.depth, fn)
+	default:
+		return true
+	}
+}
+
+// walkObject walks the values selected from cur, nested depth levels below
+// root and located at path, by the selectors of each segment in segs, as
+// for [Tree.selectObject]. Returns false if fn returns false to stop
+// walking.
+func (tree *Tree) walkObject(
+	segs []*segment, root any, cur map[string]any, path spec.NormalizedPath, depth int,
+	fn func(spec.NormalizedPath, any) bool,
+) bool {
+	s := selection{depth: depth + 1}
+	visit := func(key string, val any) bool {
+		s.reset(val)
+		tree.markMember(segs, root, key, val, &s)
+
+		return tree.walkValue(&s, root, val, append(path, spec.Name(key)), fn)
+	}
+
+	if tree.selectsAllMembers(segs) {
+		for k, v := range tree.entries(cur) {
+			if !visit(k, v) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	// Visit only the named members, once each.
+	var done []spec.Name
+
+	for _, seg := range segs {
+		for _, sel := range seg.selectors {
+			name, ok := sel.(spec.Name)
+			if !ok || slices.Contains(done, name) {
+				continue
+			}
+
+			done = append(done, name)
+			if v, ok := cur[string(name)]; ok && !visit(string(name), v) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// walkArray walks the values selected from cur, nested depth levels below
+// root and located at path, by the selectors of each segment in segs, as
+// for [Tree.selectArray]. Returns false if fn returns false to stop walking.
+func (tree *Tree) walkArray(
+	segs []*segment, root any, cur []any, path spec.NormalizedPath, depth int,
+	fn func(spec.NormalizedPath, any) bool,
+) bool {
+	s := selection{depth: depth + 1}
+
+	lower, upper := tree.selectedRange(segs, len(cur))
+	for i := lower; i < upper; i++ {
+		s.reset(cur[i])
+		tree.markItem(segs, root, i, cur, &s)
+
+		if !tree.walkValue(&s, root, cur[i], append(path, spec.Index(i)), fn) {
+			return false
+		}
+	}
+
+	return true
+}
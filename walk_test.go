@@ -0,0 +1,640 @@
+package jsontree
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/theory/jsonpath"
+	"github.com/theory/jsonpath/spec"
+)
+
+func TestSelectTo(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		input any
+		dst   []any
+		exp   []any
+	}{
+		{
+			test:  "root",
+			paths: []string{"$"},
+			input: map[string]any{"x": 1},
+			exp:   []any{map[string]any{"x": 1}},
+		},
+		{
+			test:  "root_scalar",
+			paths: []string{"$"},
+			input: "hi",
+			dst:   []any{1},
+			exp:   []any{1, "hi"},
+		},
+		{
+			test:  "scalar",
+			paths: []string{"$.x"},
+			input: "hi",
+			dst:   []any{1},
+			exp:   []any{1},
+		},
+		{
+			test:  "one_name",
+			paths: []string{"$.x"},
+			input: map[string]any{"x": 1, "y": 2},
+			exp:   []any{1},
+		},
+		{
+			test:  "append",
+			paths: []string{"$.x"},
+			input: map[string]any{"x": 1, "y": 2},
+			dst:   []any{"a", "b"},
+			exp:   []any{"a", "b", 1},
+		},
+		{
+			test:  "nested_container",
+			paths: []string{"$.x.y"},
+			input: map[string]any{"x": map[string]any{"y": []any{1, 2}, "z": 3}},
+			exp:   []any{[]any{1, 2}},
+		},
+		{
+			test:  "nested_name_not_repeated",
+			paths: []string{"$.a.b"},
+			input: map[string]any{"a": map[string]any{"a": map[string]any{"b": 9}}},
+		},
+		{
+			test:  "indexes",
+			paths: []string{"$[2, 0, -1]"},
+			input: []any{"a", "b", "c", "d"},
+			exp:   []any{"a", "c", "d"},
+		},
+		{
+			test:  "out_of_range",
+			paths: []string{"$[4, -5]"},
+			input: []any{"a", "b", "c", "d"},
+		},
+		{
+			test:  "slices",
+			paths: []string{"$[1:3]", "$[::-2]"},
+			input: []any{"a", "b", "c", "d"},
+			exp:   []any{"b", "c", "d"},
+		},
+		{
+			test:  "wildcard_array",
+			paths: []string{"$[*].x"},
+			input: []any{map[string]any{"x": 1}, map[string]any{"y": 2}, map[string]any{"x": 3}},
+			exp:   []any{1, 3},
+		},
+		{
+			test:  "filter",
+			paths: []string{"$[?@ > 1]"},
+			input: []any{1, 2, 3, 0},
+			exp:   []any{2, 3},
+		},
+		{
+			test:  "descendant",
+			paths: []string{"$..a"},
+			input: map[string]any{"a": map[string]any{"a": 1}},
+			// Nested values are part of the selected value.
+			exp: []any{map[string]any{"a": 1}},
+		},
+		{
+			test:  "descendant_array",
+			paths: []string{"$..[0]"},
+			input: []any{[]any{"x", "y"}, "z"},
+			exp:   []any{[]any{"x", "y"}},
+		},
+		{
+			test:  "descendant_child",
+			paths: []string{"$..a.b"},
+			input: map[string]any{"x": map[string]any{"b": 1, "a": map[string]any{"b": 2}}},
+			exp:   []any{2},
+		},
+		{
+			test:  "null",
+			paths: []string{"$[1]"},
+			input: []any{1, nil},
+			exp:   []any{nil},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			a.Equal(tc.exp, New(paths...).SelectTo(tc.dst, tc.input))
+			a.Equal(tc.exp, NewFixedModeTree(paths...).SelectTo(tc.dst, tc.input))
+		})
+	}
+
+	t.Run("objects", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		input := map[string]any{"a": 1, "b": 2, "c": map[string]any{"d": 3, "e": 4}}
+
+		tree := New(jsonpath.MustParse("$.*"))
+		a.Equal([]any{input}, tree.SelectTo(nil, input))
+
+		tree = New(jsonpath.MustParse("$.*.*"))
+		a.ElementsMatch([]any{1, 2, map[string]any{"d": 3, "e": 4}}, tree.SelectTo(nil, input))
+
+		tree = NewCompiler(WithExcludeKeys("b")).New(jsonpath.MustParse("$.*"))
+		a.ElementsMatch([]any{1, map[string]any{"d": 3, "e": 4}}, tree.SelectTo(nil, input))
+
+		tree = NewCompiler(WithExcludeKeys("b", "e")).New(jsonpath.MustParse("$.*.*"))
+		a.ElementsMatch([]any{3}, tree.SelectTo(nil, input))
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		raw := json.RawMessage(`{"a":[1,{"b":2}],"c":true}`)
+		a.Equal([]any{json.RawMessage("1"), json.RawMessage("2")}, New(
+			jsonpath.MustParse("$.a[0]"), jsonpath.MustParse("$.a[1].b"),
+		).SelectTo(nil, raw))
+		a.Equal([]any{raw}, New().SelectTo(nil, raw))
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		input := map[string]any{"a": map[string]any{"b": 1}}
+		res := NewCompiler(WithCopyLeaves()).New(jsonpath.MustParse("$.a")).SelectTo(nil, input)
+		a.Equal([]any{map[string]any{"b": 1}}, res)
+		res[0].(map[string]any)["b"] = 2
+		a.Equal(map[string]any{"a": map[string]any{"b": 1}}, input)
+	})
+}
+
+func TestSelectValues(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"b": []any{1, nil, map[string]any{"z": "z", "y": "y"}, 4},
+		"a": map[string]any{"x": true, "e": []any{}},
+		"c": 3,
+	}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		fixed bool
+		opts  []Option
+		input any
+		exp   []any
+	}{
+		{
+			test:  "root",
+			paths: []string{"$"},
+			input: input,
+			exp:   []any{true, 1, nil, "y", "z", 4, 3},
+		},
+		{
+			test:  "root_scalar",
+			paths: []string{"$"},
+			input: "hi",
+			exp:   []any{"hi"},
+		},
+		{
+			test:  "scalar",
+			paths: []string{"$.x"},
+			input: "hi",
+		},
+		{
+			test:  "nothing",
+			paths: []string{"$.x"},
+			input: input,
+		},
+		{
+			test:  "document_order",
+			paths: []string{"$.c", "$.b[3, 0]", "$.a.x"},
+			input: input,
+			exp:   []any{true, 1, 4, 3},
+		},
+		{
+			test:  "once",
+			paths: []string{"$.b[0]", "$.b[0:1]", "$..[?@ == 1]"},
+			input: input,
+			exp:   []any{1},
+		},
+		{
+			test:  "selected_containers",
+			paths: []string{"$.a", "$.b[2]"},
+			input: input,
+			exp:   []any{true, "y", "z"},
+		},
+		{
+			test:  "selected_null",
+			paths: []string{"$.b[1]"},
+			input: input,
+			exp:   []any{nil},
+		},
+		{
+			test:  "descendants",
+			paths: []string{"$..y", "$..[?@ == 3]"},
+			input: input,
+			exp:   []any{"y", 3},
+		},
+		{
+			test:  "fixed_mode",
+			paths: []string{"$.b[3]"},
+			fixed: true,
+			input: input,
+			exp:   []any{4},
+		},
+		{
+			test:  "null_gap",
+			paths: []string{"$.b[3]"},
+			opts:  []Option{WithNullGapThreshold(5)},
+			input: input,
+			exp:   []any{4},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			compiler := NewCompiler(tc.opts...)
+			tree := compiler.New(paths...)
+			if tc.fixed {
+				tree = compiler.NewFixedModeTree(paths...)
+			}
+
+			assert.Equal(t, tc.exp, tree.SelectValues(tc.input))
+		})
+	}
+}
+
+func BenchmarkSelectTo(b *testing.B) {
+	input := make([]any, 100)
+	for i := range input {
+		input[i] = map[string]any{"id": i, "name": "x", "tags": []any{"a", "b"}}
+	}
+
+	tree := New(jsonpath.MustParse("$[*].id"), jsonpath.MustParse("$[*].tags[0]"))
+
+	b.Run("select_and_append", func(b *testing.B) {
+		b.ReportAllocs()
+
+		var buf []any
+		for range b.N {
+			buf = buf[:0]
+			for _, item := range tree.Select(input).([]any) {
+				obj, _ := item.(map[string]any)
+				buf = append(buf, obj["id"], obj["tags"].([]any)[0])
+			}
+		}
+	})
+
+	b.Run("select_to", func(b *testing.B) {
+		b.ReportAllocs()
+
+		var buf []any
+		for range b.N {
+			buf = tree.SelectTo(buf[:0], input)
+		}
+	})
+}
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"name":    map[string]any{"first": "Kim", "last": "Wexler"},
+		"ssn":     "123-45-6789",
+		"emails":  []any{"kim@example.com", nil, "kw@example.net"},
+		"clients": []any{map[string]any{"name": "Mesa Verde", "ssn": nil, "size": 12}},
+	}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		input any
+		exp   map[string]any
+	}{
+		{
+			test:  "root",
+			paths: []string{"$"},
+			input: "hi",
+			exp:   map[string]any{"$": "hi"},
+		},
+		{
+			test:  "scalar",
+			paths: []string{"$.x"},
+			input: "hi",
+			exp:   map[string]any{},
+		},
+		{
+			test:  "names",
+			paths: []string{"$.ssn", "$.name.first", "$['ssn']", "$.nonesuch"},
+			exp:   map[string]any{"$['ssn']": "123-45-6789", "$['name']['first']": "Kim"},
+		},
+		{
+			test:  "container",
+			paths: []string{"$.name"},
+			exp:   map[string]any{"$['name']": input["name"]},
+		},
+		{
+			test:  "indexes",
+			paths: []string{"$.emails[-1,1]"},
+			exp:   map[string]any{"$['emails'][1]": nil, "$['emails'][2]": "kw@example.net"},
+		},
+		{
+			test:  "descendant",
+			paths: []string{"$..ssn"},
+			exp:   map[string]any{"$['ssn']": "123-45-6789", "$['clients'][0]['ssn']": nil},
+		},
+		{
+			test:  "filter",
+			paths: []string{"$.clients[?@.size > 10].name"},
+			exp:   map[string]any{"$['clients'][0]['name']": "Mesa Verde"},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			if tc.input == nil {
+				tc.input = input
+			}
+
+			for _, tree := range []*Tree{New(paths...), NewFixedModeTree(paths...)} {
+				got := map[string]any{}
+				tree.Walk(tc.input, func(path spec.NormalizedPath, val any) bool {
+					a.NotContains(got, path.String())
+					got[path.String()] = val
+					return true
+				})
+				a.Equal(tc.exp, got)
+				a.Len(got, tree.Count(tc.input))
+			}
+		})
+	}
+
+	t.Run("stop", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		tree := NewCompiler(WithSortedKeys()).New(jsonpath.MustParse("$[?@]"))
+		var got []string
+		tree.Walk(input, func(path spec.NormalizedPath, _ any) bool {
+			got = append(got, path.String())
+			return len(got) < 3
+		})
+		a.Equal([]string{"$['clients']", "$['emails']", "$['name']"}, got)
+	})
+
+	t.Run("order", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		tree := NewCompiler(WithSortedKeys()).New(
+			jsonpath.MustParse("$.clients[0]['ssn','name','size']"),
+			jsonpath.MustParse("$.emails[2,0]"),
+		)
+		var got []string
+		tree.Walk(input, func(path spec.NormalizedPath, _ any) bool {
+			got = append(got, path.Pointer())
+			return true
+		})
+		a.Equal([]string{
+			"/clients/0/ssn", "/clients/0/name", "/clients/0/size", "/emails/0", "/emails/2",
+		}, got)
+	})
+}
+
+func TestSelectFirst(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"profile": map[string]any{
+			"phones": map[string]any{"primary": "+1-234-567-8901", "fax": "+1-293-847-5829"},
+			"email":  map[string]any{"primary": "foo@example.com", "secondary": "2nd@example.net"},
+			"tags":   []any{nil, "a", "b"},
+		},
+		"accounts": []any{
+			map[string]any{"email": "x@example.org"},
+			map[string]any{"email": "y@example.org"},
+		},
+	}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		input any
+		exp   any
+		found bool
+	}{
+		{
+			test:  "root",
+			paths: []string{"$"},
+			input: "hi",
+			exp:   "hi",
+			found: true,
+		},
+		{
+			test:  "scalar",
+			paths: []string{"$.x"},
+			input: "hi",
+		},
+		{
+			test:  "nothing",
+			paths: []string{"$.profile.nonesuch", "$..nonesuch"},
+			input: input,
+		},
+		{
+			test:  "name_order",
+			paths: []string{`$.profile["phones","email"].primary`},
+			input: input,
+			exp:   "+1-234-567-8901",
+			found: true,
+		},
+		{
+			test:  "sorted_keys",
+			paths: []string{"$.profile.*.primary"},
+			input: input,
+			exp:   "foo@example.com",
+			found: true,
+		},
+		{
+			test:  "descendant",
+			paths: []string{"$..email"},
+			input: input,
+			exp:   "x@example.org",
+			found: true,
+		},
+		{
+			test:  "depth_first",
+			paths: []string{"$..primary", "$.accounts[1].email"},
+			input: input,
+			exp:   "y@example.org",
+			found: true,
+		},
+		{
+			test:  "index_order",
+			paths: []string{"$.profile.tags[2,0]"},
+			input: input,
+			exp:   nil,
+			found: true,
+		},
+		{
+			test:  "container",
+			paths: []string{"$.profile.email"},
+			input: input,
+			exp:   map[string]any{"primary": "foo@example.com", "secondary": "2nd@example.net"},
+			found: true,
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			tree := New(paths...)
+			for range 10 {
+				val, found := tree.SelectFirst(tc.input)
+				a.Equal(tc.exp, val)
+				a.Equal(tc.found, found)
+			}
+		})
+	}
+
+	t.Run("stops", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		matched := 0
+		tree := NewCompiler(WithObserver(func(*Segment) { matched++ })).New(
+			jsonpath.MustParse("$[?@ > 0]"),
+		)
+		val, found := tree.SelectFirst([]any{1, 2, 3, 4})
+		a.Equal(1, val)
+		a.True(found)
+		a.Equal(1, matched)
+	})
+
+	t.Run("copy_leaves", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		tree := NewCompiler(WithCopyLeaves()).New(jsonpath.MustParse("$.profile.email"))
+		val, found := tree.SelectFirst(input)
+		a.True(found)
+		email, ok := val.(map[string]any)
+		a.True(ok)
+		a.Equal(input["profile"].(map[string]any)["email"], email)
+
+		// Modifying the copy does not modify input.
+		email["primary"] = "bar@example.com"
+		a.Equal("foo@example.com", input["profile"].(map[string]any)["email"].(map[string]any)["primary"])
+	})
+}
+
+func TestSelectPaths(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]any{
+		"name":    map[string]any{"first": "Kim", "last": "Wexler"},
+		"ssn":     "123-45-6789",
+		"emails":  []any{"kim@example.com", nil, "kw@example.net"},
+		"clients": []any{map[string]any{"name": "Mesa Verde", "ssn": nil, "size": 12}},
+		"it's":    map[string]any{"a\tb": true},
+	}
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		input any
+		exp   []string
+	}{
+		{
+			test:  "root",
+			paths: []string{"$"},
+			input: "hi",
+			exp:   []string{"$"},
+		},
+		{
+			test:  "scalar",
+			paths: []string{"$.x"},
+			input: "hi",
+		},
+		{
+			test:  "nothing",
+			paths: []string{"$.nonesuch"},
+			input: input,
+		},
+		{
+			test:  "names",
+			paths: []string{"$.ssn", "$.name.first"},
+			input: input,
+			exp:   []string{"$['ssn']", "$['name']['first']"},
+		},
+		{
+			test:  "escapes",
+			paths: []string{`$["it's"]["a\tb"]`},
+			input: input,
+			exp:   []string{`$['it\'s']['a\tb']`},
+		},
+		{
+			test:  "slice",
+			paths: []string{"$.emails[1:]"},
+			input: input,
+			exp:   []string{"$['emails'][1]", "$['emails'][2]"},
+		},
+		{
+			test:  "negative_index",
+			paths: []string{"$.emails[-1]"},
+			input: input,
+			exp:   []string{"$['emails'][2]"},
+		},
+		{
+			test:  "wildcard_filter",
+			paths: []string{"$.name[*].x", "$.name[?@]"},
+			input: input,
+			exp:   []string{"$['name']['first']", "$['name']['last']"},
+		},
+		{
+			test:  "descendant",
+			paths: []string{"$..ssn"},
+			input: input,
+			exp:   []string{"$['clients'][0]['ssn']", "$['ssn']"},
+		},
+		{
+			test:  "filter",
+			paths: []string{"$.clients[?@.size > 10].name"},
+			input: input,
+			exp:   []string{"$['clients'][0]['name']"},
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			assert.Equal(t, tc.exp, New(paths...).SelectPaths(tc.input))
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package jsontree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrYAML errors are returned by [Tree.SelectYAML] and related methods.
+var ErrYAML = errors.New("jsontree: yaml")
+
+// Codec defines the interface for decoding and encoding a serialization
+// format. The jsontree package does not depend on any YAML implementation;
+// instead, configure a Tree with [WithYAMLCodec] and a Codec that wraps the
+// YAML package of your choice. For example, a Codec for
+// [gopkg.in/yaml.v3]:
+//
+//	type yamlCodec struct{}
+//
+//	func (yamlCodec) Marshal(v any) ([]byte, error)      { return yaml.Marshal(v) }
+//	func (yamlCodec) Unmarshal(data []byte, v any) error { return yaml.Unmarshal(data, v) }
+//
+// [gopkg.in/yaml.v3]: https://pkg.go.dev/gopkg.in/yaml.v3
+type Codec interface {
+	// Marshal encodes v.
+	Marshal(v any) ([]byte, error)
+
+	// Unmarshal decodes data into the value pointed to by v.
+	Unmarshal(data []byte, v any) error
+}
+
+// SelectYAML decodes src with the [Codec] configured by [WithYAMLCodec],
+// selects tree's paths from the result, and returns the selected value
+// encoded by the same Codec. YAML objects decoded as map[any]any are
+// converted to map[string]any before selection. Returns [ErrYAML] if no
+// Codec has been configured, if the Codec fails to decode or encode, or if
+// an object contains a key that is not a string, and the errors returned by
+// [Tree.SelectE] if selection fails.
+func (tree *Tree) SelectYAML(src []byte) ([]byte, error) {
+	sel, err := tree.SelectYAMLValue(src)
+	if err != nil {
+		return nil, err
+	}
+
+	return tree.encodeYAML(sel)
+}
+
+// SelectYAMLValue decodes src like [Tree.SelectYAML] and returns the value
+// selected from the result without encoding it. Only objects with string
+// keys are supported, since [Tree.Select] selects from map[string]any
+// values. Returns errors as SelectYAML does.
+func (tree *Tree) SelectYAMLValue(src []byte) (any, error) {
+	if tree.yaml == nil {
+		return nil, fmt.Errorf("%w: no codec configured", ErrYAML)
+	}
+
+	var value any
+	if err := tree.yaml.Unmarshal(src, &value); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrYAML, err)
+	}
+
+	value, err := stringKeys(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return tree.SelectE(value)
+}
+
+// SelectYAMLString selects tree's paths from the from value with
+// [Tree.Select] and returns the result encoded by the [Codec] configured by
+// [WithYAMLCodec]. Objects in from must be map[string]any values, such as
+// those returned by [Tree.SelectYAMLValue]. Returns [ErrYAML] if no Codec
+// has been configured or if the Codec fails to encode.
+func (tree *Tree) SelectYAMLString(from any) (string, error) {
+	if tree.yaml == nil {
+		return "", fmt.Errorf("%w: no codec configured", ErrYAML)
+	}
+
+	out, err := tree.encodeYAML(tree.Select(from))
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// encodeYAML encodes val with tree's YAML [Codec].
+func (tree *Tree) encodeYAML(val any) ([]byte, error) {
+	out, err := tree.yaml.Marshal(val)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrYAML, err)
+	}
+
+	return out, nil
+}
+
+// stringKeys recursively converts any map[any]any values in val to
+// map[string]any, so that [Tree.Select] can select from them. Returns an
+// error if any map contains a non-string key.
+func stringKeys(val any) (any, error) {
+	switch val := val.(type) {
+	case map[any]any:
+		obj := make(map[string]any, len(val))
+		for k, v := range val {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: unsupported object key type %T", ErrYAML, k)
+			}
+
+			v, err := stringKeys(v)
+			if err != nil {
+				return nil, err
+			}
+
+			obj[key] = v
+		}
+
+		return obj, nil
+	case map[string]any:
+		for k, v := range val {
+			v, err := stringKeys(v)
+			if err != nil {
+				return nil, err
+			}
+
+			val[k] = v
+		}
+	case []any:
+		for i, v := range val {
+			v, err := stringKeys(v)
+			if err != nil {
+				return nil, err
+			}
+
+			val[i] = v
+		}
+	}
+
+	return val, nil
+}
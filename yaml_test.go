@@ -0,0 +1,171 @@
+package jsontree
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theory/jsonpath"
+	"gopkg.in/yaml.v3"
+)
+
+type yamlCodec struct{}
+
+func (yamlCodec) Marshal(v any) ([]byte, error)      { return yaml.Marshal(v) }
+func (yamlCodec) Unmarshal(data []byte, v any) error { return yaml.Unmarshal(data, v) }
+
+// anyKeyCodec decodes to a fixed value, emulating YAML packages that decode
+// objects as map[any]any.
+type anyKeyCodec struct {
+	val any
+	err error
+}
+
+func (c anyKeyCodec) Marshal(v any) ([]byte, error) { return yaml.Marshal(v) }
+
+func (c anyKeyCodec) Unmarshal(_ []byte, v any) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	*v.(*any) = c.val
+
+	return nil
+}
+
+func TestSelectYAML(t *testing.T) {
+	t.Parallel()
+
+	errCodec := errors.New("oops")
+
+	for _, tc := range []struct {
+		test  string
+		paths []string
+		codec Codec
+		src   string
+		exp   string
+		err   string
+	}{
+		{
+			test:  "root",
+			paths: []string{"$"},
+			codec: yamlCodec{},
+			src:   "a: 1\nb: [x, z]\n",
+			exp:   "a: 1\nb:\n    - x\n    - z\n",
+		},
+		{
+			test:  "one_name",
+			paths: []string{"$.b"},
+			codec: yamlCodec{},
+			src:   "a: 1\nb: [x, z]\n",
+			exp:   "b:\n    - x\n    - z\n",
+		},
+		{
+			test:  "nested_index",
+			paths: []string{"$.b[1]", "$.c.d"},
+			codec: yamlCodec{},
+			src:   "a: 1\nb: [x, z]\nc: {d: true, e: false}\n",
+			exp:   "b:\n    - z\nc:\n    d: true\n",
+		},
+		{
+			test:  "any_keys",
+			paths: []string{"$.a.b"},
+			codec: anyKeyCodec{val: map[any]any{
+				"a": map[any]any{"b": []any{map[any]any{"c": 1}}, "x": 2},
+			}},
+			exp: "a:\n    b:\n        - c: 1\n",
+		},
+		{
+			test:  "no_codec",
+			paths: []string{"$"},
+			err:   "jsontree: yaml: no codec configured",
+		},
+		{
+			test:  "unmarshal_error",
+			paths: []string{"$"},
+			codec: anyKeyCodec{err: errCodec},
+			err:   "jsontree: yaml: oops",
+		},
+		{
+			test:  "non_string_key",
+			paths: []string{"$.a"},
+			codec: anyKeyCodec{val: map[any]any{"a": map[any]any{1: true}}},
+			err:   "jsontree: yaml: unsupported object key type int",
+		},
+		{
+			test:  "non_string_key_in_array",
+			paths: []string{"$.a"},
+			codec: anyKeyCodec{val: map[string]any{"a": []any{map[any]any{true: 1}}}},
+			err:   "jsontree: yaml: unsupported object key type bool",
+		},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			paths := make([]*jsonpath.Path, len(tc.paths))
+			for i, p := range tc.paths {
+				paths[i] = jsonpath.MustParse(p)
+			}
+
+			opts := []Option{}
+			if tc.codec != nil {
+				opts = append(opts, WithYAMLCodec(tc.codec))
+			}
+
+			tree := NewCompiler(opts...).New(paths...)
+
+			out, err := tree.SelectYAML([]byte(tc.src))
+			val, valErr := tree.SelectYAMLValue([]byte(tc.src))
+			if tc.err != "" {
+				r.EqualError(err, tc.err)
+				r.ErrorIs(err, ErrYAML)
+				a.Nil(out)
+				a.Equal(err, valErr)
+				a.Nil(val)
+
+				return
+			}
+
+			r.NoError(err)
+			a.Equal(tc.exp, string(out))
+
+			// SelectYAMLString encodes the selected value.
+			r.NoError(valErr)
+			str, err := NewCompiler(opts...).New().SelectYAMLString(val)
+			r.NoError(err)
+			a.Equal(tc.exp, str)
+		})
+	}
+}
+
+func TestSelectYAMLString(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	from := map[string]any{"a": 1, "b": []any{"x", "z"}}
+	tree := NewCompiler(WithYAMLCodec(yamlCodec{})).New(jsonpath.MustParse("$.b[1]"))
+	str, err := tree.SelectYAMLString(from)
+	a.NoError(err)
+	a.Equal("b:\n    - z\n", str)
+
+	str, err = New(jsonpath.MustParse("$.b")).SelectYAMLString(from)
+	a.ErrorIs(err, ErrYAML)
+	a.EqualError(err, "jsontree: yaml: no codec configured")
+	a.Empty(str)
+
+	errCodec := errors.New("oops")
+	tree = NewCompiler(WithYAMLCodec(errorCodec{errCodec})).New()
+	str, err = tree.SelectYAMLString(from)
+	a.ErrorIs(err, ErrYAML)
+	a.ErrorIs(err, errCodec)
+	a.Empty(str)
+}
+
+// errorCodec returns err from every call.
+type errorCodec struct{ err error }
+
+func (c errorCodec) Marshal(any) ([]byte, error) { return nil, c.err }
+func (c errorCodec) Unmarshal([]byte, any) error { return c.err }